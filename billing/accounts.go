@@ -0,0 +1,62 @@
+package billing
+
+import (
+	"fmt"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// LedgerAccount is a journal account as it is known in an accounting system.
+type LedgerAccount struct {
+	Account string `json:"account"`
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+}
+
+// DefaultChartOfAccounts is the code and name of every journal account until
+// an organization maps it to its own chart of accounts.
+var DefaultChartOfAccounts = []LedgerAccount{
+	{AccountCash, "1000", "Cash"},
+	{AccountReceivable, "1100", "Accounts Receivable"},
+	{AccountDisputed, "1150", "Disputed Funds"},
+	{AccountCredits, "2100", "Customer Credits"},
+	{AccountTaxPayable, "2200", "Sales Tax Payable"},
+	{AccountRevenue, "4000", "Sales"},
+	{AccountRefunds, "4100", "Refunds"},
+	{AccountBadDebt, "6100", "Bad Debt"},
+	{AccountDisputeFee, "6200", "Dispute Fees"},
+}
+
+// IsLedgerAccount reports whether the journal books to an account.
+func IsLedgerAccount(account string) bool {
+	for _, a := range DefaultChartOfAccounts {
+		if a.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// ChartOfAccounts returns every journal account with the code and name the
+// organization mapped it to, or its default.
+func ChartOfAccounts(db *gorm.DB, organizationID uint) ([]LedgerAccount, error) {
+	var mappings []models.AccountMapping
+	if err := db.Where("organization_id = ?", organizationID).Find(&mappings).Error; err != nil {
+		return nil, fmt.Errorf("failed to load account mappings: %w", err)
+	}
+	mapped := map[string]models.AccountMapping{}
+	for _, mapping := range mappings {
+		mapped[mapping.Account] = mapping
+	}
+
+	chart := make([]LedgerAccount, len(DefaultChartOfAccounts))
+	for i, account := range DefaultChartOfAccounts {
+		if mapping, ok := mapped[account.Account]; ok {
+			account.Code, account.Name = mapping.Code, mapping.Name
+		}
+		chart[i] = account
+	}
+	return chart, nil
+}
@@ -0,0 +1,270 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNoPaymentMethod is returned when an organization has no default
+	// payment method to charge.
+	ErrNoPaymentMethod = errors.New("no default payment method")
+	// ErrChargeNotRecorded is returned when the provider took the money but
+	// the payment could not be written. The charge must be reconciled by hand.
+	ErrChargeNotRecorded = errors.New("charge succeeded but was not recorded")
+)
+
+// ProviderError wraps an error returned by the payment provider, telling it
+// apart from failures on our side. errors.Is and errors.As see through it to
+// the provider's error.
+type ProviderError struct {
+	Err error
+}
+
+func (e *ProviderError) Error() string { return e.Err.Error() }
+
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// EnsureProviderCustomer returns the organization's customer reference at
+// the payment provider, creating the customer on first use.
+func EnsureProviderCustomer(ctx context.Context, db *gorm.DB, provider payments.Provider, organization *models.Organization) (string, error) {
+	if organization.ProviderCustomerID != "" {
+		return organization.ProviderCustomerID, nil
+	}
+
+	customerID, err := provider.CreateCustomer(ctx, payments.Customer{
+		Name:  organization.Name,
+		Email: organization.BillingEmail,
+	})
+	if err != nil {
+		return "", &ProviderError{Err: err}
+	}
+
+	organization.ProviderCustomerID = customerID
+	if err := db.Model(organization).Update("provider_customer_id", customerID).Error; err != nil {
+		return "", fmt.Errorf("failed to store provider customer: %w", err)
+	}
+	return customerID, nil
+}
+
+// DefaultPaymentMethod returns the organization's default payment method, or
+// ErrNoPaymentMethod when none is set.
+func DefaultPaymentMethod(db *gorm.DB, organizationID uint) (*models.PaymentMethod, error) {
+	var method models.PaymentMethod
+	err := db.Where("organization_id = ? AND is_default = ?", organizationID, true).First(&method).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoPaymentMethod
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load default payment method: %w", err)
+	}
+	return &method, nil
+}
+
+// CollectParams describes a charge against an invoice. Either Method or
+// Token identifies what to charge; when both are empty the organization's
+// default payment method is used.
+type CollectParams struct {
+	UserID         uint
+	Amount         float64 // defaults to the amount due
+	Currency       string  // defaults to the invoice currency; another currency is converted at today's rate
+	Method         *models.PaymentMethod
+	Token          string
+	IdempotencyKey string
+}
+
+type Collection struct {
+	Payment *models.Payment
+	Receipt *models.Receipt
+	Credit  *models.CreditEntry // overpayment kept as credit, if any
+}
+
+// CollectInvoice charges the invoice through the provider and records the
+// payment. Provider errors are returned as a *ProviderError, which callers
+// can still inspect with errors.Is and errors.As.
+func CollectInvoice(ctx context.Context, db *gorm.DB, provider payments.Provider, invoice *models.Invoice, params CollectParams) (*Collection, error) {
+	now := time.Now()
+	amountDue := AmountDue(invoice)
+	if params.Currency == "" {
+		params.Currency = invoice.Currency
+	}
+	rate, err := ExchangeRateOn(db, params.Currency, invoice.Currency, now)
+	if err != nil {
+		return nil, err
+	}
+	if params.Amount == 0 {
+		params.Amount = roundCents(amountDue / rate)
+		if roundCents(params.Amount*rate) < amountDue {
+			params.Amount = roundCents(params.Amount + 0.01)
+		}
+	}
+	if params.UserID == 0 {
+		params.UserID = invoice.UserID
+	}
+	if converted := roundCents(params.Amount * rate); converted < amountDue {
+		return nil, fmt.Errorf("payment amount %.2f %s is less than the amount due %.2f %s", converted, invoice.Currency, amountDue, invoice.Currency)
+	}
+
+	if params.Method == nil && params.Token == "" {
+		method, err := DefaultPaymentMethod(db, invoice.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		params.Method = method
+	}
+	token := params.Token
+	var methodID *uint
+	if params.Method != nil {
+		token = params.Method.ProviderToken
+		methodID = &params.Method.ID
+	}
+
+	var organization models.Organization
+	if err := db.First(&organization, invoice.OrganizationID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load organization: %w", err)
+	}
+	customerID, err := EnsureProviderCustomer(ctx, db, provider, &organization)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.IdempotencyKey == "" {
+		// A payment reversed after it succeeded reopens the invoice, so the
+		// key counts the payments already made for the next one to be
+		// charged anew instead of replaying the reversed charge.
+		var made int64
+		if err := db.Model(&models.Payment{}).Where("invoice_id = ?", invoice.ID).Count(&made).Error; err != nil {
+			return nil, fmt.Errorf("failed to count payments of invoice %d: %w", invoice.ID, err)
+		}
+		params.IdempotencyKey = fmt.Sprintf("invoice-%d-payment-%d", invoice.ID, made+1)
+	}
+	charge, err := provider.Charge(ctx, payments.ChargeRequest{
+		CustomerID:     customerID,
+		PaymentMethod:  token,
+		Amount:         params.Amount,
+		Currency:       params.Currency,
+		Description:    fmt.Sprintf("Invoice %d", invoice.ID),
+		IdempotencyKey: params.IdempotencyKey,
+	})
+	if err != nil {
+		return nil, &ProviderError{Err: err}
+	}
+
+	collection := &Collection{
+		Payment: &models.Payment{
+			InvoiceID:        invoice.ID,
+			UserID:           params.UserID,
+			Amount:           params.Amount,
+			Currency:         params.Currency,
+			PaymentDate:      now,
+			TransactionID:    charge.ID,
+			PaymentMethod:    token,
+			PaymentMethodID:  methodID,
+			Provider:         provider.Name(),
+			ProviderChargeID: charge.ID,
+			ProviderStatus:   charge.Status,
+		},
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		collection.Credit, err = SettleInvoice(tx, invoice, collection.Payment)
+		if err != nil {
+			return err
+		}
+		collection.Receipt = &models.Receipt{}
+		return tx.Where("payment_id = ?", collection.Payment.ID).First(collection.Receipt).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: charge %s on invoice %d: %v", ErrChargeNotRecorded, charge.ID, invoice.ID, err)
+	}
+	return collection, nil
+}
+
+// SettleInvoice records a payment received against an invoice and issues its
+// receipt. A payment covering the amount due marks the invoice paid; anything
+// beyond the amount due, or the whole payment when it falls short or the
+// invoice is already settled, is kept as credit for future invoices. A
+// payment in another currency is converted at the rate on its payment date.
+func SettleInvoice(tx *gorm.DB, invoice *models.Invoice, payment *models.Payment) (*models.CreditEntry, error) {
+	wasPaid := invoice.Paid
+	settled, err := bookPayment(tx, invoice, payment)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Paid && !wasPaid {
+		if err := events.Publish(tx, invoice.OrganizationID, events.InvoicePaid, invoice); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := IssueReceipt(tx, invoice, payment); err != nil {
+		return nil, err
+	}
+	if err := events.Publish(tx, invoice.OrganizationID, events.PaymentSucceeded, payment); err != nil {
+		return nil, err
+	}
+	return creditExcess(tx, invoice, payment, settled)
+}
+
+// ImportPayment records a payment migrated from another billing system. It
+// settles the invoice as SettleInvoice does, but issues no receipt and
+// publishes no payment events: customers were told about the payment when it
+// was made.
+func ImportPayment(tx *gorm.DB, invoice *models.Invoice, payment *models.Payment) error {
+	settled, err := bookPayment(tx, invoice, payment)
+	if err != nil {
+		return err
+	}
+	_, err = creditExcess(tx, invoice, payment, settled)
+	return err
+}
+
+// bookPayment saves a payment, marks the invoice paid if the payment covers
+// the amount due and journals it. It returns the amount of the invoice the
+// payment settled.
+func bookPayment(tx *gorm.DB, invoice *models.Invoice, payment *models.Payment) (float64, error) {
+	// The caller's copy of the invoice may have been loaded before a charge
+	// that took seconds, during which another payment could have settled it.
+	// Locking the row makes concurrent payments settle it one at a time, so
+	// only one of them counts against it and the rest are kept as credit.
+	var current models.Invoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, invoice.ID).Error; err != nil {
+		return 0, fmt.Errorf("failed to load invoice %d: %w", invoice.ID, err)
+	}
+	invoice.Paid, invoice.Uncollectible, invoice.CreditApplied = current.Paid, current.Uncollectible, current.CreditApplied
+
+	if err := convertPayment(tx, payment, invoice.Currency); err != nil {
+		return 0, err
+	}
+	if err := tx.Create(payment).Error; err != nil {
+		return 0, err
+	}
+
+	settled := 0.0
+	if due := AmountDue(invoice); !invoice.Paid && !invoice.Uncollectible && payment.Amount >= due {
+		settled = due
+		invoice.Paid = true
+		if err := tx.Model(invoice).Update("paid", true).Error; err != nil {
+			return 0, err
+		}
+	}
+	return settled, RecordPayment(tx, invoice, payment, settled)
+}
+
+// creditExcess keeps what a payment did not settle as credit.
+func creditExcess(tx *gorm.DB, invoice *models.Invoice, payment *models.Payment, settled float64) (*models.CreditEntry, error) {
+	if excess := roundCents(payment.Amount - settled); excess >= 0.01 {
+		return GrantCredit(tx, invoice.OrganizationID, excess, invoice.Currency,
+			fmt.Sprintf("Overpayment on invoice %d", invoice.ID), nil, &invoice.ID)
+	}
+	return nil, nil
+}
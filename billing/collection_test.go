@@ -0,0 +1,57 @@
+package billing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectInvoiceSettlesOnlyOnce(t *testing.T) {
+	db, org := setupBillingDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorSucceed)
+	ctx := context.Background()
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	// Both callers loaded the invoice while it was still unpaid.
+	first, second := invoice, invoice
+	_, err := CollectInvoice(ctx, db, provider, &first, CollectParams{Token: "pm_card_visa", IdempotencyKey: "first"})
+	assert.NoError(t, err)
+	collection, err := CollectInvoice(ctx, db, provider, &second, CollectParams{Token: "pm_card_visa", IdempotencyKey: "second"})
+	assert.NoError(t, err)
+	assert.NotNil(t, collection.Credit)
+	assert.Equal(t, 100.0, collection.Credit.Amount)
+
+	balances, err := CreditBalances(db, org.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, balances["USD"])
+	issues, err := CheckLedger(db, org.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestCollectInvoiceChargesAgainAfterReversal(t *testing.T) {
+	db, org := setupBillingDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorSucceed)
+	ctx := context.Background()
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+	first, err := CollectInvoice(ctx, db, provider, &invoice, CollectParams{Token: "pm_card_visa"})
+	assert.NoError(t, err)
+
+	// The bank returns the payment, which reopens the invoice.
+	db.Model(first.Payment).Update("provider_status", payments.StatusFailed)
+	db.Model(&invoice).Update("paid", false)
+
+	second, err := CollectInvoice(ctx, db, provider, &invoice, CollectParams{Token: "pm_card_visa"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Payment.ProviderChargeID, second.Payment.ProviderChargeID)
+	assert.True(t, invoice.Paid)
+}
@@ -0,0 +1,237 @@
+package billing
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	CreditGrant       = "grant"
+	CreditApplication = "application"
+	CreditExpiration  = "expiration"
+	CreditReversal    = "reversal" // overpayment credit withdrawn because the payment was reversed
+)
+
+// roundCents rounds an amount to two decimal places.
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// GrantCredit adds credit to an organization's balance. A nil expiresAt means
+// the credit never expires. The caller books the matching journal entry,
+// since only it knows where the credit came from.
+func GrantCredit(db *gorm.DB, organizationID uint, amount float64, currency, description string, expiresAt *time.Time, invoiceID *uint) (*models.CreditEntry, error) {
+	amount = roundCents(amount)
+	if amount <= 0 {
+		return nil, fmt.Errorf("credit amount must be positive")
+	}
+
+	entry := models.CreditEntry{
+		OrganizationID: organizationID,
+		Type:           CreditGrant,
+		Amount:         amount,
+		Currency:       currency,
+		Remaining:      amount,
+		ExpiresAt:      expiresAt,
+		InvoiceID:      invoiceID,
+		Description:    description,
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		return nil, fmt.Errorf("failed to create credit grant: %w", err)
+	}
+	if err := events.Publish(db, organizationID, events.CreditGranted, entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// CreditBalances returns the organization's available credit keyed by currency.
+func CreditBalances(db *gorm.DB, organizationID uint) (map[string]float64, error) {
+	var rows []struct {
+		Currency string
+		Total    float64
+	}
+	err := db.Model(&models.CreditEntry{}).
+		Select("currency, SUM(amount) AS total").
+		Where("organization_id = ?", organizationID).
+		Group("currency").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum credit entries: %w", err)
+	}
+
+	balances := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		balances[row.Currency] = roundCents(row.Total)
+	}
+	return balances, nil
+}
+
+// AvailableCredit returns the credit the organization can still spend, keyed
+// by currency. Unlike CreditBalances, it leaves out grants that have expired
+// but have not been written off yet, without writing anything itself.
+func AvailableCredit(db *gorm.DB, organizationID uint, now time.Time) (map[string]float64, error) {
+	balances, err := CreditBalances(db, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	var lapsed []struct {
+		Currency string
+		Total    float64
+	}
+	err = db.Model(&models.CreditEntry{}).
+		Select("currency, SUM(remaining) AS total").
+		Where("organization_id = ? AND type = ? AND remaining > 0 AND expires_at IS NOT NULL AND expires_at <= ?", organizationID, CreditGrant, now).
+		Group("currency").
+		Scan(&lapsed).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum expired credit: %w", err)
+	}
+	for _, row := range lapsed {
+		balances[row.Currency] = roundCents(balances[row.Currency] - row.Total)
+	}
+	return balances, nil
+}
+
+// ApplyCredit settles as much of the invoice as possible from unexpired
+// grants in the invoice currency, consuming the soonest-expiring grants first.
+// It returns the amount applied; the caller is responsible for saving the invoice.
+func ApplyCredit(db *gorm.DB, invoice *models.Invoice, now time.Time) (float64, error) {
+	due := roundCents(invoice.Amount - invoice.CreditApplied)
+	if due <= 0 {
+		return 0, nil
+	}
+
+	// Grants are locked until the caller's transaction ends, so that
+	// concurrent finalizations for the organization cannot spend the same
+	// credit twice.
+	var grants []models.CreditEntry
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("organization_id = ? AND currency = ? AND type = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)",
+		invoice.OrganizationID, invoice.Currency, CreditGrant, now).
+		Order("expires_at IS NULL, expires_at, id").
+		Find(&grants).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load credit grants: %w", err)
+	}
+
+	applied := 0.0
+	for i := range grants {
+		if due <= 0 {
+			break
+		}
+		grant := &grants[i]
+		take := math.Min(grant.Remaining, due)
+
+		grant.Remaining = roundCents(grant.Remaining - take)
+		if err := db.Model(grant).Update("remaining", grant.Remaining).Error; err != nil {
+			return 0, fmt.Errorf("failed to update credit grant: %w", err)
+		}
+
+		application := models.CreditEntry{
+			OrganizationID: invoice.OrganizationID,
+			Type:           CreditApplication,
+			Amount:         -take,
+			Currency:       invoice.Currency,
+			GrantID:        &grant.ID,
+			InvoiceID:      &invoice.ID,
+			Description:    fmt.Sprintf("Applied to invoice %d", invoice.ID),
+		}
+		if err := db.Create(&application).Error; err != nil {
+			return 0, fmt.Errorf("failed to record credit application: %w", err)
+		}
+
+		due = roundCents(due - take)
+		applied = roundCents(applied + take)
+	}
+
+	invoice.CreditApplied = roundCents(invoice.CreditApplied + applied)
+
+	err = PostJournalEntry(db, invoice.OrganizationID, SourceInvoice, invoice.ID,
+		fmt.Sprintf("Credit applied to invoice %d", invoice.ID),
+		[]models.JournalLine{
+			debit(AccountCredits, invoice.Currency, applied),
+			credit(AccountReceivable, invoice.Currency, applied),
+		})
+	if err != nil {
+		return 0, err
+	}
+	return applied, nil
+}
+
+// ExpireCredits writes expiration entries for the unused portion of every
+// grant whose expiry has passed. It returns the number of grants expired.
+func ExpireCredits(db *gorm.DB, organizationID uint, now time.Time) (int, error) {
+	// Grants are locked until the caller's transaction ends. Two expiry runs
+	// for the organization would otherwise both write off what is left of
+	// a grant, booking the breakage twice.
+	var grants []models.CreditEntry
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("organization_id = ? AND type = ? AND remaining > 0 AND expires_at IS NOT NULL AND expires_at <= ?",
+		organizationID, CreditGrant, now).
+		Find(&grants).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load expired credit grants: %w", err)
+	}
+
+	for i := range grants {
+		grant := &grants[i]
+		lapsed := grant.Remaining
+		expiration := models.CreditEntry{
+			OrganizationID: organizationID,
+			Type:           CreditExpiration,
+			Amount:         -lapsed,
+			Currency:       grant.Currency,
+			GrantID:        &grant.ID,
+			Description:    fmt.Sprintf("Expired credit grant %d", grant.ID),
+		}
+		if err := db.Create(&expiration).Error; err != nil {
+			return 0, fmt.Errorf("failed to record credit expiration: %w", err)
+		}
+		if err := db.Model(grant).Update("remaining", 0).Error; err != nil {
+			return 0, fmt.Errorf("failed to update credit grant: %w", err)
+		}
+
+		// Unused credit that lapses is recognized as revenue (breakage).
+		err := PostJournalEntry(db, organizationID, SourceCredit, expiration.ID, expiration.Description,
+			[]models.JournalLine{
+				debit(AccountCredits, grant.Currency, lapsed),
+				credit(AccountRevenue, grant.Currency, lapsed),
+			})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(grants), nil
+}
+
+// ExpireAllCredits runs ExpireCredits for every organization with expired
+// grants, each in its own transaction. It returns the number of grants
+// expired.
+func ExpireAllCredits(db *gorm.DB, now time.Time) (int, error) {
+	var organizationIDs []uint
+	err := db.Model(&models.CreditEntry{}).
+		Where("type = ? AND remaining > 0 AND expires_at IS NOT NULL AND expires_at <= ?", CreditGrant, now).
+		Distinct().Pluck("organization_id", &organizationIDs).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired credit grants: %w", err)
+	}
+
+	expired := 0
+	for _, organizationID := range organizationIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			n, err := ExpireCredits(tx, organizationID, now)
+			expired += n
+			return err
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
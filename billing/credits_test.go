@@ -0,0 +1,133 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupBillingDB(t *testing.T) (*gorm.DB, *models.Organization) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+
+	err = db.AutoMigrate(database.Models...)
+	assert.NoError(t, err)
+
+	org := models.Organization{Name: "Test Org", BillingEmail: "billing@test.org"}
+	err = db.Create(&org).Error
+	assert.NoError(t, err)
+
+	return db, &org
+}
+
+// lockedTables records the tables queried with a locking clause. SQLite has
+// no row locks, so the clause is checked before the driver drops it.
+func lockedTables(t *testing.T, db *gorm.DB) *[]string {
+	var tables []string
+	err := db.Callback().Query().Before("gorm:query").Register("test:locks", func(tx *gorm.DB) {
+		if _, ok := tx.Statement.Clauses["FOR"]; ok {
+			tables = append(tables, tx.Statement.Table)
+		}
+	})
+	assert.NoError(t, err)
+	return &tables
+}
+
+func TestCreditGrantsAreLockedWhileSpent(t *testing.T) {
+	db, org := setupBillingDB(t)
+	locked := lockedTables(t, db)
+
+	past := time.Now().Add(-time.Hour)
+	_, err := GrantCredit(db, org.ID, 15, "USD", "Goodwill", nil, nil)
+	assert.NoError(t, err)
+	_, err = GrantCredit(db, org.ID, 5, "USD", "Promotion", &past, nil)
+	assert.NoError(t, err)
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 10, Currency: "USD"}
+	assert.NoError(t, db.Create(&invoice).Error)
+	_, err = ApplyCredit(db, &invoice, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"credit_entries"}, *locked)
+
+	*locked = nil
+	_, err = ExpireCredits(db, org.ID, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"credit_entries"}, *locked)
+
+	*locked = nil
+	_, err = withdrawOverpayment(db, &invoice, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"credit_entries"}, *locked)
+}
+
+func TestFinalizeInvoiceAppliesCredit(t *testing.T) {
+	db, org := setupBillingDB(t)
+
+	soon := time.Now().Add(24 * time.Hour)
+	_, err := GrantCredit(db, org.ID, 15, "USD", "Goodwill", nil, nil)
+	assert.NoError(t, err)
+	_, err = GrantCredit(db, org.ID, 10, "USD", "Promotion", &soon, nil)
+	assert.NoError(t, err)
+	_, err = GrantCredit(db, org.ID, 50, "EUR", "Other currency", nil, nil)
+	assert.NoError(t, err)
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 20, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	err = FinalizeInvoice(db, &invoice)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 20.0, invoice.CreditApplied)
+	assert.True(t, invoice.Paid)
+
+	// The expiring promotion is consumed before the open-ended grant.
+	var promotion models.CreditEntry
+	db.First(&promotion, "description = ?", "Promotion")
+	assert.Equal(t, 0.0, promotion.Remaining)
+
+	balances, err := CreditBalances(db, org.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, balances["USD"])
+	assert.Equal(t, 50.0, balances["EUR"])
+}
+
+func TestExpireCredits(t *testing.T) {
+	db, org := setupBillingDB(t)
+
+	expiry := time.Now().Add(time.Hour)
+	_, err := GrantCredit(db, org.ID, 30, "USD", "Trial credit", &expiry, nil)
+	assert.NoError(t, err)
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 12.5, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	err = FinalizeInvoice(db, &invoice)
+	assert.NoError(t, err)
+
+	// Credit past its expiry is no longer available, even before it is
+	// written off.
+	available, err := AvailableCredit(db, org.ID, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 17.5, available["USD"])
+	available, err = AvailableCredit(db, org.ID, expiry.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, available["USD"])
+
+	expired, err := ExpireAllCredits(db, expiry.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	expired, err = ExpireAllCredits(db, expiry.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Zero(t, expired)
+
+	var expiration models.CreditEntry
+	err = db.First(&expiration, "type = ?", CreditExpiration).Error
+	assert.NoError(t, err)
+	assert.Equal(t, -17.5, expiration.Amount)
+
+	balances, err := CreditBalances(db, org.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, balances["USD"])
+}
@@ -0,0 +1,245 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DisputeNeedsResponse = "needs_response"
+	DisputeUnderReview   = "under_review"
+	DisputeWon           = "won"
+	DisputeLost          = "lost"
+)
+
+// disputeTransitions lists the statuses each status may move to. Won and lost
+// are final.
+var disputeTransitions = map[string][]string{
+	DisputeNeedsResponse: {DisputeUnderReview, DisputeWon, DisputeLost},
+	DisputeUnderReview:   {DisputeWon, DisputeLost},
+}
+
+// ErrInvalidTransition is returned for a status change the dispute lifecycle
+// does not allow.
+var ErrInvalidTransition = errors.New("invalid dispute status transition")
+
+// OpenDisputeParams describes a dispute raised by the cardholder's bank.
+type OpenDisputeParams struct {
+	ProviderDisputeID string
+	Amount            float64 // defaults to the full payment
+	Fee               float64
+	Reason            string
+	EvidenceDueBy     *time.Time
+}
+
+// OpenDispute records a dispute against a payment. The provider withholds the
+// disputed amount until the dispute is resolved, and charges the fee
+// outright; both are booked when the dispute opens.
+func OpenDispute(tx *gorm.DB, payment *models.Payment, params OpenDisputeParams) (*models.Dispute, error) {
+	var invoice models.Invoice
+	if err := tx.First(&invoice, payment.InvoiceID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load invoice %d: %w", payment.InvoiceID, err)
+	}
+
+	amount := roundCents(params.Amount)
+	if amount <= 0 || amount > payment.Amount {
+		amount = payment.Amount
+	}
+	dispute := models.Dispute{
+		OrganizationID:    invoice.OrganizationID,
+		PaymentID:         payment.ID,
+		InvoiceID:         invoice.ID,
+		ProviderDisputeID: params.ProviderDisputeID,
+		Amount:            amount,
+		Currency:          payment.Currency,
+		Fee:               roundCents(params.Fee),
+		Reason:            params.Reason,
+		Status:            DisputeNeedsResponse,
+		EvidenceDueBy:     params.EvidenceDueBy,
+	}
+	if err := tx.Create(&dispute).Error; err != nil {
+		return nil, fmt.Errorf("failed to create dispute: %w", err)
+	}
+
+	err := PostJournalEntry(tx, dispute.OrganizationID, SourceDispute, dispute.ID,
+		fmt.Sprintf("Funds withheld for dispute %d on payment %d", dispute.ID, payment.ID),
+		[]models.JournalLine{
+			debit(AccountDisputed, dispute.Currency, dispute.Amount),
+			credit(AccountCash, dispute.Currency, dispute.Amount),
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = PostJournalEntry(tx, dispute.OrganizationID, SourceDispute, dispute.ID,
+		fmt.Sprintf("Fee for dispute %d", dispute.ID),
+		[]models.JournalLine{
+			debit(AccountDisputeFee, dispute.Currency, dispute.Fee),
+			credit(AccountCash, dispute.Currency, dispute.Fee),
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	payment.ProviderStatus = payments.StatusDisputed
+	if err := tx.Model(payment).Update("provider_status", payment.ProviderStatus).Error; err != nil {
+		return nil, fmt.Errorf("failed to update payment %d: %w", payment.ID, err)
+	}
+	if err := events.Publish(tx, dispute.OrganizationID, events.DisputeOpened, dispute); err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+// DisputeEvidence holds the evidence submitted to contest a dispute. Empty
+// fields leave the stored evidence unchanged.
+type DisputeEvidence struct {
+	Explanation           string
+	CustomerCommunication string
+	ServiceDocumentation  string
+}
+
+func (e DisputeEvidence) empty() bool {
+	return e.Explanation == "" && e.CustomerCommunication == "" && e.ServiceDocumentation == ""
+}
+
+// UpdateDispute stores evidence and moves the dispute under review. Moving
+// to under_review submits the evidence, so some must be on file. An empty
+// status leaves it unchanged. Only the bank decides whether a dispute is won
+// or lost, through CloseDispute.
+func UpdateDispute(tx *gorm.DB, dispute *models.Dispute, status string, evidence DisputeEvidence, now time.Time) error {
+	if status == DisputeWon || status == DisputeLost {
+		return fmt.Errorf("%w: the outcome of a dispute is reported by the payment provider", ErrInvalidTransition)
+	}
+	if !evidence.empty() {
+		if dispute.Status != DisputeNeedsResponse {
+			return fmt.Errorf("%w: evidence can only be changed while the dispute needs a response", ErrInvalidTransition)
+		}
+		if evidence.Explanation != "" {
+			dispute.EvidenceExplanation = evidence.Explanation
+		}
+		if evidence.CustomerCommunication != "" {
+			dispute.EvidenceCustomerCommunication = evidence.CustomerCommunication
+		}
+		if evidence.ServiceDocumentation != "" {
+			dispute.EvidenceServiceDocumentation = evidence.ServiceDocumentation
+		}
+	}
+
+	if status == DisputeUnderReview && dispute.Status == DisputeNeedsResponse {
+		if dispute.EvidenceExplanation == "" && dispute.EvidenceCustomerCommunication == "" && dispute.EvidenceServiceDocumentation == "" {
+			return fmt.Errorf("%w: submit evidence before moving the dispute under review", ErrInvalidTransition)
+		}
+		dispute.EvidenceSubmittedAt = &now
+	}
+
+	if status == "" || status == dispute.Status {
+		if err := tx.Omit(clause.Associations).Save(dispute).Error; err != nil {
+			return fmt.Errorf("failed to update dispute %d: %w", dispute.ID, err)
+		}
+		return events.Publish(tx, dispute.OrganizationID, events.DisputeUpdated, dispute)
+	}
+	return transitionDispute(tx, dispute, status, now)
+}
+
+// CloseDispute records the outcome of a dispute decided by the bank.
+func CloseDispute(tx *gorm.DB, dispute *models.Dispute, won bool, now time.Time) error {
+	status := DisputeLost
+	if won {
+		status = DisputeWon
+	}
+	return transitionDispute(tx, dispute, status, now)
+}
+
+func transitionDispute(tx *gorm.DB, dispute *models.Dispute, status string, now time.Time) error {
+	allowed := false
+	for _, next := range disputeTransitions[dispute.Status] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, dispute.Status, status)
+	}
+
+	dispute.Status = status
+	eventType := events.DisputeUpdated
+	switch status {
+	case DisputeWon:
+		dispute.ResolvedAt = &now
+		eventType = events.DisputeWon
+		// The withheld funds are returned; the fee is not.
+		err := PostJournalEntry(tx, dispute.OrganizationID, SourceDispute, dispute.ID,
+			fmt.Sprintf("Dispute %d won", dispute.ID),
+			[]models.JournalLine{
+				debit(AccountCash, dispute.Currency, dispute.Amount),
+				credit(AccountDisputed, dispute.Currency, dispute.Amount),
+			})
+		if err != nil {
+			return err
+		}
+		if err := updatePaymentStatus(tx, dispute.PaymentID, payments.StatusSucceeded); err != nil {
+			return err
+		}
+	case DisputeLost:
+		dispute.ResolvedAt = &now
+		eventType = events.DisputeLost
+		if err := reverseDisputedPayment(tx, dispute); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Omit(clause.Associations).Save(dispute).Error; err != nil {
+		return fmt.Errorf("failed to update dispute %d: %w", dispute.ID, err)
+	}
+	return events.Publish(tx, dispute.OrganizationID, eventType, dispute)
+}
+
+// reverseDisputedPayment books a lost dispute: the withheld funds go back to
+// the cardholder and the revenue they paid for is reversed. When the whole
+// payment was disputed the invoice is no longer paid; it is marked
+// uncollectible rather than reopened, since the cardholder has refused it.
+func reverseDisputedPayment(tx *gorm.DB, dispute *models.Dispute) error {
+	err := PostJournalEntry(tx, dispute.OrganizationID, SourceDispute, dispute.ID,
+		fmt.Sprintf("Dispute %d lost", dispute.ID),
+		[]models.JournalLine{
+			debit(AccountRevenue, dispute.Currency, dispute.Amount),
+			credit(AccountDisputed, dispute.Currency, dispute.Amount),
+		})
+	if err != nil {
+		return err
+	}
+
+	var payment models.Payment
+	if err := tx.First(&payment, dispute.PaymentID).Error; err != nil {
+		return fmt.Errorf("failed to load payment %d: %w", dispute.PaymentID, err)
+	}
+	if err := updatePaymentStatus(tx, payment.ID, payments.StatusChargedBack); err != nil {
+		return err
+	}
+	if dispute.Amount < payment.Amount {
+		return nil
+	}
+
+	err = tx.Model(&models.Invoice{}).Where("id = ?", dispute.InvoiceID).
+		Updates(map[string]interface{}{"paid": false, "uncollectible": true, "next_collection_at": nil}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update invoice %d: %w", dispute.InvoiceID, err)
+	}
+	return nil
+}
+
+func updatePaymentStatus(tx *gorm.DB, paymentID uint, status string) error {
+	if err := tx.Model(&models.Payment{}).Where("id = ?", paymentID).Update("provider_status", status).Error; err != nil {
+		return fmt.Errorf("failed to update payment %d: %w", paymentID, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func settledInvoice(t *testing.T, db *gorm.DB, org *models.Organization, chargeID string) (*models.Invoice, *models.Payment) {
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+	payment := models.Payment{InvoiceID: invoice.ID, Amount: 100, Currency: "USD", PaymentDate: time.Now(), TransactionID: chargeID, ProviderChargeID: chargeID, ProviderStatus: payments.StatusSucceeded}
+	_, err := SettleInvoice(db, &invoice, &payment)
+	assert.NoError(t, err)
+	return &invoice, &payment
+}
+
+func accountBalances(t *testing.T, db *gorm.DB, org *models.Organization) map[string]float64 {
+	rows, err := TrialBalance(db, org.ID)
+	assert.NoError(t, err)
+	balances := map[string]float64{}
+	for _, row := range rows {
+		balances[row.Account] = row.Balance
+	}
+	return balances
+}
+
+func TestLostDisputeReversesRevenueAndInvoice(t *testing.T) {
+	db, org := setupBillingDB(t)
+	now := time.Now()
+	invoice, payment := settledInvoice(t, db, org, "ch_lost")
+
+	dispute, err := OpenDispute(db, payment, OpenDisputeParams{ProviderDisputeID: "dp_1", Fee: 15, Reason: "fraudulent"})
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, dispute.Amount)
+	assert.Equal(t, DisputeNeedsResponse, dispute.Status)
+
+	balances := accountBalances(t, db, org)
+	assert.Equal(t, -15.0, balances[AccountCash])
+	assert.Equal(t, 100.0, balances[AccountDisputed])
+	assert.Equal(t, 15.0, balances[AccountDisputeFee])
+
+	// Evidence is required before review, and a closed dispute cannot reopen.
+	err = UpdateDispute(db, dispute, DisputeUnderReview, DisputeEvidence{}, now)
+	assert.True(t, errors.Is(err, ErrInvalidTransition))
+	assert.NoError(t, UpdateDispute(db, dispute, DisputeUnderReview, DisputeEvidence{Explanation: "Customer used the service all month"}, now))
+	assert.NotNil(t, dispute.EvidenceSubmittedAt)
+	err = UpdateDispute(db, dispute, DisputeWon, DisputeEvidence{}, now)
+	assert.True(t, errors.Is(err, ErrInvalidTransition)) // the bank decides
+	assert.NoError(t, CloseDispute(db, dispute, false, now))
+	err = UpdateDispute(db, dispute, DisputeUnderReview, DisputeEvidence{}, now)
+	assert.True(t, errors.Is(err, ErrInvalidTransition))
+
+	balances = accountBalances(t, db, org)
+	assert.Equal(t, 0.0, balances[AccountDisputed])
+	assert.Equal(t, 0.0, balances[AccountRevenue])
+	assert.Equal(t, -15.0, balances[AccountCash])
+
+	var reloaded models.Invoice
+	db.First(&reloaded, invoice.ID)
+	assert.False(t, reloaded.Paid)
+	assert.True(t, reloaded.Uncollectible)
+	db.First(payment, payment.ID)
+	assert.Equal(t, payments.StatusChargedBack, payment.ProviderStatus)
+
+	issues, err := CheckLedger(db, org.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestWonDisputeReturnsFundsButNotFee(t *testing.T) {
+	db, org := setupBillingDB(t)
+	now := time.Now()
+	invoice, payment := settledInvoice(t, db, org, "ch_won")
+
+	// The provider reports the dispute and later its outcome.
+	opened := &payments.Event{ID: "evt_open", Type: payments.EventPaymentDisputed, Data: payments.EventData{ChargeID: "ch_won", DisputeID: "dp_2", Amount: 100, Fee: 15, Reason: "product_not_received"}}
+	_, err := HandleProviderEvent(db, "fake", opened, now)
+	assert.NoError(t, err)
+	closed := &payments.Event{ID: "evt_close", Type: payments.EventDisputeClosed, Data: payments.EventData{DisputeID: "dp_2", Status: DisputeWon}}
+	record, err := HandleProviderEvent(db, "fake", closed, now)
+	assert.NoError(t, err)
+	assert.Equal(t, EventProcessed, record.Outcome)
+
+	var dispute models.Dispute
+	db.Where("provider_dispute_id = ?", "dp_2").First(&dispute)
+	assert.Equal(t, DisputeWon, dispute.Status)
+	assert.NotNil(t, dispute.ResolvedAt)
+
+	balances := accountBalances(t, db, org)
+	assert.Equal(t, 85.0, balances[AccountCash])
+	assert.Equal(t, 0.0, balances[AccountDisputed])
+	assert.Equal(t, -100.0, balances[AccountRevenue])
+
+	db.First(invoice, invoice.ID)
+	assert.True(t, invoice.Paid)
+	db.First(payment, payment.ID)
+	assert.Equal(t, payments.StatusSucceeded, payment.ProviderStatus)
+}
@@ -0,0 +1,237 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DunningActionCancel = "cancel"
+	DunningActionPause  = "pause"
+)
+
+// DefaultDunningPolicy applies to organizations that have not configured one.
+var DefaultDunningPolicy = models.DunningPolicy{
+	RetryDays:     "1,3,7",
+	FinalAction:   DunningActionCancel,
+	SendReminders: true,
+}
+
+// ParseRetryDays parses a comma-separated retry schedule such as '1,3,7'.
+// Days must be positive and strictly increasing.
+func ParseRetryDays(schedule string) ([]int, error) {
+	var days []int
+	for _, part := range strings.Split(schedule, ",") {
+		day, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry day %q", part)
+		}
+		if day <= 0 || (len(days) > 0 && day <= days[len(days)-1]) {
+			return nil, fmt.Errorf("retry days must be positive and increasing")
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// DunningPolicyFor returns the organization's dunning policy, falling back to
+// DefaultDunningPolicy.
+func DunningPolicyFor(db *gorm.DB, organizationID uint) (*models.DunningPolicy, error) {
+	var policy models.DunningPolicy
+	err := db.Where("organization_id = ?", organizationID).First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		policy = DefaultDunningPolicy
+		policy.OrganizationID = organizationID
+		return &policy, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dunning policy: %w", err)
+	}
+	return &policy, nil
+}
+
+type DunningReport struct {
+	Attempted     int      `json:"attempted"`
+	Collected     int      `json:"collected"`
+	Scheduled     int      `json:"scheduled"`
+	Uncollectible int      `json:"uncollectible"`
+	Errors        []string `json:"errors"`
+}
+
+// RunDunning retries collection of open invoices past their due date on the
+// schedule of each organization's dunning policy. After the last retry fails
+// the invoice is written off and the subscription it bills for is cancelled
+// or paused.
+func RunDunning(ctx context.Context, db *gorm.DB, provider payments.Provider, now time.Time) (*DunningReport, error) {
+	var invoices []models.Invoice
+	err := db.Where("paid = ? AND uncollectible = ? AND due_date < ? AND (next_collection_at IS NULL OR next_collection_at <= ?)",
+		false, false, now, now).
+		Order("due_date, id").
+		Find(&invoices).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load past due invoices: %w", err)
+	}
+
+	report := &DunningReport{Errors: []string{}}
+	for i := range invoices {
+		if err := dunInvoice(ctx, db, provider, &invoices[i], now, report); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("invoice %d: %v", invoices[i].ID, err))
+		}
+	}
+	return report, nil
+}
+
+func dunInvoice(ctx context.Context, db *gorm.DB, provider payments.Provider, invoice *models.Invoice, now time.Time, report *DunningReport) error {
+	policy, err := DunningPolicyFor(db, invoice.OrganizationID)
+	if err != nil {
+		return err
+	}
+	days, err := ParseRetryDays(policy.RetryDays)
+	if err != nil {
+		return fmt.Errorf("organization %d: %w", invoice.OrganizationID, err)
+	}
+
+	var previous int64
+	if err := db.Model(&models.CollectionAttempt{}).Where("invoice_id = ?", invoice.ID).Count(&previous).Error; err != nil {
+		return fmt.Errorf("failed to count collection attempts: %w", err)
+	}
+	attemptNumber := int(previous) + 1
+	if attemptNumber > len(days) {
+		// The schedule was shortened after earlier attempts; treat it as exhausted.
+		attemptNumber = len(days)
+	}
+
+	scheduledAt := invoice.DueDate.AddDate(0, 0, days[attemptNumber-1])
+	if now.Before(scheduledAt) {
+		report.Scheduled++
+		return db.Model(invoice).Update("next_collection_at", scheduledAt).Error
+	}
+
+	report.Attempted++
+	attempt := models.CollectionAttempt{
+		InvoiceID:      invoice.ID,
+		OrganizationID: invoice.OrganizationID,
+		AttemptNumber:  attemptNumber,
+		AttemptedAt:    now,
+	}
+
+	collection, err := CollectInvoice(ctx, db, provider, invoice, CollectParams{
+		IdempotencyKey: fmt.Sprintf("invoice-%d-dunning-%d", invoice.ID, attemptNumber),
+	})
+	if err == nil {
+		report.Collected++
+		attempt.Succeeded = true
+		attempt.PaymentID = &collection.Payment.ID
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&attempt).Error; err != nil {
+				return err
+			}
+			return tx.Model(invoice).Update("next_collection_at", nil).Error
+		})
+	}
+	if errors.Is(err, ErrChargeNotRecorded) {
+		return err
+	}
+
+	attempt.FailureCode, attempt.FailureMessage = failureReason(err)
+	attempt.Final = attemptNumber >= len(days)
+	if !attempt.Final {
+		next := invoice.DueDate.AddDate(0, 0, days[attemptNumber])
+		attempt.NextAttemptAt = &next
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if attempt.Final {
+			if err := MarkUncollectible(tx, invoice, policy.FinalAction, now); err != nil {
+				return err
+			}
+		} else if err := tx.Model(invoice).Update("next_collection_at", attempt.NextAttemptAt).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		return events.Publish(tx, invoice.OrganizationID, events.CollectionFailed, attempt)
+	})
+	if err != nil {
+		return err
+	}
+	if attempt.Final {
+		report.Uncollectible++
+	}
+	return nil
+}
+
+// failureReason classifies a collection error for the attempt history.
+func failureReason(err error) (string, string) {
+	var decline *payments.DeclineError
+	switch {
+	case errors.As(err, &decline):
+		return decline.Code, decline.Message
+	case errors.Is(err, payments.ErrTimeout):
+		return "timeout", err.Error()
+	case errors.Is(err, ErrNoPaymentMethod):
+		return "no_payment_method", err.Error()
+	}
+	return "error", err.Error()
+}
+
+// MarkUncollectible writes off the open balance of an invoice as bad debt and
+// applies the final dunning action to the subscription it bills for.
+func MarkUncollectible(tx *gorm.DB, invoice *models.Invoice, action string, now time.Time) error {
+	invoice.Uncollectible = true
+	invoice.NextCollectionAt = nil
+	err := tx.Model(invoice).Updates(map[string]interface{}{"uncollectible": true, "next_collection_at": nil}).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark invoice uncollectible: %w", err)
+	}
+
+	due := AmountDue(invoice)
+	err = PostJournalEntry(tx, invoice.OrganizationID, SourceInvoice, invoice.ID,
+		fmt.Sprintf("Invoice %d written off", invoice.ID),
+		[]models.JournalLine{
+			debit(AccountBadDebt, invoice.Currency, due),
+			credit(AccountReceivable, invoice.Currency, due),
+		})
+	if err != nil {
+		return err
+	}
+	if err := events.Publish(tx, invoice.OrganizationID, events.InvoiceUncollectible, invoice); err != nil {
+		return err
+	}
+
+	if invoice.SubscriptionID == nil {
+		return nil
+	}
+	var subscription models.Subscription
+	if err := tx.First(&subscription, *invoice.SubscriptionID).Error; err != nil {
+		return fmt.Errorf("failed to load subscription %d: %w", *invoice.SubscriptionID, err)
+	}
+	if !subscription.IsActive {
+		return nil
+	}
+
+	eventType := events.SubscriptionCancelled
+	switch action {
+	case DunningActionPause:
+		subscription.PausedAt = &now
+		eventType = events.SubscriptionPaused
+	default:
+		subscription.IsActive = false
+		subscription.EndDate = now
+	}
+	if err := tx.Save(&subscription).Error; err != nil {
+		return fmt.Errorf("failed to update subscription %d: %w", subscription.ID, err)
+	}
+	return events.Publish(tx, subscription.OrganizationID, eventType, subscription)
+}
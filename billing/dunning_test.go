@@ -0,0 +1,95 @@
+package billing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDunningRetriesThenWritesOff(t *testing.T) {
+	db, org := setupBillingDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorDecline)
+	ctx := context.Background()
+
+	user := models.User{Username: "owner", Email: "owner@test.org", PasswordHash: "hash", OrganizationID: org.ID}
+	db.Create(&user)
+	plan := models.SubscriptionPlan{Name: "Monthly", Price: 40, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	db.Create(&plan)
+	subscription := models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID, StartDate: time.Now(), IsActive: true}
+	db.Create(&subscription)
+	db.Create(&models.PaymentMethod{OrganizationID: org.ID, Type: "card", ProviderToken: "tok_visa", IsDefault: true})
+	db.Create(&models.DunningPolicy{OrganizationID: org.ID, RetryDays: "1,3", FinalAction: DunningActionPause, SendReminders: true})
+
+	dueDate := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, SubscriptionID: &subscription.ID, Amount: 40, Currency: "USD", IssueDate: dueDate, DueDate: dueDate}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	// Half a day past due nothing is attempted yet.
+	report, err := RunDunning(ctx, db, provider, dueDate.Add(12*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Scheduled)
+	assert.Equal(t, 0, report.Attempted)
+
+	report, err = RunDunning(ctx, db, provider, dueDate.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Attempted)
+	assert.Equal(t, 0, report.Uncollectible)
+
+	// The next attempt is not due until day 3.
+	report, err = RunDunning(ctx, db, provider, dueDate.AddDate(0, 0, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Attempted)
+
+	report, err = RunDunning(ctx, db, provider, dueDate.AddDate(0, 0, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Attempted)
+	assert.Equal(t, 1, report.Uncollectible)
+
+	var attempts []models.CollectionAttempt
+	db.Where("invoice_id = ?", invoice.ID).Order("attempt_number").Find(&attempts)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, "card_declined", attempts[0].FailureCode)
+	assert.True(t, attempts[1].Final)
+
+	// Reminders are emailed by the notifications subscriber.
+	var published int64
+	db.Model(&models.OutboxEvent{}).Where("type = ?", events.CollectionFailed).Count(&published)
+	assert.Equal(t, int64(2), published)
+
+	db.First(&invoice, invoice.ID)
+	assert.True(t, invoice.Uncollectible)
+	db.First(&subscription, subscription.ID)
+	assert.True(t, subscription.IsActive)
+	assert.NotNil(t, subscription.PausedAt)
+
+	issues, err := CheckLedger(db, org.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestRunDunningCollects(t *testing.T) {
+	db, org := setupBillingDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	user := models.User{Username: "owner", Email: "owner@test.org", PasswordHash: "hash", OrganizationID: org.ID}
+	db.Create(&user)
+	db.Create(&models.PaymentMethod{OrganizationID: org.ID, Type: "card", ProviderToken: "tok_visa", IsDefault: true})
+
+	dueDate := time.Now().AddDate(0, 0, -2)
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 10, Currency: "USD", IssueDate: dueDate, DueDate: dueDate}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	report, err := RunDunning(context.Background(), db, provider, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Collected)
+
+	db.First(&invoice, invoice.ID)
+	assert.True(t, invoice.Paid)
+	assert.Nil(t, invoice.NextCollectionAt)
+}
@@ -0,0 +1,157 @@
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoExchangeRate is returned when no rate between two currencies is in
+// effect on the requested date.
+var ErrNoExchangeRate = errors.New("no exchange rate")
+
+// ExchangeRateInput is an exchange rate as submitted through the API or read
+// from a rates file.
+type ExchangeRateInput struct {
+	Base          string  `json:"base"`
+	Quote         string  `json:"quote"`
+	Rate          float64 `json:"rate"`           // units of quote per unit of base
+	EffectiveDate string  `json:"effective_date"` // YYYY-MM-DD
+}
+
+// ExchangeRateOn returns the number of units of to that one unit of from buys
+// on the given date. The most recent rate in effect for the pair is used,
+// whichever direction it was stored in.
+func ExchangeRateOn(db *gorm.DB, from, to string, on time.Time) (float64, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return 1, nil
+	}
+
+	var rate models.ExchangeRate
+	err := db.Where("((base_currency = ? AND quote_currency = ?) OR (base_currency = ? AND quote_currency = ?)) AND effective_date <= ?",
+		from, to, to, from, on).
+		Order("effective_date desc, id desc").First(&rate).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("%w from %s to %s on %s", ErrNoExchangeRate, from, to, on.Format("2006-01-02"))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load exchange rate: %w", err)
+	}
+	if rate.BaseCurrency == from {
+		return rate.Rate, nil
+	}
+	return 1 / rate.Rate, nil
+}
+
+// Convert converts an amount between currencies at the rate in effect on the
+// given date, returning the converted amount and the rate used.
+func Convert(db *gorm.DB, amount float64, from, to string, on time.Time) (float64, float64, error) {
+	rate, err := ExchangeRateOn(db, from, to, on)
+	if err != nil {
+		return 0, 0, err
+	}
+	return roundCents(amount * rate), rate, nil
+}
+
+// SaveExchangeRates validates and stores exchange rates. A rate for a pair
+// and date that is already stored is replaced.
+func SaveExchangeRates(db *gorm.DB, inputs []ExchangeRateInput, source string) (int, error) {
+	rates := make([]models.ExchangeRate, 0, len(inputs))
+	for i, input := range inputs {
+		base, quote := strings.ToUpper(input.Base), strings.ToUpper(input.Quote)
+		if len(base) != 3 || len(quote) != 3 || base == quote {
+			return 0, fmt.Errorf("exchange rate %d: base and quote must be two different three-letter currency codes", i+1)
+		}
+		if input.Rate <= 0 {
+			return 0, fmt.Errorf("exchange rate %d: rate must be positive", i+1)
+		}
+		date, err := time.Parse("2006-01-02", input.EffectiveDate)
+		if err != nil {
+			return 0, fmt.Errorf("exchange rate %d: effective_date must be YYYY-MM-DD", i+1)
+		}
+		rates = append(rates, models.ExchangeRate{BaseCurrency: base, QuoteCurrency: quote, EffectiveDate: date, Rate: input.Rate, Source: source})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range rates {
+			rate := &rates[i]
+			var existing models.ExchangeRate
+			err := tx.Where("base_currency = ? AND quote_currency = ? AND effective_date = ?", rate.BaseCurrency, rate.QuoteCurrency, rate.EffectiveDate).
+				First(&existing).Error
+			if err == nil {
+				rate.ID = existing.ID
+				rate.CreatedAt = existing.CreatedAt
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if err := tx.Save(rate).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to save exchange rates: %w", err)
+	}
+	return len(rates), nil
+}
+
+// LoadExchangeRates stores the rates in a JSON file holding an array of
+// ExchangeRateInput.
+func LoadExchangeRates(db *gorm.DB, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read exchange rates: %w", err)
+	}
+	var inputs []ExchangeRateInput
+	if err := json.Unmarshal(data, &inputs); err != nil {
+		return 0, fmt.Errorf("malformed exchange rates in %s: %w", path, err)
+	}
+	return SaveExchangeRates(db, inputs, "file")
+}
+
+// convertPayment restates a payment taken in another currency than its
+// invoice in the invoice currency, keeping what was charged and the rate
+// used. The journal and credit balances only ever see the invoice currency.
+func convertPayment(db *gorm.DB, payment *models.Payment, invoiceCurrency string) error {
+	if payment.Currency == "" || strings.EqualFold(payment.Currency, invoiceCurrency) {
+		payment.Currency = invoiceCurrency
+		return nil
+	}
+	amount, rate, err := Convert(db, payment.Amount, payment.Currency, invoiceCurrency, payment.PaymentDate)
+	if err != nil {
+		return err
+	}
+	payment.ChargedAmount = payment.Amount
+	payment.ChargedCurrency = strings.ToUpper(payment.Currency)
+	payment.ExchangeRate = rate
+	payment.Amount = amount
+	payment.Currency = invoiceCurrency
+	return nil
+}
+
+// ChargedAmount converts an amount of a payment's (invoice) currency into the
+// currency it was charged in, at the rate recorded on the payment.
+func ChargedAmount(payment *models.Payment, amount float64) float64 {
+	if payment.ExchangeRate == 0 {
+		return amount
+	}
+	return roundCents(amount / payment.ExchangeRate)
+}
+
+// paymentAmount converts an amount reported by the provider in the charge
+// currency into the payment's (invoice) currency.
+func paymentAmount(payment *models.Payment, amount float64, currency string) float64 {
+	if payment.ExchangeRate == 0 || !strings.EqualFold(currency, payment.ChargedCurrency) {
+		return amount
+	}
+	return roundCents(amount * payment.ExchangeRate)
+}
@@ -0,0 +1,82 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExchangeRateOnUsesLatestRateInEitherDirection(t *testing.T) {
+	db, _ := setupBillingDB(t)
+	_, err := SaveExchangeRates(db, []ExchangeRateInput{
+		{Base: "eur", Quote: "usd", Rate: 1.10, EffectiveDate: "2024-01-01"},
+		{Base: "EUR", Quote: "USD", Rate: 1.20, EffectiveDate: "2024-03-01"},
+	}, "file")
+	assert.NoError(t, err)
+
+	rate, err := ExchangeRateOn(db, "EUR", "USD", time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 1.10, rate)
+
+	rate, err = ExchangeRateOn(db, "USD", "EUR", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.InDelta(t, 1/1.20, rate, 1e-9)
+
+	_, err = ExchangeRateOn(db, "EUR", "USD", time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC))
+	assert.True(t, errors.Is(err, ErrNoExchangeRate))
+	_, err = ExchangeRateOn(db, "EUR", "GBP", time.Now())
+	assert.True(t, errors.Is(err, ErrNoExchangeRate))
+
+	// Saving a rate for the same pair and date replaces it.
+	_, err = SaveExchangeRates(db, []ExchangeRateInput{{Base: "EUR", Quote: "USD", Rate: 1.25, EffectiveDate: "2024-03-01"}}, "api")
+	assert.NoError(t, err)
+	var count int64
+	db.Model(&models.ExchangeRate{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	_, err = SaveExchangeRates(db, []ExchangeRateInput{{Base: "EUR", Quote: "EUR", Rate: 1, EffectiveDate: "2024-03-01"}}, "api")
+	assert.Error(t, err)
+}
+
+func TestCollectInvoiceInAnotherCurrency(t *testing.T) {
+	db, org := setupBillingDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorSucceed)
+	today := time.Now().Format("2006-01-02")
+	_, err := SaveExchangeRates(db, []ExchangeRateInput{{Base: "USD", Quote: "EUR", Rate: 0.9, EffectiveDate: today}}, "api")
+	assert.NoError(t, err)
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 90, Currency: "EUR", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	// Paying in a currency without a rate is refused before charging.
+	_, err = CollectInvoice(context.Background(), db, provider, &invoice, CollectParams{Currency: "GBP", Token: "pm_card_visa"})
+	assert.True(t, errors.Is(err, ErrNoExchangeRate))
+
+	collection, err := CollectInvoice(context.Background(), db, provider, &invoice, CollectParams{Currency: "USD", Token: "pm_card_visa"})
+	assert.NoError(t, err)
+	payment := collection.Payment
+	assert.Equal(t, 100.0, payment.ChargedAmount)
+	assert.Equal(t, "USD", payment.ChargedCurrency)
+	assert.Equal(t, 0.9, payment.ExchangeRate)
+	assert.Equal(t, 90.0, payment.Amount)
+	assert.Equal(t, "EUR", payment.Currency)
+	assert.True(t, invoice.Paid)
+	assert.Nil(t, collection.Credit)
+	assert.Equal(t, 100.0, ChargedAmount(payment, 90))
+
+	// The journal only ever sees the invoice currency.
+	issues, err := CheckLedger(db, org.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+	rows, err := TrialBalance(db, org.ID)
+	assert.NoError(t, err)
+	for _, row := range rows {
+		assert.Equal(t, "EUR", row.Currency)
+	}
+}
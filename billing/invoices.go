@@ -0,0 +1,71 @@
+package billing
+
+import (
+	"fmt"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AmountDue is what remains to be paid on an invoice after credits.
+func AmountDue(invoice *models.Invoice) float64 {
+	return roundCents(invoice.Amount - invoice.CreditApplied)
+}
+
+// FinalizeInvoice numbers a new invoice, calculates its tax, persists it,
+// books it in the journal and settles it from the organization's credit
+// balance. It should run inside the same transaction as the state change
+// that produced the invoice.
+func FinalizeInvoice(tx *gorm.DB, invoice *models.Invoice) error {
+	now := time.Now()
+	invoice.Amount = roundCents(invoice.Amount)
+	if err := ApplyTax(tx, invoice); err != nil {
+		return err
+	}
+
+	issued := invoice.IssueDate
+	if issued.IsZero() {
+		issued = now
+	}
+	number, err := NextInvoiceNumber(tx, invoice.OrganizationID, issued)
+	if err != nil {
+		return err
+	}
+	invoice.Number = &number
+
+	if err := tx.Create(invoice).Error; err != nil {
+		return fmt.Errorf("failed to create invoice: %w", err)
+	}
+	if err := RecordInvoice(tx, invoice); err != nil {
+		return err
+	}
+	if err := ScheduleRevenue(tx, invoice); err != nil {
+		return err
+	}
+
+	if _, err := ExpireCredits(tx, invoice.OrganizationID, now); err != nil {
+		return err
+	}
+	if _, err := ApplyCredit(tx, invoice, now); err != nil {
+		return err
+	}
+
+	if AmountDue(invoice) <= 0 {
+		invoice.Paid = true
+	}
+	if err := tx.Omit(clause.Associations).Save(invoice).Error; err != nil {
+		return fmt.Errorf("failed to update invoice: %w", err)
+	}
+
+	if err := events.Publish(tx, invoice.OrganizationID, events.InvoiceCreated, invoice); err != nil {
+		return err
+	}
+	if invoice.Paid {
+		return events.Publish(tx, invoice.OrganizationID, events.InvoicePaid, invoice)
+	}
+	return nil
+}
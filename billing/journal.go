@@ -0,0 +1,307 @@
+package billing
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AccountReceivable = "accounts_receivable"
+	AccountRevenue    = "revenue"
+	AccountCash       = "cash"
+	AccountRefunds    = "refunds"
+	AccountCredits    = "customer_credits"
+	AccountBadDebt    = "bad_debt"
+	AccountDisputed   = "disputed_funds" // funds held by the provider while a dispute is open
+	AccountDisputeFee = "dispute_fees"
+	AccountTaxPayable = "tax_payable" // tax collected on behalf of tax authorities
+)
+
+const (
+	SourceInvoice = "invoice"
+	SourcePayment = "payment"
+	SourceRefund  = "refund"
+	SourceCredit  = "credit"
+	SourceDispute = "dispute"
+)
+
+// balanceTolerance absorbs float rounding when comparing debits and credits.
+const balanceTolerance = 0.005
+
+func debit(account, currency string, amount float64) models.JournalLine {
+	return models.JournalLine{Account: account, Currency: currency, Debit: roundCents(amount)}
+}
+
+func credit(account, currency string, amount float64) models.JournalLine {
+	return models.JournalLine{Account: account, Currency: currency, Credit: roundCents(amount)}
+}
+
+// PostJournalEntry writes a balanced journal entry. Zero-value lines are
+// dropped, and an entry left without lines is not written at all.
+func PostJournalEntry(tx *gorm.DB, organizationID uint, sourceType string, sourceID uint, description string, lines []models.JournalLine) error {
+	kept := make([]models.JournalLine, 0, len(lines))
+	totals := map[string]float64{}
+	for _, line := range lines {
+		if line.Debit == 0 && line.Credit == 0 {
+			continue
+		}
+		if line.Debit < 0 || line.Credit < 0 {
+			return fmt.Errorf("journal line for %s has a negative amount", line.Account)
+		}
+		line.OrganizationID = organizationID
+		totals[line.Currency] += line.Debit - line.Credit
+		kept = append(kept, line)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	for currency, diff := range totals {
+		if math.Abs(diff) > balanceTolerance {
+			return fmt.Errorf("journal entry for %s %d is unbalanced by %.2f %s", sourceType, sourceID, diff, currency)
+		}
+	}
+
+	entry := models.JournalEntry{
+		OrganizationID: organizationID,
+		SourceType:     sourceType,
+		SourceID:       sourceID,
+		Description:    description,
+		PostedAt:       time.Now(),
+		Lines:          kept,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to post journal entry: %w", err)
+	}
+	return nil
+}
+
+// RecordInvoice books a finalized invoice as a receivable earned as revenue,
+// with any tax it carries owed to the tax authorities.
+func RecordInvoice(tx *gorm.DB, invoice *models.Invoice) error {
+	return PostJournalEntry(tx, invoice.OrganizationID, SourceInvoice, invoice.ID,
+		fmt.Sprintf("Invoice %d finalized", invoice.ID),
+		[]models.JournalLine{
+			debit(AccountReceivable, invoice.Currency, invoice.Amount),
+			credit(AccountRevenue, invoice.Currency, invoice.Amount-invoice.TaxAmount),
+			credit(AccountTaxPayable, invoice.Currency, invoice.TaxAmount),
+		})
+}
+
+// RecordPayment books cash received against an invoice. Any amount beyond
+// what was receivable is held as customer credit.
+func RecordPayment(tx *gorm.DB, invoice *models.Invoice, payment *models.Payment, settled float64) error {
+	return PostJournalEntry(tx, invoice.OrganizationID, SourcePayment, payment.ID,
+		fmt.Sprintf("Payment %d on invoice %d", payment.ID, invoice.ID),
+		[]models.JournalLine{
+			debit(AccountCash, invoice.Currency, payment.Amount),
+			credit(AccountReceivable, invoice.Currency, settled),
+			credit(AccountCredits, invoice.Currency, payment.Amount-settled),
+		})
+}
+
+// RecordRefund books cash returned to the customer as a reduction of revenue.
+// The refund's share of the invoice's tax is no longer owed, so it reverses
+// tax payable rather than revenue.
+func RecordRefund(tx *gorm.DB, organizationID uint, refund *models.Refund) error {
+	var invoice models.Invoice
+	if err := tx.Select("id", "amount", "tax_amount").First(&invoice, refund.InvoiceID).Error; err != nil {
+		return fmt.Errorf("failed to load refunded invoice: %w", err)
+	}
+	tax := 0.0
+	if invoice.Amount > 0 {
+		tax = roundCents(math.Min(refund.Amount, invoice.Amount) * invoice.TaxAmount / invoice.Amount)
+	}
+	return PostJournalEntry(tx, organizationID, SourceRefund, refund.ID,
+		fmt.Sprintf("Refund %d of payment %d", refund.ID, refund.PaymentID),
+		[]models.JournalLine{
+			debit(AccountRefunds, refund.Currency, refund.Amount-tax),
+			debit(AccountTaxPayable, refund.Currency, tax),
+			credit(AccountCash, refund.Currency, refund.Amount),
+		})
+}
+
+// RecordCreditGrant books credit granted without cash changing hands, such as
+// goodwill or downgrade credit, as a reduction of revenue.
+func RecordCreditGrant(tx *gorm.DB, entry *models.CreditEntry) error {
+	return PostJournalEntry(tx, entry.OrganizationID, SourceCredit, entry.ID, entry.Description,
+		[]models.JournalLine{
+			debit(AccountRevenue, entry.Currency, entry.Amount),
+			credit(AccountCredits, entry.Currency, entry.Amount),
+		})
+}
+
+type TrialBalanceRow struct {
+	Account  string  `json:"account"`
+	Currency string  `json:"currency"`
+	Debit    float64 `json:"debit"`
+	Credit   float64 `json:"credit"`
+	Balance  float64 `json:"balance"` // debit minus credit
+}
+
+// TrialBalance totals every account of the organization per currency.
+func TrialBalance(db *gorm.DB, organizationID uint) ([]TrialBalanceRow, error) {
+	var rows []TrialBalanceRow
+	err := db.Model(&models.JournalLine{}).
+		Select("account, currency, SUM(debit) AS debit, SUM(credit) AS credit").
+		Where("organization_id = ?", organizationID).
+		Group("account, currency").
+		Order("currency, account").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to total journal lines: %w", err)
+	}
+	for i := range rows {
+		rows[i].Debit = roundCents(rows[i].Debit)
+		rows[i].Credit = roundCents(rows[i].Credit)
+		rows[i].Balance = roundCents(rows[i].Debit - rows[i].Credit)
+	}
+	return rows, nil
+}
+
+// Balanced reports whether the debits and credits of a trial balance agree
+// in every currency.
+func Balanced(rows []TrialBalanceRow) bool {
+	totals := map[string]float64{}
+	for _, row := range rows {
+		totals[row.Currency] += row.Balance
+	}
+	for _, total := range totals {
+		if math.Abs(total) > balanceTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+type LedgerIssue struct {
+	Check    string  `json:"check"`
+	Currency string  `json:"currency"`
+	Expected float64 `json:"expected"`
+	Actual   float64 `json:"actual"`
+	Detail   string  `json:"detail"`
+}
+
+// CheckLedger verifies the journal invariants for an organization: every
+// entry balances, the books balance per currency, and the receivable and
+// credit accounts agree with the invoice and credit tables they mirror.
+func CheckLedger(db *gorm.DB, organizationID uint) ([]LedgerIssue, error) {
+	issues := []LedgerIssue{}
+
+	var unbalanced []struct {
+		JournalEntryID uint
+		Currency       string
+		Diff           float64
+	}
+	err := db.Model(&models.JournalLine{}).
+		Select("journal_entry_id, currency, SUM(debit) - SUM(credit) AS diff").
+		Where("organization_id = ?", organizationID).
+		Group("journal_entry_id, currency").
+		Scan(&unbalanced).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check journal entries: %w", err)
+	}
+	for _, row := range unbalanced {
+		if math.Abs(row.Diff) > balanceTolerance {
+			issues = append(issues, LedgerIssue{
+				Check:    "entry_balanced",
+				Currency: row.Currency,
+				Actual:   roundCents(row.Diff),
+				Detail:   fmt.Sprintf("journal entry %d does not balance", row.JournalEntryID),
+			})
+		}
+	}
+
+	rows, err := TrialBalance(db, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	totals := map[string]float64{}
+	accounts := map[string]map[string]float64{}
+	for _, row := range rows {
+		totals[row.Currency] += row.Balance
+		if accounts[row.Account] == nil {
+			accounts[row.Account] = map[string]float64{}
+		}
+		accounts[row.Account][row.Currency] = row.Balance
+	}
+	for _, currency := range sortedKeys(totals) {
+		if math.Abs(totals[currency]) > balanceTolerance {
+			issues = append(issues, LedgerIssue{
+				Check:    "trial_balance",
+				Currency: currency,
+				Actual:   roundCents(totals[currency]),
+				Detail:   "total debits and credits differ",
+			})
+		}
+	}
+
+	var receivables []struct {
+		Currency string
+		Total    float64
+	}
+	err = db.Model(&models.Invoice{}).
+		Select("currency, SUM(amount - credit_applied) AS total").
+		Where("organization_id = ? AND paid = ? AND uncollectible = ?", organizationID, false, false).
+		Group("currency").
+		Scan(&receivables).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to total open invoices: %w", err)
+	}
+	expectedAR := map[string]float64{}
+	for _, row := range receivables {
+		expectedAR[row.Currency] = roundCents(row.Total)
+	}
+
+	balances, err := CreditBalances(db, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	issues = append(issues, compareAccount(AccountReceivable, accounts[AccountReceivable], expectedAR, 1)...)
+	issues = append(issues, compareAccount(AccountCredits, accounts[AccountCredits], balances, -1)...)
+	return issues, nil
+}
+
+// compareAccount flags currencies where an account balance differs from the
+// expected subledger totals. sign is 1 for debit-normal accounts and -1 for
+// credit-normal ones.
+func compareAccount(account string, balances, expected map[string]float64, sign float64) []LedgerIssue {
+	currencies := map[string]float64{}
+	for currency, balance := range balances {
+		currencies[currency] = sign * balance
+	}
+	for currency := range expected {
+		if _, ok := currencies[currency]; !ok {
+			currencies[currency] = 0
+		}
+	}
+
+	var issues []LedgerIssue
+	for _, currency := range sortedKeys(currencies) {
+		if math.Abs(currencies[currency]-expected[currency]) > balanceTolerance {
+			issues = append(issues, LedgerIssue{
+				Check:    account + "_reconciled",
+				Currency: currency,
+				Expected: expected[currency],
+				Actual:   roundCents(currencies[currency]),
+				Detail:   fmt.Sprintf("%s does not match its subledger", account),
+			})
+		}
+	}
+	return issues
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
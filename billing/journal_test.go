@@ -0,0 +1,93 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostJournalEntryRejectsUnbalancedEntry(t *testing.T) {
+	db, org := setupBillingDB(t)
+
+	err := PostJournalEntry(db, org.ID, SourceInvoice, 1, "Broken entry", []models.JournalLine{
+		debit(AccountReceivable, "USD", 10),
+		credit(AccountRevenue, "USD", 9),
+	})
+	assert.Error(t, err)
+
+	var count int64
+	db.Model(&models.JournalEntry{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestLedgerReconcilesInvoicePaymentAndRefund(t *testing.T) {
+	db, org := setupBillingDB(t)
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	// Pay 120 against 100 due; the excess becomes customer credit.
+	payment := models.Payment{InvoiceID: invoice.ID, Amount: 120, Currency: "USD", PaymentDate: time.Now(), TransactionID: "txn_1"}
+	assert.NoError(t, db.Create(&payment).Error)
+	invoice.Paid = true
+	assert.NoError(t, db.Save(&invoice).Error)
+	_, err := GrantCredit(db, org.ID, 20, "USD", "Overpayment", nil, &invoice.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, RecordPayment(db, &invoice, &payment, 100))
+
+	refund := models.Refund{InvoiceID: invoice.ID, PaymentID: payment.ID, Amount: 30, Currency: "USD", RefundDate: time.Now(), TransactionID: "ref_1"}
+	assert.NoError(t, db.Create(&refund).Error)
+	assert.NoError(t, RecordRefund(db, org.ID, &refund))
+
+	rows, err := TrialBalance(db, org.ID)
+	assert.NoError(t, err)
+	balances := map[string]float64{}
+	for _, row := range rows {
+		balances[row.Account] = row.Balance
+	}
+	assert.Equal(t, 0.0, balances[AccountReceivable])
+	assert.Equal(t, -100.0, balances[AccountRevenue])
+	assert.Equal(t, 90.0, balances[AccountCash])
+	assert.Equal(t, 30.0, balances[AccountRefunds])
+	assert.Equal(t, -20.0, balances[AccountCredits])
+	assert.True(t, Balanced(rows))
+	assert.False(t, Balanced(append(rows, TrialBalanceRow{Account: AccountCash, Currency: "USD", Balance: 0.01})))
+
+	issues, err := CheckLedger(db, org.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+
+	// An invoice that bypasses the journal is flagged by the receivable check.
+	unbooked := models.Invoice{OrganizationID: org.ID, Amount: 40, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, db.Create(&unbooked).Error)
+
+	issues, err = CheckLedger(db, org.ID)
+	assert.NoError(t, err)
+	assert.Len(t, issues, 1)
+	assert.Equal(t, AccountReceivable+"_reconciled", issues[0].Check)
+	assert.Equal(t, 40.0, issues[0].Expected)
+}
+
+func TestRefundReversesItsShareOfTax(t *testing.T) {
+	db, org := setupBillingDB(t)
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 120, TaxAmount: 20, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, db.Create(&invoice).Error)
+	assert.NoError(t, RecordInvoice(db, &invoice))
+	refund := models.Refund{InvoiceID: invoice.ID, Amount: 60, Currency: "USD", RefundDate: time.Now(), TransactionID: "ref_1"}
+	assert.NoError(t, db.Create(&refund).Error)
+	assert.NoError(t, RecordRefund(db, org.ID, &refund))
+
+	rows, err := TrialBalance(db, org.ID)
+	assert.NoError(t, err)
+	balances := map[string]float64{}
+	for _, row := range rows {
+		balances[row.Account] = row.Balance
+	}
+	assert.Equal(t, 50.0, balances[AccountRefunds])
+	assert.Equal(t, -10.0, balances[AccountTaxPayable]) // half the tax is still owed
+	assert.Equal(t, -60.0, balances[AccountCash])
+}
@@ -0,0 +1,233 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultInvoicePrefix       = "INV"
+	DefaultInvoiceNumberFormat = "{prefix}-{year}-{seq:6}"
+)
+
+// seqToken matches the sequence placeholder of an invoice number format and
+// its optional zero-padded width.
+var seqToken = regexp.MustCompile(`\{seq(?::(\d+))?\}`)
+
+// ValidateInvoiceNumberFormat checks that a format has exactly one sequence
+// placeholder. Formats may also use {prefix}, {year} and {month}.
+func ValidateInvoiceNumberFormat(format string) error {
+	matches := seqToken.FindAllStringSubmatch(format, -1)
+	if len(matches) != 1 {
+		return errors.New("invoice number format must contain exactly one {seq} placeholder")
+	}
+	if strings.Contains(format, "{month}") && !strings.Contains(format, "{year}") {
+		return errors.New("invoice number format with {month} must also contain {year}")
+	}
+	if width := matches[0][1]; width != "" {
+		if n, _ := strconv.Atoi(width); n < 1 || n > 12 {
+			return errors.New("invoice number sequence width must be between 1 and 12")
+		}
+	}
+	return nil
+}
+
+// FormatInvoiceNumber renders the nth invoice number of a period.
+func FormatInvoiceNumber(format, prefix string, issued time.Time, n int64) string {
+	number := strings.NewReplacer(
+		"{prefix}", prefix,
+		"{year}", fmt.Sprintf("%04d", issued.Year()),
+		"{month}", fmt.Sprintf("%02d", int(issued.Month())),
+	).Replace(format)
+	return seqToken.ReplaceAllStringFunc(number, func(token string) string {
+		width, _ := strconv.Atoi(seqToken.FindStringSubmatch(token)[1])
+		return fmt.Sprintf("%0*d", width, n)
+	})
+}
+
+// numberingPeriod is the span a sequence counts within. Numbers restart each
+// year or month when the format includes it, so that they stay unique.
+func numberingPeriod(format string, issued time.Time) string {
+	switch {
+	case strings.Contains(format, "{month}"):
+		return issued.Format("2006-01")
+	case strings.Contains(format, "{year}"):
+		return issued.Format("2006")
+	}
+	return ""
+}
+
+// InvoiceNumber returns the invoice's number, falling back to its ID for
+// invoices issued before numbering was introduced.
+func InvoiceNumber(invoice *models.Invoice) string {
+	if invoice.Number != nil && *invoice.Number != "" {
+		return *invoice.Number
+	}
+	return strconv.FormatUint(uint64(invoice.ID), 10)
+}
+
+// NextInvoiceNumber takes the next number from the organization's sequence.
+// It must run in the transaction that creates the invoice: the sequence row
+// stays locked until the transaction ends, and a rollback returns the number,
+// so numbers are issued in order without gaps.
+func NextInvoiceNumber(tx *gorm.DB, organizationID uint, issued time.Time) (string, error) {
+	prefix, format, err := invoiceNumbering(tx, organizationID)
+	if err != nil {
+		return "", err
+	}
+	n, err := nextInSequence(tx, organizationID, numberingPeriod(format, issued))
+	if err != nil {
+		return "", err
+	}
+	return FormatInvoiceNumber(format, prefix, issued, n), nil
+}
+
+// ReserveInvoiceNumber advances the organization's sequence past an invoice
+// number assigned elsewhere, such as one imported from another billing
+// system, so that NextInvoiceNumber does not issue it again. Numbers that do
+// not follow the organization's format cannot collide and are left alone.
+func ReserveInvoiceNumber(tx *gorm.DB, organizationID uint, number string) error {
+	prefix, format, err := invoiceNumbering(tx, organizationID)
+	if err != nil {
+		return err
+	}
+	period, n, ok := parseInvoiceNumber(format, prefix, number)
+	if !ok {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.InvoiceSequence{}).Where("organization_id = ? AND period = ?", organizationID, period).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to read sequence: %w", err)
+	}
+	if count == 0 {
+		// As in nextInSequence, a concurrent transaction may start the
+		// sequence first, in which case it is raised instead.
+		err := tx.Transaction(func(nested *gorm.DB) error {
+			return nested.Create(&models.InvoiceSequence{OrganizationID: organizationID, Period: period, LastNumber: n}).Error
+		})
+		if err == nil {
+			return nil
+		}
+	}
+	err = tx.Model(&models.InvoiceSequence{}).
+		Where("organization_id = ? AND period = ? AND last_number < ?", organizationID, period, n).
+		Update("last_number", n).Error
+	if err != nil {
+		return fmt.Errorf("failed to advance sequence: %w", err)
+	}
+	return nil
+}
+
+// invoiceNumbering returns the prefix and format the organization numbers
+// its invoices with.
+func invoiceNumbering(tx *gorm.DB, organizationID uint) (prefix, format string, err error) {
+	var org models.Organization
+	if err := tx.First(&org, organizationID).Error; err != nil {
+		return "", "", fmt.Errorf("failed to load organization %d: %w", organizationID, err)
+	}
+	prefix, format = org.InvoicePrefix, org.InvoiceNumberFormat
+	if prefix == "" {
+		prefix = DefaultInvoicePrefix
+	}
+	if format == "" {
+		format = DefaultInvoiceNumberFormat
+	}
+	return prefix, format, nil
+}
+
+// numberToken matches every placeholder of an invoice number format.
+var numberToken = regexp.MustCompile(`\{(?:prefix|year|month|seq(?::\d+)?)\}`)
+
+// parseInvoiceNumber reads the numbering period and sequence number of a
+// number rendered by FormatInvoiceNumber, reporting false for numbers the
+// format could not have produced.
+func parseInvoiceNumber(format, prefix, number string) (period string, n int64, ok bool) {
+	var pattern strings.Builder
+	var groups []string
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range numberToken.FindAllStringIndex(format, -1) {
+		pattern.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		switch token := format[loc[0]:loc[1]]; token {
+		case "{prefix}":
+			pattern.WriteString(regexp.QuoteMeta(prefix))
+		case "{year}":
+			pattern.WriteString(`(\d{4})`)
+			groups = append(groups, "year")
+		case "{month}":
+			pattern.WriteString(`(\d{2})`)
+			groups = append(groups, "month")
+		default:
+			pattern.WriteString(`(\d+)`)
+			groups = append(groups, "seq")
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]) + "$")
+
+	match := regexp.MustCompile(pattern.String()).FindStringSubmatch(number)
+	if match == nil {
+		return "", 0, false
+	}
+	var year, month int
+	for i, group := range groups {
+		switch group {
+		case "year":
+			year, _ = strconv.Atoi(match[i+1])
+		case "month":
+			month, _ = strconv.Atoi(match[i+1])
+		case "seq":
+			var err error
+			if n, err = strconv.ParseInt(match[i+1], 10, 64); err != nil {
+				return "", 0, false
+			}
+		}
+	}
+	if month < 0 || month > 12 || (strings.Contains(format, "{month}") && month == 0) {
+		return "", 0, false
+	}
+	if month == 0 {
+		month = 1
+	}
+	return numberingPeriod(format, time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)), n, true
+}
+
+// nextInSequence advances the organization's counter for a period and
+// returns the new value.
+func nextInSequence(tx *gorm.DB, organizationID uint, period string) (int64, error) {
+	for attempt := 0; ; attempt++ {
+		result := tx.Model(&models.InvoiceSequence{}).
+			Where("organization_id = ? AND period = ?", organizationID, period).
+			Update("last_number", gorm.Expr("last_number + 1"))
+		if result.Error != nil {
+			return 0, fmt.Errorf("failed to advance sequence: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			break
+		}
+
+		// First number of the period. A concurrent transaction may create
+		// the row first, in which case the unique index rejects this one and
+		// the update above is retried.
+		err := tx.Transaction(func(nested *gorm.DB) error {
+			return nested.Create(&models.InvoiceSequence{OrganizationID: organizationID, Period: period}).Error
+		})
+		if err != nil && attempt > 0 {
+			return 0, fmt.Errorf("failed to start sequence: %w", err)
+		}
+	}
+
+	var sequence models.InvoiceSequence
+	if err := tx.Where("organization_id = ? AND period = ?", organizationID, period).First(&sequence).Error; err != nil {
+		return 0, fmt.Errorf("failed to read sequence: %w", err)
+	}
+	return sequence.LastNumber, nil
+}
@@ -0,0 +1,78 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestFormatInvoiceNumber(t *testing.T) {
+	issued := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "ACME-2026-000123", FormatInvoiceNumber(DefaultInvoiceNumberFormat, "ACME", issued, 123))
+	assert.Equal(t, "2026/03/7", FormatInvoiceNumber("{year}/{month}/{seq}", "", issued, 7))
+
+	assert.NoError(t, ValidateInvoiceNumberFormat("{prefix}{seq:4}"))
+	assert.Error(t, ValidateInvoiceNumberFormat("{prefix}-{year}"))
+	assert.Error(t, ValidateInvoiceNumberFormat("{seq}-{seq}"))
+	assert.Error(t, ValidateInvoiceNumberFormat("{month}-{seq}"))
+	assert.Error(t, ValidateInvoiceNumberFormat("{seq:40}"))
+}
+
+func TestInvoiceNumbersAreSequentialPerOrganization(t *testing.T) {
+	db, org := setupBillingDB(t)
+	other := models.Organization{Name: "Other Org", BillingEmail: "billing@other.org", InvoicePrefix: "OTH", InvoiceNumberFormat: "{prefix}{seq:3}"}
+	assert.NoError(t, db.Create(&other).Error)
+
+	finalize := func(orgID uint, issued time.Time) *models.Invoice {
+		invoice := models.Invoice{OrganizationID: orgID, Amount: 10, Currency: "USD", IssueDate: issued, DueDate: issued}
+		assert.NoError(t, FinalizeInvoice(db, &invoice))
+		return &invoice
+	}
+
+	lastYear := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+	thisYear := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "INV-2025-000001", *finalize(org.ID, lastYear).Number)
+	assert.Equal(t, "INV-2026-000001", *finalize(org.ID, thisYear).Number)
+	assert.Equal(t, "OTH001", *finalize(other.ID, thisYear).Number)
+	assert.Equal(t, "INV-2026-000002", *finalize(org.ID, thisYear).Number)
+
+	// A rolled back finalization gives its number back.
+	err := db.Transaction(func(tx *gorm.DB) error {
+		invoice := models.Invoice{OrganizationID: org.ID, Amount: 10, Currency: "USD", IssueDate: thisYear, DueDate: thisYear}
+		assert.NoError(t, FinalizeInvoice(tx, &invoice))
+		assert.Equal(t, "INV-2026-000003", *invoice.Number)
+		return errors.New("abort")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "INV-2026-000003", *finalize(org.ID, thisYear).Number)
+	assert.Equal(t, "OTH002", *finalize(other.ID, lastYear).Number)
+}
+
+func TestReserveInvoiceNumber(t *testing.T) {
+	db, org := setupBillingDB(t)
+	other := models.Organization{Name: "Other Org", BillingEmail: "billing@other.org", InvoicePrefix: "OTH", InvoiceNumberFormat: "{prefix}/{year}/{month}/{seq}"}
+	assert.NoError(t, db.Create(&other).Error)
+
+	finalize := func(orgID uint, issued time.Time) string {
+		invoice := models.Invoice{OrganizationID: orgID, Amount: 10, Currency: "USD", IssueDate: issued, DueDate: issued}
+		assert.NoError(t, FinalizeInvoice(db, &invoice))
+		return *invoice.Number
+	}
+	issued := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, ReserveInvoiceNumber(db, org.ID, "INV-2026-000007"))
+	assert.NoError(t, ReserveInvoiceNumber(db, org.ID, "INV-2026-000004")) // never moves back
+	assert.NoError(t, ReserveInvoiceNumber(db, org.ID, "OLD-12"))          // another format
+	assert.Equal(t, "INV-2026-000008", finalize(org.ID, issued))
+	assert.Equal(t, "INV-2025-000001", finalize(org.ID, issued.AddDate(-1, 0, 0)))
+
+	assert.NoError(t, ReserveInvoiceNumber(db, other.ID, "OTH/2026/03/41"))
+	assert.NoError(t, ReserveInvoiceNumber(db, other.ID, "OTH/2026/13/99"))
+	assert.Equal(t, "OTH/2026/03/42", finalize(other.ID, issued))
+	assert.Equal(t, "OTH/2026/04/1", finalize(other.ID, issued.AddDate(0, 1, 0)))
+}
@@ -0,0 +1,438 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	EventProcessed = "processed"
+	EventIgnored   = "ignored"
+)
+
+// ErrDuplicateEvent is returned for an event that has already been handled.
+var ErrDuplicateEvent = errors.New("event already processed")
+
+// HandleProviderEvent applies an event posted by the payment provider to the
+// payments, refunds and invoices it concerns, and records the event so that
+// redeliveries are ignored. Events about unknown charges are recorded as
+// ignored rather than failing, since the provider would otherwise keep
+// retrying them.
+func HandleProviderEvent(tx *gorm.DB, provider string, event *payments.Event, now time.Time) (*models.ProviderEvent, error) {
+	var existing models.ProviderEvent
+	err := tx.Where("event_id = ?", event.ID).First(&existing).Error
+	if err == nil {
+		return &existing, ErrDuplicateEvent
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to look up event %s: %w", event.ID, err)
+	}
+
+	record := models.ProviderEvent{
+		Provider:   provider,
+		EventID:    event.ID,
+		Type:       event.Type,
+		Outcome:    EventProcessed,
+		ReceivedAt: now,
+	}
+
+	switch event.Type {
+	case payments.EventPaymentSucceeded:
+		record.Detail, err = paymentSucceeded(tx, provider, event, now)
+	case payments.EventPaymentFailed:
+		record.Detail, err = paymentFailed(tx, event)
+	case payments.EventPaymentRefunded:
+		record.Detail, err = paymentRefunded(tx, provider, event, now)
+	case payments.EventPaymentDisputed:
+		record.Detail, err = paymentDisputed(tx, event)
+	case payments.EventDisputeClosed:
+		record.Detail, err = disputeClosed(tx, event, now)
+	default:
+		record.Outcome = EventIgnored
+		record.Detail = "unhandled event type"
+	}
+	var ignored *ignoredEvent
+	if errors.As(err, &ignored) {
+		record.Outcome = EventIgnored
+		record.Detail = ignored.reason
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to record event %s: %w", event.ID, err)
+	}
+	return &record, nil
+}
+
+// ignoredEvent is returned by the event handlers when an event does not
+// apply to anything Invoxa knows about.
+type ignoredEvent struct {
+	reason string
+}
+
+func (e *ignoredEvent) Error() string {
+	return e.reason
+}
+
+func ignore(format string, args ...interface{}) error {
+	return &ignoredEvent{reason: fmt.Sprintf(format, args...)}
+}
+
+func paymentByCharge(tx *gorm.DB, chargeID string) (*models.Payment, error) {
+	if chargeID == "" {
+		return nil, ignore("event has no charge ID")
+	}
+	var payment models.Payment
+	err := tx.Preload("Invoice").Where("provider_charge_id = ?", chargeID).First(&payment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up charge %s: %w", chargeID, err)
+	}
+	return &payment, nil
+}
+
+func setProviderStatus(tx *gorm.DB, payment *models.Payment, status string) error {
+	payment.ProviderStatus = status
+	if err := tx.Model(payment).Update("provider_status", status).Error; err != nil {
+		return fmt.Errorf("failed to update payment %d: %w", payment.ID, err)
+	}
+	return nil
+}
+
+// paymentSucceeded confirms a payment Invoxa already knows about, or records
+// a charge that settled asynchronously against the invoice named in the
+// charge metadata.
+func paymentSucceeded(tx *gorm.DB, provider string, event *payments.Event, now time.Time) (string, error) {
+	payment, err := paymentByCharge(tx, event.Data.ChargeID)
+	if err != nil {
+		return "", err
+	}
+	if payment != nil {
+		if payment.ProviderStatus == payments.StatusSucceeded {
+			return "", ignore("payment %d already succeeded", payment.ID)
+		}
+		return fmt.Sprintf("payment %d confirmed", payment.ID), setProviderStatus(tx, payment, payments.StatusSucceeded)
+	}
+
+	if event.Data.InvoiceID == 0 {
+		return "", ignore("charge %s is not linked to an invoice", event.Data.ChargeID)
+	}
+	var invoice models.Invoice
+	if err := tx.First(&invoice, event.Data.InvoiceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ignore("invoice %d not found", event.Data.InvoiceID)
+		}
+		return "", fmt.Errorf("failed to load invoice %d: %w", event.Data.InvoiceID, err)
+	}
+	payment = &models.Payment{
+		InvoiceID:        invoice.ID,
+		UserID:           invoice.UserID,
+		Amount:           roundCents(event.Data.Amount),
+		Currency:         event.Data.Currency,
+		PaymentDate:      eventTime(event, now),
+		TransactionID:    event.Data.ChargeID,
+		Provider:         provider,
+		ProviderChargeID: event.Data.ChargeID,
+		ProviderStatus:   payments.StatusSucceeded,
+	}
+	if _, err := SettleInvoice(tx, &invoice, payment); err != nil {
+		return "", fmt.Errorf("failed to record charge %s: %w", event.Data.ChargeID, err)
+	}
+	if !invoice.Paid {
+		return fmt.Sprintf("payment %d recorded as credit; invoice %d remains open", payment.ID, invoice.ID), nil
+	}
+	return fmt.Sprintf("payment %d recorded; invoice %d paid", payment.ID, invoice.ID), nil
+}
+
+// paymentFailed marks a payment as failed. A payment that had already
+// settled its invoice, such as a returned bank debit, is reversed and the
+// invoice reopened for collection.
+func paymentFailed(tx *gorm.DB, event *payments.Event) (string, error) {
+	payment, err := paymentByCharge(tx, event.Data.ChargeID)
+	if err != nil {
+		return "", err
+	}
+	if payment == nil {
+		// A charge that failed before Invoxa recorded it moved no money.
+		return "", ignore("charge %s was never recorded", event.Data.ChargeID)
+	}
+	if payment.ProviderStatus == payments.StatusFailed {
+		return "", ignore("payment %d already failed", payment.ID)
+	}
+
+	if err := setProviderStatus(tx, payment, payments.StatusFailed); err != nil {
+		return "", err
+	}
+	detail, err := reversePayment(tx, payment)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("payment %d failed (%s); %s", payment.ID, event.Data.FailureCode, detail), nil
+}
+
+// reversePayment undoes the journal entry of a payment that did not go
+// through. The settled portion is returned to receivables and the invoice
+// reopened; overpayment credit is withdrawn as far as it is still unused.
+func reversePayment(tx *gorm.DB, payment *models.Payment) (string, error) {
+	invoice := payment.Invoice
+
+	var booked []models.JournalLine
+	err := tx.Joins("JOIN journal_entries ON journal_entries.id = journal_lines.journal_entry_id").
+		Where("journal_entries.source_type = ? AND journal_entries.source_id = ? AND journal_entries.deleted_at IS NULL", SourcePayment, payment.ID).
+		Find(&booked).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to load journal entries of payment %d: %w", payment.ID, err)
+	}
+	settled, overpaid := 0.0, 0.0
+	for _, line := range booked {
+		switch line.Account {
+		case AccountReceivable:
+			settled += line.Credit - line.Debit
+		case AccountCredits:
+			overpaid += line.Credit - line.Debit
+		}
+	}
+	settled, overpaid = roundCents(settled), roundCents(overpaid)
+
+	withdrawn := 0.0
+	if overpaid > 0 {
+		if withdrawn, err = withdrawOverpayment(tx, &invoice, overpaid); err != nil {
+			return "", err
+		}
+	}
+
+	if settled > 0 {
+		invoice.Paid = false
+		if err := tx.Model(&invoice).Update("paid", false).Error; err != nil {
+			return "", fmt.Errorf("failed to reopen invoice %d: %w", invoice.ID, err)
+		}
+	}
+
+	err = PostJournalEntry(tx, invoice.OrganizationID, SourcePayment, payment.ID,
+		fmt.Sprintf("Payment %d on invoice %d reversed", payment.ID, invoice.ID),
+		[]models.JournalLine{
+			debit(AccountReceivable, invoice.Currency, settled),
+			debit(AccountCredits, invoice.Currency, withdrawn),
+			credit(AccountCash, invoice.Currency, settled+withdrawn),
+		})
+	if err != nil {
+		return "", err
+	}
+	if err := events.Publish(tx, invoice.OrganizationID, events.PaymentFailed, payment); err != nil {
+		return "", err
+	}
+
+	detail := "nothing to reverse"
+	if settled > 0 {
+		detail = fmt.Sprintf("invoice %d reopened", invoice.ID)
+	}
+	if unrecovered := roundCents(overpaid - withdrawn); unrecovered > 0 {
+		detail += fmt.Sprintf("; %.2f of overpayment credit was already used", unrecovered)
+	}
+	return detail, nil
+}
+
+// withdrawOverpayment takes back up to amount of the credit granted for an
+// overpayment on the invoice and returns how much was still available.
+func withdrawOverpayment(tx *gorm.DB, invoice *models.Invoice, amount float64) (float64, error) {
+	// Locked as in ApplyCredit, so that the credit withdrawn cannot also be
+	// spent on an invoice being finalized at the same time.
+	var grants []models.CreditEntry
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("organization_id = ? AND invoice_id = ? AND type = ? AND remaining > 0", invoice.OrganizationID, invoice.ID, CreditGrant).
+		Order("id desc").
+		Find(&grants).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load overpayment credit: %w", err)
+	}
+
+	withdrawn := 0.0
+	for i := range grants {
+		if amount <= 0 {
+			break
+		}
+		grant := &grants[i]
+		take := math.Min(grant.Remaining, amount)
+		remaining := roundCents(grant.Remaining - take)
+		if err := tx.Model(grant).Update("remaining", remaining).Error; err != nil {
+			return 0, fmt.Errorf("failed to update credit grant: %w", err)
+		}
+		reversal := models.CreditEntry{
+			OrganizationID: invoice.OrganizationID,
+			Type:           CreditReversal,
+			Amount:         -take,
+			Currency:       grant.Currency,
+			GrantID:        &grant.ID,
+			InvoiceID:      &invoice.ID,
+			Description:    fmt.Sprintf("Overpayment on invoice %d reversed", invoice.ID),
+		}
+		if err := tx.Create(&reversal).Error; err != nil {
+			return 0, fmt.Errorf("failed to record credit reversal: %w", err)
+		}
+		amount = roundCents(amount - take)
+		withdrawn = roundCents(withdrawn + take)
+	}
+	return withdrawn, nil
+}
+
+// paymentRefunded records a refund issued at the provider, such as one made
+// from the provider's dashboard, or confirms one Invoxa requested.
+func paymentRefunded(tx *gorm.DB, provider string, event *payments.Event, now time.Time) (string, error) {
+	if event.Data.RefundID == "" {
+		return "", ignore("event has no refund ID")
+	}
+
+	var refund models.Refund
+	err := tx.Where("provider_refund_id = ?", event.Data.RefundID).First(&refund).Error
+	if err == nil {
+		if refund.ProviderStatus == payments.StatusSucceeded {
+			return "", ignore("refund %d already succeeded", refund.ID)
+		}
+		if err := tx.Model(&refund).Update("provider_status", payments.StatusSucceeded).Error; err != nil {
+			return "", fmt.Errorf("failed to update refund %d: %w", refund.ID, err)
+		}
+		return fmt.Sprintf("refund %d confirmed", refund.ID), nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", fmt.Errorf("failed to look up refund %s: %w", event.Data.RefundID, err)
+	}
+
+	payment, err := paymentByCharge(tx, event.Data.ChargeID)
+	if err != nil {
+		return "", err
+	}
+	if payment == nil {
+		return "", ignore("charge %s was never recorded", event.Data.ChargeID)
+	}
+
+	refund = models.Refund{
+		InvoiceID:        payment.InvoiceID,
+		PaymentID:        payment.ID,
+		UserID:           payment.UserID,
+		Amount:           paymentAmount(payment, roundCents(event.Data.Amount), event.Data.Currency),
+		Currency:         payment.Currency,
+		RefundDate:       eventTime(event, now),
+		TransactionID:    event.Data.RefundID,
+		Reason:           event.Data.Reason,
+		Provider:         provider,
+		ProviderRefundID: event.Data.RefundID,
+		ProviderStatus:   payments.StatusSucceeded,
+	}
+	if err := tx.Create(&refund).Error; err != nil {
+		return "", fmt.Errorf("failed to record refund %s: %w", event.Data.RefundID, err)
+	}
+	if err := RecordRefund(tx, payment.Invoice.OrganizationID, &refund); err != nil {
+		return "", err
+	}
+	if err := RecognizeRefund(tx, &refund); err != nil {
+		return "", err
+	}
+	if err := events.Publish(tx, payment.Invoice.OrganizationID, events.PaymentRefunded, refund); err != nil {
+		return "", err
+	}
+
+	refunded, err := RefundedAmount(tx, payment.ID)
+	if err != nil {
+		return "", err
+	}
+	if refunded >= payment.Amount {
+		if err := setProviderStatus(tx, payment, payments.StatusRefunded); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("refund %d recorded for payment %d", refund.ID, payment.ID), nil
+}
+
+// RefundedAmount totals the refunds recorded against a payment, in the
+// payment's currency.
+func RefundedAmount(db *gorm.DB, paymentID uint) (float64, error) {
+	var refunded float64
+	err := db.Model(&models.Refund{}).Where("payment_id = ?", paymentID).Select("COALESCE(SUM(amount), 0)").Scan(&refunded).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to total refunds of payment %d: %w", paymentID, err)
+	}
+	return roundCents(refunded), nil
+}
+
+// paymentDisputed opens a dispute against a payment whose charge the
+// cardholder has disputed.
+func paymentDisputed(tx *gorm.DB, event *payments.Event) (string, error) {
+	payment, err := paymentByCharge(tx, event.Data.ChargeID)
+	if err != nil {
+		return "", err
+	}
+	if payment == nil {
+		return "", ignore("charge %s was never recorded", event.Data.ChargeID)
+	}
+	if event.Data.DisputeID != "" {
+		var existing int64
+		if err := tx.Model(&models.Dispute{}).Where("provider_dispute_id = ?", event.Data.DisputeID).Count(&existing).Error; err != nil {
+			return "", fmt.Errorf("failed to look up dispute %s: %w", event.Data.DisputeID, err)
+		}
+		if existing > 0 {
+			return "", ignore("dispute %s already recorded", event.Data.DisputeID)
+		}
+	}
+
+	params := OpenDisputeParams{
+		ProviderDisputeID: event.Data.DisputeID,
+		Amount:            paymentAmount(payment, event.Data.Amount, event.Data.Currency),
+		Fee:               paymentAmount(payment, event.Data.Fee, event.Data.Currency),
+		Reason:            event.Data.Reason,
+	}
+	if event.Data.EvidenceDueBy != 0 {
+		dueBy := time.Unix(event.Data.EvidenceDueBy, 0)
+		params.EvidenceDueBy = &dueBy
+	}
+	dispute, err := OpenDispute(tx, payment, params)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("dispute %d opened on payment %d (%s)", dispute.ID, payment.ID, event.Data.Reason), nil
+}
+
+// disputeClosed records the bank's decision on a dispute.
+func disputeClosed(tx *gorm.DB, event *payments.Event, now time.Time) (string, error) {
+	if event.Data.DisputeID == "" {
+		return "", ignore("event has no dispute ID")
+	}
+	var dispute models.Dispute
+	err := tx.Where("provider_dispute_id = ?", event.Data.DisputeID).First(&dispute).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ignore("dispute %s was never recorded", event.Data.DisputeID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up dispute %s: %w", event.Data.DisputeID, err)
+	}
+	if dispute.Status == DisputeWon || dispute.Status == DisputeLost {
+		return "", ignore("dispute %d already closed as %s", dispute.ID, dispute.Status)
+	}
+
+	switch event.Data.Status {
+	case DisputeWon, DisputeLost:
+	default:
+		return "", ignore("unknown dispute outcome %q", event.Data.Status)
+	}
+	if err := CloseDispute(tx, &dispute, event.Data.Status == DisputeWon, now); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("dispute %d %s", dispute.ID, dispute.Status), nil
+}
+
+func eventTime(event *payments.Event, now time.Time) time.Time {
+	if event.Created == 0 {
+		return now
+	}
+	return time.Unix(event.Created, 0)
+}
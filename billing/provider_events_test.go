@@ -0,0 +1,95 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderEventsSettleAndReverseInvoice(t *testing.T) {
+	db, org := setupBillingDB(t)
+	now := time.Now()
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 40, Currency: "USD", IssueDate: now, DueDate: now}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	// An asynchronous charge of 50 settles the invoice and leaves 10 as credit.
+	succeeded := &payments.Event{ID: "evt_1", Type: payments.EventPaymentSucceeded, Data: payments.EventData{
+		ChargeID: "ch_async", InvoiceID: invoice.ID, Amount: 50, Currency: "USD",
+	}}
+	record, err := HandleProviderEvent(db, "fake", succeeded, now)
+	assert.NoError(t, err)
+	assert.Equal(t, EventProcessed, record.Outcome)
+
+	db.First(&invoice, invoice.ID)
+	assert.True(t, invoice.Paid)
+	balances, _ := CreditBalances(db, org.ID)
+	assert.Equal(t, 10.0, balances["USD"])
+
+	// Redelivery is recognized and changes nothing.
+	_, err = HandleProviderEvent(db, "fake", succeeded, now)
+	assert.ErrorIs(t, err, ErrDuplicateEvent)
+	var count int64
+	db.Model(&models.Payment{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// The charge is returned: the invoice reopens and the credit is withdrawn.
+	failed := &payments.Event{ID: "evt_2", Type: payments.EventPaymentFailed, Data: payments.EventData{ChargeID: "ch_async", FailureCode: "insufficient_funds"}}
+	record, err = HandleProviderEvent(db, "fake", failed, now)
+	assert.NoError(t, err)
+	assert.Equal(t, EventProcessed, record.Outcome)
+
+	db.First(&invoice, invoice.ID)
+	assert.False(t, invoice.Paid)
+	var payment models.Payment
+	db.Where("provider_charge_id = ?", "ch_async").First(&payment)
+	assert.Equal(t, payments.StatusFailed, payment.ProviderStatus)
+	balances, _ = CreditBalances(db, org.ID)
+	assert.Equal(t, 0.0, balances["USD"])
+
+	issues, err := CheckLedger(db, org.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestProviderEventsRecordRefundsAndDisputes(t *testing.T) {
+	db, org := setupBillingDB(t)
+	now := time.Now()
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 30, Currency: "USD", IssueDate: now, DueDate: now}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+	payment := models.Payment{InvoiceID: invoice.ID, Amount: 30, Currency: "USD", PaymentDate: now, TransactionID: "ch_1", Provider: "fake", ProviderChargeID: "ch_1", ProviderStatus: payments.StatusSucceeded}
+	_, err := SettleInvoice(db, &invoice, &payment)
+	assert.NoError(t, err)
+
+	refunded := &payments.Event{ID: "evt_r", Type: payments.EventPaymentRefunded, Data: payments.EventData{ChargeID: "ch_1", RefundID: "re_1", Amount: 30, Reason: "requested_by_customer"}}
+	record, err := HandleProviderEvent(db, "fake", refunded, now)
+	assert.NoError(t, err)
+	assert.Equal(t, EventProcessed, record.Outcome)
+
+	var refund models.Refund
+	assert.NoError(t, db.Where("provider_refund_id = ?", "re_1").First(&refund).Error)
+	assert.Equal(t, 30.0, refund.Amount)
+	db.First(&payment, payment.ID)
+	assert.Equal(t, payments.StatusRefunded, payment.ProviderStatus)
+
+	disputed := &payments.Event{ID: "evt_d", Type: payments.EventPaymentDisputed, Data: payments.EventData{ChargeID: "ch_1", Reason: "fraudulent"}}
+	_, err = HandleProviderEvent(db, "fake", disputed, now)
+	assert.NoError(t, err)
+	db.First(&payment, payment.ID)
+	assert.Equal(t, payments.StatusDisputed, payment.ProviderStatus)
+
+	// Events about charges Invoxa never saw are acknowledged but ignored.
+	unknown := &payments.Event{ID: "evt_u", Type: payments.EventPaymentDisputed, Data: payments.EventData{ChargeID: "ch_unknown"}}
+	record, err = HandleProviderEvent(db, "fake", unknown, now)
+	assert.NoError(t, err)
+	assert.Equal(t, EventIgnored, record.Outcome)
+
+	issues, err := CheckLedger(db, org.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+}
@@ -0,0 +1,84 @@
+package billing
+
+import (
+	"fmt"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// ReceiptNumberFormat numbers receipts, e.g., 'RCPT-2026-000001'. Each
+// organization counts its receipts per year.
+const ReceiptNumberFormat = "RCPT-{year}-{seq:6}"
+
+// PaymentMethodLabel describes a stored payment method for customers, e.g.,
+// 'Visa ending in 4242'.
+func PaymentMethodLabel(method *models.PaymentMethod) string {
+	name := method.Brand
+	if name == "" {
+		name = method.Type
+	}
+	if method.Last4 == "" {
+		return name
+	}
+	return fmt.Sprintf("%s ending in %s", name, method.Last4)
+}
+
+// IssueReceipt records the receipt for a payment that has just been settled
+// against an invoice. It runs in the transaction that records the payment.
+func IssueReceipt(tx *gorm.DB, invoice *models.Invoice, payment *models.Payment) (*models.Receipt, error) {
+	issued := payment.PaymentDate
+	if issued.IsZero() {
+		issued = time.Now()
+	}
+	n, err := nextInSequence(tx, invoice.OrganizationID, "receipt:"+issued.Format("2006"))
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &models.Receipt{
+		OrganizationID:  invoice.OrganizationID,
+		Number:          FormatInvoiceNumber(ReceiptNumberFormat, "", issued, n),
+		PaymentID:       payment.ID,
+		InvoiceID:       invoice.ID,
+		InvoiceNumber:   InvoiceNumber(invoice),
+		Amount:          payment.Amount,
+		Currency:        payment.Currency,
+		ChargedAmount:   payment.ChargedAmount,
+		ChargedCurrency: payment.ChargedCurrency,
+		IssuedAt:        issued,
+	}
+	if !invoice.Paid {
+		// A payment that falls short is kept as credit rather than settling
+		// part of the invoice, so the whole amount due is still open.
+		receipt.RemainingBalance = AmountDue(invoice)
+	}
+
+	if payment.UserID != 0 {
+		var user models.User
+		if err := tx.Unscoped().First(&user, payment.UserID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load user %d: %w", payment.UserID, err)
+		}
+		receipt.Payer = user.Username
+	} else {
+		var org models.Organization
+		if err := tx.First(&org, invoice.OrganizationID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load organization %d: %w", invoice.OrganizationID, err)
+		}
+		receipt.Payer = org.Name
+	}
+	if payment.PaymentMethodID != nil {
+		var method models.PaymentMethod
+		if err := tx.Unscoped().First(&method, *payment.PaymentMethodID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load payment method %d: %w", *payment.PaymentMethodID, err)
+		}
+		receipt.PaymentMethod = PaymentMethodLabel(&method)
+	}
+
+	if err := tx.Create(receipt).Error; err != nil {
+		return nil, fmt.Errorf("failed to create receipt: %w", err)
+	}
+	return receipt, nil
+}
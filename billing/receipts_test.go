@@ -0,0 +1,64 @@
+package billing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectInvoiceIssuesNumberedReceipts(t *testing.T) {
+	db, org := setupBillingDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorSucceed)
+	ctx := context.Background()
+
+	user := models.User{Username: "jane.doe"}
+	db.Create(&user)
+	method := models.PaymentMethod{OrganizationID: org.ID, Type: "card", Brand: "Visa", Last4: "4242", ProviderToken: "tok_visa", IsDefault: true}
+	db.Create(&method)
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	first, err := CollectInvoice(ctx, db, provider, &invoice, CollectParams{UserID: user.ID})
+	assert.NoError(t, err)
+	year := first.Payment.PaymentDate.Format("2006")
+	assert.Equal(t, "RCPT-"+year+"-000001", first.Receipt.Number)
+	assert.Equal(t, "jane.doe", first.Receipt.Payer)
+	assert.Equal(t, "Visa ending in 4242", first.Receipt.PaymentMethod)
+	assert.Equal(t, *invoice.Number, first.Receipt.InvoiceNumber)
+	assert.Equal(t, 100.0, first.Receipt.Amount)
+	assert.Zero(t, first.Receipt.RemainingBalance)
+
+	second := models.Invoice{OrganizationID: org.ID, Amount: 30, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &second))
+	collected, err := CollectInvoice(ctx, db, provider, &second, CollectParams{Token: "pm_card_visa"})
+	assert.NoError(t, err)
+	assert.Equal(t, "RCPT-"+year+"-000002", collected.Receipt.Number)
+	assert.Equal(t, "Test Org", collected.Receipt.Payer)
+	assert.Empty(t, collected.Receipt.PaymentMethod)
+
+	// A payment that falls short is kept as credit, leaving the invoice open.
+	open := models.Invoice{OrganizationID: org.ID, Amount: 50, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &open))
+	payment := models.Payment{InvoiceID: open.ID, Amount: 20, Currency: "USD", PaymentDate: time.Now(), TransactionID: "txn_short"}
+	_, err = SettleInvoice(db, &open, &payment)
+	assert.NoError(t, err)
+	var receipt models.Receipt
+	assert.NoError(t, db.Where("payment_id = ?", payment.ID).First(&receipt).Error)
+	assert.Equal(t, "RCPT-"+year+"-000003", receipt.Number)
+	assert.Equal(t, 50.0, receipt.RemainingBalance)
+
+	// Receipts are numbered separately from invoices and per organization.
+	other := models.Organization{Name: "Other Org"}
+	db.Create(&other)
+	otherInvoice := models.Invoice{OrganizationID: other.ID, Amount: 10, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &otherInvoice))
+	third, err := CollectInvoice(ctx, db, provider, &otherInvoice, CollectParams{Token: "pm_card_visa"})
+	assert.NoError(t, err)
+	assert.Equal(t, "RCPT-"+year+"-000001", third.Receipt.Number)
+}
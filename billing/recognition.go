@@ -0,0 +1,256 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// Reasons for revenue schedule lines.
+const (
+	RecognitionInvoice    = "invoice"
+	RecognitionRefund     = "refund"
+	RecognitionPlanChange = "plan_change"
+)
+
+// MonthOf returns the first day of the month of a time, in UTC.
+func MonthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// pointInTime reports whether a schedule's revenue is all earned when it is
+// invoiced, as for one-off charges.
+func pointInTime(schedule *models.RevenueSchedule) bool {
+	return schedule.PeriodStart.IsZero() || !schedule.PeriodEnd.After(schedule.PeriodStart)
+}
+
+// monthSpan returns the part of a schedule's service period a month's line
+// pays for. The first month a schedule was booked in also pays for any of
+// the period before it.
+func monthSpan(schedule *models.RevenueSchedule, bookedMonth, month time.Time) (time.Time, time.Time) {
+	start := maxTime(schedule.PeriodStart, month)
+	if month.Equal(bookedMonth) {
+		start = schedule.PeriodStart
+	}
+	return start, minTime(schedule.PeriodEnd, month.AddDate(0, 1, 0))
+}
+
+// spread divides amounts into cents, with the last month absorbing the
+// rounding difference from total.
+func spread(amounts map[time.Time]float64, total float64) ([]time.Time, map[time.Time]float64) {
+	months := make([]time.Time, 0, len(amounts))
+	for month := range amounts {
+		months = append(months, month)
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Before(months[j]) })
+
+	rounded := map[time.Time]float64{}
+	var sum float64
+	for i, month := range months {
+		if i == len(months)-1 {
+			rounded[month] = roundCents(total - sum)
+			break
+		}
+		rounded[month] = roundCents(amounts[month])
+		sum += rounded[month]
+	}
+	return months, rounded
+}
+
+// ScheduleRevenue creates the revenue schedule of a finalized invoice. Its
+// amount before tax is recognized over the invoice's service period in
+// proportion to the time falling in each month; revenue for months before
+// the invoice was issued is recognized in the month it was issued. Invoices
+// without a service period are recognized when issued.
+func ScheduleRevenue(tx *gorm.DB, invoice *models.Invoice) error {
+	amount := roundCents(invoice.Amount - invoice.TaxAmount)
+	if amount <= 0 {
+		return nil
+	}
+
+	schedule := models.RevenueSchedule{
+		InvoiceID:      invoice.ID,
+		OrganizationID: invoice.OrganizationID,
+		SubscriptionID: invoice.SubscriptionID,
+		Currency:       invoice.Currency,
+		Amount:         amount,
+		PeriodStart:    invoice.PeriodStart,
+		PeriodEnd:      invoice.PeriodEnd,
+	}
+	booked := invoice.IssueDate
+	bookedMonth := MonthOf(booked)
+
+	amounts := map[time.Time]float64{bookedMonth: 0}
+	if !pointInTime(&schedule) {
+		length := schedule.PeriodEnd.Sub(schedule.PeriodStart).Seconds()
+		for month := MonthOf(schedule.PeriodStart); month.Before(schedule.PeriodEnd); month = month.AddDate(0, 1, 0) {
+			if month.Before(bookedMonth) {
+				continue
+			}
+			start, end := monthSpan(&schedule, bookedMonth, month)
+			if end.After(start) {
+				amounts[month] += amount * end.Sub(start).Seconds() / length
+			}
+		}
+	}
+
+	months, rounded := spread(amounts, amount)
+	for _, month := range months {
+		schedule.Lines = append(schedule.Lines, models.RevenueScheduleLine{
+			Month:    month,
+			Amount:   rounded[month],
+			Reason:   RecognitionInvoice,
+			BookedAt: booked,
+		})
+	}
+	if err := tx.Create(&schedule).Error; err != nil {
+		return fmt.Errorf("failed to create revenue schedule of invoice %d: %w", invoice.ID, err)
+	}
+	return nil
+}
+
+// Unearned returns, by month, the revenue of a schedule not yet earned at a
+// time: all of later months and the share of the current month still to
+// come. Schedules ended early have nothing left to earn.
+func Unearned(schedule *models.RevenueSchedule, at time.Time) map[time.Time]float64 {
+	unearned := map[time.Time]float64{}
+	if schedule.EndedAt != nil || pointInTime(schedule) || len(schedule.Lines) == 0 {
+		return unearned
+	}
+
+	byMonth := map[time.Time]float64{}
+	var bookedMonth time.Time
+	for _, line := range schedule.Lines {
+		byMonth[MonthOf(line.Month)] += line.Amount
+		if line.Reason == RecognitionInvoice && (bookedMonth.IsZero() || line.BookedAt.Before(bookedMonth)) {
+			bookedMonth = MonthOf(line.BookedAt)
+		}
+	}
+	for month, amount := range byMonth {
+		start, end := monthSpan(schedule, bookedMonth, month)
+		if !end.After(start) || !end.After(at) || amount <= 0 {
+			continue
+		}
+		unearned[month] = amount * end.Sub(maxTime(start, at)).Seconds() / end.Sub(start).Seconds()
+	}
+	return unearned
+}
+
+// adjustSchedule takes reduce off what is left of a schedule at a time,
+// spread over the remaining months in proportion. What exceeds the unearned
+// revenue reverses revenue already recognized, in the month of the
+// adjustment. With end, the service stops: whatever unearned revenue is left
+// after the reduction is recognized at once.
+func adjustSchedule(tx *gorm.DB, schedule *models.RevenueSchedule, at time.Time, reduce float64, reason string, sourceID uint, end bool) error {
+	unearned := Unearned(schedule, at)
+	var remaining float64
+	for _, amount := range unearned {
+		remaining += amount
+	}
+	take := min(reduce, remaining)
+	atMonth := MonthOf(at)
+
+	deltas := map[time.Time]float64{atMonth: -(reduce - take)}
+	for month, amount := range unearned {
+		if end {
+			deltas[month] -= amount
+		} else {
+			deltas[month] -= amount * take / remaining
+		}
+	}
+	if end {
+		deltas[atMonth] += remaining - take
+	}
+
+	months, rounded := spread(deltas, -reduce)
+	for _, month := range months {
+		if rounded[month] == 0 {
+			continue
+		}
+		line := models.RevenueScheduleLine{
+			RevenueScheduleID: schedule.ID,
+			Month:             month,
+			Amount:            rounded[month],
+			Reason:            reason,
+			SourceID:          sourceID,
+			BookedAt:          at,
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			return fmt.Errorf("failed to adjust revenue schedule %d: %w", schedule.ID, err)
+		}
+		schedule.Lines = append(schedule.Lines, line)
+	}
+	return nil
+}
+
+// RecognizeRefund takes a refund, less its tax, off the revenue schedule of
+// the refunded invoice. Credit notes are issued for refunds, so this is also
+// how a credit note adjusts the schedule.
+func RecognizeRefund(tx *gorm.DB, refund *models.Refund) error {
+	var schedule models.RevenueSchedule
+	err := tx.Preload("Invoice").Preload("Lines").Where("invoice_id = ?", refund.InvoiceID).First(&schedule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load revenue schedule of invoice %d: %w", refund.InvoiceID, err)
+	}
+	if schedule.Invoice.Amount <= 0 {
+		return nil
+	}
+
+	reduce := roundCents(refund.Amount * schedule.Amount / schedule.Invoice.Amount)
+	return adjustSchedule(tx, &schedule, refund.RefundDate, reduce, RecognitionRefund, refund.ID, false)
+}
+
+// EndRevenueSchedules stops the revenue schedules of a subscription that
+// ended early, as on a plan change. Credit granted for the unused service
+// comes off what the subscription has not yet earned; the rest is
+// recognized when the subscription ends, since there is no more service
+// left to provide for it.
+func EndRevenueSchedules(tx *gorm.DB, subscriptionID uint, at time.Time, credited float64) error {
+	var schedules []models.RevenueSchedule
+	if err := tx.Preload("Lines").Where("subscription_id = ? AND ended_at IS NULL", subscriptionID).Order("id").Find(&schedules).Error; err != nil {
+		return fmt.Errorf("failed to load revenue schedules of subscription %d: %w", subscriptionID, err)
+	}
+
+	for i := range schedules {
+		schedule := &schedules[i]
+		var remaining float64
+		for _, amount := range Unearned(schedule, at) {
+			remaining += amount
+		}
+		if remaining <= 0 {
+			continue
+		}
+		reduce := roundCents(min(credited, remaining))
+		credited -= reduce
+		if err := adjustSchedule(tx, schedule, at, reduce, RecognitionPlanChange, subscriptionID, true); err != nil {
+			return err
+		}
+		if err := tx.Model(schedule).Update("ended_at", at).Error; err != nil {
+			return fmt.Errorf("failed to end revenue schedule %d: %w", schedule.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// recognizedByMonth sums a schedule's lines by the month they recognize.
+func recognizedByMonth(t *testing.T, db *gorm.DB, invoiceID uint) (map[string]float64, float64) {
+	var schedule models.RevenueSchedule
+	assert.NoError(t, db.Preload("Lines").Where("invoice_id = ?", invoiceID).First(&schedule).Error)
+	byMonth := map[string]float64{}
+	var total float64
+	for _, line := range schedule.Lines {
+		byMonth[line.Month.Format("2006-01")] = roundCents(byMonth[line.Month.Format("2006-01")] + line.Amount)
+		total += line.Amount
+	}
+	return byMonth, roundCents(total)
+}
+
+func TestScheduleRevenueSpreadsInvoiceOverServicePeriod(t *testing.T) {
+	db, org := setupBillingDB(t)
+	invoice := func(amount float64, issued, start, end time.Time) models.Invoice {
+		invoice := models.Invoice{OrganizationID: org.ID, Amount: amount, Currency: "USD", IssueDate: issued, DueDate: issued, PeriodStart: start, PeriodEnd: end}
+		assert.NoError(t, FinalizeInvoice(db, &invoice))
+		return invoice
+	}
+
+	// A yearly plan is earned day by day over 365 days.
+	yearly := invoice(1200, date(2026, 1, 15), date(2026, 1, 15), date(2027, 1, 15))
+	byMonth, total := recognizedByMonth(t, db, yearly.ID)
+	assert.Len(t, byMonth, 13)
+	assert.Equal(t, 1200.0, total)
+	assert.Equal(t, 55.89, byMonth["2026-01"]) // 17 days
+	assert.Equal(t, 92.05, byMonth["2026-02"]) // 28 days
+	assert.Equal(t, 101.92, byMonth["2026-03"])
+	assert.Equal(t, 46.02, byMonth["2027-01"]) // 14 days, less the rounding
+
+	// Revenue for the months before an invoice was issued is caught up in
+	// the month it was issued.
+	late := invoice(300, date(2026, 2, 10), date(2026, 1, 1), date(2026, 4, 1))
+	byMonth, _ = recognizedByMonth(t, db, late.ID)
+	assert.Equal(t, map[string]float64{"2026-02": 196.67, "2026-03": 103.33}, byMonth)
+
+	oneOff := invoice(40, date(2026, 5, 20), time.Time{}, time.Time{})
+	byMonth, _ = recognizedByMonth(t, db, oneOff.ID)
+	assert.Equal(t, map[string]float64{"2026-05": 40}, byMonth)
+}
+
+func TestScheduleRevenueExcludesTax(t *testing.T) {
+	db, org := setupBillingDB(t)
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 120, TaxAmount: 20, Currency: "USD", IssueDate: date(2026, 3, 1), DueDate: date(2026, 3, 1),
+		PeriodStart: date(2026, 3, 1), PeriodEnd: date(2026, 4, 1)}
+	assert.NoError(t, db.Create(&invoice).Error)
+	assert.NoError(t, ScheduleRevenue(db, &invoice))
+
+	byMonth, _ := recognizedByMonth(t, db, invoice.ID)
+	assert.Equal(t, map[string]float64{"2026-03": 100}, byMonth)
+}
+
+func TestRecognizeRefundAdjustsRemainingSchedule(t *testing.T) {
+	db, org := setupBillingDB(t)
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 1200, Currency: "USD", IssueDate: date(2026, 1, 15), DueDate: date(2026, 1, 15),
+		PeriodStart: date(2026, 1, 15), PeriodEnd: date(2027, 1, 15)}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+	before, _ := recognizedByMonth(t, db, invoice.ID)
+
+	// Half the price is refunded on July 1st, when 198 of the 365 days, or
+	// 650.96, are still to come. The earned months are left alone.
+	refund := models.Refund{InvoiceID: invoice.ID, Amount: 600, Currency: "USD", RefundDate: date(2026, 7, 1), TransactionID: "re_1"}
+	assert.NoError(t, db.Create(&refund).Error)
+	assert.NoError(t, RecognizeRefund(db, &refund))
+
+	after, total := recognizedByMonth(t, db, invoice.ID)
+	assert.Equal(t, 600.0, total)
+	assert.Equal(t, before["2026-06"], after["2026-06"])
+	assert.InDelta(t, before["2026-07"]*50.96/650.96, after["2026-07"], 0.01)
+
+	// Refunding more than is left to earn reverses revenue already
+	// recognized in the month of the refund.
+	refund = models.Refund{InvoiceID: invoice.ID, Amount: 600, Currency: "USD", RefundDate: date(2026, 12, 20), TransactionID: "re_2"}
+	assert.NoError(t, db.Create(&refund).Error)
+	assert.NoError(t, RecognizeRefund(db, &refund))
+
+	after, total = recognizedByMonth(t, db, invoice.ID)
+	assert.Equal(t, 0.0, total)
+	assert.Equal(t, 0.0, after["2027-01"])
+	assert.Less(t, after["2026-12"], 0.0)
+}
+
+func TestEndRevenueSchedulesOnPlanChange(t *testing.T) {
+	db, org := setupBillingDB(t)
+	subscriptionID := uint(7)
+	invoice := models.Invoice{OrganizationID: org.ID, SubscriptionID: &subscriptionID, Amount: 1200, Currency: "USD", IssueDate: date(2026, 1, 1), DueDate: date(2026, 1, 1),
+		PeriodStart: date(2026, 1, 1), PeriodEnd: date(2027, 1, 1)}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	// On April 1st, 904.11 is still to be earned. 200 of it is given back as
+	// credit and the rest is recognized at once.
+	assert.NoError(t, EndRevenueSchedules(db, subscriptionID, date(2026, 4, 1), 200))
+
+	byMonth, total := recognizedByMonth(t, db, invoice.ID)
+	assert.Equal(t, 1000.0, total)
+	assert.Equal(t, 704.11, byMonth["2026-04"])
+	assert.Equal(t, 0.0, byMonth["2026-05"])
+
+	var schedule models.RevenueSchedule
+	db.Preload("Lines").Where("invoice_id = ?", invoice.ID).First(&schedule)
+	assert.NotNil(t, schedule.EndedAt)
+	assert.Empty(t, Unearned(&schedule, date(2026, 4, 2)))
+
+	// Ending again changes nothing.
+	assert.NoError(t, EndRevenueSchedules(db, subscriptionID, date(2026, 5, 1), 0))
+	_, total = recognizedByMonth(t, db, invoice.ID)
+	assert.Equal(t, 1000.0, total)
+}
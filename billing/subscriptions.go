@@ -0,0 +1,149 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NextPeriodEnd returns the end of a billing period of the given plan
+// interval that starts at start. Unknown intervals bill monthly.
+func NextPeriodEnd(start time.Time, interval string) time.Time {
+	switch interval {
+	case "weekly":
+		return start.AddDate(0, 0, 7)
+	case "quarterly":
+		return start.AddDate(0, 3, 0)
+	case "yearly", "annual", "annually":
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// subscriptionOwner returns the user renewal invoices are raised for:
+// whoever started the subscription, or the organization's first user for
+// subscriptions created before that was tracked.
+func subscriptionOwner(db *gorm.DB, subscription *models.Subscription) (uint, error) {
+	if subscription.UserID != 0 {
+		return subscription.UserID, nil
+	}
+	var user models.User
+	if err := db.Where("organization_id = ?", subscription.OrganizationID).Order("id").First(&user).Error; err != nil {
+		return 0, fmt.Errorf("failed to find a user for subscription %d: %w", subscription.ID, err)
+	}
+	return user.ID, nil
+}
+
+type RenewalReport struct {
+	Renewed         int      `json:"renewed"`
+	Charged         int      `json:"charged"`
+	AwaitingPayment int      `json:"awaiting_payment"`
+	Failed          int      `json:"failed"`
+	Errors          []string `json:"errors"`
+}
+
+// RenewSubscriptions starts the next billing period of every active,
+// unpaused subscription whose current period has ended, raises the renewal invoice
+// and charges the organization's default payment method. Charges that fail
+// leave the invoice open for collection later.
+func RenewSubscriptions(ctx context.Context, db *gorm.DB, provider payments.Provider, now time.Time) (*RenewalReport, error) {
+	var subscriptions []models.Subscription
+	err := db.Preload("SubscriptionPlan").
+		Where("is_active = ? AND paused_at IS NULL AND (current_period_end <= ? OR current_period_end IS NULL)", true, now).
+		Find(&subscriptions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subscriptions due for renewal: %w", err)
+	}
+
+	report := &RenewalReport{Errors: []string{}}
+	for i := range subscriptions {
+		invoice, err := renewSubscription(db, &subscriptions[i], now)
+		if err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		if invoice == nil {
+			continue
+		}
+		report.Renewed++
+		if invoice.Paid {
+			continue
+		}
+
+		_, err = CollectInvoice(ctx, db, provider, invoice, CollectParams{})
+		switch {
+		case err == nil:
+			report.Charged++
+		case errors.Is(err, ErrNoPaymentMethod):
+			report.AwaitingPayment++
+		default:
+			log.Printf("Renewal charge for invoice %d failed: %v", invoice.ID, err)
+			report.AwaitingPayment++
+			report.Errors = append(report.Errors, fmt.Sprintf("invoice %d: %v", invoice.ID, err))
+		}
+	}
+	return report, nil
+}
+
+// renewSubscription advances a subscription by one period and finalizes its
+// renewal invoice. Subscriptions created before periods were tracked are
+// first given a period starting on their start date; it returns a nil
+// invoice when that period is still running.
+func renewSubscription(db *gorm.DB, subscription *models.Subscription, now time.Time) (*models.Invoice, error) {
+	plan := subscription.SubscriptionPlan
+	var invoice *models.Invoice
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if subscription.CurrentPeriodEnd.IsZero() || subscription.CurrentPeriodStart.IsZero() {
+			subscription.CurrentPeriodStart = subscription.StartDate
+			subscription.CurrentPeriodEnd = NextPeriodEnd(subscription.StartDate, plan.Interval)
+			if subscription.CurrentPeriodEnd.After(now) {
+				return tx.Omit(clause.Associations).Save(subscription).Error
+			}
+		}
+
+		ownerID, err := subscriptionOwner(tx, subscription)
+		if err != nil {
+			return err
+		}
+
+		subscription.CurrentPeriodStart = subscription.CurrentPeriodEnd
+		subscription.CurrentPeriodEnd = NextPeriodEnd(subscription.CurrentPeriodStart, plan.Interval)
+		if err := tx.Omit(clause.Associations).Save(subscription).Error; err != nil {
+			return err
+		}
+		if err := events.Publish(tx, subscription.OrganizationID, events.SubscriptionRenewed, subscription); err != nil {
+			return err
+		}
+
+		invoice = &models.Invoice{
+			OrganizationID: subscription.OrganizationID,
+			UserID:         ownerID,
+			SubscriptionID: &subscription.ID,
+			Amount:         plan.Price,
+			TaxInclusive:   plan.TaxInclusive,
+			TaxCode:        plan.TaxCode,
+			Currency:       plan.Currency,
+			IssueDate:      now,
+			DueDate:        subscription.CurrentPeriodStart,
+			PeriodStart:    subscription.CurrentPeriodStart,
+			PeriodEnd:      subscription.CurrentPeriodEnd,
+		}
+		return FinalizeInvoice(tx, invoice)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscription %d: %w", subscription.ID, err)
+	}
+	return invoice, nil
+}
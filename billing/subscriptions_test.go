@@ -0,0 +1,90 @@
+package billing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenewSubscriptionsChargesDefaultMethod(t *testing.T) {
+	db, org := setupBillingDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorSucceed)
+	ctx := context.Background()
+
+	user := models.User{Username: "owner", Email: "owner@test.org", PasswordHash: "hash", OrganizationID: org.ID}
+	db.Create(&user)
+	plan := models.SubscriptionPlan{Name: "Monthly", Price: 25, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	db.Create(&plan)
+
+	start := time.Now().AddDate(0, -1, -1)
+	subscription := models.Subscription{
+		OrganizationID:     org.ID,
+		SubscriptionPlanID: plan.ID,
+		UserID:             user.ID,
+		StartDate:          start,
+		CurrentPeriodStart: start,
+		CurrentPeriodEnd:   NextPeriodEnd(start, plan.Interval),
+		IsActive:           true,
+	}
+	db.Create(&subscription)
+
+	customerID, err := EnsureProviderCustomer(ctx, db, provider, org)
+	assert.NoError(t, err)
+	_, err = provider.AttachPaymentMethod(ctx, customerID, "tok_visa")
+	assert.NoError(t, err)
+	db.Create(&models.PaymentMethod{OrganizationID: org.ID, Type: "card", ProviderToken: "tok_visa", IsDefault: true})
+
+	report, err := RenewSubscriptions(ctx, db, provider, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Renewed)
+	assert.Equal(t, 1, report.Charged)
+
+	var invoice models.Invoice
+	db.Where("subscription_id = ?", subscription.ID).First(&invoice)
+	assert.True(t, invoice.Paid)
+	assert.Equal(t, user.ID, invoice.UserID)
+
+	var payment models.Payment
+	db.Where("invoice_id = ?", invoice.ID).First(&payment)
+	assert.NotNil(t, payment.PaymentMethodID)
+	assert.Equal(t, 25.0, payment.Amount)
+
+	db.First(&subscription, subscription.ID)
+	assert.True(t, subscription.CurrentPeriodEnd.After(time.Now()))
+
+	// Nothing is due until the new period ends.
+	report, err = RenewSubscriptions(ctx, db, provider, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Renewed)
+}
+
+func TestRenewSubscriptionsWithoutPaymentMethod(t *testing.T) {
+	db, org := setupBillingDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	user := models.User{Username: "owner", Email: "owner@test.org", PasswordHash: "hash", OrganizationID: org.ID}
+	db.Create(&user)
+	plan := models.SubscriptionPlan{Name: "Yearly", Price: 100, Currency: "USD", Interval: "yearly", OrganizationID: org.ID}
+	db.Create(&plan)
+
+	// A subscription created before billing periods were tracked, whose
+	// period columns were added empty by the migration.
+	subscription := models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, StartDate: time.Now().AddDate(-1, 0, -2), IsActive: true}
+	db.Create(&subscription)
+	db.Model(&subscription).Updates(map[string]any{"current_period_start": nil, "current_period_end": nil})
+
+	report, err := RenewSubscriptions(context.Background(), db, provider, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Renewed)
+	assert.Equal(t, 1, report.AwaitingPayment)
+
+	var invoice models.Invoice
+	db.Where("subscription_id = ?", subscription.ID).First(&invoice)
+	assert.False(t, invoice.Paid)
+	assert.Equal(t, user.ID, invoice.UserID)
+}
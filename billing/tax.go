@@ -0,0 +1,233 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// SellerCountry is the ISO country code the business is established in. It
+// decides whether an EU customer is reverse charged; leave it empty when the
+// seller is outside the EU.
+var SellerCountry string
+
+var euCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true, "DK": true,
+	"EE": true, "ES": true, "FI": true, "FR": true, "GR": true, "HR": true, "HU": true,
+	"IE": true, "IT": true, "LT": true, "LU": true, "LV": true, "MT": true, "NL": true,
+	"PL": true, "PT": true, "RO": true, "SE": true, "SI": true, "SK": true,
+}
+
+// IsEUCountry reports whether an ISO country code is an EU member state.
+func IsEUCountry(country string) bool {
+	return euCountries[strings.ToUpper(country)]
+}
+
+// TaxRequest describes the sale a TaxCalculator is asked to tax.
+type TaxRequest struct {
+	OrganizationID  uint             `json:"organization_id"`
+	SellerCountry   string           `json:"seller_country"`
+	CustomerCountry string           `json:"customer_country"`
+	CustomerRegion  string           `json:"customer_region"`
+	CustomerTaxID   string           `json:"customer_tax_id"`
+	TaxCode         string           `json:"tax_code"` // product tax code of what is sold
+	Amount          float64          `json:"amount"`
+	Currency        string           `json:"currency"`
+	TaxInclusive    bool             `json:"tax_inclusive"`    // Amount already includes tax
+	Rates           []models.TaxRate `json:"configured_rates"` // rates the organization has configured for the customer's jurisdiction
+}
+
+// ReverseCharged reports whether the customer accounts for VAT themselves: a
+// business with a tax ID in a different EU member state from the seller.
+func (r TaxRequest) ReverseCharged() bool {
+	if r.CustomerTaxID == "" || !IsEUCountry(r.SellerCountry) || !IsEUCountry(r.CustomerCountry) {
+		return false
+	}
+	return !strings.EqualFold(r.SellerCountry, r.CustomerCountry)
+}
+
+type TaxLine struct {
+	TaxRateID     *uint   `json:"tax_rate_id,omitempty"`
+	Name          string  `json:"name"`
+	Country       string  `json:"country"`
+	Region        string  `json:"region,omitempty"`
+	Rate          float64 `json:"rate"`
+	TaxableAmount float64 `json:"taxable_amount"`
+	Amount        float64 `json:"amount"`
+}
+
+// TaxResult splits the requested amount into a subtotal and tax. Subtotal
+// plus TaxAmount is the invoice total.
+type TaxResult struct {
+	Subtotal      float64   `json:"subtotal"`
+	TaxAmount     float64   `json:"tax_amount"`
+	ReverseCharge bool      `json:"reverse_charge"`
+	Lines         []TaxLine `json:"lines"`
+}
+
+// TaxCalculator computes the tax on an invoice when it is finalized.
+type TaxCalculator interface {
+	Name() string
+	Calculate(ctx context.Context, req TaxRequest) (*TaxResult, error)
+}
+
+// Calculator is the TaxCalculator used by FinalizeInvoice. It defaults to
+// the rates each organization has configured.
+var Calculator TaxCalculator = OrganizationRates{}
+
+// OrganizationRates taxes invoices at the rates configured for the customer's
+// jurisdiction. Country-wide rates and rates for the customer's region stack.
+type OrganizationRates struct{}
+
+func (OrganizationRates) Name() string {
+	return "organization_rates"
+}
+
+func (OrganizationRates) Calculate(ctx context.Context, req TaxRequest) (*TaxResult, error) {
+	if req.ReverseCharged() {
+		return reverseCharge(req), nil
+	}
+	lines := make([]TaxLine, 0, len(req.Rates))
+	for _, rate := range req.Rates {
+		rateID := rate.ID
+		lines = append(lines, TaxLine{TaxRateID: &rateID, Name: rate.Name, Country: rate.Country, Region: rate.Region, Rate: rate.Rate})
+	}
+	return splitTax(req, lines), nil
+}
+
+func reverseCharge(req TaxRequest) *TaxResult {
+	return &TaxResult{
+		Subtotal:      roundCents(req.Amount),
+		ReverseCharge: true,
+		Lines: []TaxLine{{
+			Name:          "VAT reverse charge",
+			Country:       strings.ToUpper(req.CustomerCountry),
+			TaxableAmount: roundCents(req.Amount),
+		}},
+	}
+}
+
+// splitTax fills in the amounts of lines whose rates apply to the request.
+// For tax-exclusive pricing tax is added on top of the amount; for
+// tax-inclusive pricing it is backed out of it.
+func splitTax(req TaxRequest, lines []TaxLine) *TaxResult {
+	if len(lines) == 0 || req.Amount <= 0 {
+		return &TaxResult{Subtotal: roundCents(req.Amount)}
+	}
+	result := &TaxResult{Subtotal: roundCents(req.Amount), Lines: lines}
+
+	totalRate := 0.0
+	for _, line := range lines {
+		totalRate += line.Rate
+	}
+	if req.TaxInclusive {
+		result.Subtotal = roundCents(req.Amount / (1 + totalRate/100))
+	}
+	for i := range result.Lines {
+		line := &result.Lines[i]
+		line.TaxableAmount = result.Subtotal
+		line.Amount = roundCents(result.Subtotal * line.Rate / 100)
+		result.TaxAmount = roundCents(result.TaxAmount + line.Amount)
+	}
+
+	if req.TaxInclusive {
+		// Rounding each line can leave the parts a cent away from the price
+		// the customer was quoted; the last line absorbs the difference.
+		last := &result.Lines[len(result.Lines)-1]
+		diff := roundCents(req.Amount - result.Subtotal - result.TaxAmount)
+		last.Amount = roundCents(last.Amount + diff)
+		result.TaxAmount = roundCents(result.TaxAmount + diff)
+	}
+	return result
+}
+
+// ApplicableTaxRates returns the organization's rates for the customer's
+// jurisdiction: country-wide rates plus any for their region.
+func ApplicableTaxRates(tx *gorm.DB, org *models.Organization) ([]models.TaxRate, error) {
+	var rates []models.TaxRate
+	if org.Country == "" {
+		return rates, nil
+	}
+	err := tx.Where("organization_id = ? AND UPPER(country) = ? AND (region = '' OR UPPER(region) = ?)",
+		org.ID, strings.ToUpper(org.Country), strings.ToUpper(org.Region)).
+		Order("id").Find(&rates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tax rates: %w", err)
+	}
+	return rates, nil
+}
+
+// ApplyTax runs the Calculator on an unsaved invoice. It sets the subtotal,
+// tax and total, the tax lines, and a record of the calculation's inputs and
+// result that is saved with the invoice for audit.
+func ApplyTax(tx *gorm.DB, invoice *models.Invoice) error {
+	var org models.Organization
+	if err := tx.First(&org, invoice.OrganizationID).Error; err != nil {
+		return fmt.Errorf("failed to load organization %d: %w", invoice.OrganizationID, err)
+	}
+	rates, err := ApplicableTaxRates(tx, &org)
+	if err != nil {
+		return err
+	}
+
+	req := TaxRequest{
+		OrganizationID:  org.ID,
+		SellerCountry:   strings.ToUpper(SellerCountry),
+		CustomerCountry: strings.ToUpper(org.Country),
+		CustomerRegion:  org.Region,
+		CustomerTaxID:   org.TaxID,
+		TaxCode:         invoice.TaxCode,
+		Amount:          roundCents(invoice.Amount),
+		Currency:        invoice.Currency,
+		TaxInclusive:    invoice.TaxInclusive,
+		Rates:           rates,
+	}
+	result, err := Calculator.Calculate(tx.Statement.Context, req)
+	if err != nil {
+		return fmt.Errorf("tax calculation by %s failed: %w", Calculator.Name(), err)
+	}
+
+	total := roundCents(result.Subtotal + result.TaxAmount)
+	if req.TaxInclusive && !result.ReverseCharge && math.Abs(total-req.Amount) > balanceTolerance {
+		return fmt.Errorf("tax calculation by %s does not add up to the tax-inclusive price: %.2f + %.2f != %.2f",
+			Calculator.Name(), result.Subtotal, result.TaxAmount, req.Amount)
+	}
+
+	invoice.Subtotal = roundCents(result.Subtotal)
+	invoice.TaxAmount = roundCents(result.TaxAmount)
+	invoice.Amount = total
+	invoice.ReverseCharge = result.ReverseCharge
+	invoice.TaxLines = nil
+	for _, line := range result.Lines {
+		invoice.TaxLines = append(invoice.TaxLines, models.InvoiceTaxLine{
+			TaxRateID:     line.TaxRateID,
+			Name:          line.Name,
+			Country:       line.Country,
+			Region:        line.Region,
+			Rate:          line.Rate,
+			TaxableAmount: line.TaxableAmount,
+			Amount:        line.Amount,
+		})
+	}
+
+	request, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode tax request: %w", err)
+	}
+	response, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode tax result: %w", err)
+	}
+	invoice.TaxCalculation = &models.TaxCalculation{
+		Calculator: Calculator.Name(),
+		Request:    string(request),
+		Result:     string(response),
+	}
+	return nil
+}
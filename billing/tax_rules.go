@@ -0,0 +1,92 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TaxRule is one row of a tax rules table. An empty Region applies to the
+// whole country and an empty TaxCode to every product.
+type TaxRule struct {
+	Country string  `json:"country"`
+	Region  string  `json:"region"`
+	TaxCode string  `json:"tax_code"`
+	Name    string  `json:"name"`
+	Rate    float64 `json:"rate"` // percentage; 0 for exempt products
+}
+
+// RulesCalculator is a table-driven TaxCalculator. Rules for the customer's
+// country and for their region both apply. At each of those levels, rules
+// for the product's tax code replace the general rules, so a reduced or zero
+// rate can be declared for a code without repeating the standard rate.
+type RulesCalculator struct {
+	Rules []TaxRule
+}
+
+// LoadTaxRules reads a RulesCalculator from a JSON file holding an array of
+// rules.
+func LoadTaxRules(path string) (*RulesCalculator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tax rules: %w", err)
+	}
+	var rules []TaxRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("malformed tax rules in %s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if len(rule.Country) != 2 || rule.Name == "" || rule.Rate < 0 || rule.Rate > 100 {
+			return nil, fmt.Errorf("invalid tax rule %d in %s: a two-letter country, a name and a rate between 0 and 100 are required", i+1, path)
+		}
+	}
+	return &RulesCalculator{Rules: rules}, nil
+}
+
+func (c *RulesCalculator) Name() string {
+	return "rules"
+}
+
+func (c *RulesCalculator) Calculate(ctx context.Context, req TaxRequest) (*TaxResult, error) {
+	if req.ReverseCharged() {
+		return reverseCharge(req), nil
+	}
+
+	levels := []string{""}
+	if req.CustomerRegion != "" {
+		levels = append(levels, req.CustomerRegion)
+	}
+	var lines []TaxLine
+	for _, region := range levels {
+		for _, rule := range c.match(req.CustomerCountry, region, req.TaxCode) {
+			if rule.Rate == 0 {
+				continue
+			}
+			lines = append(lines, TaxLine{Name: rule.Name, Country: strings.ToUpper(rule.Country), Region: rule.Region, Rate: rule.Rate})
+		}
+	}
+	return splitTax(req, lines), nil
+}
+
+// match returns the rules for one jurisdiction level, preferring those for
+// the tax code over the general ones.
+func (c *RulesCalculator) match(country, region, taxCode string) []TaxRule {
+	var general, specific []TaxRule
+	for _, rule := range c.Rules {
+		if !strings.EqualFold(rule.Country, country) || !strings.EqualFold(rule.Region, region) {
+			continue
+		}
+		switch {
+		case rule.TaxCode == "":
+			general = append(general, rule)
+		case taxCode != "" && strings.EqualFold(rule.TaxCode, taxCode):
+			specific = append(specific, rule)
+		}
+	}
+	if len(specific) > 0 {
+		return specific
+	}
+	return general
+}
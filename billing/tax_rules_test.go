@@ -0,0 +1,101 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRulesCalculatorPrefersTaxCodeRules(t *testing.T) {
+	rules, err := LoadTaxRules("../tax_rules.example.json")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		country   string
+		region    string
+		taxCode   string
+		taxAmount float64
+		lines     int
+	}{
+		{"standard rate", "DE", "", "", 19, 1},
+		{"reduced rate for tax code", "DE", "", "ebook", 7, 1},
+		{"unknown tax code uses standard rate", "DE", "", "saas", 19, 1},
+		{"country and region stack", "CA", "BC", "", 12, 2},
+		{"exempt in region only", "CA", "BC", "saas", 5, 1},
+		{"region without country rate", "US", "TX", "", 6.25, 1},
+		{"no rules for jurisdiction", "US", "NY", "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := rules.Calculate(context.Background(), TaxRequest{
+				CustomerCountry: tt.country,
+				CustomerRegion:  tt.region,
+				TaxCode:         tt.taxCode,
+				Amount:          100,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 100.0, result.Subtotal)
+			assert.Equal(t, tt.taxAmount, result.TaxAmount)
+			assert.Len(t, result.Lines, tt.lines)
+		})
+	}
+}
+
+func TestLoadTaxRulesRejectsInvalidRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"country": "Germany", "name": "VAT", "rate": 19}]`), 0o600))
+	_, err := LoadTaxRules(path)
+	assert.Error(t, err)
+
+	_, err = LoadTaxRules(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+type failingCalculator struct{}
+
+func (failingCalculator) Name() string { return "failing" }
+
+func (failingCalculator) Calculate(ctx context.Context, req TaxRequest) (*TaxResult, error) {
+	return nil, errors.New("service unavailable")
+}
+
+func TestFinalizeInvoiceRecordsTaxCalculation(t *testing.T) {
+	db, org := setupBillingDB(t)
+	org.Country = "DE"
+	assert.NoError(t, db.Save(org).Error)
+
+	rules, err := LoadTaxRules("../tax_rules.example.json")
+	assert.NoError(t, err)
+	Calculator = rules
+	t.Cleanup(func() { Calculator = OrganizationRates{} })
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 10.70, TaxInclusive: true, TaxCode: "ebook", Currency: "EUR", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+	assert.Equal(t, 10.0, invoice.Subtotal)
+	assert.Equal(t, 0.7, invoice.TaxAmount)
+
+	var audit models.TaxCalculation
+	assert.NoError(t, db.Where("invoice_id = ?", invoice.ID).First(&audit).Error)
+	assert.Equal(t, "rules", audit.Calculator)
+	var request TaxRequest
+	assert.NoError(t, json.Unmarshal([]byte(audit.Request), &request))
+	assert.Equal(t, "ebook", request.TaxCode)
+	assert.Equal(t, 10.70, request.Amount)
+	var result TaxResult
+	assert.NoError(t, json.Unmarshal([]byte(audit.Result), &result))
+	assert.Equal(t, 0.7, result.TaxAmount)
+
+	// A failing calculator stops the invoice from being finalized untaxed.
+	Calculator = failingCalculator{}
+	invoice = models.Invoice{OrganizationID: org.ID, Amount: 10, Currency: "EUR", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.Error(t, FinalizeInvoice(db, &invoice))
+}
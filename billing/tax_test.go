@@ -0,0 +1,93 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupTaxRates(t *testing.T, db *gorm.DB, org *models.Organization, country, region string) {
+	org.Country = country
+	org.Region = region
+	assert.NoError(t, db.Save(org).Error)
+	rates := []models.TaxRate{
+		{OrganizationID: org.ID, Name: "GST", Country: "CA", Rate: 5},
+		{OrganizationID: org.ID, Name: "PST", Country: "CA", Region: "BC", Rate: 7},
+		{OrganizationID: org.ID, Name: "QST", Country: "CA", Region: "QC", Rate: 9.975},
+		{OrganizationID: org.ID, Name: "VAT", Country: "DE", Rate: 19},
+	}
+	assert.NoError(t, db.Create(&rates).Error)
+}
+
+func TestTaxExclusiveInvoiceStacksRegionalRates(t *testing.T) {
+	db, org := setupBillingDB(t)
+	setupTaxRates(t, db, org, "CA", "BC")
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "CAD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	assert.Equal(t, 100.0, invoice.Subtotal)
+	assert.Equal(t, 12.0, invoice.TaxAmount)
+	assert.Equal(t, 112.0, invoice.Amount)
+	var lines []models.InvoiceTaxLine
+	assert.NoError(t, db.Where("invoice_id = ?", invoice.ID).Order("id").Find(&lines).Error)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "GST", lines[0].Name)
+		assert.Equal(t, 5.0, lines[0].Amount)
+		assert.Equal(t, "PST", lines[1].Name)
+		assert.Equal(t, 7.0, lines[1].Amount)
+	}
+
+	balances := accountBalances(t, db, org)
+	assert.Equal(t, 112.0, balances[AccountReceivable])
+	assert.Equal(t, -100.0, balances[AccountRevenue])
+	assert.Equal(t, -12.0, balances[AccountTaxPayable])
+}
+
+func TestTaxInclusiveInvoiceKeepsQuotedTotal(t *testing.T) {
+	db, org := setupBillingDB(t)
+	setupTaxRates(t, db, org, "DE", "")
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 9.99, TaxInclusive: true, Currency: "EUR", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+
+	assert.Equal(t, 9.99, invoice.Amount)
+	assert.Equal(t, 8.39, invoice.Subtotal)
+	assert.Equal(t, 1.6, invoice.TaxAmount)
+	assert.Len(t, invoice.TaxLines, 1)
+}
+
+func TestReverseChargeForEUBusinessCustomer(t *testing.T) {
+	db, org := setupBillingDB(t)
+	SellerCountry = "FR"
+	t.Cleanup(func() { SellerCountry = "" })
+	setupTaxRates(t, db, org, "DE", "")
+
+	// Without a tax ID the customer is a consumer and pays local VAT.
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "EUR", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+	assert.False(t, invoice.ReverseCharge)
+	assert.Equal(t, 119.0, invoice.Amount)
+
+	org.TaxID = "DE123456789"
+	assert.NoError(t, db.Save(org).Error)
+	invoice = models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "EUR", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+	assert.True(t, invoice.ReverseCharge)
+	assert.Equal(t, 100.0, invoice.Amount)
+	assert.Equal(t, 0.0, invoice.TaxAmount)
+	if assert.Len(t, invoice.TaxLines, 1) {
+		assert.Equal(t, "VAT reverse charge", invoice.TaxLines[0].Name)
+	}
+
+	// A domestic business is charged VAT as usual.
+	SellerCountry = "DE"
+	invoice = models.Invoice{OrganizationID: org.ID, Amount: 100, Currency: "EUR", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, FinalizeInvoice(db, &invoice))
+	assert.False(t, invoice.ReverseCharge)
+	assert.Equal(t, 19.0, invoice.TaxAmount)
+}
@@ -13,6 +13,44 @@ import (
 
 var DB *gorm.DB
 
+// Models lists every model managed by the migrations, in creation order.
+var Models = []interface{}{
+	&models.User{},
+	&models.Organization{},
+	&models.SubscriptionPlan{},
+	&models.Subscription{},
+	&models.Invoice{},
+	&models.Payment{},
+	&models.Refund{},
+	&models.CreditEntry{},
+	&models.JournalEntry{},
+	&models.JournalLine{},
+	&models.PaymentMethod{},
+	&models.DunningPolicy{},
+	&models.CollectionAttempt{},
+	&models.ProviderEvent{},
+	&models.WebhookEndpoint{},
+	&models.WebhookDelivery{},
+	&models.WebhookAttempt{},
+	&models.OutboxEvent{},
+	&models.EventHandling{},
+	&models.Dispute{},
+	&models.TaxRate{},
+	&models.InvoiceTaxLine{},
+	&models.TaxCalculation{},
+	&models.ExchangeRate{},
+	&models.InvoiceSequence{},
+	&models.OrganizationBranding{},
+	&models.DocumentTemplate{},
+	&models.SentMessage{},
+	&models.Receipt{},
+	&models.RevenueSchedule{},
+	&models.RevenueScheduleLine{},
+	&models.AccountMapping{},
+	&models.ImportRun{},
+	&models.ImportError{},
+}
+
 func ConnectDatabase() {
 	dsn := "host=localhost user=dhawalpandya password='' dbname=invoxadb port=5432 sslmode=disable TimeZone=Asia/Shanghai"
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
@@ -26,15 +64,7 @@ func ConnectDatabase() {
 
 	// Auto-migrate models
 	log.Println("Running database migrations...")
-	err = DB.AutoMigrate(
-		&models.User{},
-		&models.Organization{},
-		&models.SubscriptionPlan{},
-		&models.Subscription{},
-		&models.Invoice{},
-		&models.Payment{},
-		&models.Refund{},
-	)
+	err = DB.AutoMigrate(Models...)
 	if err != nil {
 		log.Fatalf("Failed to auto migrate database: %v", err)
 	}
@@ -47,15 +77,7 @@ func ConnectDatabase() {
 func ClearDBAndMigrate() error {
 	log.Println("Clearing database...")
 	// Drop all tables
-	err := DB.Migrator().DropTable(
-		&models.User{},
-		&models.Organization{},
-		&models.SubscriptionPlan{},
-		&models.Subscription{},
-		&models.Invoice{},
-		&models.Payment{},
-		&models.Refund{},
-	)
+	err := DB.Migrator().DropTable(Models...)
 	if err != nil {
 		log.Printf("Failed to drop tables: %v", err)
 		return fmt.Errorf("failed to drop tables: %w", err)
@@ -63,15 +85,7 @@ func ClearDBAndMigrate() error {
 
 	log.Println("Database cleared. Running migrations again...")
 	// Re-run migrations
-	err = DB.AutoMigrate(
-		&models.User{},
-		&models.Organization{},
-		&models.SubscriptionPlan{},
-		&models.Subscription{},
-		&models.Invoice{},
-		&models.Payment{},
-		&models.Refund{},
-	)
+	err = DB.AutoMigrate(Models...)
 	if err != nil {
 		log.Printf("Failed to re-migrate database: %v", err)
 		return fmt.Errorf("failed to re-migrate database: %w", err)
@@ -0,0 +1,49 @@
+package documents
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// CreditNoteDocument records money given back against an invoice.
+type CreditNoteDocument struct {
+	Number        string
+	Date          time.Time
+	Currency      string
+	Amount        float64
+	Reason        string
+	InvoiceNumber string
+	Customer      models.Organization
+	Branding      Branding
+}
+
+// LoadCreditNote gathers a refund with the invoice it was issued against.
+func LoadCreditNote(db *gorm.DB, refundID uint) (*CreditNoteDocument, error) {
+	var refund models.Refund
+	if err := db.Preload("Invoice.Organization").First(&refund, refundID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load refund %d: %w", refundID, err)
+	}
+
+	doc := &CreditNoteDocument{
+		Number:        strconv.FormatUint(uint64(refund.ID), 10),
+		Date:          refund.RefundDate,
+		Currency:      refund.Currency,
+		Amount:        refund.Amount,
+		Reason:        refund.Reason,
+		InvoiceNumber: billing.InvoiceNumber(&refund.Invoice),
+		Customer:      refund.Invoice.Organization,
+	}
+
+	var err error
+	doc.Branding, err = LoadBranding(db, refund.Invoice.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,167 @@
+// Package documents renders customer-facing billing documents.
+package documents
+
+import (
+	"fmt"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+)
+
+const (
+	StatusPaid          = "Paid"
+	StatusOpen          = "Open"
+	StatusPastDue       = "Past due"
+	StatusUncollectible = "Uncollectible"
+)
+
+// DefaultPrimaryColor and DefaultAccentColor are used when an organization
+// has no branding.
+const (
+	DefaultPrimaryColor = "#1f2937"
+	DefaultAccentColor  = "#e5e7eb"
+)
+
+type Branding struct {
+	Logo         []byte
+	LogoType     string // 'png' or 'jpg'
+	PrimaryColor string
+	AccentColor  string
+	FooterText   string
+}
+
+type LineItem struct {
+	Description string
+	Amount      float64
+}
+
+type TaxLine struct {
+	Name          string
+	Rate          float64
+	TaxableAmount float64
+	Amount        float64
+}
+
+type PaymentLine struct {
+	Date   time.Time
+	Method string
+	Amount float64
+	Status string
+}
+
+// InvoiceDocument is everything shown on an invoice, independent of the
+// format it is rendered in.
+type InvoiceDocument struct {
+	Number        string
+	IssueDate     time.Time
+	DueDate       time.Time
+	Currency      string
+	Status        string
+	Customer      models.Organization
+	Lines         []LineItem
+	TaxLines      []TaxLine
+	Subtotal      float64
+	TaxAmount     float64
+	Total         float64
+	TaxInclusive  bool
+	ReverseCharge bool
+	CreditApplied float64
+	AmountPaid    float64
+	AmountDue     float64
+	Payments      []PaymentLine
+	Branding      Branding
+}
+
+// LoadBranding returns an organization's branding with defaults filled in.
+func LoadBranding(db *gorm.DB, organizationID uint) (Branding, error) {
+	branding := Branding{PrimaryColor: DefaultPrimaryColor, AccentColor: DefaultAccentColor}
+	var stored models.OrganizationBranding
+	result := db.Where("organization_id = ?", organizationID).Limit(1).Find(&stored)
+	if result.Error != nil {
+		return branding, fmt.Errorf("failed to load branding: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return branding, nil
+	}
+	branding.Logo = stored.Logo
+	branding.LogoType = stored.LogoType
+	branding.FooterText = stored.FooterText
+	if stored.PrimaryColor != "" {
+		branding.PrimaryColor = stored.PrimaryColor
+	}
+	if stored.AccentColor != "" {
+		branding.AccentColor = stored.AccentColor
+	}
+	return branding, nil
+}
+
+// LoadInvoice gathers an invoice with its customer, line items, taxes and
+// payments. now decides whether an open invoice is past due.
+func LoadInvoice(db *gorm.DB, invoiceID uint, now time.Time) (*InvoiceDocument, error) {
+	var invoice models.Invoice
+	err := db.Preload("Organization").Preload("TaxLines").Preload("Payments").First(&invoice, invoiceID).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invoice %d: %w", invoiceID, err)
+	}
+
+	doc := &InvoiceDocument{
+		Number:        billing.InvoiceNumber(&invoice),
+		IssueDate:     invoice.IssueDate,
+		DueDate:       invoice.DueDate,
+		Currency:      invoice.Currency,
+		Customer:      invoice.Organization,
+		Subtotal:      invoice.Subtotal,
+		TaxAmount:     invoice.TaxAmount,
+		Total:         invoice.Amount,
+		TaxInclusive:  invoice.TaxInclusive,
+		ReverseCharge: invoice.ReverseCharge,
+		CreditApplied: invoice.CreditApplied,
+		AmountDue:     billing.AmountDue(&invoice),
+	}
+	if doc.Subtotal == 0 && doc.TaxAmount == 0 {
+		// Invoices from before tax was introduced only have a total.
+		doc.Subtotal = invoice.Amount
+	}
+
+	switch {
+	case invoice.Paid:
+		doc.Status = StatusPaid
+		doc.AmountDue = 0
+	case invoice.Uncollectible:
+		doc.Status = StatusUncollectible
+	case now.After(invoice.DueDate):
+		doc.Status = StatusPastDue
+	default:
+		doc.Status = StatusOpen
+	}
+
+	line := LineItem{Description: "Invoice " + doc.Number, Amount: doc.Subtotal}
+	if invoice.SubscriptionID != nil {
+		var subscription models.Subscription
+		if err := db.Preload("SubscriptionPlan").First(&subscription, *invoice.SubscriptionID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load subscription %d: %w", *invoice.SubscriptionID, err)
+		}
+		line.Description = fmt.Sprintf("%s (%s)", subscription.SubscriptionPlan.Name, subscription.SubscriptionPlan.Interval)
+	}
+	doc.Lines = []LineItem{line}
+
+	for _, tax := range invoice.TaxLines {
+		doc.TaxLines = append(doc.TaxLines, TaxLine{Name: tax.Name, Rate: tax.Rate, TaxableAmount: tax.TaxableAmount, Amount: tax.Amount})
+	}
+	for _, payment := range invoice.Payments {
+		doc.Payments = append(doc.Payments, PaymentLine{Date: payment.PaymentDate, Method: payment.Provider, Amount: payment.Amount, Status: payment.ProviderStatus})
+		if payment.ProviderStatus != payments.StatusFailed && payment.ProviderStatus != payments.StatusDeclined {
+			doc.AmountPaid += payment.Amount
+		}
+	}
+
+	doc.Branding, err = LoadBranding(db, invoice.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
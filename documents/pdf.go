@@ -0,0 +1,277 @@
+package documents
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoxa/models"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	pageMargin   = 20.0
+	contentWidth = 170.0 // A4 width less both margins, in mm
+)
+
+// pdfEpoch is written as the creation date of every document so that the
+// same invoice always renders to the same bytes.
+var pdfEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ParseHexColor parses a "#rrggbb" color.
+func ParseHexColor(hex string) (r, g, b int, err error) {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return 0, 0, 0, fmt.Errorf("invalid color %q: expected #rrggbb", hex)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid color %q: expected #rrggbb", hex)
+	}
+	return int(value >> 16), int(value >> 8 & 0xff), int(value & 0xff), nil
+}
+
+func money(currency string, amount float64) string {
+	return fmt.Sprintf("%s %.2f", currency, amount)
+}
+
+// pdfColor is an RGB color.
+type pdfColor struct{ r, g, b int }
+
+// pdfDocument is a page being laid out in an organization's branding.
+type pdfDocument struct {
+	*gofpdf.Fpdf
+	tr      func(string) string
+	primary pdfColor
+	accent  pdfColor
+}
+
+func (d *pdfDocument) textColor(c pdfColor) { d.SetTextColor(c.r, c.g, c.b) }
+
+func (d *pdfDocument) bodyText() { d.SetTextColor(55, 65, 81) }
+
+func parseColor(hex string) (pdfColor, error) {
+	r, g, b, err := ParseHexColor(hex)
+	return pdfColor{r, g, b}, err
+}
+
+// newPDFDocument starts an A4 document with the branding's footer on every
+// page.
+func newPDFDocument(title string, branding Branding) (*pdfDocument, error) {
+	primary, err := parseColor(branding.PrimaryColor)
+	if err != nil {
+		return nil, err
+	}
+	accent, err := parseColor(branding.AccentColor)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetCatalogSort(true)
+	pdf.SetCreationDate(pdfEpoch)
+	pdf.SetModificationDate(pdfEpoch)
+	pdf.SetTitle(title, true)
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(true, 25)
+	d := &pdfDocument{Fpdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor(""), primary: primary, accent: accent}
+
+	if footer := branding.FooterText; footer != "" {
+		pdf.SetFooterFunc(func() {
+			pdf.SetY(-18)
+			pdf.SetFont("Helvetica", "", 8)
+			pdf.SetTextColor(107, 114, 128)
+			pdf.MultiCell(contentWidth, 4, d.tr(footer), "", "C", false)
+		})
+	}
+	pdf.AddPage()
+	return d, nil
+}
+
+// header draws the logo, or the organization's name without one, the
+// document title and its reference rows.
+func (d *pdfDocument) header(branding Branding, name, title string, rows [][2]string) {
+	if len(branding.Logo) > 0 {
+		imageType := strings.ToUpper(branding.LogoType)
+		options := gofpdf.ImageOptions{ImageType: imageType}
+		d.RegisterImageOptionsReader("logo", options, bytes.NewReader(branding.Logo))
+		if d.Ok() {
+			d.ImageOptions("logo", pageMargin, pageMargin, 0, 16, false, options, 0, "")
+		}
+	} else {
+		d.SetFont("Helvetica", "B", 16)
+		d.textColor(d.primary)
+		d.Text(pageMargin, pageMargin+8, d.tr(name))
+	}
+	d.SetFont("Helvetica", "B", 22)
+	d.textColor(d.primary)
+	d.SetXY(pageMargin, pageMargin)
+	d.CellFormat(contentWidth, 10, title, "", 1, "R", false, 0, "")
+	d.SetFont("Helvetica", "", 10)
+	d.bodyText()
+	for _, row := range rows {
+		d.CellFormat(contentWidth-50, 5, row[0], "", 0, "R", false, 0, "")
+		d.CellFormat(50, 5, d.tr(row[1]), "", 1, "R", false, 0, "")
+	}
+}
+
+// party draws a labelled block with a customer's billing details.
+func (d *pdfDocument) party(label string, customer models.Organization) {
+	d.Ln(8)
+	d.SetFont("Helvetica", "B", 10)
+	d.textColor(d.primary)
+	d.CellFormat(contentWidth, 6, label, "", 1, "L", false, 0, "")
+	d.SetFont("Helvetica", "", 10)
+	d.bodyText()
+	for _, line := range billingAddress(customer) {
+		d.CellFormat(contentWidth, 5, d.tr(line), "", 1, "L", false, 0, "")
+	}
+}
+
+// total draws a right-aligned label and amount.
+func (d *pdfDocument) total(label, value string, bold bool) {
+	style := ""
+	if bold {
+		style = "B"
+	}
+	d.SetFont("Helvetica", style, 10)
+	d.CellFormat(contentWidth-40, 6, d.tr(label), "", 0, "R", false, 0, "")
+	d.CellFormat(40, 6, value, "", 1, "R", false, 0, "")
+}
+
+func (d *pdfDocument) output(w io.Writer, what string) error {
+	if err := d.Output(w); err != nil {
+		return fmt.Errorf("failed to render %s: %w", what, err)
+	}
+	return nil
+}
+
+// RenderInvoicePDF writes an invoice as an A4 PDF. The output depends only on
+// the document, so it can be compared byte for byte in tests.
+func RenderInvoicePDF(w io.Writer, doc *InvoiceDocument) error {
+	pdf, err := newPDFDocument("Invoice "+doc.Number, doc.Branding)
+	if err != nil {
+		return err
+	}
+
+	pdf.header(doc.Branding, doc.Customer.Name, "INVOICE", [][2]string{
+		{"Invoice number", doc.Number},
+		{"Issue date", doc.IssueDate.Format("2006-01-02")},
+		{"Due date", doc.DueDate.Format("2006-01-02")},
+		{"Status", doc.Status},
+	})
+	pdf.party("Bill to", doc.Customer)
+
+	// Line items.
+	pdf.Ln(8)
+	pdf.SetFillColor(pdf.accent.r, pdf.accent.g, pdf.accent.b)
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.textColor(pdf.primary)
+	pdf.CellFormat(contentWidth-40, 8, "Description", "", 0, "L", true, 0, "")
+	pdf.CellFormat(40, 8, "Amount", "", 1, "R", true, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.bodyText()
+	for _, item := range doc.Lines {
+		pdf.CellFormat(contentWidth-40, 7, pdf.tr(item.Description), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(40, 7, money(doc.Currency, item.Amount), "B", 1, "R", false, 0, "")
+	}
+
+	// Totals.
+	pdf.Ln(3)
+	pdf.total("Subtotal", money(doc.Currency, doc.Subtotal), false)
+	for _, tax := range doc.TaxLines {
+		label := tax.Name
+		if tax.Rate != 0 {
+			label = fmt.Sprintf("%s (%s%%)", tax.Name, strconv.FormatFloat(tax.Rate, 'f', -1, 64))
+		}
+		pdf.total(label, money(doc.Currency, tax.Amount), false)
+	}
+	pdf.total("Total", money(doc.Currency, doc.Total), true)
+	if doc.CreditApplied > 0 {
+		pdf.total("Credit applied", money(doc.Currency, -doc.CreditApplied), false)
+	}
+	if doc.AmountPaid > 0 {
+		pdf.total("Amount paid", money(doc.Currency, -doc.AmountPaid), false)
+	}
+	pdf.textColor(pdf.primary)
+	pdf.total("Amount due", money(doc.Currency, doc.AmountDue), true)
+	pdf.bodyText()
+
+	// Notes.
+	pdf.Ln(6)
+	pdf.SetFont("Helvetica", "", 9)
+	if doc.TaxInclusive {
+		pdf.MultiCell(contentWidth, 5, "Prices include tax.", "", "L", false)
+	}
+	if doc.ReverseCharge {
+		pdf.MultiCell(contentWidth, 5, "Reverse charge: VAT to be accounted for by the recipient.", "", "L", false)
+	}
+
+	return pdf.output(w, "invoice "+doc.Number)
+}
+
+// RenderReceiptPDF writes a payment receipt as an A4 PDF. Like invoices,
+// receipts render to the same bytes every time.
+func RenderReceiptPDF(w io.Writer, doc *ReceiptDocument) error {
+	pdf, err := newPDFDocument("Receipt "+doc.Number, doc.Branding)
+	if err != nil {
+		return err
+	}
+
+	rows := [][2]string{
+		{"Receipt number", doc.Number},
+		{"Payment date", doc.Date.Format("2006-01-02")},
+		{"Invoice", doc.InvoiceNumber},
+	}
+	if doc.Method != "" {
+		rows = append(rows, [2]string{"Payment method", doc.Method})
+	}
+	pdf.header(doc.Branding, doc.Customer.Name, "RECEIPT", rows)
+	pdf.party("Received from", doc.Customer)
+	if doc.Payer != "" && doc.Payer != doc.Customer.Name {
+		pdf.CellFormat(contentWidth, 5, pdf.tr("Paid by "+doc.Payer), "", 1, "L", false, 0, "")
+	}
+
+	pdf.Ln(8)
+	pdf.SetFillColor(pdf.accent.r, pdf.accent.g, pdf.accent.b)
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.textColor(pdf.primary)
+	pdf.CellFormat(contentWidth-40, 8, "Amount paid", "", 0, "L", true, 0, "")
+	pdf.CellFormat(40, 8, money(doc.Currency, doc.Amount), "", 1, "R", true, 0, "")
+	pdf.bodyText()
+	pdf.Ln(3)
+	if doc.ChargedCurrency != "" {
+		pdf.total("Charged", money(doc.ChargedCurrency, doc.ChargedAmount), false)
+	}
+	pdf.total("Remaining balance on invoice "+doc.InvoiceNumber, money(doc.Currency, doc.RemainingBalance), false)
+
+	return pdf.output(w, "receipt "+doc.Number)
+}
+
+// billingAddress lists the customer's name, address and tax ID, skipping
+// empty lines.
+func billingAddress(customer models.Organization) []string {
+	cityLine := strings.TrimSpace(strings.Join(nonEmpty(customer.PostalCode, customer.City), " "))
+	if customer.Region != "" {
+		cityLine = strings.Join(nonEmpty(cityLine, customer.Region), ", ")
+	}
+	lines := nonEmpty(customer.Name, customer.BillingEmail, customer.AddressLine1, customer.AddressLine2, cityLine, customer.Country)
+	if customer.TaxID != "" {
+		lines = append(lines, "Tax ID: "+customer.TaxID)
+	}
+	return lines
+}
+
+func nonEmpty(values ...string) []string {
+	kept := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
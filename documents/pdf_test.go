@@ -0,0 +1,122 @@
+package documents
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func testLogo(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 8), G: 80, B: 160, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func testInvoiceDocument(t *testing.T) *InvoiceDocument {
+	return &InvoiceDocument{
+		Number:    "ACME-2026-000042",
+		IssueDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		DueDate:   time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC),
+		Currency:  "EUR",
+		Status:    StatusOpen,
+		Customer: models.Organization{
+			Name:         "Müller GmbH",
+			BillingEmail: "billing@mueller.example",
+			AddressLine1: "Hauptstraße 1",
+			City:         "Berlin",
+			PostalCode:   "10115",
+			Country:      "DE",
+			TaxID:        "DE123456789",
+		},
+		Lines:         []LineItem{{Description: "Pro Plan (monthly)", Amount: 100}},
+		TaxLines:      []TaxLine{{Name: "VAT", Rate: 19, TaxableAmount: 100, Amount: 19}},
+		Subtotal:      100,
+		TaxAmount:     19,
+		Total:         119,
+		CreditApplied: 10,
+		AmountDue:     109,
+		Branding: Branding{
+			Logo:         testLogo(t),
+			LogoType:     "png",
+			PrimaryColor: "#0f766e",
+			AccentColor:  "#ccfbf1",
+			FooterText:   "Acme Billing · Registered in Berlin · Thank you for your business",
+		},
+	}
+}
+
+func TestRenderInvoicePDFMatchesGolden(t *testing.T) {
+	var first, second bytes.Buffer
+	assert.NoError(t, RenderInvoicePDF(&first, testInvoiceDocument(t)))
+	assert.NoError(t, RenderInvoicePDF(&second, testInvoiceDocument(t)))
+	assert.Equal(t, first.Bytes(), second.Bytes(), "rendering is not deterministic")
+	assert.True(t, bytes.HasPrefix(first.Bytes(), []byte("%PDF-")))
+
+	golden := filepath.Join("testdata", "invoice.pdf")
+	if *update {
+		assert.NoError(t, os.MkdirAll("testdata", 0o755))
+		assert.NoError(t, os.WriteFile(golden, first.Bytes(), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(want, first.Bytes()), "rendered PDF differs from %s; run go test ./documents -update if the change is intended", golden)
+}
+
+func TestRenderInvoicePDFRejectsBadBranding(t *testing.T) {
+	doc := testInvoiceDocument(t)
+	doc.Branding.PrimaryColor = "teal"
+	assert.Error(t, RenderInvoicePDF(&bytes.Buffer{}, doc))
+
+	doc = testInvoiceDocument(t)
+	doc.Branding.Logo = []byte("not an image")
+	assert.Error(t, RenderInvoicePDF(&bytes.Buffer{}, doc))
+}
+
+func TestRenderReceiptPDFMatchesGolden(t *testing.T) {
+	invoice := testInvoiceDocument(t)
+	doc := &ReceiptDocument{
+		Number:           "RCPT-2026-000007",
+		Date:             time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC),
+		Payer:            "jane.doe",
+		Currency:         "EUR",
+		Amount:           50,
+		ChargedCurrency:  "USD",
+		ChargedAmount:    54.5,
+		Method:           "Visa ending in 4242",
+		InvoiceNumber:    invoice.Number,
+		RemainingBalance: 59,
+		Customer:         invoice.Customer,
+		Branding:         invoice.Branding,
+	}
+
+	var first, second bytes.Buffer
+	assert.NoError(t, RenderReceiptPDF(&first, doc))
+	assert.NoError(t, RenderReceiptPDF(&second, doc))
+	assert.Equal(t, first.Bytes(), second.Bytes(), "rendering is not deterministic")
+
+	golden := filepath.Join("testdata", "receipt.pdf")
+	if *update {
+		assert.NoError(t, os.WriteFile(golden, first.Bytes(), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(want, first.Bytes()), "rendered PDF differs from %s; run go test ./documents -update if the change is intended", golden)
+}
@@ -0,0 +1,59 @@
+package documents
+
+import (
+	"fmt"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// ReceiptDocument confirms a single payment towards an invoice.
+type ReceiptDocument struct {
+	Number           string
+	Date             time.Time
+	Payer            string
+	Currency         string
+	Amount           float64
+	ChargedCurrency  string // set when the payment was made in another currency than the invoice
+	ChargedAmount    float64
+	Method           string // e.g., "Visa ending in 4242"; empty when unknown
+	InvoiceNumber    string
+	RemainingBalance float64
+	Customer         models.Organization
+	Branding         Branding
+}
+
+// LoadReceipt gathers the receipt issued for a payment.
+func LoadReceipt(db *gorm.DB, paymentID uint) (*ReceiptDocument, error) {
+	var receipt models.Receipt
+	if err := db.Where("payment_id = ?", paymentID).First(&receipt).Error; err != nil {
+		return nil, fmt.Errorf("failed to load receipt of payment %d: %w", paymentID, err)
+	}
+	var customer models.Organization
+	if err := db.First(&customer, receipt.OrganizationID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load organization %d: %w", receipt.OrganizationID, err)
+	}
+
+	doc := &ReceiptDocument{
+		Number:           receipt.Number,
+		Date:             receipt.IssuedAt,
+		Payer:            receipt.Payer,
+		Currency:         receipt.Currency,
+		Amount:           receipt.Amount,
+		ChargedCurrency:  receipt.ChargedCurrency,
+		ChargedAmount:    receipt.ChargedAmount,
+		Method:           receipt.PaymentMethod,
+		InvoiceNumber:    receipt.InvoiceNumber,
+		RemainingBalance: receipt.RemainingBalance,
+		Customer:         customer,
+	}
+
+	var err error
+	doc.Branding, err = LoadBranding(db, receipt.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
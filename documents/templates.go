@@ -0,0 +1,314 @@
+package documents
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	KindInvoice    = "invoice"
+	KindReceipt    = "receipt"
+	KindCreditNote = "credit_note"
+)
+
+const (
+	FormatHTML = "html"
+	FormatText = "text"
+)
+
+var (
+	TemplateKinds   = []string{KindInvoice, KindReceipt, KindCreditNote}
+	TemplateFormats = []string{FormatHTML, FormatText}
+)
+
+const (
+	// MaxTemplateSize bounds the source of an uploaded template.
+	MaxTemplateSize = 64 << 10
+	// maxRenderedSize bounds the output of a template, so that a template
+	// cannot produce an unbounded document.
+	maxRenderedSize = 1 << 20
+)
+
+var ErrInvalidTemplate = errors.New("invalid template")
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+var templateExtensions = map[string]string{FormatHTML: ".html", FormatText: ".txt"}
+
+var textFuncs = template.FuncMap{
+	"money":   money,
+	"date":    func(t time.Time) string { return t.Format("2006-01-02") },
+	"percent": func(rate float64) string { return strconv.FormatFloat(rate, 'f', -1, 64) + "%" },
+	"address": billingAddress,
+}
+
+var htmlFuncs = htmltemplate.FuncMap{
+	"money":   textFuncs["money"],
+	"date":    textFuncs["date"],
+	"percent": textFuncs["percent"],
+	"address": textFuncs["address"],
+	"logo":    logoURL,
+}
+
+// logoURL embeds a logo in an HTML document as a data URL.
+func logoURL(branding Branding) htmltemplate.URL {
+	if len(branding.Logo) == 0 {
+		return ""
+	}
+	mime := "image/png"
+	if branding.LogoType == "jpg" {
+		mime = "image/jpeg"
+	}
+	return htmltemplate.URL("data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(branding.Logo))
+}
+
+func checkKindAndFormat(kind, format string) error {
+	if _, ok := templateExtensions[format]; !ok {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidTemplate, format)
+	}
+	for _, known := range TemplateKinds {
+		if kind == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown document kind %q", ErrInvalidTemplate, kind)
+}
+
+// DefaultTemplate returns the built-in template for a kind of document.
+func DefaultTemplate(kind, format string) (string, error) {
+	if err := checkKindAndFormat(kind, format); err != nil {
+		return "", err
+	}
+	body, err := defaultTemplates.ReadFile("templates/" + kind + templateExtensions[format])
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// ValidateTemplate checks that a template parses, stays within the limits
+// placed on uploaded templates, and renders the sample document of its kind.
+func ValidateTemplate(kind, format, body string) error {
+	if err := checkKindAndFormat(kind, format); err != nil {
+		return err
+	}
+	if len(body) > MaxTemplateSize {
+		return fmt.Errorf("%w: template must be at most %d KB", ErrInvalidTemplate, MaxTemplateSize>>10)
+	}
+	_, err := RenderTemplate(kind, format, body, SampleDocument(kind))
+	return err
+}
+
+// RenderTemplate executes a template against a document.
+func RenderTemplate(kind, format, body string, doc any) (string, error) {
+	if err := checkKindAndFormat(kind, format); err != nil {
+		return "", err
+	}
+	if err := checkTemplate(kind, format, body); err != nil {
+		return "", err
+	}
+
+	out := &limitedBuffer{limit: maxRenderedSize}
+	var err error
+	if format == FormatHTML {
+		var tmpl *htmltemplate.Template
+		tmpl, err = htmltemplate.New(kind).Funcs(htmlFuncs).Parse(body)
+		if err == nil {
+			err = tmpl.Execute(out, doc)
+		}
+	} else {
+		var tmpl *template.Template
+		tmpl, err = template.New(kind).Funcs(textFuncs).Parse(body)
+		if err == nil {
+			err = tmpl.Execute(out, doc)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	return out.String(), nil
+}
+
+// checkTemplate rejects the template features that could make rendering run
+// away: nested template definitions and calls, which allow recursion, and
+// ranges over numbers.
+func checkTemplate(kind, format, body string) error {
+	funcs := textFuncs
+	if format == FormatHTML {
+		funcs = template.FuncMap(htmlFuncs)
+	}
+	tmpl, err := template.New(kind).Funcs(funcs).Parse(body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if len(tmpl.Templates()) > 1 {
+		return fmt.Errorf("%w: define and block are not allowed", ErrInvalidTemplate)
+	}
+	if tmpl.Tree == nil {
+		return nil
+	}
+	return checkNode(tmpl.Tree.Root)
+}
+
+func checkNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return fmt.Errorf("%w: template calls are not allowed", ErrInvalidTemplate)
+	case *parse.RangeNode:
+		for _, cmd := range n.Pipe.Cmds {
+			for _, arg := range cmd.Args {
+				switch arg := arg.(type) {
+				case *parse.FieldNode, *parse.VariableNode, *parse.ChainNode, *parse.DotNode:
+				case *parse.IdentifierNode:
+					// Our functions return lists; builtins such as len
+					// return numbers, which range would count up to.
+					if _, ok := textFuncs[arg.Ident]; !ok {
+						return fmt.Errorf("%w: range over %s is not allowed", ErrInvalidTemplate, arg)
+					}
+				default:
+					return fmt.Errorf("%w: range is only allowed over fields of the document, not %s", ErrInvalidTemplate, arg)
+				}
+			}
+		}
+		return checkBranch(&n.BranchNode)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode)
+	}
+	return nil
+}
+
+func checkBranch(n *parse.BranchNode) error {
+	if err := checkNode(n.List); err != nil {
+		return err
+	}
+	if n.ElseList != nil {
+		return checkNode(n.ElseList)
+	}
+	return nil
+}
+
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("rendered document exceeds %d KB", b.limit>>10)
+	}
+	return b.Buffer.Write(p)
+}
+
+// Render renders a document with the organization's template for its kind
+// and format, or the default template when the organization has none. A
+// stored template that fails on this document is logged and the default is
+// used instead, so a customer always gets their document.
+func Render(db *gorm.DB, organizationID uint, kind, format string, doc any) (string, error) {
+	defaultBody, err := DefaultTemplate(kind, format)
+	if err != nil {
+		return "", err
+	}
+
+	var stored models.DocumentTemplate
+	result := db.Where("organization_id = ? AND kind = ? AND format = ?", organizationID, kind, format).Limit(1).Find(&stored)
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to load %s %s template: %w", kind, format, result.Error)
+	}
+	if result.RowsAffected > 0 {
+		rendered, renderErr := RenderTemplate(kind, format, stored.Body, doc)
+		if renderErr == nil {
+			return rendered, nil
+		}
+		log.Printf("documents: organization %d %s %s template failed, using the default: %v", organizationID, kind, format, renderErr)
+	}
+	return RenderTemplate(kind, format, defaultBody, doc)
+}
+
+// SampleDocument returns a document of the given kind filled with example
+// data. Uploaded templates are checked against it, and previews show it.
+func SampleDocument(kind string) any {
+	customer := models.Organization{
+		Name:         "Example Customer Ltd",
+		BillingEmail: "billing@example.com",
+		AddressLine1: "1 Example Street",
+		City:         "London",
+		PostalCode:   "EC1A 1AA",
+		Country:      "GB",
+		TaxID:        "GB123456789",
+	}
+	branding := Branding{PrimaryColor: DefaultPrimaryColor, AccentColor: DefaultAccentColor, FooterText: "Thank you for your business."}
+	issued := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	switch kind {
+	case KindReceipt:
+		return &ReceiptDocument{
+			Number:           "RCPT-2026-000001",
+			Date:             issued.AddDate(0, 0, 3),
+			Payer:            "jane.doe",
+			Currency:         "GBP",
+			Amount:           60,
+			ChargedCurrency:  "EUR",
+			ChargedAmount:    70.59,
+			Method:           "Visa ending in 4242",
+			InvoiceNumber:    "INV-2026-000001",
+			RemainingBalance: 60,
+			Customer:         customer,
+			Branding:         branding,
+		}
+	case KindCreditNote:
+		return &CreditNoteDocument{
+			Number:        "1001",
+			Date:          issued.AddDate(0, 0, 10),
+			Currency:      "GBP",
+			Amount:        20,
+			Reason:        "Service outage",
+			InvoiceNumber: "INV-2026-000001",
+			Customer:      customer,
+			Branding:      branding,
+		}
+	default:
+		return &InvoiceDocument{
+			Number:        "INV-2026-000001",
+			IssueDate:     issued,
+			DueDate:       issued.AddDate(0, 0, 30),
+			Currency:      "GBP",
+			Status:        StatusOpen,
+			Customer:      customer,
+			Lines:         []LineItem{{Description: "Pro Plan (monthly)", Amount: 100}},
+			TaxLines:      []TaxLine{{Name: "VAT", Rate: 20, TaxableAmount: 100, Amount: 20}},
+			Subtotal:      100,
+			TaxAmount:     20,
+			Total:         120,
+			CreditApplied: 10,
+			AmountPaid:    50,
+			AmountDue:     60,
+			Payments:      []PaymentLine{{Date: issued.AddDate(0, 0, 3), Method: "fake", Amount: 50, Status: "succeeded"}},
+			Branding:      branding,
+		}
+	}
+}
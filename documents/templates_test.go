@@ -0,0 +1,101 @@
+package documents
+
+import (
+	"strings"
+	"testing"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDefaultTemplatesRenderSamples(t *testing.T) {
+	for _, kind := range TemplateKinds {
+		for _, format := range TemplateFormats {
+			body, err := DefaultTemplate(kind, format)
+			assert.NoError(t, err)
+			assert.NoError(t, ValidateTemplate(kind, format, body), "%s %s", kind, format)
+		}
+	}
+
+	doc := SampleDocument(KindInvoice).(*InvoiceDocument)
+	body, _ := DefaultTemplate(KindInvoice, FormatText)
+	rendered, err := RenderTemplate(KindInvoice, FormatText, body, doc)
+	assert.NoError(t, err)
+	assert.Contains(t, rendered, "INVOICE INV-2026-000001")
+	assert.Contains(t, rendered, "VAT (20%): GBP 20.00")
+	assert.Contains(t, rendered, "Amount due: GBP 60.00")
+}
+
+func TestHTMLTemplatesEscapeDocumentData(t *testing.T) {
+	doc := SampleDocument(KindInvoice).(*InvoiceDocument)
+	doc.Customer.Name = `<script>alert("x")</script>`
+	doc.Branding.Logo = testLogo(t)
+	doc.Branding.LogoType = "png"
+
+	body, _ := DefaultTemplate(KindInvoice, FormatHTML)
+	rendered, err := RenderTemplate(KindInvoice, FormatHTML, body, doc)
+	assert.NoError(t, err)
+	assert.NotContains(t, rendered, "<script>")
+	assert.Contains(t, rendered, "&lt;script&gt;")
+	assert.Contains(t, rendered, `src="data:image/png;base64,`)
+}
+
+func TestValidateTemplateRejectsUnsafeTemplates(t *testing.T) {
+	for name, body := range map[string]string{
+		"syntax error":  "{{.Number",
+		"unknown field": "{{.Nope}}",
+		"define":        `{{define "x"}}{{end}}{{.Number}}`,
+		"template call": `{{template "invoice" .}}`,
+		"range number":  "{{range 1000000000}}x{{end}}",
+		"range len":     "{{range len .Lines}}x{{end}}",
+		"nested range":  "{{range .Lines}}{{range 100000}}{{range 100000}}{{end}}{{end}}{{end}}",
+		"too large":     strings.Repeat("x", MaxTemplateSize+1),
+	} {
+		err := ValidateTemplate(KindInvoice, FormatText, body)
+		assert.ErrorIs(t, err, ErrInvalidTemplate, name)
+	}
+
+	assert.Error(t, ValidateTemplate("statement", FormatText, "{{.Number}}"))
+	assert.Error(t, ValidateTemplate(KindInvoice, "markdown", "{{.Number}}"))
+	// Fields of another kind of document are caught against its sample.
+	assert.Error(t, ValidateTemplate(KindReceipt, FormatText, "{{.DueDate}}"))
+
+	// Output is capped even when every construct is allowed.
+	huge := "{{range .Lines}}" + strings.Repeat("x", MaxTemplateSize-40) + "{{end}}"
+	doc := SampleDocument(KindInvoice).(*InvoiceDocument)
+	for i := 0; i < 20; i++ {
+		doc.Lines = append(doc.Lines, doc.Lines[0])
+	}
+	_, err := RenderTemplate(KindInvoice, FormatText, huge, doc)
+	assert.ErrorIs(t, err, ErrInvalidTemplate)
+}
+
+func TestRenderUsesOrganizationTemplate(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(database.Models...))
+	doc := SampleDocument(KindReceipt)
+
+	rendered, err := Render(db, 1, KindReceipt, FormatText, doc)
+	assert.NoError(t, err)
+	assert.Contains(t, rendered, "RECEIPT RCPT-2026-000001")
+
+	db.Create(&models.DocumentTemplate{OrganizationID: 1, Kind: KindReceipt, Format: FormatText, Body: "Thanks for {{money .Currency .Amount}}"})
+	rendered, err = Render(db, 1, KindReceipt, FormatText, doc)
+	assert.NoError(t, err)
+	assert.Equal(t, "Thanks for GBP 60.00", rendered)
+
+	// Other organizations and formats keep the defaults.
+	rendered, _ = Render(db, 2, KindReceipt, FormatText, doc)
+	assert.Contains(t, rendered, "RECEIPT RCPT-2026-000001")
+
+	// A stored template that fails on a real document falls back to the default.
+	db.Model(&models.DocumentTemplate{}).Where("organization_id = 1").Update("body", "{{.Missing}}")
+	rendered, err = Render(db, 1, KindReceipt, FormatText, doc)
+	assert.NoError(t, err)
+	assert.Contains(t, rendered, "RECEIPT RCPT-2026-000001")
+}
@@ -0,0 +1,184 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxAttempts is the number of dispatches tried before an event is
+	// marked failed and left for inspection.
+	MaxAttempts = 10
+	retryDelay  = 30 * time.Second
+	maxDelay    = time.Hour
+	batchSize   = 100
+)
+
+// Handler reacts to an event. It runs in a transaction together with the
+// record that the subscriber has handled the event, so database changes it
+// makes are applied exactly once. Side effects outside the database may be
+// repeated if the transaction fails, and should be idempotent.
+type Handler func(ctx context.Context, tx *gorm.DB, event *Event) error
+
+type subscriber struct {
+	name    string
+	types   map[string]bool // nil means every type
+	handler Handler
+}
+
+func (s *subscriber) wants(eventType string) bool {
+	return s.types == nil || s.types[eventType]
+}
+
+// Dispatcher delivers outbox events to subscribers, oldest first. A
+// subscriber that fails is retried later without repeating the subscribers
+// that already handled the event; other events are not held up meanwhile.
+type Dispatcher struct {
+	subscribers []*subscriber
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{}
+}
+
+// Subscribe registers a handler under a unique name for the given event
+// types, or for every event when none are given. The name identifies the
+// subscriber's progress in the outbox and must not change between releases.
+func (d *Dispatcher) Subscribe(name string, handler Handler, eventTypes ...string) {
+	for _, existing := range d.subscribers {
+		if existing.name == name {
+			panic(fmt.Sprintf("events: subscriber %q registered twice", name))
+		}
+	}
+
+	s := &subscriber{name: name, handler: handler}
+	if len(eventTypes) > 0 {
+		s.types = make(map[string]bool, len(eventTypes))
+		for _, eventType := range eventTypes {
+			s.types[eventType] = true
+		}
+	}
+	d.subscribers = append(d.subscribers, s)
+}
+
+type DispatchReport struct {
+	Dispatched int `json:"dispatched"`
+	Retrying   int `json:"retrying"`
+	Failed     int `json:"failed"`
+}
+
+// DispatchPending hands every due pending event to its subscribers.
+func (d *Dispatcher) DispatchPending(ctx context.Context, db *gorm.DB, now time.Time) (*DispatchReport, error) {
+	var pending []models.OutboxEvent
+	err := db.Where("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", StatusPending, now).
+		Order("id").
+		Limit(batchSize).
+		Find(&pending).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending events: %w", err)
+	}
+
+	report := &DispatchReport{}
+	for i := range pending {
+		if err := d.dispatch(ctx, db, &pending[i], now); err != nil {
+			return report, err
+		}
+		switch pending[i].Status {
+		case StatusDispatched:
+			report.Dispatched++
+		case StatusFailed:
+			report.Failed++
+		default:
+			report.Retrying++
+		}
+	}
+	return report, nil
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, db *gorm.DB, outbox *models.OutboxEvent, now time.Time) error {
+	var handled []string
+	if err := db.Model(&models.EventHandling{}).Where("outbox_event_id = ?", outbox.ID).Pluck("subscriber", &handled).Error; err != nil {
+		return fmt.Errorf("failed to load handling of event %s: %w", outbox.EventID, err)
+	}
+	done := make(map[string]bool, len(handled))
+	for _, name := range handled {
+		done[name] = true
+	}
+
+	event := fromOutbox(outbox)
+	var failures []string
+	for _, s := range d.subscribers {
+		if done[s.name] || !s.wants(event.Type) {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := s.handler(ctx, tx, event); err != nil {
+				return err
+			}
+			return tx.Create(&models.EventHandling{OutboxEventID: outbox.ID, Subscriber: s.name, HandledAt: now}).Error
+		})
+		if err != nil {
+			log.Printf("Subscriber %s failed to handle event %s (%s): %v", s.name, event.ID, event.Type, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", s.name, err))
+		}
+	}
+
+	outbox.Attempts++
+	switch {
+	case len(failures) == 0:
+		outbox.Status = StatusDispatched
+		outbox.DispatchedAt = &now
+		outbox.NextAttemptAt = nil
+		outbox.LastError = ""
+	case outbox.Attempts >= MaxAttempts:
+		outbox.Status = StatusFailed
+		outbox.NextAttemptAt = nil
+		outbox.LastError = strings.Join(failures, "; ")
+	default:
+		delay := retryDelay << (outbox.Attempts - 1)
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+		next := now.Add(delay)
+		outbox.NextAttemptAt = &next
+		outbox.LastError = strings.Join(failures, "; ")
+	}
+
+	err := db.Model(outbox).Updates(map[string]interface{}{
+		"status":          outbox.Status,
+		"attempts":        outbox.Attempts,
+		"next_attempt_at": outbox.NextAttemptAt,
+		"last_error":      outbox.LastError,
+		"dispatched_at":   outbox.DispatchedAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update event %s: %w", outbox.EventID, err)
+	}
+	return nil
+}
+
+// Run dispatches pending events every interval until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := d.DispatchPending(ctx, db, time.Now())
+			if err != nil {
+				log.Printf("Event dispatch failed: %v", err)
+			} else if report.Retrying > 0 || report.Failed > 0 {
+				log.Printf("Dispatched %d events (%d to retry, %d failed)", report.Dispatched, report.Retrying, report.Failed)
+			}
+		}
+	}
+}
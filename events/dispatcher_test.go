@@ -0,0 +1,101 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupEventsDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(database.Models...))
+	return db
+}
+
+func TestPublishIsTransactional(t *testing.T) {
+	db := setupEventsDB(t)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := Publish(tx, 1, InvoiceCreated, map[string]int{"id": 1}); err != nil {
+			return err
+		}
+		return errors.New("roll back")
+	})
+	assert.Error(t, err)
+
+	var count int64
+	db.Model(&models.OutboxEvent{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestDispatcherRetriesOnlyFailedSubscribers(t *testing.T) {
+	db := setupEventsDB(t)
+	ctx := context.Background()
+
+	assert.NoError(t, Publish(db, 1, InvoicePaid, map[string]int{"id": 42}))
+	assert.NoError(t, Publish(db, 1, CreditGranted, map[string]int{"id": 7}))
+
+	var audited []string
+	var paidInvoices []int
+	failing := true
+
+	dispatcher := NewDispatcher()
+	dispatcher.Subscribe("audit", func(ctx context.Context, tx *gorm.DB, event *Event) error {
+		audited = append(audited, event.Type)
+		return nil
+	})
+	dispatcher.Subscribe("flaky", func(ctx context.Context, tx *gorm.DB, event *Event) error {
+		if failing {
+			return errors.New("downstream unavailable")
+		}
+		var data struct{ ID int }
+		assert.NoError(t, event.Decode(&data))
+		paidInvoices = append(paidInvoices, data.ID)
+		return nil
+	}, InvoicePaid)
+
+	now := time.Now()
+	report, err := dispatcher.DispatchPending(ctx, db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Dispatched)
+	assert.Equal(t, 1, report.Retrying)
+	assert.Equal(t, []string{InvoicePaid, CreditGranted}, audited)
+
+	var outbox models.OutboxEvent
+	db.Where("type = ?", InvoicePaid).First(&outbox)
+	assert.Equal(t, StatusPending, outbox.Status)
+	assert.Contains(t, outbox.LastError, "downstream unavailable")
+
+	// Not due again until the retry delay has passed.
+	report, _ = dispatcher.DispatchPending(ctx, db, now.Add(time.Second))
+	assert.Equal(t, 0, report.Dispatched+report.Retrying)
+
+	failing = false
+	report, err = dispatcher.DispatchPending(ctx, db, now.Add(retryDelay))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Dispatched)
+	assert.Equal(t, []int{42}, paidInvoices)
+	// The subscriber that already handled the event does not see it again.
+	assert.Len(t, audited, 2)
+
+	var dispatched models.OutboxEvent
+	db.First(&dispatched, outbox.ID)
+	assert.Equal(t, StatusDispatched, dispatched.Status)
+	assert.NotNil(t, dispatched.DispatchedAt)
+}
+
+func TestSubscribeRejectsDuplicateNames(t *testing.T) {
+	dispatcher := NewDispatcher()
+	handler := func(ctx context.Context, tx *gorm.DB, event *Event) error { return nil }
+	dispatcher.Subscribe("audit", handler)
+	assert.Panics(t, func() { dispatcher.Subscribe("audit", handler) })
+}
@@ -0,0 +1,119 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// Billing event types. The data of each event is the record named first.
+const (
+	SubscriptionCreated   = "subscription.created"  // subscription
+	SubscriptionUpgraded  = "subscription.upgraded" // old and new subscription
+	SubscriptionRenewed   = "subscription.renewed"  // subscription
+	SubscriptionCancelled = "subscription.cancelled"
+	SubscriptionPaused    = "subscription.paused"
+	InvoiceCreated        = "invoice.created"
+	InvoicePaid           = "invoice.paid"
+	InvoiceUncollectible  = "invoice.uncollectible"
+	CollectionFailed      = "invoice.collection_failed" // collection attempt
+	PaymentSucceeded      = "payment.succeeded"         // payment
+	PaymentFailed         = "payment.failed"            // payment reversed after it had succeeded
+	PaymentRefunded       = "payment.refunded"          // refund
+	CreditGranted         = "credit.granted"            // credit entry
+	DisputeOpened         = "dispute.opened"            // dispute
+	DisputeUpdated        = "dispute.updated"
+	DisputeWon            = "dispute.won"
+	DisputeLost           = "dispute.lost"
+)
+
+// Types lists every event type.
+var Types = []string{
+	SubscriptionCreated,
+	SubscriptionUpgraded,
+	SubscriptionRenewed,
+	SubscriptionCancelled,
+	SubscriptionPaused,
+	InvoiceCreated,
+	InvoicePaid,
+	InvoiceUncollectible,
+	CollectionFailed,
+	PaymentSucceeded,
+	PaymentFailed,
+	PaymentRefunded,
+	CreditGranted,
+	DisputeOpened,
+	DisputeUpdated,
+	DisputeWon,
+	DisputeLost,
+}
+
+const (
+	StatusPending    = "pending"
+	StatusDispatched = "dispatched"
+	StatusFailed     = "failed"
+)
+
+// Event is a billing action that has been committed.
+type Event struct {
+	ID             string          `json:"id"`
+	Type           string          `json:"type"`
+	OrganizationID uint            `json:"organization_id"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// Decode unmarshals the event data.
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// NewID returns a random event identifier.
+func NewID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return "evt_" + hex.EncodeToString(b)
+}
+
+// Publish writes an event to the outbox. Pass the transaction that makes the
+// change the event describes: the event then exists if and only if the change
+// is committed, and subscribers see it once the dispatcher picks it up.
+func Publish(tx *gorm.DB, organizationID uint, eventType string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	now := time.Now()
+	outbox := models.OutboxEvent{
+		EventID:        NewID(),
+		OrganizationID: organizationID,
+		Type:           eventType,
+		Payload:        string(encoded),
+		OccurredAt:     now,
+		Status:         StatusPending,
+		NextAttemptAt:  &now,
+	}
+	if err := tx.Create(&outbox).Error; err != nil {
+		return fmt.Errorf("failed to publish %s event: %w", eventType, err)
+	}
+	return nil
+}
+
+func fromOutbox(outbox *models.OutboxEvent) *Event {
+	return &Event{
+		ID:             outbox.EventID,
+		Type:           outbox.Type,
+		OrganizationID: outbox.OrganizationID,
+		OccurredAt:     outbox.OccurredAt,
+		Data:           json.RawMessage(outbox.Payload),
+	}
+}
@@ -0,0 +1,279 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// journalLine is a line of a journal entry, with the account it books to
+// mapped to the organization's chart of accounts.
+type journalLine struct {
+	Entry     *models.JournalEntry
+	Reference string // invoice number, payment transaction or credit note number
+	Account   billing.LedgerAccount
+	Currency  string
+	Debit     float64
+	Credit    float64
+}
+
+// references returns the document each entry of a batch was posted for.
+// Refunds are referred to by their credit note, which is numbered after the
+// refund.
+func references(db *gorm.DB, batch []models.JournalEntry) (map[string]map[uint]string, error) {
+	ids := map[string][]uint{}
+	for _, entry := range batch {
+		ids[entry.SourceType] = append(ids[entry.SourceType], entry.SourceID)
+	}
+	refs := map[string]map[uint]string{billing.SourceInvoice: {}, billing.SourcePayment: {}}
+
+	if len(ids[billing.SourceInvoice]) > 0 {
+		var invoices []models.Invoice
+		if err := db.Unscoped().Select("id, number").Where("id IN ?", ids[billing.SourceInvoice]).Find(&invoices).Error; err != nil {
+			return nil, fmt.Errorf("failed to load invoices: %w", err)
+		}
+		for i := range invoices {
+			refs[billing.SourceInvoice][invoices[i].ID] = billing.InvoiceNumber(&invoices[i])
+		}
+	}
+	if len(ids[billing.SourcePayment]) > 0 {
+		var payments []models.Payment
+		if err := db.Unscoped().Select("id, transaction_id").Where("id IN ?", ids[billing.SourcePayment]).Find(&payments).Error; err != nil {
+			return nil, fmt.Errorf("failed to load payments: %w", err)
+		}
+		for _, payment := range payments {
+			refs[billing.SourcePayment][payment.ID] = payment.TransactionID
+		}
+	}
+	return refs, nil
+}
+
+// journalLines flattens a batch of journal entries into their lines.
+func journalLines(db *gorm.DB, batch []models.JournalEntry) ([]journalLine, error) {
+	refs, err := references(db, batch)
+	if err != nil {
+		return nil, err
+	}
+	charts := map[uint]map[string]billing.LedgerAccount{}
+
+	var lines []journalLine
+	for i := range batch {
+		entry := &batch[i]
+		chart, ok := charts[entry.OrganizationID]
+		if !ok {
+			accounts, err := billing.ChartOfAccounts(db, entry.OrganizationID)
+			if err != nil {
+				return nil, err
+			}
+			chart = map[string]billing.LedgerAccount{}
+			for _, account := range accounts {
+				chart[account.Account] = account
+			}
+			charts[entry.OrganizationID] = chart
+		}
+
+		reference, ok := refs[entry.SourceType][entry.SourceID]
+		if !ok {
+			reference = strconv.FormatUint(uint64(entry.SourceID), 10)
+		}
+		sort.Slice(entry.Lines, func(a, b int) bool { return entry.Lines[a].ID < entry.Lines[b].ID })
+		for _, line := range entry.Lines {
+			account, ok := chart[line.Account]
+			if !ok {
+				account = billing.LedgerAccount{Account: line.Account, Code: line.Account, Name: line.Account}
+			}
+			lines = append(lines, journalLine{
+				Entry:     entry,
+				Reference: reference,
+				Account:   account,
+				Currency:  line.Currency,
+				Debit:     line.Debit,
+				Credit:    line.Credit,
+			})
+		}
+	}
+	return lines, nil
+}
+
+// journal is the double-entry journal. Besides CSV and NDJSON it can be
+// written for QuickBooks and Xero, whose encoders pick the columns they need
+// by name.
+var journal = &dataset[models.JournalEntry, journalLine]{
+	table:      "journal_entries",
+	dateColumn: "posted_at",
+	scope:      byOrganization,
+	preload:    []string{"Organization", "Lines"},
+	rows:       journalLines,
+	columns: []column[journalLine]{
+		{"entry_id", func(l *journalLine) any { return l.Entry.ID }},
+		{"date", func(l *journalLine) any { return l.Entry.PostedAt }},
+		{"source_type", func(l *journalLine) any { return l.Entry.SourceType }},
+		{"source_id", func(l *journalLine) any { return l.Entry.SourceID }},
+		{"reference", func(l *journalLine) any { return l.Reference }},
+		{"organization_id", func(l *journalLine) any { return l.Entry.OrganizationID }},
+		{"customer", func(l *journalLine) any { return l.Entry.Organization.Name }},
+		{"description", func(l *journalLine) any { return l.Entry.Description }},
+		{"account", func(l *journalLine) any { return l.Account.Account }},
+		{"account_code", func(l *journalLine) any { return l.Account.Code }},
+		{"account_name", func(l *journalLine) any { return l.Account.Name }},
+		{"currency", func(l *journalLine) any { return l.Currency }},
+		{"debit", func(l *journalLine) any { return amount(l.Debit) }},
+		{"credit", func(l *journalLine) any { return amount(l.Credit) }},
+	},
+}
+
+// journalFields reads the columns of a journal row by name.
+type journalFields map[string]int
+
+func (f journalFields) text(values []any, name string) string {
+	return formatCSV(values[f[name]])
+}
+
+func (f journalFields) date(values []any, name string) time.Time {
+	t, _ := values[f[name]].(time.Time)
+	return t
+}
+
+// signed is a line's debit as a positive amount or its credit as a negative
+// one.
+func (f journalFields) signed(values []any) string {
+	debit, _ := values[f["debit"]].(amount)
+	credit, _ := values[f["credit"]].(amount)
+	return strconv.FormatFloat(float64(debit-credit), 'f', 2, 64)
+}
+
+func newJournalFields(names []string) journalFields {
+	fields := journalFields{}
+	for i, name := range names {
+		fields[name] = i
+	}
+	return fields
+}
+
+// iifEncoder writes journal entries as QuickBooks IIF transactions: a TRNS
+// line for the first line of an entry and an SPL line for each of the
+// others. Invoices and payments are imported as such; write-offs, refunds,
+// credit and disputes as general journal entries.
+type iifEncoder struct {
+	w      io.Writer
+	buf    bytes.Buffer
+	fields journalFields
+	entry  string // the entry a transaction is open for
+	kind   string
+}
+
+var iifHeader = strings.Join([]string{
+	"!TRNS\tTRNSID\tTRNSTYPE\tDATE\tACCNT\tNAME\tAMOUNT\tDOCNUM\tMEMO",
+	"!SPL\tSPLID\tTRNSTYPE\tDATE\tACCNT\tNAME\tAMOUNT\tDOCNUM\tMEMO",
+	"!ENDTRNS",
+}, "\r\n") + "\r\n"
+
+// iifField keeps tabs and line breaks, which separate fields and records,
+// out of a value.
+var iifField = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ", `"`, "'")
+
+func (e *iifEncoder) header(names []string) error {
+	e.fields = newJournalFields(names)
+	e.buf.WriteString(iifHeader)
+	return nil
+}
+
+// iifTransactionType is the kind of transaction QuickBooks imports an entry
+// as, judged by the source and first line of the entry.
+func iifTransactionType(sourceType, account string, debit bool) string {
+	switch {
+	case sourceType == billing.SourceInvoice && account == billing.AccountReceivable && debit:
+		return "INVOICE"
+	case sourceType == billing.SourcePayment && account == billing.AccountCash && debit:
+		return "PAYMENT"
+	default:
+		return "GENERAL JOURNAL"
+	}
+}
+
+func (e *iifEncoder) row(_ []string, values []any) error {
+	record := "SPL"
+	if entry := e.fields.text(values, "entry_id"); entry != e.entry {
+		e.end()
+		e.entry = entry
+		debit, _ := values[e.fields["debit"]].(amount)
+		e.kind = iifTransactionType(e.fields.text(values, "source_type"), e.fields.text(values, "account"), debit > 0)
+		record = "TRNS"
+	}
+	fields := []string{
+		record,
+		"",
+		e.kind,
+		e.fields.date(values, "date").UTC().Format("01/02/2006"),
+		e.fields.text(values, "account_name"),
+		e.fields.text(values, "customer"),
+		e.fields.signed(values),
+		e.fields.text(values, "reference"),
+		e.fields.text(values, "description"),
+	}
+	for i := range fields {
+		fields[i] = iifField.Replace(fields[i])
+	}
+	e.buf.WriteString(strings.Join(fields, "\t"))
+	e.buf.WriteString("\r\n")
+	return nil
+}
+
+func (e *iifEncoder) end() {
+	if e.entry != "" {
+		e.buf.WriteString("ENDTRNS\r\n")
+		e.entry = ""
+	}
+}
+
+// flush closes the open transaction; entries never span batches.
+func (e *iifEncoder) flush() error {
+	e.end()
+	_, err := e.w.Write(e.buf.Bytes())
+	e.buf.Reset()
+	return err
+}
+
+// XeroTaxRate is the tax rate given to lines of Xero manual journals. Tax is
+// booked to its own account, so the lines themselves carry none.
+var XeroTaxRate = "Tax Exempt"
+
+// xeroEncoder writes journal entries as a Xero manual journal import file,
+// one journal per entry.
+type xeroEncoder struct {
+	w      *csv.Writer
+	fields journalFields
+}
+
+func (e *xeroEncoder) header(names []string) error {
+	e.fields = newJournalFields(names)
+	return e.w.Write([]string{"*Narration", "*Date", "Description", "*AccountCode", "*TaxRate", "*Amount"})
+}
+
+func (e *xeroEncoder) row(_ []string, values []any) error {
+	return e.w.Write([]string{
+		// Xero groups lines into journals by narration and date, so the
+		// entry is named to keep entries of a day apart.
+		EscapeFormula(fmt.Sprintf("%s (entry %s)", e.fields.text(values, "description"), e.fields.text(values, "entry_id"))),
+		e.fields.date(values, "date").UTC().Format("02/01/2006"),
+		EscapeFormula(e.fields.text(values, "customer") + " " + e.fields.text(values, "reference")),
+		EscapeFormula(e.fields.text(values, "account_code")),
+		XeroTaxRate,
+		e.fields.signed(values),
+	})
+}
+
+func (e *xeroEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
@@ -0,0 +1,138 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// bookSale journals an invoice with tax, its payment and a partial refund,
+// all posted on March 5th.
+func bookSale(t *testing.T, db *gorm.DB, org *models.Organization) {
+	number := "INV-0001"
+	invoice := models.Invoice{OrganizationID: org.ID, Number: &number, Amount: 120, TaxAmount: 20, Currency: "USD", IssueDate: day(time.March, 5), DueDate: day(time.March, 19)}
+	assert.NoError(t, db.Create(&invoice).Error)
+	assert.NoError(t, billing.RecordInvoice(db, &invoice))
+	payment := models.Payment{InvoiceID: invoice.ID, Amount: 120, Currency: "USD", PaymentDate: day(time.March, 5), TransactionID: "txn_1"}
+	assert.NoError(t, db.Create(&payment).Error)
+	assert.NoError(t, billing.RecordPayment(db, &invoice, &payment, 120))
+	refund := models.Refund{InvoiceID: invoice.ID, PaymentID: payment.ID, Amount: 30, Currency: "USD", RefundDate: day(time.March, 5), TransactionID: "re_1"}
+	assert.NoError(t, db.Create(&refund).Error)
+	assert.NoError(t, billing.RecordRefund(db, org.ID, &refund))
+	assert.NoError(t, db.Model(&models.JournalEntry{}).Where("1 = 1").Update("posted_at", day(time.March, 5)).Error)
+}
+
+func exportJournal(t *testing.T, db *gorm.DB, format string, organizationID uint) string {
+	var out bytes.Buffer
+	_, err := Write(context.Background(), db, &out, Options{Dataset: "journal", Format: format, OrganizationID: organizationID})
+	assert.NoError(t, err)
+	return out.String()
+}
+
+func TestExportJournalWithAccountMapping(t *testing.T) {
+	db, org := setupExportDB(t)
+	bookSale(t, db, org)
+	db.Create(&models.AccountMapping{OrganizationID: org.ID, Account: billing.AccountRevenue, Code: "200", Name: "Subscription Revenue"})
+
+	records, err := csv.NewReader(strings.NewReader(exportJournal(t, db, FormatCSV, org.ID))).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"entry_id", "date", "source_type", "source_id", "reference", "organization_id", "customer", "description", "account", "account_code", "account_name", "currency", "debit", "credit"}, records[0])
+	assert.Len(t, records, 9) // three lines each for the invoice and refund, two for the payment
+
+	var accounts [][]string
+	for _, record := range records[1:] {
+		accounts = append(accounts, []string{record[2], record[4], record[9], record[10], record[12], record[13]})
+	}
+	assert.Equal(t, [][]string{
+		{"invoice", "INV-0001", "1100", "Accounts Receivable", "120.00", "0.00"},
+		{"invoice", "INV-0001", "200", "Subscription Revenue", "0.00", "100.00"},
+		{"invoice", "INV-0001", "2200", "Sales Tax Payable", "0.00", "20.00"},
+		{"payment", "txn_1", "1000", "Cash", "120.00", "0.00"},
+		{"payment", "txn_1", "1100", "Accounts Receivable", "0.00", "120.00"},
+		{"refund", "1", "4100", "Refunds", "25.00", "0.00"}, // referred to by its credit note
+		{"refund", "1", "2200", "Sales Tax Payable", "5.00", "0.00"},
+		{"refund", "1", "1000", "Cash", "0.00", "30.00"},
+	}, accounts)
+}
+
+func TestExportJournalForQuickBooks(t *testing.T) {
+	db, org := setupExportDB(t)
+	bookSale(t, db, org)
+
+	assert.Equal(t, strings.Join([]string{
+		"!TRNS\tTRNSID\tTRNSTYPE\tDATE\tACCNT\tNAME\tAMOUNT\tDOCNUM\tMEMO",
+		"!SPL\tSPLID\tTRNSTYPE\tDATE\tACCNT\tNAME\tAMOUNT\tDOCNUM\tMEMO",
+		"!ENDTRNS",
+		"TRNS\t\tINVOICE\t03/05/2026\tAccounts Receivable\tAcme, Inc.\t120.00\tINV-0001\tInvoice 1 finalized",
+		"SPL\t\tINVOICE\t03/05/2026\tSales\tAcme, Inc.\t-100.00\tINV-0001\tInvoice 1 finalized",
+		"SPL\t\tINVOICE\t03/05/2026\tSales Tax Payable\tAcme, Inc.\t-20.00\tINV-0001\tInvoice 1 finalized",
+		"ENDTRNS",
+		"TRNS\t\tPAYMENT\t03/05/2026\tCash\tAcme, Inc.\t120.00\ttxn_1\tPayment 1 on invoice 1",
+		"SPL\t\tPAYMENT\t03/05/2026\tAccounts Receivable\tAcme, Inc.\t-120.00\ttxn_1\tPayment 1 on invoice 1",
+		"ENDTRNS",
+		"TRNS\t\tGENERAL JOURNAL\t03/05/2026\tRefunds\tAcme, Inc.\t25.00\t1\tRefund 1 of payment 1",
+		"SPL\t\tGENERAL JOURNAL\t03/05/2026\tSales Tax Payable\tAcme, Inc.\t5.00\t1\tRefund 1 of payment 1",
+		"SPL\t\tGENERAL JOURNAL\t03/05/2026\tCash\tAcme, Inc.\t-30.00\t1\tRefund 1 of payment 1",
+		"ENDTRNS",
+	}, "\r\n")+"\r\n", exportJournal(t, db, FormatIIF, org.ID))
+
+	// Entries never span batches, so every transaction is closed.
+	defer func(size int) { BatchSize = size }(BatchSize)
+	BatchSize = 1
+	iif := exportJournal(t, db, FormatIIF, org.ID)
+	assert.Equal(t, 3, strings.Count(iif, "\nTRNS\t"))
+	assert.Equal(t, 3, strings.Count(iif, "\nENDTRNS\r\n"))
+}
+
+func TestExportJournalForXero(t *testing.T) {
+	db, org := setupExportDB(t)
+	bookSale(t, db, org)
+	db.Create(&models.AccountMapping{OrganizationID: org.ID, Account: billing.AccountCash, Code: "090", Name: "Business Bank Account"})
+
+	records, err := csv.NewReader(strings.NewReader(exportJournal(t, db, FormatXero, org.ID))).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*Narration", "*Date", "Description", "*AccountCode", "*TaxRate", "*Amount"}, records[0])
+	assert.Equal(t, []string{"Payment 1 on invoice 1 (entry 2)", "05/03/2026", "Acme, Inc. txn_1", "090", "Tax Exempt", "120.00"}, records[4])
+	assert.Equal(t, []string{"Payment 1 on invoice 1 (entry 2)", "05/03/2026", "Acme, Inc. txn_1", "1100", "Tax Exempt", "-120.00"}, records[5])
+}
+
+func TestExportEscapesFormulas(t *testing.T) {
+	db, org := setupExportDB(t)
+	db.Model(org).Update("name", "=HYPERLINK(\"http://evil.test\")")
+	bookSale(t, db, org)
+
+	records, err := csv.NewReader(strings.NewReader(exportJournal(t, db, FormatCSV, org.ID))).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, "'=HYPERLINK(\"http://evil.test\")", records[1][6])
+	assert.Equal(t, "0.00", records[1][13])
+
+	records, err = csv.NewReader(strings.NewReader(exportJournal(t, db, FormatXero, org.ID))).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, "'=HYPERLINK(\"http://evil.test\") txn_1", records[4][2])
+	assert.Equal(t, "-120.00", records[5][5]) // amounts are numbers, not text
+
+	assert.Equal(t, "'@SUM(A1)", EscapeFormula("@SUM(A1)"))
+	assert.Equal(t, "Acme", EscapeFormula("Acme"))
+	assert.Equal(t, "", EscapeFormula(""))
+}
+
+func TestAccountingFormatsOnlyForJournal(t *testing.T) {
+	for _, format := range []string{FormatIIF, FormatXero} {
+		opts := Options{Dataset: "invoices", Format: format}
+		assert.True(t, errors.Is(opts.Validate(), ErrUnknownFormat))
+		opts.Dataset = "journal"
+		assert.NoError(t, opts.Validate())
+	}
+	assert.Equal(t, "journal.iif", (&Options{Dataset: "journal", Format: FormatIIF}).FileName())
+	assert.Equal(t, "journal-xero.csv", (&Options{Dataset: "journal", Format: FormatXero}).FileName())
+}
@@ -0,0 +1,253 @@
+package export
+
+import (
+	"fmt"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+var datasets = map[string]exporter{
+	"invoices":      invoices,
+	"invoice_lines": invoiceLines,
+	"journal":       journal,
+	"payments":      paymentsDataset,
+	"refunds":       refunds,
+	"subscriptions": subscriptions,
+}
+
+func byOrganization(db *gorm.DB, organizationID uint) *gorm.DB {
+	return db.Where("organization_id = ?", organizationID)
+}
+
+// byInvoiceOrganization scopes records that belong to an invoice.
+func byInvoiceOrganization(db *gorm.DB, organizationID uint) *gorm.DB {
+	return db.Where("invoice_id IN (?)", db.Session(&gorm.Session{NewDB: true}).Model(&models.Invoice{}).Select("id").Where("organization_id = ?", organizationID))
+}
+
+func optionalID(id *uint) any {
+	if id == nil {
+		return nil
+	}
+	return *id
+}
+
+func optionalTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+// InvoiceStatus describes an invoice as paid, open, past_due or
+// uncollectible.
+func InvoiceStatus(invoice *models.Invoice, now time.Time) string {
+	switch {
+	case invoice.Paid:
+		return "paid"
+	case invoice.Uncollectible:
+		return "uncollectible"
+	case invoice.DueDate.Before(now):
+		return "past_due"
+	default:
+		return "open"
+	}
+}
+
+// SubscriptionStatus describes a subscription as active, paused or
+// cancelled.
+func SubscriptionStatus(subscription *models.Subscription) string {
+	switch {
+	case !subscription.IsActive:
+		return "cancelled"
+	case subscription.PausedAt != nil:
+		return "paused"
+	default:
+		return "active"
+	}
+}
+
+var invoices = &dataset[models.Invoice, models.Invoice]{
+	table:      "invoices",
+	dateColumn: "issue_date",
+	scope:      byOrganization,
+	preload:    []string{"Organization"},
+	rows:       records[models.Invoice],
+	columns: []column[models.Invoice]{
+		{"id", func(i *models.Invoice) any { return i.ID }},
+		{"number", func(i *models.Invoice) any { return billing.InvoiceNumber(i) }},
+		{"organization_id", func(i *models.Invoice) any { return i.OrganizationID }},
+		{"organization", func(i *models.Invoice) any { return i.Organization.Name }},
+		{"user_id", func(i *models.Invoice) any { return i.UserID }},
+		{"subscription_id", func(i *models.Invoice) any { return optionalID(i.SubscriptionID) }},
+		{"status", func(i *models.Invoice) any { return InvoiceStatus(i, time.Now()) }},
+		{"currency", func(i *models.Invoice) any { return i.Currency }},
+		{"subtotal", func(i *models.Invoice) any { return amount(i.Amount - i.TaxAmount) }},
+		{"tax_amount", func(i *models.Invoice) any { return amount(i.TaxAmount) }},
+		{"amount", func(i *models.Invoice) any { return amount(i.Amount) }},
+		{"credit_applied", func(i *models.Invoice) any { return amount(i.CreditApplied) }},
+		{"amount_due", func(i *models.Invoice) any { return amount(billing.AmountDue(i)) }},
+		{"reverse_charge", func(i *models.Invoice) any { return i.ReverseCharge }},
+		{"issue_date", func(i *models.Invoice) any { return i.IssueDate }},
+		{"due_date", func(i *models.Invoice) any { return i.DueDate }},
+		{"period_start", func(i *models.Invoice) any { return i.PeriodStart }},
+		{"period_end", func(i *models.Invoice) any { return i.PeriodEnd }},
+	},
+}
+
+// invoiceLine is a line of an invoice: what it bills for, followed by its
+// tax lines.
+type invoiceLine struct {
+	InvoiceID     uint
+	InvoiceNumber string
+	Line          int
+	Type          string // 'item' or 'tax'
+	Description   string
+	TaxRate       any // percentage, for tax lines
+	TaxableAmount any
+	Amount        float64
+	Currency      string
+}
+
+// expandInvoiceLines turns a batch of invoices into their lines, describing
+// subscription invoices by their plan.
+func expandInvoiceLines(db *gorm.DB, batch []models.Invoice) ([]invoiceLine, error) {
+	var subscriptionIDs []uint
+	for _, invoice := range batch {
+		if invoice.SubscriptionID != nil {
+			subscriptionIDs = append(subscriptionIDs, *invoice.SubscriptionID)
+		}
+	}
+	plans := map[uint]string{}
+	if len(subscriptionIDs) > 0 {
+		var subscriptions []models.Subscription
+		if err := db.Unscoped().Preload("SubscriptionPlan", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+			Where("id IN ?", subscriptionIDs).Find(&subscriptions).Error; err != nil {
+			return nil, fmt.Errorf("failed to load subscriptions: %w", err)
+		}
+		for _, subscription := range subscriptions {
+			plans[subscription.ID] = subscription.SubscriptionPlan.Name
+		}
+	}
+
+	var lines []invoiceLine
+	for i := range batch {
+		invoice := &batch[i]
+		number := billing.InvoiceNumber(invoice)
+		description := "Invoice " + number
+		if invoice.SubscriptionID != nil {
+			description = "Subscription to " + plans[*invoice.SubscriptionID]
+		}
+		if !invoice.PeriodStart.IsZero() {
+			description += fmt.Sprintf(" (%s to %s)", invoice.PeriodStart.Format("2006-01-02"), invoice.PeriodEnd.Format("2006-01-02"))
+		}
+		lines = append(lines, invoiceLine{
+			InvoiceID: invoice.ID, InvoiceNumber: number, Line: 1, Type: "item", Description: description,
+			Amount: invoice.Amount - invoice.TaxAmount, Currency: invoice.Currency,
+		})
+		for j, tax := range invoice.TaxLines {
+			lines = append(lines, invoiceLine{
+				InvoiceID: invoice.ID, InvoiceNumber: number, Line: j + 2, Type: "tax", Description: tax.Name,
+				TaxRate: tax.Rate, TaxableAmount: amount(tax.TaxableAmount), Amount: tax.Amount, Currency: invoice.Currency,
+			})
+		}
+	}
+	return lines, nil
+}
+
+var invoiceLines = &dataset[models.Invoice, invoiceLine]{
+	table:      "invoices",
+	dateColumn: "issue_date",
+	scope:      byOrganization,
+	preload:    []string{"TaxLines"},
+	rows:       expandInvoiceLines,
+	columns: []column[invoiceLine]{
+		{"invoice_id", func(l *invoiceLine) any { return l.InvoiceID }},
+		{"invoice_number", func(l *invoiceLine) any { return l.InvoiceNumber }},
+		{"line", func(l *invoiceLine) any { return l.Line }},
+		{"type", func(l *invoiceLine) any { return l.Type }},
+		{"description", func(l *invoiceLine) any { return l.Description }},
+		{"tax_rate", func(l *invoiceLine) any { return l.TaxRate }},
+		{"taxable_amount", func(l *invoiceLine) any { return l.TaxableAmount }},
+		{"amount", func(l *invoiceLine) any { return amount(l.Amount) }},
+		{"currency", func(l *invoiceLine) any { return l.Currency }},
+	},
+}
+
+var paymentsDataset = &dataset[models.Payment, models.Payment]{
+	table:      "payments",
+	dateColumn: "payment_date",
+	scope:      byInvoiceOrganization,
+	preload:    []string{"Invoice"},
+	rows:       records[models.Payment],
+	columns: []column[models.Payment]{
+		{"id", func(p *models.Payment) any { return p.ID }},
+		{"invoice_id", func(p *models.Payment) any { return p.InvoiceID }},
+		{"invoice_number", func(p *models.Payment) any { return billing.InvoiceNumber(&p.Invoice) }},
+		{"organization_id", func(p *models.Payment) any { return p.Invoice.OrganizationID }},
+		{"user_id", func(p *models.Payment) any { return p.UserID }},
+		{"status", func(p *models.Payment) any { return p.ProviderStatus }},
+		{"amount", func(p *models.Payment) any { return amount(p.Amount) }},
+		{"currency", func(p *models.Payment) any { return p.Currency }},
+		{"charged_amount", func(p *models.Payment) any { return amount(p.ChargedAmount) }},
+		{"charged_currency", func(p *models.Payment) any { return p.ChargedCurrency }},
+		{"exchange_rate", func(p *models.Payment) any { return p.ExchangeRate }},
+		{"payment_date", func(p *models.Payment) any { return p.PaymentDate }},
+		{"transaction_id", func(p *models.Payment) any { return p.TransactionID }},
+		{"payment_method", func(p *models.Payment) any { return p.PaymentMethod }},
+		{"payment_method_id", func(p *models.Payment) any { return optionalID(p.PaymentMethodID) }},
+		{"provider", func(p *models.Payment) any { return p.Provider }},
+		{"provider_charge_id", func(p *models.Payment) any { return p.ProviderChargeID }},
+	},
+}
+
+var refunds = &dataset[models.Refund, models.Refund]{
+	table:      "refunds",
+	dateColumn: "refund_date",
+	scope:      byInvoiceOrganization,
+	preload:    []string{"Invoice"},
+	rows:       records[models.Refund],
+	columns: []column[models.Refund]{
+		{"id", func(r *models.Refund) any { return r.ID }},
+		{"invoice_id", func(r *models.Refund) any { return r.InvoiceID }},
+		{"invoice_number", func(r *models.Refund) any { return billing.InvoiceNumber(&r.Invoice) }},
+		{"organization_id", func(r *models.Refund) any { return r.Invoice.OrganizationID }},
+		{"payment_id", func(r *models.Refund) any { return r.PaymentID }},
+		{"user_id", func(r *models.Refund) any { return r.UserID }},
+		{"status", func(r *models.Refund) any { return r.ProviderStatus }},
+		{"amount", func(r *models.Refund) any { return amount(r.Amount) }},
+		{"currency", func(r *models.Refund) any { return r.Currency }},
+		{"refund_date", func(r *models.Refund) any { return r.RefundDate }},
+		{"transaction_id", func(r *models.Refund) any { return r.TransactionID }},
+		{"reason", func(r *models.Refund) any { return r.Reason }},
+		{"provider", func(r *models.Refund) any { return r.Provider }},
+		{"provider_refund_id", func(r *models.Refund) any { return r.ProviderRefundID }},
+	},
+}
+
+var subscriptions = &dataset[models.Subscription, models.Subscription]{
+	table:      "subscriptions",
+	dateColumn: "start_date",
+	scope:      byOrganization,
+	preload:    []string{"SubscriptionPlan"},
+	rows:       records[models.Subscription],
+	columns: []column[models.Subscription]{
+		{"id", func(s *models.Subscription) any { return s.ID }},
+		{"organization_id", func(s *models.Subscription) any { return s.OrganizationID }},
+		{"user_id", func(s *models.Subscription) any { return s.UserID }},
+		{"plan_id", func(s *models.Subscription) any { return s.SubscriptionPlanID }},
+		{"plan", func(s *models.Subscription) any { return s.SubscriptionPlan.Name }},
+		{"status", func(s *models.Subscription) any { return SubscriptionStatus(s) }},
+		{"price", func(s *models.Subscription) any { return amount(s.SubscriptionPlan.Price) }},
+		{"currency", func(s *models.Subscription) any { return s.SubscriptionPlan.Currency }},
+		{"interval", func(s *models.Subscription) any { return s.SubscriptionPlan.Interval }},
+		{"start_date", func(s *models.Subscription) any { return s.StartDate }},
+		{"end_date", func(s *models.Subscription) any { return s.EndDate }},
+		{"current_period_start", func(s *models.Subscription) any { return s.CurrentPeriodStart }},
+		{"current_period_end", func(s *models.Subscription) any { return s.CurrentPeriodEnd }},
+		{"paused_at", func(s *models.Subscription) any { return optionalTime(s.PausedAt) }},
+	},
+}
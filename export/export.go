@@ -0,0 +1,307 @@
+// Package export writes billing data as CSV or newline-delimited JSON
+// (NDJSON) for accounting tools. Records are read and written in batches, so
+// exports of any size run in constant memory.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownDataset = errors.New("unknown export dataset")
+	ErrUnknownFormat  = errors.New("unknown export format")
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatIIF    = "iif"  // QuickBooks, journal only
+	FormatXero   = "xero" // Xero manual journals, journal only
+)
+
+// BatchSize is how many records are loaded at a time.
+var BatchSize = 500
+
+// Options selects what to export. From and To are inclusive days; either may
+// be zero to leave the range open.
+type Options struct {
+	Dataset        string
+	Format         string
+	OrganizationID uint // 0 exports every organization
+	From, To       time.Time
+}
+
+// Validate checks the dataset and format of an export before anything is
+// written.
+func (o *Options) Validate() error {
+	if _, ok := datasets[o.Dataset]; !ok {
+		return fmt.Errorf("%w %q; expected one of %v", ErrUnknownDataset, o.Dataset, Datasets())
+	}
+	switch o.Format {
+	case FormatCSV, FormatNDJSON:
+	case FormatIIF, FormatXero:
+		if o.Dataset != "journal" {
+			return fmt.Errorf("%w %q for %s; only the journal can be exported for accounting systems", ErrUnknownFormat, o.Format, o.Dataset)
+		}
+	default:
+		return fmt.Errorf("%w %q; expected csv, ndjson, iif or xero", ErrUnknownFormat, o.Format)
+	}
+	if !o.From.IsZero() && !o.To.IsZero() && o.To.Before(o.From) {
+		return errors.New("the end of the date range is before its start")
+	}
+	return nil
+}
+
+// ContentType is the MIME type of an export format.
+func ContentType(format string) string {
+	switch format {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatIIF:
+		return "text/plain; charset=utf-8"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// FileName is the name an export is downloaded as.
+func (o *Options) FileName() string {
+	switch o.Format {
+	case FormatXero:
+		return o.Dataset + "-xero.csv"
+	default:
+		return o.Dataset + "." + o.Format
+	}
+}
+
+// Datasets lists what can be exported.
+func Datasets() []string {
+	names := make([]string, 0, len(datasets))
+	for name := range datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Write exports a dataset to w and returns the number of rows written. Rows
+// are written out after every batch, calling w's Flush method if it has one
+// so that HTTP responses stream.
+func Write(ctx context.Context, db *gorm.DB, w io.Writer, opts Options) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+	var enc encoder
+	switch opts.Format {
+	case FormatCSV:
+		enc = &csvEncoder{w: csv.NewWriter(w)}
+	case FormatNDJSON:
+		enc = &ndjsonEncoder{w: w}
+	case FormatIIF:
+		enc = &iifEncoder{w: w}
+	case FormatXero:
+		enc = &xeroEncoder{w: csv.NewWriter(w)}
+	}
+	flush := func() error {
+		if err := enc.flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+		return nil
+	}
+	return datasets[opts.Dataset].export(ctx, db.WithContext(ctx), &opts, enc, flush)
+}
+
+// amount is money, written with two decimals in CSV.
+type amount float64
+
+type column[R any] struct {
+	name  string
+	value func(*R) any
+}
+
+// dataset exports records of type T as rows of type R; most datasets write
+// one row per record.
+type dataset[T, R any] struct {
+	table      string
+	dateColumn string
+	// scope limits the query to an organization's records.
+	scope   func(db *gorm.DB, organizationID uint) *gorm.DB
+	preload []string
+	rows    func(db *gorm.DB, batch []T) ([]R, error)
+	columns []column[R]
+}
+
+type exporter interface {
+	export(ctx context.Context, db *gorm.DB, opts *Options, enc encoder, flush func() error) (int, error)
+}
+
+func (d *dataset[T, R]) export(ctx context.Context, db *gorm.DB, opts *Options, enc encoder, flush func() error) (int, error) {
+	names := make([]string, len(d.columns))
+	for i, col := range d.columns {
+		names[i] = col.name
+	}
+	if err := enc.header(names); err != nil {
+		return 0, err
+	}
+
+	query := db.Model(new(T))
+	for _, association := range d.preload {
+		query = query.Preload(association)
+	}
+	if opts.OrganizationID != 0 {
+		query = d.scope(query, opts.OrganizationID)
+	}
+	if !opts.From.IsZero() {
+		query = query.Where(d.table+"."+d.dateColumn+" >= ?", opts.From)
+	}
+	if !opts.To.IsZero() {
+		query = query.Where(d.table+"."+d.dateColumn+" < ?", opts.To.AddDate(0, 0, 1))
+	}
+
+	written := 0
+	values := make([]any, len(d.columns))
+	var batch []T
+	result := query.FindInBatches(&batch, BatchSize, func(tx *gorm.DB, _ int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rows, err := d.rows(db, batch)
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			for j, col := range d.columns {
+				values[j] = col.value(&rows[i])
+			}
+			if err := enc.row(names, values); err != nil {
+				return err
+			}
+			written++
+		}
+		return flush()
+	})
+	if result.Error != nil {
+		return written, fmt.Errorf("failed to export %s: %w", opts.Dataset, result.Error)
+	}
+	return written, flush()
+}
+
+// records writes one row per record.
+func records[T any](_ *gorm.DB, batch []T) ([]T, error) {
+	return batch, nil
+}
+
+type encoder interface {
+	header(names []string) error
+	row(names []string, values []any) error
+	flush() error
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	record []string
+}
+
+func (e *csvEncoder) header(names []string) error {
+	return e.w.Write(names)
+}
+
+func (e *csvEncoder) row(_ []string, values []any) error {
+	e.record = e.record[:0]
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			value = EscapeFormula(s)
+		}
+		e.record = append(e.record, formatCSV(value))
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// EscapeFormula keeps a spreadsheet from evaluating text as a formula when a
+// CSV file is opened, by prefixing text that starts with a formula character
+// with a quote. Names and descriptions come from tenants, who could otherwise
+// plant formulas in the files operators and accountants open.
+func EscapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatCSV(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case amount:
+		return strconv.FormatFloat(float64(v), 'f', 2, 64)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type ndjsonEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *ndjsonEncoder) header([]string) error { return nil }
+
+// row writes an object with the columns in order, which encoding a map
+// would not keep.
+func (e *ndjsonEncoder) row(names []string, values []any) error {
+	e.buf = append(e.buf, '{')
+	for i, value := range values {
+		if t, ok := value.(time.Time); ok {
+			value = nil
+			if !t.IsZero() {
+				value = t.UTC().Format(time.RFC3339)
+			}
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.buf = strconv.AppendQuote(e.buf, names[i])
+		e.buf = append(e.buf, ':')
+		e.buf = append(e.buf, encoded...)
+	}
+	e.buf = append(e.buf, '}', '\n')
+	return nil
+}
+
+func (e *ndjsonEncoder) flush() error {
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
@@ -0,0 +1,169 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupExportDB(t *testing.T) (*gorm.DB, *models.Organization) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(database.Models...))
+
+	org := models.Organization{Name: "Acme, Inc."}
+	assert.NoError(t, db.Create(&org).Error)
+	return db, &org
+}
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2026, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+// flushCounter records how often it is flushed.
+type flushCounter struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (w *flushCounter) Flush() { w.flushes++ }
+
+func TestExportInvoicesInBatches(t *testing.T) {
+	db, org := setupExportDB(t)
+	other := models.Organization{Name: "Other"}
+	db.Create(&other)
+	for i := 1; i <= 5; i++ {
+		db.Create(&models.Invoice{OrganizationID: org.ID, Amount: float64(i)*10 + 0.5, TaxAmount: 0.5, Currency: "USD", IssueDate: day(time.March, i), DueDate: day(time.March, i+14), Paid: true})
+	}
+	db.Create(&models.Invoice{OrganizationID: other.ID, Amount: 99, Currency: "USD", IssueDate: day(time.March, 2), DueDate: day(time.March, 16)})
+
+	defer func(size int) { BatchSize = size }(BatchSize)
+	BatchSize = 2
+
+	var out flushCounter
+	written, err := Write(context.Background(), db, &out, Options{Dataset: "invoices", Format: FormatCSV, OrganizationID: org.ID, From: day(time.March, 2), To: day(time.March, 4)})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, written)
+	assert.Equal(t, 3, out.flushes) // after each of the two batches, then at the end
+
+	assert.Equal(t, fmt.Sprintf(`id,number,organization_id,organization,user_id,subscription_id,status,currency,subtotal,tax_amount,amount,credit_applied,amount_due,reverse_charge,issue_date,due_date,period_start,period_end
+2,2,%[1]d,"Acme, Inc.",0,,paid,USD,20.00,0.50,20.50,0.00,20.50,false,2026-03-02T00:00:00Z,2026-03-16T00:00:00Z,,
+3,3,%[1]d,"Acme, Inc.",0,,paid,USD,30.00,0.50,30.50,0.00,30.50,false,2026-03-03T00:00:00Z,2026-03-17T00:00:00Z,,
+4,4,%[1]d,"Acme, Inc.",0,,paid,USD,40.00,0.50,40.50,0.00,40.50,false,2026-03-04T00:00:00Z,2026-03-18T00:00:00Z,,
+`, org.ID), out.String())
+
+	// Without an organization, every organization is exported.
+	out.Reset()
+	written, err = Write(context.Background(), db, &out, Options{Dataset: "invoices", Format: FormatCSV})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, written)
+}
+
+func TestExportInvoiceLinesAsNDJSON(t *testing.T) {
+	db, org := setupExportDB(t)
+	plan := models.SubscriptionPlan{Name: "Pro", Price: 100, Currency: "EUR", Interval: "monthly", OrganizationID: org.ID}
+	db.Create(&plan)
+	subscription := models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, StartDate: day(time.April, 1), EndDate: day(time.May, 1)}
+	db.Create(&subscription)
+	invoice := models.Invoice{OrganizationID: org.ID, SubscriptionID: &subscription.ID, Amount: 119, TaxAmount: 19, Currency: "EUR",
+		IssueDate: day(time.April, 1), DueDate: day(time.April, 15), PeriodStart: day(time.April, 1), PeriodEnd: day(time.May, 1),
+		TaxLines: []models.InvoiceTaxLine{{Name: "VAT", Country: "DE", Rate: 19, TaxableAmount: 100, Amount: 19}}}
+	db.Create(&invoice)
+
+	var out bytes.Buffer
+	written, err := Write(context.Background(), db, &out, Options{Dataset: "invoice_lines", Format: FormatNDJSON})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, written)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Equal(t, []string{
+		`{"invoice_id":1,"invoice_number":"1","line":1,"type":"item","description":"Subscription to Pro (2026-04-01 to 2026-05-01)","tax_rate":null,"taxable_amount":null,"amount":100,"currency":"EUR"}`,
+		`{"invoice_id":1,"invoice_number":"1","line":2,"type":"tax","description":"VAT","tax_rate":19,"taxable_amount":100,"amount":19,"currency":"EUR"}`,
+	}, lines)
+	for _, line := range lines {
+		assert.True(t, json.Valid([]byte(line)))
+	}
+}
+
+func TestExportPaymentsRefundsAndSubscriptions(t *testing.T) {
+	db, org := setupExportDB(t)
+	other := models.Organization{Name: "Other"}
+	db.Create(&other)
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 50, Currency: "USD", IssueDate: day(time.June, 1), DueDate: day(time.June, 15)}
+	db.Create(&invoice)
+	otherInvoice := models.Invoice{OrganizationID: other.ID, Amount: 70, Currency: "USD", IssueDate: day(time.June, 1), DueDate: day(time.June, 15)}
+	db.Create(&otherInvoice)
+	payment := models.Payment{InvoiceID: invoice.ID, Amount: 50, Currency: "USD", PaymentDate: day(time.June, 2), TransactionID: "txn_1", PaymentMethod: "card", ProviderStatus: "succeeded"}
+	db.Create(&payment)
+	db.Create(&models.Payment{InvoiceID: otherInvoice.ID, Amount: 70, Currency: "USD", PaymentDate: day(time.June, 2), TransactionID: "txn_2"})
+	db.Create(&models.Refund{InvoiceID: invoice.ID, PaymentID: payment.ID, Amount: 20, Currency: "USD", RefundDate: day(time.June, 20), TransactionID: "re_1", Reason: "duplicate"})
+
+	export := func(dataset string) []map[string]any {
+		var out bytes.Buffer
+		_, err := Write(context.Background(), db, &out, Options{Dataset: dataset, Format: FormatNDJSON, OrganizationID: org.ID})
+		assert.NoError(t, err)
+		var rows []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var row map[string]any
+			assert.NoError(t, json.Unmarshal([]byte(line), &row))
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	payments := export("payments")
+	assert.Len(t, payments, 1)
+	assert.Equal(t, "txn_1", payments[0]["transaction_id"])
+	assert.Equal(t, float64(org.ID), payments[0]["organization_id"])
+	assert.Equal(t, "2026-06-02T00:00:00Z", payments[0]["payment_date"])
+
+	refunds := export("refunds")
+	assert.Len(t, refunds, 1)
+	assert.Equal(t, "duplicate", refunds[0]["reason"])
+	assert.Equal(t, 20.0, refunds[0]["amount"])
+
+	plan := models.SubscriptionPlan{Name: "Basic", Price: 10, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	db.Create(&plan)
+	subscription := models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, StartDate: day(time.June, 1), EndDate: day(time.July, 1)}
+	db.Create(&subscription)
+	db.Model(&subscription).Update("is_active", false)
+
+	subscriptions := export("subscriptions")
+	assert.Len(t, subscriptions, 1)
+	assert.Equal(t, "Basic", subscriptions[0]["plan"])
+	assert.Equal(t, "cancelled", subscriptions[0]["status"])
+	assert.Nil(t, subscriptions[0]["paused_at"])
+}
+
+func TestExportValidatesOptions(t *testing.T) {
+	db, _ := setupExportDB(t)
+	var out bytes.Buffer
+
+	_, err := Write(context.Background(), db, &out, Options{Dataset: "customers", Format: FormatCSV})
+	assert.True(t, errors.Is(err, ErrUnknownDataset))
+	_, err = Write(context.Background(), db, &out, Options{Dataset: "invoices", Format: "xlsx"})
+	assert.True(t, errors.Is(err, ErrUnknownFormat))
+	_, err = Write(context.Background(), db, &out, Options{Dataset: "invoices", Format: FormatCSV, From: day(time.May, 1), To: day(time.April, 1)})
+	assert.Error(t, err)
+	assert.Empty(t, out.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Write(ctx, db, &out, Options{Dataset: "invoices", Format: FormatCSV})
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"invoxa/database"
+	"invoxa/export"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const exportUsage = `usage: invoxa export <dataset> [flags]
+
+Writes a dataset (%s) as CSV or NDJSON.
+
+`
+
+// runExport runs the export command and returns its exit code.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), exportUsage, strings.Join(export.Datasets(), ", "))
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", export.FormatCSV, "csv or ndjson, or iif or xero for the journal")
+	from := flags.String("from", "", "first day to export, as YYYY-MM-DD")
+	to := flags.String("to", "", "last day to export, as YYYY-MM-DD")
+	org := flags.Uint("org", 0, "organization to export; all of them if 0")
+	output := flags.String("o", "", "file to write to instead of standard output")
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		flags.Usage()
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	opts := export.Options{Dataset: args[0], Format: *format, OrganizationID: uint(*org)}
+	for _, day := range []struct {
+		value string
+		into  *time.Time
+	}{{*from, &opts.From}, {*to, &opts.To}} {
+		if day.value == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", day.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid date %q; expected YYYY-MM-DD\n", day.value)
+			return 2
+		}
+		*day.into = parsed
+	}
+	if err := opts.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// Standard output may carry the export, so database logs go to standard
+	// error, and queries run by the export itself are not logged.
+	logger.Default = logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{SlowThreshold: 200 * time.Millisecond})
+	database.ConnectDatabase()
+	db := database.DB.Session(&gorm.Session{Logger: database.DB.Logger.LogMode(logger.Silent)})
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	written, err := export.Write(ctx, db, buffered, opts)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	log.Printf("Exported %d %s rows", written, opts.Dataset)
+	return 0
+}
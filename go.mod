@@ -10,6 +10,8 @@ require (
 	gorm.io/gorm v1.30.0
 )
 
+require github.com/jung-kurt/gofpdf v1.16.2
+
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
 	github.com/bytedance/sonic/loader v0.1.1 // indirect
@@ -2,12 +2,37 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
+	"invoxa/billing"
 	"invoxa/database"
+	"invoxa/models"
 
 	"github.com/gin-gonic/gin"
 )
 
+// targetOrganization parses the :id route parameter of an admin route and
+// checks that the organization exists, writing the error response when it
+// does not. Unlike callerOrganization, any organization can be the target.
+func targetOrganization(c *gin.Context) (uint, bool) {
+	orgID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return 0, false
+	}
+	var count int64
+	if err := database.DB.Model(&models.Organization{}).Where("id = ?", orgID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up organization"})
+		return 0, false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return 0, false
+	}
+	return uint(orgID), true
+}
+
 func ClearDatabase(c *gin.Context) {
 
 	err := database.ClearDBAndMigrate()
@@ -18,3 +43,27 @@ func ClearDatabase(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Database cleared and migrated successfully"})
 }
+
+// RunRenewals renews every subscription whose billing period has ended and
+// charges the renewal invoices. The scheduler in main runs the same job hourly.
+func RunRenewals(c *gin.Context) {
+	report, err := billing.RenewSubscriptions(c.Request.Context(), database.DB, PaymentProvider, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run subscription renewals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RunCreditExpiry writes off credit grants whose expiry has passed. The
+// scheduler in main runs the same job hourly.
+func RunCreditExpiry(c *gin.Context) {
+	expired, err := billing.ExpireAllCredits(database.DB, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to expire credits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"expired": expired})
+}
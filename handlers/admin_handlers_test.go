@@ -6,7 +6,6 @@ import (
 	"testing"
 
 	"invoxa/database"
-	"invoxa/models"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -19,15 +18,7 @@ func setupTestDB(t *testing.T) {
 	assert.NoError(t, err)
 	database.DB = db
 
-	err = db.AutoMigrate(
-		&models.User{},
-		&models.Organization{},
-		&models.SubscriptionPlan{},
-		&models.Subscription{},
-		&models.Invoice{},
-		&models.Payment{},
-		&models.Refund{},
-	)
+	err = db.AutoMigrate(database.Models...)
 	assert.NoError(t, err)
 }
 
@@ -45,3 +36,32 @@ func TestClearDatabase(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Database cleared and migrated successfully")
 }
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+	defer func(token string) { AdminToken = token }(AdminToken)
+
+	r := gin.Default()
+	admin := r.Group("/admin", AdminMiddleware())
+	admin.POST("/run_renewals", RunRenewals)
+
+	renew := func(authorization string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/run_renewals", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	AdminToken = ""
+	assert.Equal(t, http.StatusForbidden, renew("Bearer anything"))
+
+	AdminToken = "s3cret"
+	assert.Equal(t, http.StatusUnauthorized, renew(""))
+	assert.Equal(t, http.StatusUnauthorized, renew("Bearer wrong"))
+	assert.Equal(t, http.StatusUnauthorized, renew("s3cret"))
+	assert.Equal(t, http.StatusOK, renew("Bearer s3cret"))
+}
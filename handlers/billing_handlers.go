@@ -1,12 +1,20 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"invoxa/billing"
 	"invoxa/database"
+	"invoxa/events"
 	"invoxa/models"
+	"invoxa/payments"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -50,28 +58,44 @@ func Subscribe(c *gin.Context) {
 		return
 	}
 
-	subscription := models.Subscription{
-		OrganizationID:     req.OrganizationID,
-		SubscriptionPlanID: plan.ID,
-		StartDate:          time.Now(),
-		IsActive:           true,
-	}
-	if err := database.DB.Create(&subscription).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
-		return
-	}
+	var subscription models.Subscription
+	var invoice models.Invoice
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		subscription = models.Subscription{
+			OrganizationID:     req.OrganizationID,
+			SubscriptionPlanID: plan.ID,
+			UserID:             req.UserID,
+			StartDate:          now,
+			CurrentPeriodStart: now,
+			CurrentPeriodEnd:   billing.NextPeriodEnd(now, plan.Interval),
+			IsActive:           true,
+		}
+		if err := tx.Create(&subscription).Error; err != nil {
+			return err
+		}
+		if err := events.Publish(tx, req.OrganizationID, events.SubscriptionCreated, subscription); err != nil {
+			return err
+		}
 
-	invoice := models.Invoice{
-		OrganizationID: req.OrganizationID,
-		UserID:         req.UserID,
-		Amount:         plan.Price, 
-		Currency:       plan.Currency,
-		IssueDate:      time.Now(),
-		DueDate:        time.Now().AddDate(0, 1, 0), // due in 1 month for monthly plans
-		Paid:           false,
-	}
-	if err := database.DB.Create(&invoice).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create initial invoice"})
+		invoice = models.Invoice{
+			OrganizationID: req.OrganizationID,
+			UserID:         req.UserID,
+			SubscriptionID: &subscription.ID,
+			Amount:         plan.Price,
+			TaxInclusive:   plan.TaxInclusive,
+			TaxCode:        plan.TaxCode,
+			Currency:       plan.Currency,
+			IssueDate:      time.Now(),
+			DueDate:        time.Now().AddDate(0, 1, 0), // due in 1 month for monthly plans
+			PeriodStart:    subscription.CurrentPeriodStart,
+			PeriodEnd:      subscription.CurrentPeriodEnd,
+			Paid:           false,
+		}
+		return billing.FinalizeInvoice(tx, &invoice)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription and initial invoice"})
 		return
 	}
 
@@ -79,16 +103,22 @@ func Subscribe(c *gin.Context) {
 		"message":         "Subscription and initial invoice created successfully",
 		"subscription_id": subscription.ID,
 		"invoice_id":      invoice.ID,
+		"invoice_number":  invoice.Number,
+		"subtotal":        invoice.Subtotal,
+		"tax_amount":      invoice.TaxAmount,
+		"credit_applied":  invoice.CreditApplied,
+		"amount_due":      billing.AmountDue(&invoice),
 	})
 }
 
 type PayInvoiceRequest struct {
-	InvoiceID     uint    `json:"invoice_id" binding:"required"`
-	UserID        uint    `json:"user_id" binding:"required"`
-	Amount        float64 `json:"amount" binding:"required,gt=0"`
-	Currency      string  `json:"currency" binding:"required"`
-	TransactionID string  `json:"transaction_id" binding:"required"`
-	PaymentMethod string  `json:"payment_method" binding:"required"`
+	InvoiceID       uint    `json:"invoice_id" binding:"required"`
+	UserID          uint    `json:"user_id" binding:"required"`
+	Amount          float64 `json:"amount" binding:"required,gt=0"`
+	Currency        string  `json:"currency" binding:"required"`
+	TransactionID   string  `json:"transaction_id"`    // optional idempotency key for the charge
+	PaymentMethod   string  `json:"payment_method"`    // one-off payment method token at the provider
+	PaymentMethodID uint    `json:"payment_method_id"` // stored payment method; the default is used when neither is given
 }
 
 func PayInvoice(c *gin.Context) {
@@ -101,7 +131,7 @@ func PayInvoice(c *gin.Context) {
 	callerOrganizationID := c.GetUint64("callerOrganizationID")
 
 	var invoice models.Invoice
-	if err := database.DB.Preload("Organization").First(&invoice, req.InvoiceID).Error; err != nil {
+	if err := database.DB.First(&invoice, req.InvoiceID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
 		return
 	}
@@ -116,7 +146,23 @@ func PayInvoice(c *gin.Context) {
 		return
 	}
 
-	if req.Amount < invoice.Amount {
+	if invoice.Uncollectible {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invoice has been written off as uncollectible"})
+		return
+	}
+
+	// Payments in another currency are converted at today's rate and must
+	// still cover the amount due.
+	amount, _, err := billing.Convert(database.DB, req.Amount, req.Currency, invoice.Currency, time.Now())
+	if errors.Is(err, billing.ErrNoExchangeRate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Cannot pay a %s invoice in %s: no exchange rate available", invoice.Currency, req.Currency)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert payment amount"})
+		return
+	}
+	if amount < billing.AmountDue(&invoice) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment amount is less than invoice amount. Partial payments not supported in this version."})
 		return
 	}
@@ -127,28 +173,61 @@ func PayInvoice(c *gin.Context) {
 		return
 	}
 
-	payment := models.Payment{
-		InvoiceID:     req.InvoiceID,
-		UserID:        req.UserID,
-		Amount:        req.Amount,
-		Currency:      req.Currency,
-		PaymentDate:   time.Now(),
-		TransactionID: req.TransactionID,
-		PaymentMethod: req.PaymentMethod,
+	params := billing.CollectParams{
+		UserID:   req.UserID,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Token:    req.PaymentMethod,
+	}
+	if req.PaymentMethodID != 0 {
+		var method models.PaymentMethod
+		if err := database.DB.Where("id = ? AND organization_id = ?", req.PaymentMethodID, invoice.OrganizationID).First(&method).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found for this organization"})
+			return
+		}
+		params.Method = &method
+	}
+	if req.TransactionID != "" {
+		params.IdempotencyKey = fmt.Sprintf("invoice-%d-%s", invoice.ID, req.TransactionID)
 	}
 
-	if err := database.DB.Create(&payment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment record"})
+	collection, err := billing.CollectInvoice(c.Request.Context(), database.DB, PaymentProvider, &invoice, params)
+	if errors.Is(err, billing.ErrNoPaymentMethod) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No payment method given and the organization has no default payment method"})
 		return
 	}
-
-	invoice.Paid = true
-	if err := database.DB.Save(&invoice).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice status"})
+	if errors.Is(err, billing.ErrNoExchangeRate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, billing.ErrChargeNotRecorded) {
+		log.Printf("Failed to record payment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+	var providerErr *billing.ProviderError
+	if errors.As(err, &providerErr) {
+		respondProviderError(c, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to collect invoice %d: %v", invoice.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to collect payment"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Invoice paid successfully", "payment_id": payment.ID})
+	response := gin.H{"message": "Invoice paid successfully", "payment_id": collection.Payment.ID}
+	if collection.Receipt != nil {
+		response["receipt_number"] = collection.Receipt.Number
+	}
+	if collection.Credit != nil {
+		response["credit_granted"] = collection.Credit.Amount
+	}
+	if collection.Payment.ExchangeRate != 0 {
+		response["exchange_rate"] = collection.Payment.ExchangeRate
+		response["settled_amount"] = collection.Payment.Amount
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 type UpgradePlanRequest struct {
@@ -215,6 +294,13 @@ func UpgradePlan(c *gin.Context) {
 		return
 	}
 
+	// The prorated credit for the current plan is netted against the new
+	// plan's price, so both must be priced in the same currency.
+	if !strings.EqualFold(currentPlan.Currency, newPlan.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Cannot change from a plan priced in %s to one priced in %s", currentPlan.Currency, newPlan.Currency)})
+		return
+	}
+
 	today := time.Now()
 	endOfCurrentMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location()).AddDate(0, 1, 0).Add(-time.Nanosecond)
 	daysInMonth := float64(endOfCurrentMonth.Day())
@@ -222,36 +308,74 @@ func UpgradePlan(c *gin.Context) {
 
 	proratedAmount := (currentPlan.Price / daysInMonth) * daysRemaining
 
-	currentSubscription.EndDate = today
-	currentSubscription.IsActive = false
-	if err := database.DB.Save(&currentSubscription).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel old subscription"})
-		return
-	}
+	var newSubscription models.Subscription
+	var invoice models.Invoice
+	var downgradeCredit float64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		currentSubscription.EndDate = today
+		currentSubscription.IsActive = false
+		if err := tx.Save(&currentSubscription).Error; err != nil {
+			return err
+		}
 
-	newSubscription := models.Subscription{
-		OrganizationID:     req.OrganizationID,
-		SubscriptionPlanID: req.NewSubscriptionPlanID,
-		StartDate:          today,
-		IsActive:           true,
-	}
-	if err := database.DB.Create(&newSubscription).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create new subscription"})
-		return
-	}
+		newSubscription = models.Subscription{
+			OrganizationID:     req.OrganizationID,
+			SubscriptionPlanID: req.NewSubscriptionPlanID,
+			UserID:             req.UserID,
+			StartDate:          today,
+			CurrentPeriodStart: today,
+			CurrentPeriodEnd:   billing.NextPeriodEnd(today, newPlan.Interval),
+			IsActive:           true,
+		}
+		if err := tx.Create(&newSubscription).Error; err != nil {
+			return err
+		}
+		upgraded := gin.H{"old_subscription": currentSubscription, "new_subscription": newSubscription}
+		if err := events.Publish(tx, req.OrganizationID, events.SubscriptionUpgraded, upgraded); err != nil {
+			return err
+		}
 
-	invoice := models.Invoice{
-		OrganizationID: req.OrganizationID,
-		UserID:         req.UserID,
-		Amount:         newPlan.Price - proratedAmount, //new plan price minus prorated credit
-		Currency:       newPlan.Currency,
-		IssueDate:      today,
-		DueDate:        today.AddDate(0, 1, 0), // due in 1 month
-		Paid:           false,
-	}
+		amount := newPlan.Price - proratedAmount // new plan price minus prorated credit
+		if amount < 0 {
+			// Downgrades leave the organization in credit instead of producing a negative invoice.
+			downgradeCredit = -amount
+			amount = 0
+		}
+
+		invoice = models.Invoice{
+			OrganizationID: req.OrganizationID,
+			UserID:         req.UserID,
+			SubscriptionID: &newSubscription.ID,
+			Amount:         amount,
+			TaxInclusive:   newPlan.TaxInclusive,
+			TaxCode:        newPlan.TaxCode,
+			Currency:       newPlan.Currency,
+			IssueDate:      today,
+			DueDate:        today.AddDate(0, 1, 0), // due in 1 month
+			PeriodStart:    newSubscription.CurrentPeriodStart,
+			PeriodEnd:      newSubscription.CurrentPeriodEnd,
+			Paid:           false,
+		}
+		if err := billing.FinalizeInvoice(tx, &invoice); err != nil {
+			return err
+		}
 
-	if err := database.DB.Create(&invoice).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create prorated invoice"})
+		if downgradeCredit >= 0.01 {
+			entry, err := billing.GrantCredit(tx, req.OrganizationID, downgradeCredit, newPlan.Currency,
+				fmt.Sprintf("Downgrade credit from %s to %s", currentPlan.Name, newPlan.Name), nil, &invoice.ID)
+			if err != nil {
+				return err
+			}
+			if err := billing.RecordCreditGrant(tx, entry); err != nil {
+				return err
+			}
+		}
+		// The credit for the unused part of the current plan was netted
+		// against the new invoice; only a downgrade credit is given back.
+		return billing.EndRevenueSchedules(tx, currentSubscription.ID, today, downgradeCredit)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upgrade subscription plan"})
 		return
 	}
 
@@ -260,7 +384,10 @@ func UpgradePlan(c *gin.Context) {
 		"old_subscription_id": currentSubscription.ID,
 		"new_subscription_id": newSubscription.ID,
 		"prorated_invoice_id": invoice.ID,
+		"invoice_number":      invoice.Number,
 		"prorated_amount":     proratedAmount,
+		"credit_applied":      invoice.CreditApplied,
+		"credit_granted":      downgradeCredit,
 	})
 }
 
@@ -275,7 +402,7 @@ func GetInvoice(c *gin.Context) {
 	callerOrganizationID := c.GetUint64("callerOrganizationID")
 
 	var invoice models.Invoice
-	if err := database.DB.Preload("Organization").Preload("User").First(&invoice, invoiceID).Error; err != nil {
+	if err := database.DB.Preload("Organization").Preload("User").Preload("TaxLines").Preload("TaxCalculation").First(&invoice, invoiceID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
 			return
@@ -298,7 +425,7 @@ type RefundRequest struct {
 	UserID        uint    `json:"user_id" binding:"required"`
 	Amount        float64 `json:"amount" binding:"required,gt=0"`
 	Currency      string  `json:"currency" binding:"required"`
-	TransactionID string  `json:"transaction_id" binding:"required"`
+	TransactionID string  `json:"transaction_id"` // optional idempotency key for the refund
 	Reason        string  `json:"reason" binding:"required"`
 }
 
@@ -334,17 +461,31 @@ func Refund(c *gin.Context) {
 		return
 	}
 
-	var existingRefund models.Refund
-	if err := database.DB.Where("payment_id = ? AND transaction_id = ?", req.PaymentID, req.TransactionID).First(&existingRefund).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "A refund with this transaction ID already exists for this payment"})
-		return
-	} else if err != gorm.ErrRecordNotFound {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for existing refund"})
+	if req.TransactionID != "" {
+		var existingRefund models.Refund
+		if err := database.DB.Where("payment_id = ? AND transaction_id = ?", req.PaymentID, req.TransactionID).First(&existingRefund).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "A refund with this transaction ID already exists for this payment"})
+			return
+		} else if err != gorm.ErrRecordNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for existing refund"})
+			return
+		}
+	}
+
+	if !strings.EqualFold(req.Currency, payment.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Refund currency must match the payment currency %s", payment.Currency)})
 		return
 	}
 
-	if req.Amount > payment.Amount {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount cannot exceed original payment amount"})
+	// Checked here as well as by the provider, which knows nothing of
+	// payments made outside it.
+	refunded, err := billing.RefundedAmount(database.DB, payment.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check earlier refunds"})
+		return
+	}
+	if remaining := payment.Amount - refunded; req.Amount > remaining+0.005 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Refund amount cannot exceed the %.2f %s of the payment not yet refunded", math.Max(remaining, 0), payment.Currency)})
 		return
 	}
 
@@ -353,17 +494,62 @@ func Refund(c *gin.Context) {
 		PaymentID:     req.PaymentID,
 		UserID:        req.UserID,
 		Amount:        req.Amount,
-		Currency:      req.Currency,
+		Currency:      payment.Currency,
 		RefundDate:    time.Now(),
 		TransactionID: req.TransactionID,
 		Reason:        req.Reason,
 	}
 
-	if err := database.DB.Create(&refund).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refund record"})
+	// Payments recorded before the provider integration have no charge to refund.
+	if payment.ProviderChargeID != "" {
+		idempotencyKey := ""
+		if req.TransactionID != "" {
+			idempotencyKey = fmt.Sprintf("refund-%d-%s", payment.ID, req.TransactionID)
+		}
+		providerRefund, err := PaymentProvider.Refund(c.Request.Context(), payments.RefundRequest{
+			ChargeID:       payment.ProviderChargeID,
+			Amount:         billing.ChargedAmount(&payment, req.Amount), // in the charge currency
+			Reason:         req.Reason,
+			IdempotencyKey: idempotencyKey,
+		})
+		if err != nil {
+			respondProviderError(c, err)
+			return
+		}
+		refund.Provider = PaymentProvider.Name()
+		refund.ProviderRefundID = providerRefund.ID
+		refund.ProviderStatus = providerRefund.Status
+		if refund.TransactionID == "" {
+			refund.TransactionID = providerRefund.ID
+		}
+	} else if refund.TransactionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A transaction ID is required to refund a payment made outside the payment provider"})
 		return
 	}
 
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&refund).Error; err != nil {
+			return err
+		}
+		if err := billing.RecordRefund(tx, invoice.OrganizationID, &refund); err != nil {
+			return err
+		}
+		if err := billing.RecognizeRefund(tx, &refund); err != nil {
+			return err
+		}
+		return events.Publish(tx, invoice.OrganizationID, events.PaymentRefunded, refund)
+	})
+	if err != nil && refund.ProviderRefundID != "" {
+		// The provider has already returned the money, so the refund must be
+		// reconciled by hand.
+		log.Printf("Refund %s of charge %s on payment %d succeeded but was not recorded: %v", refund.ProviderRefundID, payment.ProviderChargeID, payment.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Refund succeeded but was not recorded", "provider_refund_id": refund.ProviderRefundID})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refund record"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Refund created successfully", "refund_id": refund.ID})
 }
@@ -373,8 +559,10 @@ type CreateSubscriptionPlanRequest struct {
 	Description    string  `json:"description"`
 	Price          float64 `json:"price" binding:"required,gte=0"`
 	Currency       string  `json:"currency" binding:"required"`
-	Interval       string  `json:"interval" binding:"required"` 
-	OrganizationID uint    `json:"organization_id" binding:"required"` 
+	Interval       string  `json:"interval" binding:"required"`
+	TaxInclusive   bool    `json:"tax_inclusive"` // price includes tax
+	TaxCode        string  `json:"tax_code"`
+	OrganizationID uint    `json:"organization_id" binding:"required"`
 }
 
 func CreateSubscriptionPlan(c *gin.Context) {
@@ -403,6 +591,8 @@ func CreateSubscriptionPlan(c *gin.Context) {
 		Price:          req.Price,
 		Currency:       req.Currency,
 		Interval:       req.Interval,
+		TaxInclusive:   req.TaxInclusive,
+		TaxCode:        req.TaxCode,
 		OrganizationID: req.OrganizationID,
 	}
 
@@ -22,15 +22,7 @@ func setupBillingTestDB(t *testing.T) (*gorm.DB, *models.Organization, *models.U
 	assert.NoError(t, err)
 	database.DB = db
 
-	err = db.AutoMigrate(
-		&models.User{},
-		&models.Organization{},
-		&models.SubscriptionPlan{},
-		&models.Subscription{},
-		&models.Invoice{},
-		&models.Payment{},
-		&models.Refund{},
-	)
+	err = db.AutoMigrate(database.Models...)
 	assert.NoError(t, err)
 
 	// Create a test organization
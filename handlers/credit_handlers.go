@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreditBalanceResponse struct {
+	OrganizationID uint                 `json:"organization_id"`
+	Balances       map[string]float64   `json:"balances"`
+	Entries        []models.CreditEntry `json:"entries"`
+}
+
+func GetCreditBalance(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	balances, err := billing.AvailableCredit(database.DB, orgID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate credit balance"})
+		return
+	}
+
+	var entries []models.CreditEntry
+	if err := database.DB.Where("organization_id = ?", orgID).Order("created_at desc, id desc").Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve credit history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, CreditBalanceResponse{
+		OrganizationID: orgID,
+		Balances:       balances,
+		Entries:        entries,
+	})
+}
+
+type GrantCreditRequest struct {
+	Amount      float64    `json:"amount" binding:"required,gt=0"`
+	Currency    string     `json:"currency" binding:"required"`
+	Description string     `json:"description" binding:"required"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// GrantCredit grants credit to an organization, such as goodwill or
+// migration credit. It is an admin route: the credit pays the organization's
+// invoices, so its members cannot grant it to themselves.
+func GrantCredit(c *gin.Context) {
+	orgID, ok := targetOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req GrantCreditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry date must be in the future"})
+		return
+	}
+
+	var entry *models.CreditEntry
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		entry, err = billing.GrantCredit(tx, orgID, req.Amount, req.Currency, req.Description, req.ExpiresAt, nil)
+		if err != nil {
+			return err
+		}
+		return billing.RecordCreditGrant(tx, entry)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant credit"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Credit granted successfully", "credit_entry_id": entry.ID})
+}
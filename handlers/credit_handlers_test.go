@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrantCreditAppliedToSubscription(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "s3cret"
+
+	r := gin.Default()
+	r.POST("/admin/org/:id/credits", AdminMiddleware(), GrantCredit)
+	r.GET("/org/:id/credits", AuthMiddleware(), GetCreditBalance)
+	r.POST("/subscribe", AuthMiddleware(), Subscribe)
+
+	plan := models.SubscriptionPlan{Name: "Basic Plan", Price: 30, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+
+	grantCredit := func(orgID uint, authorization string) int {
+		jsonValue, _ := json.Marshal(GrantCreditRequest{Amount: 50, Currency: "USD", Description: "Migration credit"})
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/org/%d/credits?caller_user_id=%d&caller_organization_id=%d", orgID, user.ID, org.ID), bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	// Members of the organization cannot grant themselves credit.
+	assert.Equal(t, http.StatusUnauthorized, grantCredit(org.ID, ""))
+	assert.Equal(t, http.StatusNotFound, grantCredit(999, "Bearer s3cret"))
+	assert.Equal(t, http.StatusCreated, grantCredit(org.ID, "Bearer s3cret"))
+
+	subscribe := SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID}
+	jsonValue, _ := json.Marshal(subscribe)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/subscribe?caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var invoice models.Invoice
+	database.DB.First(&invoice)
+	assert.Equal(t, 30.0, invoice.CreditApplied)
+	assert.True(t, invoice.Paid)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/credits?caller_user_id=%d&caller_organization_id=%d", org.ID, user.ID, org.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var balance CreditBalanceResponse
+	err := json.Unmarshal(w.Body.Bytes(), &balance)
+	assert.NoError(t, err)
+	assert.Equal(t, 20.0, balance.Balances["USD"])
+	assert.Len(t, balance.Entries, 2)
+}
+
+func TestCreditBalanceLeavesOutExpiredCredit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.GET("/org/:id/credits", AuthMiddleware(), GetCreditBalance)
+
+	past := time.Now().Add(-time.Hour)
+	_, err := billing.GrantCredit(database.DB, org.ID, 10, "USD", "Promotion", &past, nil)
+	assert.NoError(t, err)
+	_, err = billing.GrantCredit(database.DB, org.ID, 5, "USD", "Goodwill", nil, nil)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/org/%d/credits?caller_user_id=%d&caller_organization_id=%d", org.ID, user.ID, org.ID), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var balance CreditBalanceResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &balance))
+	assert.Equal(t, 5.0, balance.Balances["USD"])
+	assert.Len(t, balance.Entries, 2) // reading the balance writes nothing
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListDisputes returns an organization's disputes, newest first, optionally
+// filtered by ?status=. It is an admin route for the support team.
+func ListDisputes(c *gin.Context) {
+	orgID, ok := targetOrganization(c)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("organization_id = ?", orgID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var disputes []models.Dispute
+	if err := query.Order("id desc").Find(&disputes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve disputes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, disputes)
+}
+
+// findDispute loads the :dispute_id route parameter within the organization.
+func findDispute(c *gin.Context, orgID uint) (*models.Dispute, bool) {
+	disputeID, err := strconv.ParseUint(c.Param("dispute_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dispute ID"})
+		return nil, false
+	}
+
+	var dispute models.Dispute
+	if err := database.DB.Where("id = ? AND organization_id = ?", disputeID, orgID).First(&dispute).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dispute not found for this organization"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve dispute"})
+		return nil, false
+	}
+	return &dispute, true
+}
+
+func GetDispute(c *gin.Context) {
+	orgID, ok := targetOrganization(c)
+	if !ok {
+		return
+	}
+
+	dispute, ok := findDispute(c, orgID)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.First(&dispute.Payment, dispute.PaymentID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve disputed payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
+
+type UpdateDisputeRequest struct {
+	Status                        string `json:"status" binding:"omitempty,oneof=needs_response under_review"`
+	EvidenceExplanation           string `json:"evidence_explanation"`
+	EvidenceCustomerCommunication string `json:"evidence_customer_communication"`
+	EvidenceServiceDocumentation  string `json:"evidence_service_documentation"`
+}
+
+// UpdateDispute records evidence and submits it by moving the dispute under
+// review. The outcome comes only from the provider's dispute.closed event.
+func UpdateDispute(c *gin.Context) {
+	orgID, ok := targetOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateDisputeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dispute, ok := findDispute(c, orgID)
+	if !ok {
+		return
+	}
+
+	evidence := billing.DisputeEvidence{
+		Explanation:           req.EvidenceExplanation,
+		CustomerCommunication: req.EvidenceCustomerCommunication,
+		ServiceDocumentation:  req.EvidenceServiceDocumentation,
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return billing.UpdateDispute(tx, dispute, req.Status, evidence, time.Now())
+	})
+	if errors.Is(err, billing.ErrInvalidTransition) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update dispute"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dispute)
+}
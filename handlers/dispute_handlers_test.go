@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateDisputeLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "s3cret"
+
+	r := gin.Default()
+	admin := r.Group("/admin", AdminMiddleware())
+	admin.GET("/org/:id/disputes", ListDisputes)
+	admin.GET("/org/:id/disputes/:dispute_id", GetDispute)
+	admin.PATCH("/org/:id/disputes/:dispute_id", UpdateDispute)
+
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 50, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, billing.FinalizeInvoice(database.DB, &invoice))
+	payment := models.Payment{InvoiceID: invoice.ID, UserID: user.ID, Amount: 50, Currency: "USD", PaymentDate: time.Now(), TransactionID: "ch_1", ProviderChargeID: "ch_1"}
+	_, err := billing.SettleInvoice(database.DB, &invoice, &payment)
+	assert.NoError(t, err)
+	dispute, err := billing.OpenDispute(database.DB, &payment, billing.OpenDisputeParams{Fee: 15, Reason: "fraudulent"})
+	assert.NoError(t, err)
+
+	path := fmt.Sprintf("/admin/org/%d/disputes/%d", org.ID, dispute.ID)
+	patch := func(body UpdateDisputeRequest) *httptest.ResponseRecorder {
+		jsonValue, _ := json.Marshal(body)
+		req, _ := http.NewRequest("PATCH", path, bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Tenants cannot change the status of their own disputes.
+	jsonValue, _ := json.Marshal(UpdateDisputeRequest{Status: "under_review", EvidenceExplanation: "Delivered"})
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("%s?caller_user_id=%d&caller_organization_id=%d", path, user.ID, org.ID), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = patch(UpdateDisputeRequest{Status: "under_review"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = patch(UpdateDisputeRequest{Status: "escalated"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = patch(UpdateDisputeRequest{Status: "under_review", EvidenceCustomerCommunication: "Emails confirming delivery"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Only the bank's decision, reported by the provider, closes a dispute.
+	for _, status := range []string{"won", "lost"} {
+		w = patch(UpdateDisputeRequest{Status: status})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	database.DB.First(dispute, dispute.ID)
+	assert.Equal(t, billing.DisputeUnderReview, dispute.Status)
+	assert.NoError(t, billing.CloseDispute(database.DB, dispute, false, time.Now()))
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/admin/org/%d/disputes?status=lost", org.ID), nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var disputes []models.Dispute
+	json.Unmarshal(w.Body.Bytes(), &disputes)
+	assert.Len(t, disputes, 1)
+	assert.Equal(t, "Emails confirming delivery", disputes[0].EvidenceCustomerCommunication)
+
+	req, _ = http.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"ProviderStatus":"charged_back"`)
+
+	database.DB.First(&invoice, invoice.ID)
+	assert.True(t, invoice.Uncollectible)
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // registers the JPEG logo decoder
+	_ "image/png"  // registers the PNG logo decoder
+	"net/http"
+	"strconv"
+	"time"
+
+	"invoxa/database"
+	"invoxa/documents"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxLogoSize bounds uploaded logos, which are stored in the database and
+// embedded in every PDF.
+const maxLogoSize = 256 << 10
+
+// callerInvoiceDocument loads an invoice of the caller's organization as a
+// document, writing the error response if it cannot.
+func callerInvoiceDocument(c *gin.Context) (*documents.InvoiceDocument, uint, bool) {
+	invoiceID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return nil, 0, false
+	}
+
+	var invoice models.Invoice
+	if err := database.DB.First(&invoice, invoiceID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+			return nil, 0, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve invoice"})
+		return nil, 0, false
+	}
+
+	callerOrganizationID := c.GetUint64("callerOrganizationID")
+	if invoice.OrganizationID != uint(callerOrganizationID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized: Invoice does not belong to the caller's organization"})
+		return nil, 0, false
+	}
+
+	doc, err := documents.LoadInvoice(database.DB, invoice.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load invoice"})
+		return nil, 0, false
+	}
+	return doc, invoice.OrganizationID, true
+}
+
+// GetInvoicePDF renders an invoice as a PDF download.
+func GetInvoicePDF(c *gin.Context) {
+	doc, _, ok := callerInvoiceDocument(c)
+	if !ok {
+		return
+	}
+
+	var pdf bytes.Buffer
+	if err := documents.RenderInvoicePDF(&pdf, doc); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render invoice"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "invoice-"+doc.Number+".pdf"))
+	c.Data(http.StatusOK, "application/pdf", pdf.Bytes())
+}
+
+// GetInvoicePage renders an invoice with the organization's HTML template,
+// or its plain-text template with ?format=text.
+func GetInvoicePage(c *gin.Context) {
+	format := c.DefaultQuery("format", documents.FormatHTML)
+	if format != documents.FormatHTML && format != documents.FormatText {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be html or text"})
+		return
+	}
+	doc, orgID, ok := callerInvoiceDocument(c)
+	if !ok {
+		return
+	}
+
+	rendered, err := documents.Render(database.DB, orgID, documents.KindInvoice, format, doc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render invoice"})
+		return
+	}
+	renderedDocument(c, format, rendered)
+}
+
+func GetBranding(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	branding, err := documents.LoadBranding(database.DB, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve branding"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"has_logo":      len(branding.Logo) > 0,
+		"logo_type":     branding.LogoType,
+		"primary_color": branding.PrimaryColor,
+		"accent_color":  branding.AccentColor,
+		"footer_text":   branding.FooterText,
+	})
+}
+
+type UpdateBrandingRequest struct {
+	Logo         []byte `json:"logo"` // base64-encoded PNG or JPEG; omit to keep the current logo
+	RemoveLogo   bool   `json:"remove_logo"`
+	PrimaryColor string `json:"primary_color"` // #rrggbb
+	AccentColor  string `json:"accent_color"`  // #rrggbb
+	FooterText   string `json:"footer_text" binding:"max=500"`
+}
+
+// UpdateBranding sets the logo, colors and footer shown on an organization's
+// invoice documents.
+func UpdateBranding(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateBrandingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, color := range []string{req.PrimaryColor, req.AccentColor} {
+		if color == "" {
+			continue
+		}
+		if _, _, _, err := documents.ParseHexColor(color); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var branding models.OrganizationBranding
+	err := database.DB.Where("organization_id = ?", orgID).First(&branding).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve branding"})
+		return
+	}
+	branding.OrganizationID = orgID
+
+	if len(req.Logo) > 0 {
+		if len(req.Logo) > maxLogoSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Logo must be at most %d KB", maxLogoSize>>10)})
+			return
+		}
+		_, format, err := image.DecodeConfig(bytes.NewReader(req.Logo))
+		if err != nil || (format != "png" && format != "jpeg") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Logo must be a PNG or JPEG image"})
+			return
+		}
+		branding.Logo = req.Logo
+		branding.LogoType = map[string]string{"png": "png", "jpeg": "jpg"}[format]
+	} else if req.RemoveLogo {
+		branding.Logo = nil
+		branding.LogoType = ""
+	}
+	branding.PrimaryColor = req.PrimaryColor
+	branding.AccentColor = req.AccentColor
+	branding.FooterText = req.FooterText
+
+	if err := database.DB.Save(&branding).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save branding"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Branding updated successfully"})
+}
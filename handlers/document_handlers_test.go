@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrandedInvoicePDF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.GET("/org/:id/branding", GetBranding)
+	r.PUT("/org/:id/branding", UpdateBranding)
+	r.POST("/subscribe", Subscribe)
+	r.GET("/invoice/:id/pdf", GetInvoicePDF)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+
+	jsonValue, _ := json.Marshal(UpdateBrandingRequest{Logo: []byte("GIF89a"), PrimaryColor: "#0f766e"})
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/org/%d/branding?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var logo bytes.Buffer
+	png.Encode(&logo, image.NewGray(image.Rect(0, 0, 8, 8)))
+	jsonValue, _ = json.Marshal(UpdateBrandingRequest{Logo: logo.Bytes(), PrimaryColor: "#0f766e", FooterText: "Thank you"})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/org/%d/branding?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/branding?%s", org.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"has_logo":true`)
+	assert.Contains(t, w.Body.String(), `"accent_color":"#e5e7eb"`)
+
+	plan := models.SubscriptionPlan{Name: "Basic Plan", Price: 30, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+	jsonValue, _ = json.Marshal(SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID})
+	req, _ = http.NewRequest("POST", "/subscribe?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		InvoiceID     uint   `json:"invoice_id"`
+		InvoiceNumber string `json:"invoice_number"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/invoice/%d/pdf?%s", created.InvoiceID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), created.InvoiceNumber)
+	assert.True(t, bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")))
+
+	other := models.Organization{Name: "Other Org", BillingEmail: "billing@other.org"}
+	database.DB.Create(&other)
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/invoice/%d/pdf?caller_user_id=%d&caller_organization_id=%d", created.InvoiceID, user.ID, other.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetDunningPolicy(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	policy, err := billing.DunningPolicyFor(database.DB, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve dunning policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+type UpdateDunningPolicyRequest struct {
+	RetryDays     string `json:"retry_days" binding:"required"`                      // e.g., "1,3,7"
+	FinalAction   string `json:"final_action" binding:"required,oneof=cancel pause"` // what happens to the subscription after the last retry
+	SendReminders *bool  `json:"send_reminders"`
+}
+
+func UpdateDunningPolicy(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateDunningPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := billing.ParseRetryDays(req.RetryDays); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var policy models.DunningPolicy
+	err := database.DB.Where("organization_id = ?", orgID).First(&policy).Error
+	if err == gorm.ErrRecordNotFound {
+		policy = billing.DefaultDunningPolicy
+		policy.OrganizationID = orgID
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve dunning policy"})
+		return
+	}
+
+	policy.RetryDays = req.RetryDays
+	policy.FinalAction = req.FinalAction
+	sendReminders := policy.SendReminders
+	if req.SendReminders != nil {
+		sendReminders = *req.SendReminders
+	}
+
+	// Creating the row would replace a false SendReminders with the column
+	// default, so it is written separately.
+	if err := database.DB.Save(&policy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save dunning policy"})
+		return
+	}
+	if err := database.DB.Model(&policy).Update("send_reminders", sendReminders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save dunning policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func GetCollectionAttempts(c *gin.Context) {
+	invoiceID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	callerOrganizationID := c.GetUint64("callerOrganizationID")
+
+	var invoice models.Invoice
+	if err := database.DB.First(&invoice, invoiceID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve invoice"})
+		return
+	}
+
+	if invoice.OrganizationID != uint(callerOrganizationID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized: Invoice does not belong to the caller's organization"})
+		return
+	}
+
+	var attempts []models.CollectionAttempt
+	if err := database.DB.Where("invoice_id = ?", invoiceID).Order("attempt_number, id").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve collection attempts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invoice_id":         invoice.ID,
+		"paid":               invoice.Paid,
+		"uncollectible":      invoice.Uncollectible,
+		"next_collection_at": invoice.NextCollectionAt,
+		"attempts":           attempts,
+	})
+}
+
+// RunDunning retries collection of past due invoices now instead of waiting
+// for the background scheduler.
+func RunDunning(c *gin.Context) {
+	report, err := billing.RunDunning(c.Request.Context(), database.DB, PaymentProvider, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run dunning"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
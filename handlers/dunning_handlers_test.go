@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDunningPolicyAndCollectionAttempts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorDecline)
+
+	r := gin.Default()
+	r.POST("/admin/run_dunning", RunDunning)
+	authRequired := r.Group("/")
+	authRequired.Use(AuthMiddleware())
+	authRequired.GET("/org/:id/dunning_policy", GetDunningPolicy)
+	authRequired.PUT("/org/:id/dunning_policy", UpdateDunningPolicy)
+	authRequired.GET("/invoice/:id/collection_attempts", GetCollectionAttempts)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+
+	jsonValue, _ := json.Marshal(UpdateDunningPolicyRequest{RetryDays: "3,1", FinalAction: "cancel"})
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/org/%d/dunning_policy?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	sendReminders := false
+	jsonValue, _ = json.Marshal(UpdateDunningPolicyRequest{RetryDays: "1", FinalAction: "cancel", SendReminders: &sendReminders})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/org/%d/dunning_policy?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var policy models.DunningPolicy
+	database.DB.First(&policy, "organization_id = ?", org.ID)
+	assert.Equal(t, "1", policy.RetryDays)
+	assert.False(t, policy.SendReminders)
+
+	dueDate := time.Now().AddDate(0, 0, -5)
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 12, Currency: "USD", IssueDate: dueDate, DueDate: dueDate}
+	database.DB.Create(&invoice)
+
+	req, _ = http.NewRequest("POST", "/admin/run_dunning", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/invoice/%d/collection_attempts?%s", invoice.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var history struct {
+		Uncollectible bool                       `json:"uncollectible"`
+		Attempts      []models.CollectionAttempt `json:"attempts"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &history)
+	assert.NoError(t, err)
+	assert.True(t, history.Uncollectible)
+	assert.Len(t, history.Attempts, 1)
+	assert.Equal(t, "no_payment_method", history.Attempts[0].FailureCode)
+	assert.False(t, history.Attempts[0].ReminderSent)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImportExchangeRates stores a batch of exchange rates, replacing any already
+// stored for the same pair and date.
+func ImportExchangeRates(c *gin.Context) {
+	var inputs []billing.ExchangeRateInput
+	if err := c.ShouldBindJSON(&inputs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(inputs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No exchange rates given"})
+		return
+	}
+
+	saved, err := billing.SaveExchangeRates(database.DB, inputs, "api")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Exchange rates saved successfully", "saved": saved})
+}
+
+// GetExchangeRate returns the rate from ?base= to ?quote= in effect on ?date=
+// (YYYY-MM-DD), or today.
+func GetExchangeRate(c *gin.Context) {
+	base, quote := strings.ToUpper(c.Query("base")), strings.ToUpper(c.Query("quote"))
+	if base == "" || quote == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "base and quote currencies are required"})
+		return
+	}
+
+	on := time.Now()
+	if date := c.Query("date"); date != "" {
+		parsed, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date; expected YYYY-MM-DD"})
+			return
+		}
+		on = parsed
+	}
+
+	rate, err := billing.ExchangeRateOn(database.DB, base, quote, on)
+	if errors.Is(err, billing.ErrNoExchangeRate) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve exchange rate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"base": base, "quote": quote, "date": on.Format("2006-01-02"), "rate": rate})
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExchangeRatesForPaymentsAndSummary(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.POST("/admin/exchange_rates", ImportExchangeRates)
+	authRequired := r.Group("/")
+	authRequired.Use(AuthMiddleware())
+	authRequired.GET("/exchange_rates", GetExchangeRate)
+	authRequired.POST("/pay_invoice", PayInvoice)
+	authRequired.GET("/org/:id/summary", GetOrgSummary)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+	yesterday := time.Now().AddDate(0, 0, -1)
+
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 50, Currency: "EUR", IssueDate: yesterday, DueDate: time.Now()}
+	database.DB.Create(&invoice)
+	database.DB.Create(&models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 20, Currency: "USD", IssueDate: yesterday, DueDate: time.Now()})
+	database.DB.Create(&models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 1000, Currency: "JPY", IssueDate: yesterday, DueDate: time.Now(), Paid: true})
+
+	pay := PayInvoiceRequest{InvoiceID: invoice.ID, UserID: user.ID, Amount: 55, Currency: "USD", PaymentMethod: "pm_card_visa"}
+	jsonValue, _ := json.Marshal(pay)
+	req, _ := http.NewRequest("POST", "/pay_invoice?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "no exchange rate")
+
+	rates := []billing.ExchangeRateInput{{Base: "EUR", Quote: "USD", Rate: 1.1, EffectiveDate: yesterday.Format("2006-01-02")}}
+	jsonValue, _ = json.Marshal(rates)
+	req, _ = http.NewRequest("POST", "/admin/exchange_rates", bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	req, _ = http.NewRequest("GET", "/exchange_rates?base=usd&quote=eur&"+query, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rate struct {
+		Rate float64 `json:"rate"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &rate)
+	assert.InDelta(t, 1/1.1, rate.Rate, 1e-9)
+
+	// 50 USD is only 45.45 EUR.
+	pay.Amount = 50
+	jsonValue, _ = json.Marshal(pay)
+	req, _ = http.NewRequest("POST", "/pay_invoice?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	pay.Amount = 55
+	jsonValue, _ = json.Marshal(pay)
+	req, _ = http.NewRequest("POST", "/pay_invoice?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var payment models.Payment
+	database.DB.Where("invoice_id = ?", invoice.ID).First(&payment)
+	assert.Equal(t, 50.0, payment.Amount)
+	assert.Equal(t, "EUR", payment.Currency)
+	assert.Equal(t, 55.0, payment.ChargedAmount)
+	assert.Equal(t, "USD", payment.ChargedCurrency)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/summary?%s", org.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var summary OrgSummaryResponse
+	json.Unmarshal(w.Body.Bytes(), &summary)
+	assert.Equal(t, "USD", summary.ReportingCurrency)
+	// The unpaid USD invoice is not revenue yet.
+	assert.InDelta(t, 55.0, summary.TotalRevenue, 0.001)
+	assert.Equal(t, 50.0, summary.RevenueByCurrency["EUR"])
+	assert.NotContains(t, summary.RevenueByCurrency, "USD")
+	assert.Equal(t, []string{"JPY"}, summary.MissingExchangeRates)
+}
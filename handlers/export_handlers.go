@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"invoxa/database"
+	"invoxa/export"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportOptions parses the dataset, ?format= (csv by default) and the ?from=
+// and ?to= days (YYYY-MM-DD, inclusive) of an export.
+func exportOptions(c *gin.Context, organizationID uint) (export.Options, bool) {
+	opts := export.Options{
+		Dataset:        c.Param("dataset"),
+		Format:         c.DefaultQuery("format", export.FormatCSV),
+		OrganizationID: organizationID,
+	}
+	for param, day := range map[string]*time.Time{"from": &opts.From, "to": &opts.To} {
+		s := c.Query(param)
+		if s == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a date formatted as YYYY-MM-DD"})
+			return opts, false
+		}
+		*day = parsed
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return opts, false
+	}
+	return opts, true
+}
+
+// streamExport writes an export straight to the response. Once rows have
+// been sent the status can no longer change, so a failure part way through
+// is only logged and the response is cut short.
+func streamExport(c *gin.Context, organizationID uint) {
+	opts, ok := exportOptions(c, organizationID)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", export.ContentType(opts.Format))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", opts.FileName()))
+	c.Status(http.StatusOK)
+	if _, err := export.Write(c.Request.Context(), database.DB, c.Writer, opts); err != nil {
+		log.Printf("Export of %s failed: %v", opts.Dataset, err)
+		c.Abort()
+	}
+}
+
+// ExportData streams one of the caller's datasets (invoices, invoice_lines,
+// payments, refunds, subscriptions or journal) as CSV or NDJSON, or the
+// journal for QuickBooks or Xero.
+func ExportData(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+	streamExport(c, orgID)
+}
+
+// ExportAllData streams a dataset of every organization.
+func ExportAllData(c *gin.Context) {
+	streamExport(c, 0)
+}
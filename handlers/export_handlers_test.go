@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.GET("/admin/export/:dataset", ExportAllData)
+	r.GET("/org/:id/export/:dataset", AuthMiddleware(), ExportData)
+
+	other := models.Organization{Name: "Other Org"}
+	database.DB.Create(&other)
+	issued := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	database.DB.Create(&models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 45, Currency: "USD", IssueDate: issued, DueDate: issued})
+	database.DB.Create(&models.Invoice{OrganizationID: other.ID, Amount: 15, Currency: "USD", IssueDate: issued, DueDate: issued})
+	database.DB.Create(&models.Invoice{OrganizationID: org.ID, Amount: 30, Currency: "USD", IssueDate: issued.AddDate(0, 1, 0), DueDate: issued.AddDate(0, 1, 0)})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	caller := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+
+	w := get(fmt.Sprintf("/org/%d/export/invoices?from=2026-05-01&to=2026-05-31&%s", org.ID, caller))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "invoices.csv")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[1], ",45.00,")
+
+	w = get("/admin/export/invoices?format=ndjson")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), 3)
+
+	assert.Equal(t, http.StatusForbidden, get(fmt.Sprintf("/org/%d/export/invoices?%s", other.ID, caller)).Code)
+	assert.Equal(t, http.StatusBadRequest, get("/admin/export/customers").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/admin/export/invoices?format=xlsx").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/admin/export/invoices?from=May").Code)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"invoxa/database"
+	"invoxa/imports"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// MaxImportSize is the largest file an import accepts. Runs keep their file
+// so that they can be resumed, so larger migrations are split into several
+// files.
+var MaxImportSize int64 = 32 << 20
+
+// importFormat takes the format of an uploaded file from ?format=, or else
+// from its content type.
+func importFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+	switch contentType := c.ContentType(); {
+	case strings.Contains(contentType, "json"):
+		return imports.FormatJSON
+	case contentType == "text/csv":
+		return imports.FormatCSV
+	default:
+		return contentType
+	}
+}
+
+// importRunResponse describes a run without the file it imports.
+func importRunResponse(run *models.ImportRun) gin.H {
+	rowErrors := make([]imports.RowError, len(run.Errors))
+	for i, e := range run.Errors {
+		rowErrors[i] = imports.RowError{Row: e.Row, Field: e.Field, Message: e.Message}
+	}
+	return gin.H{
+		"id":             run.ID,
+		"entity":         run.Entity,
+		"format":         run.Format,
+		"status":         run.Status,
+		"error":          run.Error,
+		"total_rows":     run.TotalRows,
+		"processed_rows": run.ProcessedRows,
+		"created":        run.Created,
+		"updated":        run.Updated,
+		"failed":         run.Failed,
+		"errors":         rowErrors,
+		"created_at":     run.CreatedAt,
+	}
+}
+
+func loadImportRun(runID uint) (*models.ImportRun, error) {
+	var run models.ImportRun
+	err := database.DB.Preload("Errors", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&run, runID).Error
+	return &run, err
+}
+
+// respondWithImportRun reloads a run with its row errors after it was
+// applied. A run that stopped part way is still returned, with why it
+// stopped, so that it can be resumed.
+func respondWithImportRun(c *gin.Context, runID uint, status int) {
+	run, err := loadImportRun(runID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve import run"})
+		return
+	}
+	if run.Status != imports.StatusCompleted {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, importRunResponse(run))
+}
+
+// ImportData imports a CSV or JSON file of an entity from the request body,
+// creating records or updating those imported before with the same external
+// ID. With ?dry_run=true the file is only validated, and the response reports
+// what importing it would do.
+func ImportData(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d bytes", MaxImportSize)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	entity, format := c.Param("entity"), importFormat(c)
+
+	if c.Query("dry_run") == "true" {
+		report, err := imports.DryRun(database.DB, entity, format, data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	run, err := imports.Start(c.Request.Context(), database.DB, entity, format, data)
+	if run == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	respondWithImportRun(c, run.ID, http.StatusCreated)
+}
+
+// GetImportRun returns the progress and row errors of an import run.
+func GetImportRun(c *gin.Context) {
+	runID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import run ID"})
+		return
+	}
+	run, err := loadImportRun(uint(runID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import run not found"})
+		return
+	}
+	c.JSON(http.StatusOK, importRunResponse(run))
+}
+
+// ResumeImportRun continues an import run that stopped before the end.
+func ResumeImportRun(c *gin.Context) {
+	runID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import run ID"})
+		return
+	}
+	run, err := imports.Resume(c.Request.Context(), database.DB, uint(runID))
+	switch {
+	case errors.Is(err, imports.ErrCompleted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case run == nil:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import run not found"})
+		return
+	}
+	respondWithImportRun(c, run.ID, http.StatusOK)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupHandlerTestDB(t)
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "s3cret"
+
+	r := gin.Default()
+	admin := r.Group("/admin", AdminMiddleware())
+	admin.POST("/imports/:entity", ImportData)
+	admin.GET("/import_runs/:id", GetImportRun)
+	admin.POST("/import_runs/:id/resume", ResumeImportRun)
+
+	token := "s3cret"
+	send := func(method, path, contentType, body string) (*httptest.ResponseRecorder, map[string]any) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response map[string]any
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+	file := "external_id,name,billing_email\norg_1,Imported Org,billing@imported.test\norg_2,,billing@other.test\n"
+
+	w, report := send("POST", "/admin/imports/organizations?dry_run=true", "text/csv", file)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), report["create"])
+	assert.Equal(t, float64(1), report["failed"])
+	var count int64
+	database.DB.Model(&models.Organization{}).Where("external_id IS NOT NULL").Count(&count)
+	assert.Zero(t, count)
+
+	w, run := send("POST", "/admin/imports/organizations", "text/csv", file)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "completed", run["status"])
+	assert.Equal(t, float64(1), run["created"])
+	assert.Equal(t, []any{map[string]any{"row": float64(2), "field": "name", "message": "is required"}}, run["errors"])
+	assert.NotContains(t, run, "data")
+
+	w, _ = send("GET", fmt.Sprintf("/admin/import_runs/%v", run["id"]), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = send("POST", fmt.Sprintf("/admin/import_runs/%v/resume", run["id"]), "", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, _ = send("POST", "/admin/imports/organizations", "application/json", `[{"external_id": "org_3", "name": "JSON Org", "billing_email": "json@imported.test"}]`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w, _ = send("POST", "/admin/imports/customers", "text/csv", file)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = send("POST", "/admin/imports/organizations", "application/xml", "<organizations/>")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = send("GET", "/admin/import_runs/999", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	defer func(size int64) { MaxImportSize = size }(MaxImportSize)
+	MaxImportSize = 32
+	w, _ = send("POST", "/admin/imports/organizations", "text/csv", file)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	token = ""
+	w, _ = send("POST", "/admin/imports/organizations", "text/csv", file)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = send("GET", fmt.Sprintf("/admin/import_runs/%v", run["id"]), "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestImportedInvoiceNumbersAreNotReissued(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "s3cret"
+	externalID := "org_1"
+	database.DB.Model(org).Update("external_id", &externalID)
+
+	r := gin.Default()
+	r.POST("/admin/imports/:entity", AdminMiddleware(), ImportData)
+	r.POST("/subscribe", AuthMiddleware(), Subscribe)
+
+	year := time.Now().Year()
+	file := fmt.Sprintf("external_id,organization_external_id,number,amount,issue_date\nin_1,org_1,INV-%d-000001,50,%d-01-15\n", year, year)
+	req, _ := http.NewRequest("POST", "/admin/imports/invoices", strings.NewReader(file))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"created":1`)
+
+	plan := models.SubscriptionPlan{Name: "Basic Plan", Price: 30, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+	jsonValue, _ := json.Marshal(SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID})
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/subscribe?caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		InvoiceNumber string `json:"invoice_number"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, fmt.Sprintf("INV-%d-000002", year), created.InvoiceNumber)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UpdateInvoiceNumberingRequest struct {
+	Prefix string `json:"prefix" binding:"max=20"`
+	Format string `json:"format" binding:"max=64"` // e.g., "{prefix}-{year}-{seq:6}"
+}
+
+// UpdateInvoiceNumbering sets the prefix and format of an organization's
+// invoice numbers. Empty values restore the defaults. Invoices already issued
+// keep their numbers.
+func UpdateInvoiceNumbering(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateInvoiceNumberingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Format != "" {
+		if err := billing.ValidateInvoiceNumberFormat(req.Format); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err := database.DB.Model(&models.Organization{}).Where("id = ?", orgID).
+		Updates(map[string]interface{}{"invoice_prefix": req.Prefix, "invoice_number_format": req.Format}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice numbering"})
+		return
+	}
+
+	prefix, format := req.Prefix, req.Format
+	if prefix == "" {
+		prefix = billing.DefaultInvoicePrefix
+	}
+	if format == "" {
+		format = billing.DefaultInvoiceNumberFormat
+	}
+	c.JSON(http.StatusOK, gin.H{"prefix": prefix, "format": format})
+}
+
+// GetInvoiceByNumber looks up one of the organization's invoices by its
+// invoice number.
+func GetInvoiceByNumber(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var invoice models.Invoice
+	err := database.DB.Preload("TaxLines").Where("organization_id = ? AND number = ?", orgID, c.Param("number")).First(&invoice).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found for this organization"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve invoice"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoiceNumberingAndLookup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.PUT("/org/:id/invoice_numbering", UpdateInvoiceNumbering)
+	r.GET("/org/:id/invoices/by_number/:number", GetInvoiceByNumber)
+	r.POST("/subscribe", Subscribe)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+
+	jsonValue, _ := json.Marshal(UpdateInvoiceNumberingRequest{Prefix: "ACME", Format: "{prefix}-{year}"})
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/org/%d/invoice_numbering?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	jsonValue, _ = json.Marshal(UpdateInvoiceNumberingRequest{Prefix: "ACME"})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/org/%d/invoice_numbering?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	plan := models.SubscriptionPlan{Name: "Basic Plan", Price: 30, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+
+	jsonValue, _ = json.Marshal(SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID})
+	req, _ = http.NewRequest("POST", "/subscribe?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		InvoiceID     uint   `json:"invoice_id"`
+		InvoiceNumber string `json:"invoice_number"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	number := fmt.Sprintf("ACME-%d-000001", time.Now().Year())
+	assert.Equal(t, number, created.InvoiceNumber)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/invoices/by_number/%s?%s", org.ID, number, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var invoice models.Invoice
+	json.Unmarshal(w.Body.Bytes(), &invoice)
+	assert.Equal(t, created.InvoiceID, invoice.ID)
+
+	// Numbers are only unique within an organization.
+	other := models.Organization{Name: "Other Org", BillingEmail: "billing@other.org"}
+	database.DB.Create(&other)
+	otherUser := models.User{Username: "otheruser", Email: "other@other.org", PasswordHash: "hash", OrganizationID: other.ID}
+	database.DB.Create(&otherUser)
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/invoices/by_number/%s?caller_user_id=%d&caller_organization_id=%d", other.ID, number, otherUser.ID, other.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
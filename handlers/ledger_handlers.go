@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TrialBalanceResponse struct {
+	OrganizationID uint                      `json:"organization_id"`
+	Accounts       []billing.TrialBalanceRow `json:"accounts"`
+	Balanced       bool                      `json:"balanced"`
+}
+
+func GetTrialBalance(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	rows, err := billing.TrialBalance(database.DB, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate trial balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, TrialBalanceResponse{
+		OrganizationID: orgID,
+		Accounts:       rows,
+		Balanced:       billing.Balanced(rows),
+	})
+}
+
+type LedgerCheckResponse struct {
+	OrganizationID uint                  `json:"organization_id"`
+	OK             bool                  `json:"ok"`
+	Issues         []billing.LedgerIssue `json:"issues"`
+}
+
+func CheckLedger(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	issues, err := billing.CheckLedger(database.DB, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check ledger"})
+		return
+	}
+
+	c.JSON(http.StatusOK, LedgerCheckResponse{
+		OrganizationID: orgID,
+		OK:             len(issues) == 0,
+		Issues:         issues,
+	})
+}
+
+// GetChartOfAccounts returns the code and name accounting exports give each
+// journal account of the caller.
+func GetChartOfAccounts(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	chart, err := billing.ChartOfAccounts(database.DB, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve chart of accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": chart})
+}
+
+type UpdateChartOfAccountsRequest struct {
+	Accounts []struct {
+		Account string `json:"account" binding:"required"` // e.g., 'revenue'
+		Code    string `json:"code" binding:"required"`
+		Name    string `json:"name"` // the default name if empty
+	} `json:"accounts" binding:"required,dive"`
+}
+
+// UpdateChartOfAccounts maps journal accounts to the caller's own account
+// codes. Accounts left out keep their mapping.
+func UpdateChartOfAccounts(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateChartOfAccountsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defaults := map[string]billing.LedgerAccount{}
+	for _, account := range billing.DefaultChartOfAccounts {
+		defaults[account.Account] = account
+	}
+	for _, account := range req.Accounts {
+		if _, ok := defaults[account.Account]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown account: " + account.Account})
+			return
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, account := range req.Accounts {
+			name := account.Name
+			if name == "" {
+				name = defaults[account.Account].Name
+			}
+			var mapping models.AccountMapping
+			err := tx.Where("organization_id = ? AND account = ?", orgID, account.Account).First(&mapping).Error
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+			mapping.OrganizationID = orgID
+			mapping.Account = account.Account
+			mapping.Code = account.Code
+			mapping.Name = name
+			if err := tx.Save(&mapping).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save chart of accounts"})
+		return
+	}
+
+	GetChartOfAccounts(c)
+}
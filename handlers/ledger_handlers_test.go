@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrialBalanceAfterSubscribeAndPay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/subscribe", Subscribe)
+	r.POST("/pay_invoice", PayInvoice)
+	r.GET("/org/:id/trial_balance", GetTrialBalance)
+	r.GET("/org/:id/ledger/check", CheckLedger)
+
+	plan := models.SubscriptionPlan{Name: "Pro Plan", Price: 49.99, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+
+	jsonValue, _ := json.Marshal(SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID})
+	req, _ := http.NewRequest("POST", "/subscribe?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var invoice models.Invoice
+	database.DB.First(&invoice)
+
+	jsonValue, _ = json.Marshal(PayInvoiceRequest{InvoiceID: invoice.ID, UserID: user.ID, Amount: 49.99, Currency: "USD", TransactionID: "txn_123", PaymentMethod: "card"})
+	req, _ = http.NewRequest("POST", "/pay_invoice?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/trial_balance?%s", org.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var trialBalance TrialBalanceResponse
+	err := json.Unmarshal(w.Body.Bytes(), &trialBalance)
+	assert.NoError(t, err)
+	assert.True(t, trialBalance.Balanced)
+	assert.Len(t, trialBalance.Accounts, 3)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/ledger/check?%s", org.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var check LedgerCheckResponse
+	err = json.Unmarshal(w.Body.Bytes(), &check)
+	assert.NoError(t, err)
+	assert.True(t, check.OK)
+}
+
+func TestUpdateChartOfAccounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.GET("/org/:id/chart_of_accounts", GetChartOfAccounts)
+	r.PUT("/org/:id/chart_of_accounts", UpdateChartOfAccounts)
+
+	path := fmt.Sprintf("/org/%d/chart_of_accounts?caller_user_id=%d&caller_organization_id=%d", org.ID, user.ID, org.ID)
+	put := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	chart := func(w *httptest.ResponseRecorder) map[string]billing.LedgerAccount {
+		var response struct {
+			Accounts []billing.LedgerAccount `json:"accounts"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		accounts := map[string]billing.LedgerAccount{}
+		for _, account := range response.Accounts {
+			accounts[account.Account] = account
+		}
+		return accounts
+	}
+
+	w := put(`{"accounts": [{"account": "revenue", "code": "200", "name": "Subscription Revenue"}, {"account": "cash", "code": "090"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	accounts := chart(w)
+	assert.Equal(t, billing.LedgerAccount{Account: "revenue", Code: "200", Name: "Subscription Revenue"}, accounts["revenue"])
+	assert.Equal(t, billing.LedgerAccount{Account: "cash", Code: "090", Name: "Cash"}, accounts["cash"])
+	assert.Equal(t, "1100", accounts["accounts_receivable"].Code)
+
+	// Mapping an account again replaces its mapping.
+	put(`{"accounts": [{"account": "revenue", "code": "210"}]}`)
+	req, _ := http.NewRequest("GET", path, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "210", chart(w)["revenue"].Code)
+	var count int64
+	database.DB.Model(&models.AccountMapping{}).Where("organization_id = ?", org.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	assert.Equal(t, http.StatusBadRequest, put(`{"accounts": [{"account": "marketing", "code": "500"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put(`{"accounts": [{"account": "revenue"}]}`).Code)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+)
+
+// organizationInvoices selects the IDs of an organization's invoices, which
+// scopes payments and refunds.
+func organizationInvoices(orgID uint) any {
+	return database.DB.Model(&models.Invoice{}).Select("id").Where("organization_id = ?", orgID)
+}
+
+var invoiceList = listSpec[models.Invoice]{
+	Name: "invoices",
+	Sorts: map[string]sortKey[models.Invoice]{
+		"created_at": {"created_at", func(i *models.Invoice) any { return i.CreatedAt }},
+		"issue_date": {"issue_date", func(i *models.Invoice) any { return i.IssueDate }},
+		"due_date":   {"due_date", func(i *models.Invoice) any { return i.DueDate }},
+		"amount":     {"amount", func(i *models.Invoice) any { return i.Amount }},
+	},
+	DefaultSort: "-created_at",
+	ID:          func(i *models.Invoice) uint { return i.ID },
+}
+
+// ListInvoices lists an organization's invoices. Filters: status (paid, open,
+// past_due or uncollectible), from and to (issue date), user_id, plan_id,
+// subscription_id, currency, min_amount and max_amount.
+func ListInvoices(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	f := &listFilters{c: c, query: database.DB.Where("organization_id = ?", orgID)}
+	f.status(map[string]func(){
+		"paid":          func() { f.where("paid = ?", true) },
+		"uncollectible": func() { f.where("uncollectible = ?", true) },
+		"open":          func() { f.where("paid = ? AND uncollectible = ? AND due_date >= ?", false, false, now) },
+		"past_due":      func() { f.where("paid = ? AND uncollectible = ? AND due_date < ?", false, false, now) },
+	})
+	f.dates("issue_date")
+	f.equalID("user_id", "user_id")
+	f.equalID("subscription_id", "subscription_id")
+	if planID, ok := f.id("plan_id"); ok {
+		f.where("subscription_id IN (?)", database.DB.Model(&models.Subscription{}).Select("id").Where("subscription_plan_id = ?", planID))
+	}
+	f.currency("currency")
+	f.amounts("amount")
+	if f.err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": f.err.Error()})
+		return
+	}
+
+	respondWithPage(c, f.query, invoiceList)
+}
+
+var paymentList = listSpec[models.Payment]{
+	Name: "payments",
+	Sorts: map[string]sortKey[models.Payment]{
+		"created_at":   {"created_at", func(p *models.Payment) any { return p.CreatedAt }},
+		"payment_date": {"payment_date", func(p *models.Payment) any { return p.PaymentDate }},
+		"amount":       {"amount", func(p *models.Payment) any { return p.Amount }},
+	},
+	DefaultSort: "-created_at",
+	ID:          func(p *models.Payment) uint { return p.ID },
+}
+
+// ListPayments lists the payments towards an organization's invoices.
+// Filters: status (the provider status, e.g., succeeded or declined), from and
+// to (payment date), user_id, invoice_id, currency, min_amount and max_amount.
+func ListPayments(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	f := &listFilters{c: c, query: database.DB.Where("invoice_id IN (?)", organizationInvoices(orgID))}
+	statuses := map[string]func(){}
+	for _, status := range []string{payments.StatusSucceeded, payments.StatusPending, payments.StatusDeclined, payments.StatusRefunded,
+		payments.StatusFailed, payments.StatusDisputed, payments.StatusChargedBack} {
+		statuses[status] = func() { f.where("provider_status = ?", status) }
+	}
+	f.status(statuses)
+	f.dates("payment_date")
+	f.equalID("user_id", "user_id")
+	f.equalID("invoice_id", "invoice_id")
+	f.currency("currency")
+	f.amounts("amount")
+	if f.err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": f.err.Error()})
+		return
+	}
+
+	respondWithPage(c, f.query, paymentList)
+}
+
+var refundList = listSpec[models.Refund]{
+	Name: "refunds",
+	Sorts: map[string]sortKey[models.Refund]{
+		"created_at":  {"created_at", func(r *models.Refund) any { return r.CreatedAt }},
+		"refund_date": {"refund_date", func(r *models.Refund) any { return r.RefundDate }},
+		"amount":      {"amount", func(r *models.Refund) any { return r.Amount }},
+	},
+	DefaultSort: "-created_at",
+	ID:          func(r *models.Refund) uint { return r.ID },
+}
+
+// ListRefunds lists the refunds of an organization's invoices. Filters:
+// status (the provider status), from and to (refund date), user_id,
+// invoice_id, payment_id, currency, min_amount and max_amount.
+func ListRefunds(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	f := &listFilters{c: c, query: database.DB.Where("invoice_id IN (?)", organizationInvoices(orgID))}
+	statuses := map[string]func(){}
+	for _, status := range []string{payments.StatusSucceeded, payments.StatusPending, payments.StatusFailed} {
+		statuses[status] = func() { f.where("provider_status = ?", status) }
+	}
+	f.status(statuses)
+	f.dates("refund_date")
+	f.equalID("user_id", "user_id")
+	f.equalID("invoice_id", "invoice_id")
+	f.equalID("payment_id", "payment_id")
+	f.currency("currency")
+	f.amounts("amount")
+	if f.err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": f.err.Error()})
+		return
+	}
+
+	respondWithPage(c, f.query, refundList)
+}
+
+var subscriptionList = listSpec[models.Subscription]{
+	Name: "subscriptions",
+	Sorts: map[string]sortKey[models.Subscription]{
+		"created_at":         {"created_at", func(s *models.Subscription) any { return s.CreatedAt }},
+		"start_date":         {"start_date", func(s *models.Subscription) any { return s.StartDate }},
+		"current_period_end": {"current_period_end", func(s *models.Subscription) any { return s.CurrentPeriodEnd }},
+	},
+	DefaultSort: "-created_at",
+	ID:          func(s *models.Subscription) uint { return s.ID },
+}
+
+// ListSubscriptions lists an organization's subscriptions with their plans.
+// Filters: status (active, paused or cancelled), from and to (start date),
+// user_id, plan_id, and currency, min_amount and max_amount of the plan's
+// price.
+func ListSubscriptions(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	f := &listFilters{c: c, query: database.DB.Preload("SubscriptionPlan").Where("organization_id = ?", orgID)}
+	f.status(map[string]func(){
+		"active":    func() { f.where("is_active = ? AND paused_at IS NULL", true) },
+		"paused":    func() { f.where("is_active = ? AND paused_at IS NOT NULL", true) },
+		"cancelled": func() { f.where("is_active = ?", false) },
+	})
+	f.dates("start_date")
+	f.equalID("user_id", "user_id")
+	f.equalID("plan_id", "subscription_plan_id")
+
+	plans := &listFilters{c: c, query: database.DB.Model(&models.SubscriptionPlan{}).Select("id")}
+	plans.currency("currency")
+	plans.amounts("price")
+	if f.err == nil {
+		f.err = plans.err
+	}
+	if c.Query("currency") != "" || c.Query("min_amount") != "" || c.Query("max_amount") != "" {
+		f.where("subscription_plan_id IN (?)", plans.query)
+	}
+	if f.err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": f.err.Error()})
+		return
+	}
+
+	respondWithPage(c, f.query, subscriptionList)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type invoicePage struct {
+	Data       []models.Invoice `json:"data"`
+	HasMore    bool             `json:"has_more"`
+	NextCursor *string          `json:"next_cursor"`
+	PrevCursor *string          `json:"prev_cursor"`
+}
+
+func invoiceIDs(page invoicePage) []uint {
+	ids := []uint{}
+	for _, invoice := range page.Data {
+		ids = append(ids, invoice.ID)
+	}
+	return ids
+}
+
+func TestListInvoicesPagesWithCursors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.GET("/org/:id/invoices", ListInvoices)
+
+	issued := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	amounts := []float64{30, 10, 50, 10, 20}
+	var invoices []models.Invoice
+	for i, amount := range amounts {
+		invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: amount, Currency: "USD",
+			IssueDate: issued.AddDate(0, 0, i), DueDate: issued.AddDate(0, 0, i+30)}
+		database.DB.Create(&invoice)
+		invoices = append(invoices, invoice)
+	}
+	database.DB.Model(&invoices[2]).Update("paid", true)
+	other := models.Organization{Name: "Other Org"}
+	database.DB.Create(&other)
+	database.DB.Create(&models.Invoice{OrganizationID: other.ID, Amount: 99, Currency: "USD", IssueDate: issued, DueDate: issued})
+
+	list := func(params url.Values) (int, invoicePage) {
+		params.Set("caller_user_id", fmt.Sprint(user.ID))
+		params.Set("caller_organization_id", fmt.Sprint(org.ID))
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/org/%d/invoices?%s", org.ID, params.Encode()), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var page invoicePage
+		json.Unmarshal(w.Body.Bytes(), &page)
+		return w.Code, page
+	}
+
+	// Sorted by amount, ties broken by ID, two at a time.
+	code, page := list(url.Values{"sort": {"amount"}, "limit": {"2"}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint{invoices[1].ID, invoices[3].ID}, invoiceIDs(page))
+	assert.True(t, page.HasMore)
+	assert.Nil(t, page.PrevCursor)
+
+	code, page = list(url.Values{"sort": {"amount"}, "limit": {"2"}, "cursor": {*page.NextCursor}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint{invoices[4].ID, invoices[0].ID}, invoiceIDs(page))
+	assert.NotNil(t, page.PrevCursor)
+	second := page
+
+	code, page = list(url.Values{"sort": {"amount"}, "limit": {"2"}, "cursor": {*page.NextCursor}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint{invoices[2].ID}, invoiceIDs(page))
+	assert.False(t, page.HasMore)
+	assert.Nil(t, page.NextCursor)
+
+	// Going back returns the previous pages.
+	code, page = list(url.Values{"sort": {"amount"}, "limit": {"2"}, "cursor": {*page.PrevCursor}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, invoiceIDs(second), invoiceIDs(page))
+	code, page = list(url.Values{"sort": {"amount"}, "limit": {"2"}, "cursor": {*page.PrevCursor}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint{invoices[1].ID, invoices[3].ID}, invoiceIDs(page))
+	assert.Nil(t, page.PrevCursor)
+	assert.True(t, page.HasMore)
+
+	// Newest first by default, scoped to the caller's organization.
+	code, page = list(url.Values{})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, page.Data, 5)
+	assert.Equal(t, invoices[4].ID, page.Data[0].ID)
+
+	code, page = list(url.Values{"status": {"paid"}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint{invoices[2].ID}, invoiceIDs(page))
+
+	code, page = list(url.Values{"from": {"2026-03-02"}, "to": {"2026-03-04"}, "min_amount": {"15"}, "sort": {"issue_date"}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint{invoices[2].ID}, invoiceIDs(page))
+
+	code, page = list(url.Values{"currency": {"eur"}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, page.Data)
+	assert.NotNil(t, page.Data)
+
+	code, _ = list(url.Values{"status": {"overdue"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = list(url.Values{"sort": {"number"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = list(url.Values{"limit": {"1000"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = list(url.Values{"cursor": {"bogus"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// A cursor only works with the sort order it was made for.
+	_, page = list(url.Values{"sort": {"amount"}, "limit": {"2"}})
+	code, _ = list(url.Values{"sort": {"-amount"}, "cursor": {*page.NextCursor}})
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestListPaymentsRefundsAndSubscriptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.GET("/org/:id/payments", ListPayments)
+	r.GET("/org/:id/refunds", ListRefunds)
+	r.GET("/org/:id/subscriptions", ListSubscriptions)
+
+	other := models.Organization{Name: "Other Org"}
+	database.DB.Create(&other)
+	now := time.Now()
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 40, Currency: "USD", IssueDate: now, DueDate: now}
+	otherInvoice := models.Invoice{OrganizationID: other.ID, Amount: 40, Currency: "USD", IssueDate: now, DueDate: now}
+	database.DB.Create(&invoice)
+	database.DB.Create(&otherInvoice)
+	database.DB.Create(&models.Payment{InvoiceID: invoice.ID, UserID: user.ID, Amount: 40, Currency: "USD", PaymentDate: now, TransactionID: "txn_1", ProviderStatus: "succeeded"})
+	database.DB.Create(&models.Payment{InvoiceID: invoice.ID, UserID: user.ID, Amount: 40, Currency: "USD", PaymentDate: now, TransactionID: "txn_2", ProviderStatus: "declined"})
+	database.DB.Create(&models.Payment{InvoiceID: otherInvoice.ID, Amount: 40, Currency: "USD", PaymentDate: now, TransactionID: "txn_3", ProviderStatus: "succeeded"})
+	database.DB.Create(&models.Refund{InvoiceID: invoice.ID, Amount: 5, Currency: "USD", RefundDate: now, TransactionID: "ref_1"})
+	database.DB.Create(&models.Refund{InvoiceID: otherInvoice.ID, Amount: 5, Currency: "USD", RefundDate: now, TransactionID: "ref_2"})
+
+	basic := models.SubscriptionPlan{Name: "Basic", Price: 10, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	pro := models.SubscriptionPlan{Name: "Pro", Price: 49, Currency: "EUR", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&basic)
+	database.DB.Create(&pro)
+	database.DB.Create(&models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: basic.ID, StartDate: now, IsActive: true})
+	database.DB.Create(&models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: pro.ID, StartDate: now, IsActive: true, PausedAt: &now})
+	cancelled := models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: pro.ID, StartDate: now, IsActive: true}
+	database.DB.Create(&cancelled)
+	database.DB.Model(&cancelled).Update("is_active", false)
+
+	count := func(path string) int {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/org/%d/%s&caller_user_id=%d&caller_organization_id=%d", org.ID, path, user.ID, org.ID), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		var page struct {
+			Data []json.RawMessage `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &page)
+		return len(page.Data)
+	}
+
+	assert.Equal(t, 2, count("payments?sort=-payment_date"))
+	assert.Equal(t, 1, count("payments?status=declined"))
+	assert.Equal(t, 2, count(fmt.Sprintf("payments?user_id=%d", user.ID)))
+	assert.Equal(t, 1, count("refunds?max_amount=5"))
+	assert.Equal(t, 3, count("subscriptions?sort=start_date"))
+	assert.Equal(t, 1, count("subscriptions?status=active"))
+	assert.Equal(t, 1, count("subscriptions?status=paused"))
+	assert.Equal(t, 1, count("subscriptions?status=cancelled"))
+	assert.Equal(t, 2, count(fmt.Sprintf("subscriptions?plan_id=%d", pro.ID)))
+	assert.Equal(t, 2, count("subscriptions?currency=EUR&min_amount=20"))
+	assert.Equal(t, 0, count("subscriptions?currency=USD&min_amount=20"))
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"invoxa/database"
 	"invoxa/models"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -41,3 +43,26 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminToken is the bearer token operators send to reach the /admin routes,
+// which act across every organization. They are refused while it is unset.
+var AdminToken string
+
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if AdminToken == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin API is not configured"})
+			c.Abort()
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized: admin token required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
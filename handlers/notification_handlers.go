@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/notifications"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetNotificationSettings lists the emails sent to an organization's billing
+// contact and the ones it has opted out of.
+func GetNotificationSettings(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var org models.Organization
+	if err := database.DB.First(&org, orgID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve organization"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"billing_email": org.BillingEmail,
+		"types":         notifications.Types,
+		"opt_outs":      notifications.OptOuts(&org),
+	})
+}
+
+type UpdateNotificationSettingsRequest struct {
+	OptOuts []string `json:"opt_outs"` // notification types not to send, e.g., ["renewal_upcoming"]
+}
+
+func UpdateNotificationSettings(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateNotificationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := notifications.ValidTypes(req.OptOuts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := database.DB.Model(&models.Organization{}).Where("id = ?", orgID).
+		Update("notification_opt_outs", strings.Join(req.OptOuts, ",")).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"opt_outs": req.OptOuts})
+}
+
+// GetSentMessages returns the most recent emails sent to the organization.
+func GetSentMessages(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("organization_id = ?", orgID)
+	if notificationType := c.Query("type"); notificationType != "" {
+		query = query.Where("type = ?", notificationType)
+	}
+
+	var messages []models.SentMessage
+	if err := query.Order("id desc").Limit(100).Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sent messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationSettings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.GET("/org/:id/notifications", GetNotificationSettings)
+	r.PUT("/org/:id/notifications", UpdateNotificationSettings)
+	r.GET("/org/:id/sent_messages", GetSentMessages)
+
+	path := fmt.Sprintf("/org/%d/notifications?caller_user_id=%d&caller_organization_id=%d", org.ID, user.ID, org.ID)
+
+	jsonValue, _ := json.Marshal(UpdateNotificationSettingsRequest{OptOuts: []string{"newsletter"}})
+	req, _ := http.NewRequest("PUT", path, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	jsonValue, _ = json.Marshal(UpdateNotificationSettingsRequest{OptOuts: []string{"renewal_upcoming", "refund_issued"}})
+	req, _ = http.NewRequest("PUT", path, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", path, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var settings struct {
+		Types   []string `json:"types"`
+		OptOuts []string `json:"opt_outs"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &settings)
+	assert.Contains(t, settings.Types, "invoice_created")
+	assert.Equal(t, []string{"renewal_upcoming", "refund_issued"}, settings.OptOuts)
+
+	database.DB.Create(&models.SentMessage{OrganizationID: org.ID, Type: "invoice_created", DedupKey: "evt_1", Recipient: org.BillingEmail, Subject: "Invoice INV-2026-000001", SentAt: time.Now()})
+	database.DB.Create(&models.SentMessage{OrganizationID: org.ID, Type: "payment_succeeded", DedupKey: "evt_2", Recipient: org.BillingEmail, Subject: "Payment received", SentAt: time.Now()})
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/sent_messages?type=invoice_created&caller_user_id=%d&caller_organization_id=%d", org.ID, user.ID, org.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var messages []models.SentMessage
+	json.Unmarshal(w.Body.Bytes(), &messages)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "Invoice INV-2026-000001", messages[0].Subject)
+}
@@ -3,9 +3,12 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"time"
 
+	"invoxa/billing"
 	"invoxa/database"
 	"invoxa/models"
+	"invoxa/reports"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -46,13 +49,20 @@ func CreateOrganization(c *gin.Context) {
 }
 
 type OrgSummaryResponse struct {
-	OrganizationName string           `json:"organization_name"`
-	BillingEmail     string           `json:"billing_email"`
-	TotalUsers       int64            `json:"total_users"`
-	TotalInvoices    int64            `json:"total_invoices"`
-	TotalRevenue     float64          `json:"total_revenue"`
-	LatestInvoices   []models.Invoice `json:"latest_invoices"`
-	RecentPayments   []models.Payment `json:"recent_payments"`
+	OrganizationName  string             `json:"organization_name"`
+	BillingEmail      string             `json:"billing_email"`
+	TotalUsers        int64              `json:"total_users"`
+	TotalInvoices     int64              `json:"total_invoices"`
+	ReportingCurrency string             `json:"reporting_currency"`
+	TotalRevenue      float64            `json:"total_revenue"`       // paid, before tax, in the reporting currency
+	RevenueByCurrency map[string]float64 `json:"revenue_by_currency"` // in each invoice currency
+	// MissingExchangeRates lists currencies whose invoices could not be
+	// converted and are left out of TotalRevenue.
+	MissingExchangeRates []string             `json:"missing_exchange_rates,omitempty"`
+	LatestInvoices       []models.Invoice     `json:"latest_invoices"`
+	RecentPayments       []models.Payment     `json:"recent_payments"`
+	CreditBalances       map[string]float64   `json:"credit_balances"`
+	RecentCredits        []models.CreditEntry `json:"recent_credits"`
 }
 
 func GetOrgSummary(c *gin.Context) {
@@ -83,12 +93,15 @@ func GetOrgSummary(c *gin.Context) {
 	var totalUsers int64
 	database.DB.Model(&models.User{}).Where("organization_id = ?", orgID).Count(&totalUsers)
 
-	var invoices []models.Invoice
-	database.DB.Where("organization_id = ?", orgID).Find(&invoices)
+	var totalInvoices int64
+	database.DB.Model(&models.Invoice{}).Where("organization_id = ?", orgID).Count(&totalInvoices)
 
-	var totalRevenue float64
-	for _, invoice := range invoices {
-		totalRevenue += invoice.Amount
+	// Revenue counts paid invoices before tax and net of refunds, converted
+	// to the reporting currency at the rate on their issue date.
+	revenue, err := reports.OrganizationRevenue(database.DB, uint(orgID), organization.ReportingCurrency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate revenue"})
+		return
 	}
 
 	var latestInvoices []models.Invoice
@@ -97,14 +110,28 @@ func GetOrgSummary(c *gin.Context) {
 	var recentPayments []models.Payment
 	database.DB.Joins("JOIN invoices ON payments.invoice_id = invoices.id").Where("invoices.organization_id = ?", orgID).Order("payments.payment_date desc").Limit(5).Find(&recentPayments)
 
+	creditBalances, err := billing.AvailableCredit(database.DB, uint(orgID), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate credit balance"})
+		return
+	}
+
+	var recentCredits []models.CreditEntry
+	database.DB.Where("organization_id = ?", orgID).Order("created_at desc, id desc").Limit(5).Find(&recentCredits)
+
 	response := OrgSummaryResponse{
-		OrganizationName: organization.Name,
-		BillingEmail:     organization.BillingEmail,
-		TotalUsers:       totalUsers,
-		TotalInvoices:    int64(len(invoices)),
-		TotalRevenue:     totalRevenue,
-		LatestInvoices:   latestInvoices,
-		RecentPayments:   recentPayments,
+		OrganizationName:     organization.Name,
+		BillingEmail:         organization.BillingEmail,
+		TotalUsers:           totalUsers,
+		TotalInvoices:        totalInvoices,
+		ReportingCurrency:    organization.ReportingCurrency,
+		TotalRevenue:         revenue.Total,
+		RevenueByCurrency:    revenue.ByCurrency,
+		MissingExchangeRates: revenue.MissingExchangeRates,
+		LatestInvoices:       latestInvoices,
+		RecentPayments:       recentPayments,
+		CreditBalances:       creditBalances,
+		RecentCredits:        recentCredits,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 25
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// sortKey is a column a list can be sorted by. Value reads the column from a
+// row so that cursors can point just past it.
+type sortKey[T any] struct {
+	Column string
+	Value  func(*T) any // time.Time, float64 or string
+}
+
+// listSpec describes how a list endpoint sorts and pages its rows.
+type listSpec[T any] struct {
+	Name        string // plural, for error messages
+	Sorts       map[string]sortKey[T]
+	DefaultSort string // e.g., "-created_at"; a leading '-' sorts descending
+	ID          func(*T) uint
+}
+
+// pageCursor is the position after (or, for Before, in front of) a row in a
+// list sorted by Sort. Rows with equal sort values are ordered by ID, so the
+// position is exact.
+type pageCursor struct {
+	Sort   string `json:"s"`
+	Kind   string `json:"k"`
+	Value  string `json:"v"`
+	ID     uint   `json:"id"`
+	Before bool   `json:"b,omitempty"`
+}
+
+func encodeCursor(sort string, value any, id uint, before bool) string {
+	cursor := pageCursor{Sort: sort, ID: id, Before: before}
+	switch v := value.(type) {
+	case time.Time:
+		cursor.Kind, cursor.Value = "t", v.Format(time.RFC3339Nano)
+	case float64:
+		cursor.Kind, cursor.Value = "f", strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		cursor.Kind, cursor.Value = "s", fmt.Sprint(v)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, nil, errInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, nil, errInvalidCursor
+	}
+	switch cursor.Kind {
+	case "t":
+		value, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, nil, errInvalidCursor
+		}
+		return &cursor, value, nil
+	case "f":
+		value, err := strconv.ParseFloat(cursor.Value, 64)
+		if err != nil {
+			return nil, nil, errInvalidCursor
+		}
+		return &cursor, value, nil
+	case "s":
+		return &cursor, cursor.Value, nil
+	}
+	return nil, nil, errInvalidCursor
+}
+
+// respondWithPage runs a list query one page at a time and writes the page in
+// the envelope shared by all list endpoints:
+//
+//	{"data": [...], "has_more": true, "next_cursor": "...", "prev_cursor": "..."}
+//
+// The page is chosen with ?limit= (25 by default, at most 100), ?sort= (a
+// field of spec.Sorts, prefixed with '-' for descending order) and ?cursor=,
+// which takes a next_cursor or prev_cursor from an earlier page. A cursor
+// only works with the sort order it was made for.
+func respondWithPage[T any](c *gin.Context, query *gorm.DB, spec listSpec[T]) {
+	limit := defaultPageSize
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return
+		}
+		limit = n
+	}
+
+	sortParam := c.DefaultQuery("sort", spec.DefaultSort)
+	key, ok := spec.Sorts[strings.TrimPrefix(sortParam, "-")]
+	if !ok {
+		fields := make([]string, 0, len(spec.Sorts))
+		for field := range spec.Sorts {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of " + strings.Join(fields, ", ") + ", optionally prefixed with '-'"})
+		return
+	}
+	desc := strings.HasPrefix(sortParam, "-")
+
+	var cursor *pageCursor
+	if s := c.Query("cursor"); s != "" {
+		var value any
+		var err error
+		cursor, value, err = decodeCursor(s)
+		if err != nil || cursor.Sort != sortParam {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		// Going back walks the list in reverse from the cursor.
+		op := ">"
+		if desc != cursor.Before {
+			op = "<"
+		}
+		query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", key.Column, op, key.Column, op), value, value, cursor.ID)
+	}
+
+	backwards := cursor != nil && cursor.Before
+	direction := "ASC"
+	if desc != backwards {
+		direction = "DESC"
+	}
+
+	var rows []T
+	if err := query.Order(key.Column + " " + direction).Order("id " + direction).Limit(limit + 1).Find(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve " + spec.Name})
+		return
+	}
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	if backwards {
+		slices.Reverse(rows)
+	}
+
+	var next, prev *string
+	if len(rows) > 0 {
+		first, last := &rows[0], &rows[len(rows)-1]
+		if more || backwards {
+			s := encodeCursor(sortParam, key.Value(last), spec.ID(last), false)
+			next = &s
+		}
+		if (backwards && more) || (!backwards && cursor != nil) {
+			s := encodeCursor(sortParam, key.Value(first), spec.ID(first), true)
+			prev = &s
+		}
+	}
+	if rows == nil {
+		rows = []T{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": rows, "has_more": next != nil, "next_cursor": next, "prev_cursor": prev})
+}
+
+// listFilters narrows a list query from query string parameters, keeping the
+// first malformed parameter as its error.
+type listFilters struct {
+	c     *gin.Context
+	query *gorm.DB
+	err   error
+}
+
+func (f *listFilters) where(query any, args ...any) {
+	f.query = f.query.Where(query, args...)
+}
+
+// id returns the ID given in a parameter, or false if it is absent.
+func (f *listFilters) id(param string) (uint, bool) {
+	s := f.c.Query(param)
+	if s == "" || f.err != nil {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		f.err = fmt.Errorf("invalid %s", param)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// equalID filters by an ID column.
+func (f *listFilters) equalID(param, column string) {
+	if id, ok := f.id(param); ok {
+		f.where(column+" = ?", id)
+	}
+}
+
+// currency filters by a currency column.
+func (f *listFilters) currency(column string) {
+	if currency := f.c.Query("currency"); currency != "" {
+		f.where(column+" = ?", strings.ToUpper(currency))
+	}
+}
+
+// dates keeps rows with column between ?from= and ?to= (YYYY-MM-DD, both
+// inclusive).
+func (f *listFilters) dates(column string) {
+	for _, param := range []string{"from", "to"} {
+		s := f.c.Query(param)
+		if s == "" || f.err != nil {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			f.err = fmt.Errorf("invalid %s; expected YYYY-MM-DD", param)
+			return
+		}
+		if param == "from" {
+			f.where(column+" >= ?", day)
+		} else {
+			f.where(column+" < ?", day.AddDate(0, 0, 1))
+		}
+	}
+}
+
+// amounts keeps rows with column between ?min_amount= and ?max_amount=.
+func (f *listFilters) amounts(column string) {
+	for _, param := range []string{"min_amount", "max_amount"} {
+		s := f.c.Query(param)
+		if s == "" || f.err != nil {
+			continue
+		}
+		amount, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			f.err = fmt.Errorf("invalid %s", param)
+			return
+		}
+		if param == "min_amount" {
+			f.where(column+" >= ?", amount)
+		} else {
+			f.where(column+" <= ?", amount)
+		}
+	}
+}
+
+// status maps ?status= to a condition. Unknown statuses are an error.
+func (f *listFilters) status(conditions map[string]func()) {
+	status := f.c.Query("status")
+	if status == "" || f.err != nil {
+		return
+	}
+	apply, ok := conditions[status]
+	if !ok {
+		statuses := make([]string, 0, len(conditions))
+		for s := range conditions {
+			statuses = append(statuses, s)
+		}
+		sort.Strings(statuses)
+		f.err = fmt.Errorf("status must be one of %s", strings.Join(statuses, ", "))
+		return
+	}
+	apply()
+}
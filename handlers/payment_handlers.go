@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PaymentProvider is the gateway used to move money. It defaults to the
+// in-process fake so that development setups work without credentials.
+var PaymentProvider payments.Provider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+// respondProviderError maps payment provider failures onto HTTP responses.
+func respondProviderError(c *gin.Context, err error) {
+	var decline *payments.DeclineError
+	switch {
+	case errors.As(err, &decline):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Payment declined", "decline_code": decline.Code, "decline_message": decline.Message})
+	case errors.Is(err, payments.ErrDeclined):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Payment declined"})
+	case errors.Is(err, payments.ErrTimeout):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Payment provider timed out"})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment provider error: " + err.Error()})
+	}
+}
+
+// GetPayment returns a payment after refreshing its status from the provider.
+func GetPayment(c *gin.Context) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	callerOrganizationID := c.GetUint64("callerOrganizationID")
+
+	var payment models.Payment
+	if err := database.DB.Preload("Invoice").First(&payment, paymentID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payment"})
+		return
+	}
+
+	if payment.Invoice.OrganizationID != uint(callerOrganizationID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized: Payment does not belong to the caller's organization"})
+		return
+	}
+
+	if payment.ProviderChargeID != "" && payment.Provider == PaymentProvider.Name() {
+		status, err := PaymentProvider.ChargeStatus(c.Request.Context(), payment.ProviderChargeID)
+		if err != nil {
+			respondProviderError(c, err)
+			return
+		}
+		if status != payment.ProviderStatus {
+			payment.ProviderStatus = status
+			if err := database.DB.Model(&payment).Update("provider_status", status).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment status"})
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
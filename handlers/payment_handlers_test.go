@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayInvoiceChargesProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/pay_invoice", PayInvoice)
+	r.POST("/refund", Refund)
+	r.GET("/payment/:id", GetPayment)
+
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 20, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	database.DB.Create(&invoice)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+
+	// A declined card leaves the invoice unpaid.
+	jsonValue, _ := json.Marshal(PayInvoiceRequest{InvoiceID: invoice.ID, UserID: user.ID, Amount: 20, Currency: "USD", PaymentMethod: payments.TokenDeclinePrefix})
+	req, _ := http.NewRequest("POST", "/pay_invoice?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+
+	database.DB.First(&invoice, invoice.ID)
+	assert.False(t, invoice.Paid)
+
+	jsonValue, _ = json.Marshal(PayInvoiceRequest{InvoiceID: invoice.ID, UserID: user.ID, Amount: 20, Currency: "USD", PaymentMethod: "tok_visa"})
+	req, _ = http.NewRequest("POST", "/pay_invoice?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var payment models.Payment
+	database.DB.First(&payment)
+	assert.Equal(t, "fake", payment.Provider)
+	assert.NotEmpty(t, payment.ProviderChargeID)
+	assert.Equal(t, payment.ProviderChargeID, payment.TransactionID)
+
+	var organization models.Organization
+	database.DB.First(&organization, org.ID)
+	assert.NotEmpty(t, organization.ProviderCustomerID)
+
+	jsonValue, _ = json.Marshal(RefundRequest{InvoiceID: invoice.ID, PaymentID: payment.ID, UserID: user.ID, Amount: 20, Currency: "USD", Reason: "Customer request"})
+	req, _ = http.NewRequest("POST", "/refund?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var refund models.Refund
+	database.DB.First(&refund)
+	assert.NotEmpty(t, refund.ProviderRefundID)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/payment/%d?%s", payment.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var fetched models.Payment
+	err := json.Unmarshal(w.Body.Bytes(), &fetched)
+	assert.NoError(t, err)
+	assert.Equal(t, payments.StatusRefunded, fetched.ProviderStatus)
+}
+
+func TestRefundNotRecordedReturnsProviderRefund(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/pay_invoice", PayInvoice)
+	r.POST("/refund", Refund)
+
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 20, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	database.DB.Create(&invoice)
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		jsonValue, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", path+"?"+query, bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, post("/pay_invoice", PayInvoiceRequest{InvoiceID: invoice.ID, UserID: user.ID, Amount: 20, Currency: "USD", PaymentMethod: "tok_visa"}).Code)
+	var payment models.Payment
+	database.DB.First(&payment)
+
+	// The refund goes through at the provider, but cannot be written.
+	assert.NoError(t, database.DB.Migrator().DropColumn(&models.Refund{}, "reason"))
+	w := post("/refund", RefundRequest{InvoiceID: invoice.ID, PaymentID: payment.ID, UserID: user.ID, Amount: 5, Currency: "USD", Reason: "Customer request"})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var response map[string]string
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NotEmpty(t, response["provider_refund_id"])
+}
+
+func TestRefundsCannotExceedThePayment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/pay_invoice", PayInvoice)
+	r.POST("/refund", Refund)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		jsonValue, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", path+"?"+query, bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A payment made outside the provider, which cannot catch over-refunds.
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 20, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now(), Paid: true}
+	database.DB.Create(&invoice)
+	payment := models.Payment{InvoiceID: invoice.ID, Amount: 20, Currency: "USD", PaymentDate: time.Now(), TransactionID: "bank_1"}
+	database.DB.Create(&payment)
+
+	refund := RefundRequest{InvoiceID: invoice.ID, PaymentID: payment.ID, UserID: user.ID, Amount: 15, Currency: "USD", TransactionID: "ref_1", Reason: "Customer request"}
+	assert.Equal(t, http.StatusCreated, post("/refund", refund).Code)
+	refund.TransactionID = "ref_2"
+	w := post("/refund", refund)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "5.00 USD")
+	refund.Amount = 5
+	assert.Equal(t, http.StatusCreated, post("/refund", refund).Code)
+
+	// A payment made through the provider is checked before it is asked.
+	provided := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 30, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	database.DB.Create(&provided)
+	assert.Equal(t, http.StatusOK, post("/pay_invoice", PayInvoiceRequest{InvoiceID: provided.ID, UserID: user.ID, Amount: 30, Currency: "USD", PaymentMethod: "tok_visa"}).Code)
+	var charged models.Payment
+	database.DB.Where("invoice_id = ?", provided.ID).First(&charged)
+	refund = RefundRequest{InvoiceID: provided.ID, PaymentID: charged.ID, UserID: user.ID, Amount: 30, Currency: "USD", Reason: "Customer request"}
+	assert.Equal(t, http.StatusCreated, post("/refund", refund).Code)
+	refund.Amount = 1
+	assert.Equal(t, http.StatusBadRequest, post("/refund", refund).Code)
+}
+
+func TestPayInvoiceReportsInternalFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/pay_invoice", PayInvoice)
+
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 20, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	database.DB.Create(&invoice)
+
+	// Failing to load the organization is not the provider's fault.
+	database.DB.Delete(&models.Organization{}, org.ID)
+	jsonValue, _ := json.Marshal(PayInvoiceRequest{InvoiceID: invoice.ID, UserID: user.ID, Amount: 20, Currency: "USD", PaymentMethod: "tok_visa"})
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/pay_invoice?caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "Payment provider error")
+}
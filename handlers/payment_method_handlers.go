@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// callerOrganization parses the :id route parameter and checks that it is the
+// caller's organization, writing the error response when it is not.
+func callerOrganization(c *gin.Context) (uint, bool) {
+	orgID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return 0, false
+	}
+
+	callerOrganizationID := c.GetUint64("callerOrganizationID")
+
+	if orgID != callerOrganizationID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized: Caller organization ID does not match target organization ID"})
+		return 0, false
+	}
+	return uint(orgID), true
+}
+
+type AddPaymentMethodRequest struct {
+	Token      string `json:"token" binding:"required"` // tokenized payment method from the provider
+	SetDefault bool   `json:"set_default"`
+}
+
+func AddPaymentMethod(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req AddPaymentMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var organization models.Organization
+	if err := database.DB.First(&organization, orgID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
+	customerID, err := billing.EnsureProviderCustomer(c.Request.Context(), database.DB, PaymentProvider, &organization)
+	var providerErr *billing.ProviderError
+	if errors.As(err, &providerErr) {
+		respondProviderError(c, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to set up provider customer for organization %d: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add payment method"})
+		return
+	}
+
+	details, err := PaymentProvider.AttachPaymentMethod(c.Request.Context(), customerID, req.Token)
+	if err != nil {
+		respondProviderError(c, err)
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&models.PaymentMethod{}).Where("organization_id = ?", orgID).Count(&existing)
+
+	method := models.PaymentMethod{
+		OrganizationID: orgID,
+		Type:           details.Type,
+		Brand:          details.Brand,
+		Last4:          details.Last4,
+		ExpMonth:       details.ExpMonth,
+		ExpYear:        details.ExpYear,
+		ProviderToken:  details.Token,
+		IsDefault:      req.SetDefault || existing == 0, // the first method becomes the default
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if method.IsDefault {
+			if err := tx.Model(&models.PaymentMethod{}).Where("organization_id = ?", orgID).Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&method).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payment method"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, method)
+}
+
+func ListPaymentMethods(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var methods []models.PaymentMethod
+	if err := database.DB.Where("organization_id = ?", orgID).Order("is_default desc, id").Find(&methods).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payment methods"})
+		return
+	}
+
+	c.JSON(http.StatusOK, methods)
+}
+
+// findPaymentMethod loads the :method_id route parameter within the organization.
+func findPaymentMethod(c *gin.Context, orgID uint) (*models.PaymentMethod, bool) {
+	methodID, err := strconv.ParseUint(c.Param("method_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment method ID"})
+		return nil, false
+	}
+
+	var method models.PaymentMethod
+	if err := database.DB.Where("id = ? AND organization_id = ?", methodID, orgID).First(&method).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found for this organization"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payment method"})
+		return nil, false
+	}
+	return &method, true
+}
+
+func RemovePaymentMethod(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	method, ok := findPaymentMethod(c, orgID)
+	if !ok {
+		return
+	}
+
+	var organization models.Organization
+	if err := database.DB.First(&organization, orgID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+	if organization.ProviderCustomerID != "" {
+		if err := PaymentProvider.DetachPaymentMethod(c.Request.Context(), organization.ProviderCustomerID, method.ProviderToken); err != nil {
+			respondProviderError(c, err)
+			return
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(method).Error; err != nil {
+			return err
+		}
+		if !method.IsDefault {
+			return nil
+		}
+
+		// Promote the most recently added remaining method to default.
+		var next models.PaymentMethod
+		err := tx.Where("organization_id = ?", orgID).Order("id desc").First(&next).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&next).Update("is_default", true).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove payment method"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment method removed successfully"})
+}
+
+func SetDefaultPaymentMethod(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	method, ok := findPaymentMethod(c, orgID)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PaymentMethod{}).Where("organization_id = ?", orgID).Update("is_default", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(method).Update("is_default", true).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set default payment method"})
+		return
+	}
+
+	c.JSON(http.StatusOK, method)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentMethodLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.GET("/org/:id/payment_methods", ListPaymentMethods)
+	r.POST("/org/:id/payment_methods", AddPaymentMethod)
+	r.DELETE("/org/:id/payment_methods/:method_id", RemovePaymentMethod)
+	r.POST("/org/:id/payment_methods/:method_id/default", SetDefaultPaymentMethod)
+	r.POST("/pay_invoice", PayInvoice)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+	addMethod := func(token string) models.PaymentMethod {
+		jsonValue, _ := json.Marshal(AddPaymentMethodRequest{Token: token})
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/org/%d/payment_methods?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var method models.PaymentMethod
+		json.Unmarshal(w.Body.Bytes(), &method)
+		return method
+	}
+
+	visa := addMethod("tok_visa_4242")
+	mastercard := addMethod("tok_mastercard_4444")
+	assert.True(t, visa.IsDefault)
+	assert.False(t, mastercard.IsDefault)
+	assert.Equal(t, "4444", mastercard.Last4)
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/org/%d/payment_methods/%d/default?%s", org.ID, mastercard.ID, query), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Paying without naming a method charges the default.
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 15, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	database.DB.Create(&invoice)
+	jsonValue, _ := json.Marshal(PayInvoiceRequest{InvoiceID: invoice.ID, UserID: user.ID, Amount: 15, Currency: "USD"})
+	req, _ = http.NewRequest("POST", "/pay_invoice?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var payment models.Payment
+	database.DB.First(&payment)
+	assert.Equal(t, mastercard.ID, *payment.PaymentMethodID)
+
+	// Removing the default promotes the remaining method.
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/org/%d/payment_methods/%d?%s", org.ID, mastercard.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/payment_methods?%s", org.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var methods []models.PaymentMethod
+	err := json.Unmarshal(w.Body.Bytes(), &methods)
+	assert.NoError(t, err)
+	assert.Len(t, methods, 1)
+	assert.Equal(t, visa.ID, methods[0].ID)
+	assert.True(t, methods[0].IsDefault)
+}
+
+func TestAddPaymentMethodReportsInternalFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/org/:id/payment_methods", AddPaymentMethod)
+
+	// The provider creates the customer, but storing its ID fails.
+	assert.NoError(t, database.DB.Migrator().DropColumn(&models.Organization{}, "provider_customer_id"))
+
+	jsonValue, _ := json.Marshal(AddPaymentMethodRequest{Token: "tok_visa_4242"})
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/org/%d/payment_methods?caller_user_id=%d&caller_organization_id=%d", org.ID, user.ID, org.ID), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
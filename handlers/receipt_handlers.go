@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"invoxa/database"
+	"invoxa/documents"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// callerReceipt loads the receipt of a payment made by the caller's
+// organization, writing the error response if it cannot.
+func callerReceipt(c *gin.Context) (*models.Receipt, bool) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return nil, false
+	}
+
+	var receipt models.Receipt
+	if err := database.DB.Where("payment_id = ?", paymentID).First(&receipt).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve receipt"})
+		return nil, false
+	}
+
+	callerOrganizationID := c.GetUint64("callerOrganizationID")
+	if receipt.OrganizationID != uint(callerOrganizationID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized: Payment does not belong to the caller's organization"})
+		return nil, false
+	}
+	return &receipt, true
+}
+
+// callerReceiptDocument loads the receipt of a payment as a document.
+func callerReceiptDocument(c *gin.Context) (*documents.ReceiptDocument, uint, bool) {
+	receipt, ok := callerReceipt(c)
+	if !ok {
+		return nil, 0, false
+	}
+	doc, err := documents.LoadReceipt(database.DB, receipt.PaymentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load receipt"})
+		return nil, 0, false
+	}
+	return doc, receipt.OrganizationID, true
+}
+
+// GetReceipt returns the receipt issued for a payment.
+func GetReceipt(c *gin.Context) {
+	receipt, ok := callerReceipt(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// GetReceiptPDF renders a payment's receipt as a PDF download.
+func GetReceiptPDF(c *gin.Context) {
+	doc, _, ok := callerReceiptDocument(c)
+	if !ok {
+		return
+	}
+
+	var pdf bytes.Buffer
+	if err := documents.RenderReceiptPDF(&pdf, doc); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render receipt"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "receipt-"+doc.Number+".pdf"))
+	c.Data(http.StatusOK, "application/pdf", pdf.Bytes())
+}
+
+// GetReceiptPage renders a payment's receipt with the organization's HTML
+// template, or its plain-text template with ?format=text.
+func GetReceiptPage(c *gin.Context) {
+	format := c.DefaultQuery("format", documents.FormatHTML)
+	if format != documents.FormatHTML && format != documents.FormatText {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be html or text"})
+		return
+	}
+	doc, orgID, ok := callerReceiptDocument(c)
+	if !ok {
+		return
+	}
+
+	rendered, err := documents.Render(database.DB, orgID, documents.KindReceipt, format, doc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render receipt"})
+		return
+	}
+	renderedDocument(c, format, rendered)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentReceipts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/pay_invoice", PayInvoice)
+	r.GET("/payment/:id/receipt", GetReceipt)
+	r.GET("/payment/:id/receipt/pdf", GetReceiptPDF)
+	r.GET("/payment/:id/receipt/html", GetReceiptPage)
+
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 20, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	database.DB.Create(&invoice)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+	jsonValue, _ := json.Marshal(PayInvoiceRequest{InvoiceID: invoice.ID, UserID: user.ID, Amount: 20, Currency: "USD", PaymentMethod: "tok_visa"})
+	req, _ := http.NewRequest("POST", "/pay_invoice?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var paid struct {
+		PaymentID     uint   `json:"payment_id"`
+		ReceiptNumber string `json:"receipt_number"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &paid)
+	number := "RCPT-" + time.Now().Format("2006") + "-000001"
+	assert.Equal(t, number, paid.ReceiptNumber)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/payment/%d/receipt?%s", paid.PaymentID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var receipt models.Receipt
+	json.Unmarshal(w.Body.Bytes(), &receipt)
+	assert.Equal(t, number, receipt.Number)
+	assert.Equal(t, user.Username, receipt.Payer)
+	assert.Equal(t, 20.0, receipt.Amount)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/payment/%d/receipt/pdf?%s", paid.PaymentID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")))
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/payment/%d/receipt/html?format=text&%s", paid.PaymentID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "RECEIPT "+number), w.Body.String())
+
+	// Receipts of other organizations are not visible.
+	other := models.Organization{Name: "Other Org"}
+	database.DB.Create(&other)
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/payment/%d/receipt?caller_user_id=%d&caller_organization_id=%d", paid.PaymentID, user.ID, other.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/payment/%d/receipt?%s", paid.PaymentID+100, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
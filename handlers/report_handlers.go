@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/reports"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// reportCurrency is the currency reports are converted to, from ?currency=
+// (USD by default).
+func reportCurrency(c *gin.Context) string {
+	if currency := c.Query("currency"); currency != "" {
+		return strings.ToUpper(currency)
+	}
+	return "USD"
+}
+
+// reportMonths parses the ?from= and ?to= months (YYYY-MM) of a report. They
+// default to the twelve months up to and including the current one.
+func reportMonths(c *gin.Context, now time.Time) (time.Time, time.Time, bool) {
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -11, 0)
+	to := now
+	for param, month := range map[string]*time.Time{"from": &from, "to": &to} {
+		s := c.Query(param)
+		if s == "" {
+			continue
+		}
+		parsed, err := time.Parse("2006-01", s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a month formatted as YYYY-MM"})
+			return time.Time{}, time.Time{}, false
+		}
+		*month = parsed
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// reportDate parses the ?date= (YYYY-MM-DD) a report is run for, returning
+// the end of that day, or now.
+func reportDate(c *gin.Context) (time.Time, bool) {
+	s := c.Query("date")
+	if s == "" {
+		return time.Now(), true
+	}
+	date, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be formatted as YYYY-MM-DD"})
+		return time.Time{}, false
+	}
+	return date.AddDate(0, 0, 1).Add(-time.Nanosecond), true
+}
+
+// GetMRRReport returns the MRR and ARR of all subscriptions at the end of
+// ?date= (YYYY-MM-DD), or now.
+func GetMRRReport(c *gin.Context) {
+	at, ok := reportDate(c)
+	if !ok {
+		return
+	}
+
+	report, err := reports.MRR(database.DB, reportCurrency(c), at)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate MRR"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GetMRRMovements returns the new, expansion, contraction and churned MRR and
+// the churn rates of each month.
+func GetMRRMovements(c *gin.Context) {
+	now := time.Now()
+	from, to, ok := reportMonths(c, now)
+	if !ok {
+		return
+	}
+
+	report, err := reports.Movements(database.DB, reportCurrency(c), from, to, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate MRR movements"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GetCohortReport returns customer and revenue retention tables for the
+// customers who started subscribing in each month.
+func GetCohortReport(c *gin.Context) {
+	now := time.Now()
+	from, to, ok := reportMonths(c, now)
+	if !ok {
+		return
+	}
+
+	report, err := reports.Cohorts(database.DB, reportCurrency(c), from, to, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate cohorts"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// respondWithAging writes an aging report as JSON, or as CSV with
+// ?format=csv.
+func respondWithAging(c *gin.Context, organizationID uint) {
+	at, ok := reportDate(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	report, err := reports.Aging(database.DB, organizationID, at)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate receivables aging"})
+		return
+	}
+	if format == "json" {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	var out bytes.Buffer
+	if err := reports.WriteAgingCSV(&out, report); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write receivables aging"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "ar-aging-"+at.Format("2006-01-02")+".csv"))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", out.Bytes())
+}
+
+// GetARAging buckets the unpaid invoices of all organizations by days past
+// due.
+func GetARAging(c *gin.Context) {
+	respondWithAging(c, 0)
+}
+
+// GetOrgARAging buckets the caller's unpaid invoices by days past due.
+func GetOrgARAging(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+	respondWithAging(c, orgID)
+}
+
+// respondWithRecognition writes the recognized and deferred revenue of each
+// month from ?from= to ?to=.
+func respondWithRecognition(c *gin.Context, organizationID uint) {
+	from, to, ok := reportMonths(c, time.Now())
+	if !ok {
+		return
+	}
+
+	report, err := reports.Recognition(database.DB, organizationID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate revenue recognition"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GetRevenueRecognition reports the recognized and deferred revenue of all
+// organizations by month.
+func GetRevenueRecognition(c *gin.Context) {
+	respondWithRecognition(c, 0)
+}
+
+// GetOrgRevenueRecognition reports the recognized and deferred revenue of the
+// caller's invoices by month.
+func GetOrgRevenueRecognition(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+	respondWithRecognition(c, orgID)
+}
+
+// GetRevenueSchedule returns the revenue schedule of one of the caller's
+// invoices, with the lines recognizing it month by month.
+func GetRevenueSchedule(c *gin.Context) {
+	invoiceID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var schedule models.RevenueSchedule
+	err = database.DB.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("month, id") }).
+		Where("invoice_id = ?", invoiceID).First(&schedule).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revenue schedule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revenue schedule"})
+		return
+	}
+
+	callerOrganizationID := c.GetUint64("callerOrganizationID")
+	if schedule.OrganizationID != uint(callerOrganizationID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized: Invoice does not belong to the caller's organization"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/reports"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.GET("/admin/reports/mrr", GetMRRReport)
+	r.GET("/admin/reports/mrr_movements", GetMRRMovements)
+	r.GET("/admin/reports/cohorts", GetCohortReport)
+
+	start := time.Now().AddDate(0, -2, 0)
+	plan := models.SubscriptionPlan{Name: "Yearly", Price: 240, Currency: "EUR", Interval: "yearly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+	database.DB.Create(&models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID, StartDate: start, IsActive: true})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/admin/reports/mrr?currency=eur")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var mrr reports.MRRReport
+	json.Unmarshal(w.Body.Bytes(), &mrr)
+	assert.Equal(t, "EUR", mrr.Currency)
+	assert.Equal(t, 20.0, mrr.MRR)
+	assert.Equal(t, 240.0, mrr.ARR)
+
+	// Without a rate to USD the subscription is reported as missing.
+	w = get("/admin/reports/mrr")
+	json.Unmarshal(w.Body.Bytes(), &mrr)
+	assert.Equal(t, 0.0, mrr.MRR)
+	assert.Equal(t, []string{"EUR"}, mrr.MissingExchangeRates)
+
+	// The subscription started the day before its month ended.
+	w = get("/admin/reports/mrr?currency=EUR&date=" + start.AddDate(0, 0, -1).Format("2006-01-02"))
+	json.Unmarshal(w.Body.Bytes(), &mrr)
+	assert.Equal(t, 0.0, mrr.MRR)
+
+	w = get("/admin/reports/mrr_movements?currency=EUR")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var movements reports.MovementsReport
+	json.Unmarshal(w.Body.Bytes(), &movements)
+	assert.Len(t, movements.Months, 12)
+	assert.Equal(t, time.Now().Format("2006-01"), movements.Months[11].Month)
+	assert.Equal(t, 20.0, movements.Months[11].EndingMRR)
+
+	w = get("/admin/reports/cohorts?currency=EUR&from=" + start.Format("2006-01") + "&to=" + start.Format("2006-01"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var cohorts reports.CohortReport
+	json.Unmarshal(w.Body.Bytes(), &cohorts)
+	assert.Len(t, cohorts.Cohorts, 1)
+	assert.Equal(t, []int{1, 1, 1}, cohorts.Cohorts[0].Retained)
+
+	for _, path := range []string{
+		"/admin/reports/mrr?date=2026-13-01",
+		"/admin/reports/mrr_movements?from=2026-1",
+		"/admin/reports/cohorts?from=2026-05&to=2026-04",
+	} {
+		assert.Equal(t, http.StatusBadRequest, get(path).Code, path)
+	}
+}
+
+func TestARAgingEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.GET("/admin/reports/ar_aging", GetARAging)
+	r.GET("/org/:id/ar_aging", AuthMiddleware(), GetOrgARAging)
+
+	other := models.Organization{Name: "Other Org"}
+	database.DB.Create(&other)
+	due := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	database.DB.Create(&models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 45, Currency: "USD", IssueDate: due, DueDate: due})
+	database.DB.Create(&models.Invoice{OrganizationID: other.ID, Amount: 15, Currency: "USD", IssueDate: due, DueDate: due})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/admin/reports/ar_aging?date=2026-06-15")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report reports.AgingReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Len(t, report.Organizations, 2)
+	assert.Equal(t, reports.AgingBuckets{Days31To60: 60, Total: 60}, report.Totals["USD"])
+
+	w = get(fmt.Sprintf("/org/%d/ar_aging?format=csv&date=2026-05-20&caller_user_id=%d&caller_organization_id=%d", org.ID, user.ID, org.ID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "ar-aging-2026-05-20.csv")
+	assert.Equal(t, fmt.Sprintf(`organization_id,organization,user_id,customer,currency,invoices,current,days_1_30,days_31_60,days_61_90,over_90,total
+%d,%s,%d,%s,USD,1,0.00,45.00,0.00,0.00,0.00,45.00
+,Total,,,USD,,0.00,45.00,0.00,0.00,0.00,45.00
+`, org.ID, org.Name, user.ID, user.Username), w.Body.String())
+
+	w = get(fmt.Sprintf("/org/%d/ar_aging?caller_user_id=%d&caller_organization_id=%d", other.ID, user.ID, org.ID))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusBadRequest, get("/admin/reports/ar_aging?format=xlsx").Code)
+}
+
+func TestRevenueRecognitionAcrossPlanChange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/subscribe", Subscribe)
+	r.POST("/upgrade_plan", UpgradePlan)
+	r.GET("/invoice/:id/revenue_schedule", GetRevenueSchedule)
+	r.GET("/org/:id/revenue_recognition", GetOrgRevenueRecognition)
+
+	yearly := models.SubscriptionPlan{Name: "Yearly", Price: 1200, Currency: "USD", Interval: "yearly", OrganizationID: org.ID}
+	monthly := models.SubscriptionPlan{Name: "Monthly", Price: 10, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&yearly)
+	database.DB.Create(&monthly)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+	send := func(method, path string, body any) *httptest.ResponseRecorder {
+		jsonValue, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path+"?"+query, bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	schedule := func(invoiceID uint) (models.RevenueSchedule, float64) {
+		w := send("GET", fmt.Sprintf("/invoice/%d/revenue_schedule", invoiceID), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var schedule models.RevenueSchedule
+		json.Unmarshal(w.Body.Bytes(), &schedule)
+		var total float64
+		for _, line := range schedule.Lines {
+			total += line.Amount
+		}
+		return schedule, math.Round(total*100) / 100
+	}
+
+	w := send("POST", "/subscribe", SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: yearly.ID, UserID: user.ID})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var subscribed struct {
+		InvoiceID uint `json:"invoice_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &subscribed)
+
+	// The yearly invoice is recognized over twelve months or so.
+	before, total := schedule(subscribed.InvoiceID)
+	assert.Equal(t, 1200.0, total)
+	assert.GreaterOrEqual(t, len(before.Lines), 12)
+	assert.Nil(t, before.EndedAt)
+
+	// Moving to the monthly plan grants credit for the unused part of the
+	// month and recognizes the rest of the yearly invoice at once.
+	w = send("POST", "/upgrade_plan", UpgradePlanRequest{OrganizationID: org.ID, NewSubscriptionPlanID: monthly.ID, UserID: user.ID})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var upgraded struct {
+		CreditGranted float64 `json:"credit_granted"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &upgraded)
+	assert.Greater(t, upgraded.CreditGranted, 0.0)
+
+	after, total := schedule(subscribed.InvoiceID)
+	assert.NotNil(t, after.EndedAt)
+	assert.InDelta(t, 1200-upgraded.CreditGranted, total, 0.01)
+
+	w = send("GET", fmt.Sprintf("/org/%d/revenue_recognition", org.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report reports.RecognitionReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	current := report.Months[len(report.Months)-1]
+	assert.Equal(t, time.Now().Format("2006-01"), current.Month)
+	assert.Equal(t, 1200.0, current.Billed)
+	assert.InDelta(t, 1200-upgraded.CreditGranted, current.Recognized, 0.01)
+	assert.Equal(t, 0.0, current.DeferredEnd)
+
+	w = send("GET", "/invoice/999/revenue_schedule", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"invoxa/database"
+	"invoxa/search"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Search finds invoices, payments, refunds and users of all organizations
+// matching ?q=, newest first. It returns at most ?limit= hits (25 by
+// default, at most 100).
+func Search(c *gin.Context) {
+	limit := defaultPageSize
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return
+		}
+		limit = n
+	}
+
+	query := c.Query("q")
+	hits, err := search.Run(database.DB, query, time.Now(), limit)
+	if errors.Is(err, search.ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"query": query, "hits": hits})
+}
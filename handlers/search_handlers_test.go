@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/search"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "s3cret"
+
+	r := gin.Default()
+	r.GET("/admin/search", AdminMiddleware(), Search)
+
+	number := "INV-2026-000007"
+	database.DB.Create(&models.Invoice{OrganizationID: org.ID, Number: &number, Amount: 70, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()})
+
+	// Tenant credentials do not reach other organizations' records.
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/search?q=number:000007&caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotContains(t, w.Body.String(), number)
+
+	req, _ = http.NewRequest("GET", "/admin/search?q=number:000007+amount:>50", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Hits []search.Hit `json:"hits"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Len(t, response.Hits, 1)
+	assert.Equal(t, "invoice", response.Hits[0].Type)
+	assert.Equal(t, org.Name, response.Hits[0].Organization)
+
+	for _, path := range []string{"/admin/search?q=", "/admin/search?q=(unclosed", "/admin/search?q=acme&limit=0"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"testing"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupHandlerTestDB points database.DB at a fresh in-memory database holding
+// one organization and one of its users.
+func setupHandlerTestDB(t *testing.T) (*gorm.DB, *models.Organization, *models.User) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	database.DB = db
+
+	err = db.AutoMigrate(database.Models...)
+	assert.NoError(t, err)
+
+	org := models.Organization{Name: "Test Org", BillingEmail: "billing@test.org"}
+	err = db.Create(&org).Error
+	assert.NoError(t, err)
+
+	user := models.User{Username: "testuser", Email: "test@test.org", PasswordHash: "hash", OrganizationID: org.ID}
+	err = db.Create(&user).Error
+	assert.NoError(t, err)
+
+	return db, &org, &user
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UpdateBillingDetailsRequest struct {
+	TaxID        string `json:"tax_id"`
+	AddressLine1 string `json:"address_line1"`
+	AddressLine2 string `json:"address_line2"`
+	City         string `json:"city"`
+	Region       string `json:"region"`
+	PostalCode   string `json:"postal_code"`
+	Country      string `json:"country" binding:"required,len=2"` // ISO 3166-1 alpha-2 code
+	// ReportingCurrency is the currency summaries are converted to; empty
+	// leaves it unchanged.
+	ReportingCurrency string `json:"reporting_currency" binding:"omitempty,len=3"`
+}
+
+// UpdateBillingDetails sets the organization's billing address and tax ID,
+// which decide the tax charged on its future invoices.
+func UpdateBillingDetails(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateBillingDetailsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var organization models.Organization
+	if err := database.DB.First(&organization, orgID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
+	organization.TaxID = strings.ToUpper(strings.ReplaceAll(req.TaxID, " ", ""))
+	organization.AddressLine1 = req.AddressLine1
+	organization.AddressLine2 = req.AddressLine2
+	organization.City = req.City
+	organization.Region = req.Region
+	organization.PostalCode = req.PostalCode
+	organization.Country = strings.ToUpper(req.Country)
+	if req.ReportingCurrency != "" {
+		organization.ReportingCurrency = strings.ToUpper(req.ReportingCurrency)
+	}
+
+	if err := database.DB.Save(&organization).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update billing details"})
+		return
+	}
+
+	c.JSON(http.StatusOK, organization)
+}
+
+func ListTaxRates(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var rates []models.TaxRate
+	if err := database.DB.Where("organization_id = ?", orgID).Order("country, region, id").Find(&rates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tax rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+type CreateTaxRateRequest struct {
+	Name    string  `json:"name" binding:"required"`
+	Country string  `json:"country" binding:"required,len=2"`
+	Region  string  `json:"region"`                               // leave empty for a country-wide rate
+	Rate    float64 `json:"rate" binding:"required,gt=0,lte=100"` // percentage
+}
+
+func CreateTaxRate(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req CreateTaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rate := models.TaxRate{
+		OrganizationID: orgID,
+		Name:           req.Name,
+		Country:        strings.ToUpper(req.Country),
+		Region:         req.Region,
+		Rate:           req.Rate,
+	}
+	if err := database.DB.Create(&rate).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tax rate"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rate)
+}
+
+// DeleteTaxRate stops a rate applying to new invoices. Invoices already
+// issued keep their tax lines.
+func DeleteTaxRate(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	rateID, err := strconv.ParseUint(c.Param("rate_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rate ID"})
+		return
+	}
+
+	var rate models.TaxRate
+	if err := database.DB.Where("id = ? AND organization_id = ?", rateID, orgID).First(&rate).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tax rate not found for this organization"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tax rate"})
+		return
+	}
+
+	if err := database.DB.Delete(&rate).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tax rate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tax rate deleted successfully"})
+}
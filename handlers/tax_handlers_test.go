@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaxRatesAppliedToSubscriptionInvoice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.PUT("/org/:id/billing_details", UpdateBillingDetails)
+	r.GET("/org/:id/tax_rates", ListTaxRates)
+	r.POST("/org/:id/tax_rates", CreateTaxRate)
+	r.DELETE("/org/:id/tax_rates/:rate_id", DeleteTaxRate)
+	r.POST("/subscribe", Subscribe)
+	r.GET("/invoice/:id", GetInvoice)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+
+	jsonValue, _ := json.Marshal(UpdateBillingDetailsRequest{AddressLine1: "1 Main St", City: "Austin", Region: "TX", Country: "us"})
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/org/%d/billing_details?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var updated models.Organization
+	database.DB.First(&updated, org.ID)
+	assert.Equal(t, "US", updated.Country)
+
+	for _, rate := range []CreateTaxRateRequest{
+		{Name: "Texas sales tax", Country: "US", Region: "TX", Rate: 6.25},
+		{Name: "California sales tax", Country: "US", Region: "CA", Rate: 7.25},
+	} {
+		jsonValue, _ = json.Marshal(rate)
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/org/%d/tax_rates?%s", org.ID, query), bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/org/%d/tax_rates?%s", org.ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rates []models.TaxRate
+	json.Unmarshal(w.Body.Bytes(), &rates)
+	assert.Len(t, rates, 2)
+
+	plan := models.SubscriptionPlan{Name: "Basic Plan", Price: 40, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+
+	jsonValue, _ = json.Marshal(SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID})
+	req, _ = http.NewRequest("POST", "/subscribe?"+query, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		InvoiceID uint    `json:"invoice_id"`
+		Subtotal  float64 `json:"subtotal"`
+		TaxAmount float64 `json:"tax_amount"`
+		AmountDue float64 `json:"amount_due"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, 40.0, created.Subtotal)
+	assert.Equal(t, 2.5, created.TaxAmount)
+	assert.Equal(t, 42.5, created.AmountDue)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/invoice/%d?%s", created.InvoiceID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var invoice models.Invoice
+	json.Unmarshal(w.Body.Bytes(), &invoice)
+	if assert.Len(t, invoice.TaxLines, 1) {
+		assert.Equal(t, "Texas sales tax", invoice.TaxLines[0].Name)
+	}
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/org/%d/tax_rates/%d?%s", org.ID, rates[0].ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/org/%d/tax_rates/%d?%s", org.ID, rates[0].ID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
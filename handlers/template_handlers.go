@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"invoxa/database"
+	"invoxa/documents"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// htmlDocumentPolicy is sent with rendered HTML documents. Templates are
+// written by organizations, so their pages may not run scripts or load
+// anything but inline styles and embedded images.
+const htmlDocumentPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src data:"
+
+// renderedDocument writes a rendered template with the content type of its
+// format.
+func renderedDocument(c *gin.Context, format, body string) {
+	if format == documents.FormatHTML {
+		c.Header("Content-Security-Policy", htmlDocumentPolicy)
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(body))
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(body))
+}
+
+// ListDocumentTemplates returns the template in use for every kind of
+// document and format, marking those the organization has customized.
+func ListDocumentTemplates(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var stored []models.DocumentTemplate
+	if err := database.DB.Where("organization_id = ?", orgID).Find(&stored).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve templates"})
+		return
+	}
+	custom := make(map[string]models.DocumentTemplate, len(stored))
+	for _, tmpl := range stored {
+		custom[tmpl.Kind+"/"+tmpl.Format] = tmpl
+	}
+
+	templates := []gin.H{}
+	for _, kind := range documents.TemplateKinds {
+		for _, format := range documents.TemplateFormats {
+			entry := gin.H{"kind": kind, "format": format, "customized": false}
+			if tmpl, ok := custom[kind+"/"+format]; ok {
+				entry["customized"] = true
+				entry["body"] = tmpl.Body
+				entry["updated_at"] = tmpl.UpdatedAt
+			} else {
+				body, err := documents.DefaultTemplate(kind, format)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load default template"})
+					return
+				}
+				entry["body"] = body
+			}
+			templates = append(templates, entry)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+type DocumentTemplateRequest struct {
+	Body string `json:"body"`
+}
+
+// UpdateDocumentTemplate replaces the template an organization uses for one
+// kind of document and format. The template is checked against a sample
+// document before it is stored.
+func UpdateDocumentTemplate(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req DocumentTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A template body is required"})
+		return
+	}
+	kind, format := c.Param("kind"), c.Param("format")
+	if err := documents.ValidateTemplate(kind, format, req.Body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var tmpl models.DocumentTemplate
+	err := database.DB.Where("organization_id = ? AND kind = ? AND format = ?", orgID, kind, format).First(&tmpl).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve template"})
+		return
+	}
+	tmpl.OrganizationID = orgID
+	tmpl.Kind = kind
+	tmpl.Format = format
+	tmpl.Body = req.Body
+	if err := database.DB.Save(&tmpl).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template updated successfully", "kind": kind, "format": format})
+}
+
+// DeleteDocumentTemplate restores the default template for one kind of
+// document and format.
+func DeleteDocumentTemplate(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Unscoped().Where("organization_id = ? AND kind = ? AND format = ?", orgID, c.Param("kind"), c.Param("format")).
+		Delete(&models.DocumentTemplate{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not customized for this organization"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template reset to the default"})
+}
+
+// PreviewDocumentTemplate renders a sample document with the organization's
+// branding. With a body in the request the unsaved template is previewed,
+// otherwise the template currently in use.
+func PreviewDocumentTemplate(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req DocumentTemplateRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	kind, format := c.Param("kind"), c.Param("format")
+	if _, err := documents.DefaultTemplate(kind, format); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Body != "" {
+		if err := documents.ValidateTemplate(kind, format, req.Body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	branding, err := documents.LoadBranding(database.DB, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve branding"})
+		return
+	}
+	doc := documents.SampleDocument(kind)
+	switch doc := doc.(type) {
+	case *documents.InvoiceDocument:
+		doc.Branding = branding
+	case *documents.ReceiptDocument:
+		doc.Branding = branding
+	case *documents.CreditNoteDocument:
+		doc.Branding = branding
+	}
+
+	var rendered string
+	if req.Body != "" {
+		rendered, err = documents.RenderTemplate(kind, format, req.Body, doc)
+	} else {
+		rendered, err = documents.Render(database.DB, orgID, kind, format, doc)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render preview"})
+		return
+	}
+	renderedDocument(c, format, rendered)
+}
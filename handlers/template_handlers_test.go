@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentTemplates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.GET("/org/:id/templates", ListDocumentTemplates)
+	r.PUT("/org/:id/templates/:kind/:format", UpdateDocumentTemplate)
+	r.DELETE("/org/:id/templates/:kind/:format", DeleteDocumentTemplate)
+	r.POST("/org/:id/templates/:kind/:format/preview", PreviewDocumentTemplate)
+	r.POST("/subscribe", Subscribe)
+	r.GET("/invoice/:id/html", GetInvoicePage)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, _ := http.NewRequest(method, path+"?"+query, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	templatesPath := fmt.Sprintf("/org/%d/templates", org.ID)
+
+	w := send("GET", templatesPath, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		Templates []struct {
+			Kind       string `json:"kind"`
+			Format     string `json:"format"`
+			Customized bool   `json:"customized"`
+		} `json:"templates"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	assert.Len(t, listed.Templates, 6)
+
+	// Unsafe or broken templates are rejected on upload.
+	w = send("PUT", templatesPath+"/invoice/html", DocumentTemplateRequest{Body: "{{range 1000000000}}x{{end}}"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("PUT", templatesPath+"/invoice/html", DocumentTemplateRequest{Body: "{{.Customer.Nickname}}"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("PUT", templatesPath+"/statement/html", DocumentTemplateRequest{Body: "{{.Number}}"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// An unsaved template can be previewed against the sample invoice.
+	w = send("POST", templatesPath+"/invoice/text/preview", DocumentTemplateRequest{Body: "Due {{money .Currency .AmountDue}}"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Due GBP 60.00", w.Body.String())
+	w = send("POST", templatesPath+"/invoice/html/preview", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "default-src 'none'")
+
+	body := `<h1>{{.Customer.Name}} owes {{money .Currency .AmountDue}}</h1>`
+	w = send("PUT", templatesPath+"/invoice/html", DocumentTemplateRequest{Body: body})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send("PUT", templatesPath+"/invoice/html", DocumentTemplateRequest{Body: body})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var count int64
+	database.DB.Model(&models.DocumentTemplate{}).Where("organization_id = ?", org.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// The hosted invoice page uses the stored template and escapes data.
+	database.DB.Model(org).Update("name", "<b>Acme</b>")
+	plan := models.SubscriptionPlan{Name: "Basic Plan", Price: 30, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+	w = send("POST", "/subscribe", SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		InvoiceID uint `json:"invoice_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	w = send("GET", fmt.Sprintf("/invoice/%d/html", created.InvoiceID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<h1>&lt;b&gt;Acme&lt;/b&gt; owes USD 30.00</h1>", w.Body.String())
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/invoice/%d/html?format=text&%s", created.InvoiceID, query), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Basic Plan (monthly): USD 30.00")
+
+	w = send("DELETE", templatesPath+"/invoice/html", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send("DELETE", templatesPath+"/invoice/html", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = send("GET", fmt.Sprintf("/invoice/%d/html", created.InvoiceID), nil)
+	assert.Contains(t, w.Body.String(), "<!DOCTYPE html>")
+}
@@ -22,15 +22,7 @@ func setupUserTestDB(t *testing.T) (*gorm.DB, *models.Organization, *models.User
 	assert.NoError(t, err)
 	database.DB = db
 
-	err = db.AutoMigrate(
-		&models.User{},
-		&models.Organization{},
-		&models.SubscriptionPlan{},
-		&models.Subscription{},
-		&models.Invoice{},
-		&models.Payment{},
-		&models.Refund{},
-	)
+	err = db.AutoMigrate(database.Models...)
 	assert.NoError(t, err)
 
 	// Create a test organization
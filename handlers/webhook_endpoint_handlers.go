@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateWebhookEndpointRequest struct {
+	URL    string   `json:"url" binding:"required,url"`
+	Events []string `json:"events"` // event types to deliver; all when empty
+}
+
+func CreateWebhookEndpoint(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var req CreateWebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := webhooks.ValidateURL(c.Request.Context(), req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events := strings.Join(req.Events, ",")
+	if err := webhooks.ValidEventTypes(events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoint := models.WebhookEndpoint{
+		OrganizationID: orgID,
+		URL:            req.URL,
+		Secret:         webhooks.NewSecret(),
+		Events:         events,
+		Active:         true,
+	}
+	if err := database.DB.Create(&endpoint).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook endpoint"})
+		return
+	}
+
+	// The secret is only ever returned here; receivers need it to verify signatures.
+	c.JSON(http.StatusCreated, gin.H{"endpoint": endpoint, "secret": endpoint.Secret})
+}
+
+func ListWebhookEndpoints(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	var endpoints []models.WebhookEndpoint
+	if err := database.DB.Where("organization_id = ?", orgID).Order("id").Find(&endpoints).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook endpoints"})
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoints)
+}
+
+// findWebhookEndpoint loads the :endpoint_id route parameter within the organization.
+func findWebhookEndpoint(c *gin.Context, orgID uint) (*models.WebhookEndpoint, bool) {
+	endpointID, err := strconv.ParseUint(c.Param("endpoint_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook endpoint ID"})
+		return nil, false
+	}
+
+	var endpoint models.WebhookEndpoint
+	if err := database.DB.Where("id = ? AND organization_id = ?", endpointID, orgID).First(&endpoint).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook endpoint not found for this organization"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook endpoint"})
+		return nil, false
+	}
+	return &endpoint, true
+}
+
+// DeleteWebhookEndpoint removes an endpoint. Its pending deliveries give up
+// on their next attempt.
+func DeleteWebhookEndpoint(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	endpoint, ok := findWebhookEndpoint(c, orgID)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Delete(endpoint).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook endpoint"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook endpoint deleted successfully"})
+}
+
+// GetWebhookDeliveries returns the most recent deliveries to an endpoint
+// together with the log of their attempts.
+func GetWebhookDeliveries(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	endpoint, ok := findWebhookEndpoint(c, orgID)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Preload("Log", func(db *gorm.DB) *gorm.DB { return db.Order("attempted_at") }).
+		Where("endpoint_id = ?", endpoint.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("id desc").Limit(100).Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// ResendWebhookDelivery attempts a delivery again right away, regardless of
+// whether it previously succeeded or gave up.
+func ResendWebhookDelivery(c *gin.Context) {
+	orgID, ok := callerOrganization(c)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.ParseUint(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook delivery ID"})
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	if err := database.DB.Where("id = ? AND organization_id = ?", deliveryID, orgID).First(&delivery).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook delivery not found for this organization"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook delivery"})
+		return
+	}
+
+	if err := webhooks.Resend(c.Request.Context(), database.DB, &delivery, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resend webhook delivery"})
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
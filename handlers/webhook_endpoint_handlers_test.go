@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookEndpointsReceiveBillingEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	webhooks.AllowInternalAddresses = true // the receiver below listens on loopback
+	defer func() { webhooks.AllowInternalAddresses = false }()
+
+	received := make(chan webhooks.Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhooks.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+
+	r := gin.Default()
+	r.Use(AuthMiddleware())
+	r.POST("/org/:id/webhook_endpoints", CreateWebhookEndpoint)
+	r.GET("/org/:id/webhook_endpoints/:endpoint_id/deliveries", GetWebhookDeliveries)
+	r.POST("/org/:id/webhook_deliveries/:delivery_id/resend", ResendWebhookDelivery)
+	r.POST("/subscribe", Subscribe)
+
+	query := fmt.Sprintf("caller_user_id=%d&caller_organization_id=%d", user.ID, org.ID)
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		jsonValue, _ := json.Marshal(body)
+		separator := "?"
+		if strings.Contains(path, "?") {
+			separator = "&"
+		}
+		req, _ := http.NewRequest(method, path+separator+query, bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", fmt.Sprintf("/org/%d/webhook_endpoints", org.ID), CreateWebhookEndpointRequest{URL: server.URL, Events: []string{"invoice.deleted"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	webhooks.AllowInternalAddresses = false
+	for _, url := range []string{server.URL, "https://169.254.169.254/latest/meta-data", "https://10.0.0.5/hooks"} {
+		w = do("POST", fmt.Sprintf("/org/%d/webhook_endpoints", org.ID), CreateWebhookEndpointRequest{URL: url})
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+	}
+	webhooks.AllowInternalAddresses = true
+
+	w = do("POST", fmt.Sprintf("/org/%d/webhook_endpoints", org.ID), CreateWebhookEndpointRequest{URL: server.URL, Events: []string{events.SubscriptionCreated, events.InvoiceCreated}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"secret":"whsec_`)
+	var endpoint models.WebhookEndpoint
+	database.DB.First(&endpoint)
+
+	plan := models.SubscriptionPlan{Name: "Basic Plan", Price: 30, Currency: "USD", Interval: "monthly", OrganizationID: org.ID}
+	database.DB.Create(&plan)
+	w = do("POST", "/subscribe", SubscribeRequest{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, UserID: user.ID})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// The events are published with the subscription and queued for the
+	// endpoint by the dispatcher, not sent by the request itself.
+	dispatcher := events.NewDispatcher()
+	dispatcher.Subscribe("webhooks", webhooks.HandleEvent)
+	_, err := dispatcher.DispatchPending(context.Background(), database.DB, time.Now())
+	assert.NoError(t, err)
+
+	var deliveries []models.WebhookDelivery
+	database.DB.Order("id").Find(&deliveries)
+	assert.Len(t, deliveries, 2)
+	assert.Len(t, received, 0)
+
+	// Resending delivers immediately and shows up in the delivery log.
+	w = do("POST", fmt.Sprintf("/org/%d/webhook_deliveries/%d/resend", org.ID, deliveries[0].ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	event := <-received
+	assert.Equal(t, events.SubscriptionCreated, event.Type)
+	assert.Equal(t, org.ID, event.OrganizationID)
+
+	w = do("GET", fmt.Sprintf("/org/%d/webhook_endpoints/%d/deliveries?status=succeeded", org.ID, endpoint.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var logged []models.WebhookDelivery
+	json.Unmarshal(w.Body.Bytes(), &logged)
+	assert.Len(t, logged, 1)
+	assert.Len(t, logged[0].Log, 1)
+	assert.Equal(t, http.StatusOK, logged[0].Log[0].StatusCode)
+}
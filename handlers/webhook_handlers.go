@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PaymentWebhookSecret is the secret shared with the payment provider for
+// signing webhook events. Events are rejected while it is unset.
+var PaymentWebhookSecret string
+
+// ReceivePaymentWebhook handles events posted by the payment provider. The
+// provider retries anything that does not get a 2xx response, so events that
+// are duplicates or do not concern a known payment are still acknowledged.
+func ReceivePaymentWebhook(c *gin.Context) {
+	payload, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	if PaymentWebhookSecret == "" {
+		log.Printf("Rejected payment webhook: no webhook secret configured")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Webhooks are not configured"})
+		return
+	}
+
+	now := time.Now()
+	event, err := payments.ParseEvent(payload, c.GetHeader(payments.SignatureHeader), PaymentWebhookSecret, now)
+	if err != nil {
+		if errors.Is(err, payments.ErrInvalidSignature) || errors.Is(err, payments.ErrStaleTimestamp) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var record *models.ProviderEvent
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		record, err = billing.HandleProviderEvent(tx, PaymentProvider.Name(), event, now)
+		return err
+	})
+	if errors.Is(err, billing.ErrDuplicateEvent) {
+		c.JSON(http.StatusOK, gin.H{"message": "Event already processed", "event_id": event.ID})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to process payment webhook %s: %v", event.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"event_id": record.EventID, "outcome": record.Outcome, "detail": record.Detail})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceivePaymentWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, org, user := setupHandlerTestDB(t)
+	PaymentProvider = payments.NewFakeProvider(payments.BehaviorSucceed)
+	PaymentWebhookSecret = "whsec_test"
+	defer func() { PaymentWebhookSecret = "" }()
+
+	r := gin.Default()
+	r.POST("/webhooks/payments", ReceivePaymentWebhook)
+
+	invoice := models.Invoice{OrganizationID: org.ID, UserID: user.ID, Amount: 25, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	database.DB.Create(&invoice)
+
+	payload, _ := json.Marshal(payments.Event{ID: "evt_1", Type: payments.EventPaymentSucceeded, Created: time.Now().Unix(), Data: payments.EventData{
+		ChargeID: "ch_async_1", InvoiceID: invoice.ID, Amount: 25, Currency: "USD",
+	}})
+	send := func(signature string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/webhooks/payments", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(payments.SignatureHeader, signature)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(payments.SignPayload(payload, "wrong_secret", time.Now()))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = send(payments.SignPayload(payload, "whsec_test", time.Now().Add(-time.Hour)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	database.DB.First(&invoice, invoice.ID)
+	assert.False(t, invoice.Paid)
+
+	w = send(payments.SignPayload(payload, "whsec_test", time.Now()))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"outcome":"processed"`)
+
+	database.DB.First(&invoice, invoice.ID)
+	assert.True(t, invoice.Paid)
+
+	// The provider redelivers the same event; it is acknowledged once more but not applied.
+	w = send(payments.SignPayload(payload, "whsec_test", time.Now()))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Event already processed")
+
+	var count int64
+	database.DB.Model(&models.Payment{}).Where("invoice_id = ?", invoice.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
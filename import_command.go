@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"invoxa/database"
+	"invoxa/imports"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const importUsage = `usage: invoxa import <entity> [flags] <file>
+       invoxa import resume <run ID>
+
+Creates or updates records of an entity (%s) from a CSV or JSON file,
+matching them by external_id. Import entities in that order, as records
+refer to the ones before them.
+
+`
+
+// runImport runs the import command and returns its exit code.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), importUsage, strings.Join(imports.Entities(), ", "))
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", "", "csv or json; taken from the file extension if not given")
+	dryRun := flags.Bool("dry-run", false, "only validate the file and report what importing it would do")
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		flags.Usage()
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if args[0] == "resume" {
+		runID, err := strconv.ParseUint(flags.Arg(0), 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid import run ID %q\n", flags.Arg(0))
+			return 2
+		}
+		db := connectForImport()
+		run, err := imports.Resume(ctx, db, uint(runID))
+		return reportImportRun(db, run, err)
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(flags.Arg(0))), ".")
+		if *format == "ndjson" {
+			*format = imports.FormatJSON
+		}
+	}
+
+	db := connectForImport()
+	if *dryRun {
+		report, err := imports.DryRun(db, args[0], *format, data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+		if report.Failed > 0 {
+			return 1
+		}
+		return 0
+	}
+	run, err := imports.Start(ctx, db, args[0], *format, data)
+	return reportImportRun(db, run, err)
+}
+
+// connectForImport connects without logging every query an import runs.
+func connectForImport() *gorm.DB {
+	database.ConnectDatabase()
+	return database.DB.Session(&gorm.Session{Logger: database.DB.Logger.LogMode(logger.Silent)})
+}
+
+// reportImportRun prints the outcome and row errors of a run. A run that
+// stopped part way can be resumed by its ID.
+func reportImportRun(db *gorm.DB, run *models.ImportRun, err error) int {
+	if run == nil || run.ID == 0 {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var rowErrors []models.ImportError
+	if err := db.Where("import_run_id = ?", run.ID).Order("id").Find(&rowErrors).Error; err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	for _, e := range rowErrors {
+		if e.Field == "" {
+			fmt.Fprintf(os.Stderr, "row %d: %s\n", e.Row, e.Message)
+		} else {
+			fmt.Fprintf(os.Stderr, "row %d: %s %s\n", e.Row, e.Field, e.Message)
+		}
+	}
+	log.Printf("Import run %d %s: %d of %d rows processed, %d created, %d updated, %d failed",
+		run.ID, run.Status, run.ProcessedRows, run.TotalRows, run.Created, run.Updated, run.Failed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if run.Status != imports.StatusCompleted {
+			fmt.Fprintf(os.Stderr, "resume with: invoxa import resume %d\n", run.ID)
+		}
+		return 1
+	}
+	if run.Failed > 0 {
+		return 1
+	}
+	return 0
+}
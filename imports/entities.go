@@ -0,0 +1,428 @@
+package imports
+
+import (
+	"fmt"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// entity is a kind of record that can be imported. apply checks a row and,
+// with write, creates or updates the record it describes; it writes nothing
+// for rows with errors. It reports whether the row creates a record.
+type entity struct {
+	fields []string // besides external_id
+	apply  func(tx *gorm.DB, r *row, write bool) (bool, error)
+}
+
+var entities = map[string]*entity{
+	"organizations": {
+		fields: []string{"name", "billing_email", "tax_id", "address_line1", "address_line2", "city", "region", "postal_code", "country", "reporting_currency", "invoice_prefix"},
+		apply:  importOrganization,
+	},
+	"users": {
+		fields: []string{"organization_external_id", "username", "email", "password"},
+		apply:  importUser,
+	},
+	"plans": {
+		fields: []string{"organization_external_id", "name", "description", "price", "currency", "interval", "tax_inclusive", "tax_code"},
+		apply:  importPlan,
+	},
+	"subscriptions": {
+		fields: []string{"organization_external_id", "plan_external_id", "user_external_id", "status", "start_date", "end_date", "current_period_start", "current_period_end"},
+		apply:  importSubscription,
+	},
+	"invoices": {
+		fields: []string{"organization_external_id", "user_external_id", "subscription_external_id", "number", "currency", "amount", "tax_amount", "issue_date", "due_date", "period_start", "period_end"},
+		apply:  importInvoice,
+	},
+	"payments": {
+		fields: []string{"invoice_external_id", "user_external_id", "amount", "currency", "payment_date", "transaction_id", "payment_method", "provider", "status"},
+		apply:  importPayment,
+	},
+}
+
+// Entities lists what can be imported, in the order files should be
+// imported: records refer to records of the entities before them.
+func Entities() []string {
+	return []string{"organizations", "users", "plans", "subscriptions", "invoices", "payments"}
+}
+
+// existing returns the record a row updates, or nil if the row creates one.
+func existing[T any](tx *gorm.DB, r *row) (*T, string, error) {
+	externalID := r.required("external_id")
+	if externalID == "" {
+		r.creating = true
+		return nil, "", nil
+	}
+	record, err := imported[T](tx, externalID)
+	r.creating = record == nil
+	return record, externalID, err
+}
+
+// save writes a record without touching the records it refers to.
+func save(tx *gorm.DB, r *row, record any) error {
+	if r.creating {
+		return tx.Omit(clause.Associations).Create(record).Error
+	}
+	return tx.Omit(clause.Associations).Save(record).Error
+}
+
+// unchanged fails the row if it changes a field that cannot change once the
+// record is imported, such as amounts that were booked in the journal.
+func unchanged[T comparable](r *row, field string, given, current T) {
+	if !r.creating && r.has(field) && given != current {
+		r.fail(field, "cannot change once imported")
+	}
+}
+
+func importOrganization(tx *gorm.DB, r *row, write bool) (bool, error) {
+	org, externalID, err := existing[models.Organization](tx, r)
+	if err != nil {
+		return false, err
+	}
+	if org == nil {
+		org = &models.Organization{ExternalID: &externalID}
+	}
+
+	r.set(&org.Name, "name", true)
+	r.set(&org.BillingEmail, "billing_email", true)
+	r.set(&org.TaxID, "tax_id", false)
+	r.set(&org.AddressLine1, "address_line1", false)
+	r.set(&org.AddressLine2, "address_line2", false)
+	r.set(&org.City, "city", false)
+	r.set(&org.Region, "region", false)
+	r.set(&org.PostalCode, "postal_code", false)
+	r.code(&org.Country, "country", false)
+	r.code(&org.ReportingCurrency, "reporting_currency", false)
+	r.set(&org.InvoicePrefix, "invoice_prefix", false)
+	if err := unique(tx, r, &models.Organization{}, "name", "name", org.Name, org.ID); err != nil {
+		return false, err
+	}
+
+	if len(r.errors) > 0 || !write {
+		return r.creating, nil
+	}
+	return r.creating, save(tx, r, org)
+}
+
+func importUser(tx *gorm.DB, r *row, write bool) (bool, error) {
+	user, externalID, err := existing[models.User](tx, r)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		user = &models.User{ExternalID: &externalID}
+	}
+
+	org, err := reference[models.Organization](tx, r, "organization_external_id", "organization", true)
+	if err != nil {
+		return false, err
+	}
+	if org != nil {
+		user.OrganizationID = org.ID
+	}
+	r.set(&user.Username, "username", true)
+	r.set(&user.Email, "email", true)
+	if err := unique(tx, r, &models.User{}, "username", "username", user.Username, user.ID); err != nil {
+		return false, err
+	}
+	if err := unique(tx, r, &models.User{}, "email", "email", user.Email, user.ID); err != nil {
+		return false, err
+	}
+	// Users imported without a password cannot sign in until one is set.
+	password := r.text("password")
+	if password != "" && len(password) < 6 {
+		r.fail("password", "must be at least 6 characters")
+	}
+
+	if len(r.errors) > 0 || !write {
+		return r.creating, nil
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return false, fmt.Errorf("failed to hash password: %w", err)
+		}
+		user.PasswordHash = string(hash)
+	}
+	return r.creating, save(tx, r, user)
+}
+
+func importPlan(tx *gorm.DB, r *row, write bool) (bool, error) {
+	plan, externalID, err := existing[models.SubscriptionPlan](tx, r)
+	if err != nil {
+		return false, err
+	}
+	if plan == nil {
+		plan = &models.SubscriptionPlan{ExternalID: &externalID, Currency: "USD", Interval: "monthly"}
+	}
+
+	org, err := reference[models.Organization](tx, r, "organization_external_id", "organization", true)
+	if err != nil {
+		return false, err
+	}
+	if org != nil {
+		plan.OrganizationID = org.ID
+	}
+	r.set(&plan.Name, "name", true)
+	r.set(&plan.Description, "description", false)
+	r.float(&plan.Price, "price", true)
+	r.code(&plan.Currency, "currency", true)
+	r.oneOf(&plan.Interval, "interval", "weekly", "monthly", "quarterly", "yearly")
+	r.boolean(&plan.TaxInclusive, "tax_inclusive")
+	r.set(&plan.TaxCode, "tax_code", false)
+	if err := unique(tx.Where("organization_id = ?", plan.OrganizationID), r, &models.SubscriptionPlan{}, "name", "name", plan.Name, plan.ID); err != nil {
+		return false, err
+	}
+
+	if len(r.errors) > 0 || !write {
+		return r.creating, nil
+	}
+	return r.creating, save(tx, r, plan)
+}
+
+func importSubscription(tx *gorm.DB, r *row, write bool) (bool, error) {
+	subscription, externalID, err := existing[models.Subscription](tx, r)
+	if err != nil {
+		return false, err
+	}
+	if subscription == nil {
+		subscription = &models.Subscription{ExternalID: &externalID, IsActive: true}
+	}
+
+	org, err := reference[models.Organization](tx, r, "organization_external_id", "organization", true)
+	if err != nil {
+		return false, err
+	}
+	if org != nil {
+		subscription.OrganizationID = org.ID
+	}
+	plan, err := reference[models.SubscriptionPlan](tx, r, "plan_external_id", "plan", true)
+	if err != nil {
+		return false, err
+	}
+	if plan != nil {
+		subscription.SubscriptionPlanID = plan.ID
+	}
+	user, err := reference[models.User](tx, r, "user_external_id", "user", false)
+	if err != nil {
+		return false, err
+	}
+	if user != nil {
+		subscription.UserID = user.ID
+	}
+
+	r.date(&subscription.StartDate, "start_date", true)
+	r.date(&subscription.EndDate, "end_date", false)
+	r.date(&subscription.CurrentPeriodStart, "current_period_start", false)
+	r.date(&subscription.CurrentPeriodEnd, "current_period_end", false)
+	if subscription.CurrentPeriodStart.IsZero() {
+		subscription.CurrentPeriodStart = subscription.StartDate
+	}
+	if subscription.CurrentPeriodEnd.IsZero() && plan != nil {
+		subscription.CurrentPeriodEnd = billing.NextPeriodEnd(subscription.CurrentPeriodStart, plan.Interval)
+	}
+	if subscription.CurrentPeriodEnd.Before(subscription.CurrentPeriodStart) {
+		r.fail("current_period_end", "must not be before current_period_start")
+	}
+
+	status := ""
+	r.oneOf(&status, "status", "active", "paused", "cancelled")
+	switch status {
+	case "active":
+		subscription.IsActive, subscription.PausedAt = true, nil
+	case "paused":
+		subscription.IsActive = true
+		if subscription.PausedAt == nil {
+			now := time.Now()
+			subscription.PausedAt = &now
+		}
+	case "cancelled":
+		subscription.IsActive = false
+	}
+
+	if len(r.errors) > 0 || !write {
+		return r.creating, nil
+	}
+	// Creating the row replaces a false IsActive with the column default, so
+	// it is written separately.
+	isActive := subscription.IsActive
+	if err := save(tx, r, subscription); err != nil {
+		return false, err
+	}
+	return r.creating, tx.Model(subscription).Update("is_active", isActive).Error
+}
+
+// importInvoice books new invoices in the journal and schedules their
+// revenue as finalizing them would. Their number and tax are taken from the
+// file, not assigned or calculated again, and the organization's invoice
+// sequence is moved past the number so it is not issued twice.
+func importInvoice(tx *gorm.DB, r *row, write bool) (bool, error) {
+	invoice, externalID, err := existing[models.Invoice](tx, r)
+	if err != nil {
+		return false, err
+	}
+	if invoice == nil {
+		invoice = &models.Invoice{ExternalID: &externalID, Currency: "USD"}
+	}
+
+	org, err := reference[models.Organization](tx, r, "organization_external_id", "organization", true)
+	if err != nil {
+		return false, err
+	}
+	if org != nil {
+		unchanged(r, "organization_external_id", org.ID, invoice.OrganizationID)
+		invoice.OrganizationID = org.ID
+	}
+	user, err := reference[models.User](tx, r, "user_external_id", "user", false)
+	if err != nil {
+		return false, err
+	}
+	if user != nil {
+		invoice.UserID = user.ID
+	}
+	subscription, err := reference[models.Subscription](tx, r, "subscription_external_id", "subscription", false)
+	if err != nil {
+		return false, err
+	}
+	if subscription != nil {
+		invoice.SubscriptionID = &subscription.ID
+	}
+
+	if r.has("number") {
+		invoice.Number = nil
+		if number := r.text("number"); number != "" {
+			invoice.Number = &number
+		}
+	}
+	if invoice.Number != nil {
+		if err := unique(tx.Where("organization_id = ?", invoice.OrganizationID), r, &models.Invoice{}, "number", "number", *invoice.Number, invoice.ID); err != nil {
+			return false, err
+		}
+	}
+
+	currency, amount, taxAmount := invoice.Currency, invoice.Amount, invoice.TaxAmount
+	r.code(&currency, "currency", false)
+	r.float(&amount, "amount", true)
+	r.float(&taxAmount, "tax_amount", false)
+	unchanged(r, "currency", currency, invoice.Currency)
+	unchanged(r, "amount", amount, invoice.Amount)
+	unchanged(r, "tax_amount", taxAmount, invoice.TaxAmount)
+	if taxAmount > amount {
+		r.fail("tax_amount", "must not be more than amount")
+	}
+	invoice.Currency, invoice.Amount, invoice.TaxAmount = currency, amount, taxAmount
+	invoice.Subtotal = amount - taxAmount
+
+	r.date(&invoice.IssueDate, "issue_date", true)
+	r.date(&invoice.DueDate, "due_date", false)
+	r.date(&invoice.PeriodStart, "period_start", false)
+	r.date(&invoice.PeriodEnd, "period_end", false)
+	if invoice.DueDate.IsZero() {
+		invoice.DueDate = invoice.IssueDate
+	}
+	if !invoice.PeriodStart.IsZero() && !invoice.PeriodEnd.After(invoice.PeriodStart) {
+		r.fail("period_end", "must be after period_start")
+	}
+
+	if len(r.errors) > 0 || !write {
+		return r.creating, nil
+	}
+	if err := save(tx, r, invoice); err != nil {
+		return false, err
+	}
+	if invoice.Number != nil {
+		if err := billing.ReserveInvoiceNumber(tx, invoice.OrganizationID, *invoice.Number); err != nil {
+			return false, err
+		}
+	}
+	if !r.creating {
+		return false, nil
+	}
+	if err := billing.RecordInvoice(tx, invoice); err != nil {
+		return false, err
+	}
+	return true, billing.ScheduleRevenue(tx, invoice)
+}
+
+// importPayment settles invoices with the payments made on them. Payments
+// that did not succeed are recorded without being booked.
+func importPayment(tx *gorm.DB, r *row, write bool) (bool, error) {
+	payment, externalID, err := existing[models.Payment](tx, r)
+	if err != nil {
+		return false, err
+	}
+	if payment == nil {
+		payment = &models.Payment{ExternalID: &externalID, TransactionID: "import_" + externalID, ProviderStatus: payments.StatusSucceeded}
+	}
+
+	invoice, err := reference[models.Invoice](tx, r, "invoice_external_id", "invoice", true)
+	if err != nil {
+		return false, err
+	}
+	if invoice != nil {
+		unchanged(r, "invoice_external_id", invoice.ID, payment.InvoiceID)
+		payment.InvoiceID = invoice.ID
+	}
+	user, err := reference[models.User](tx, r, "user_external_id", "user", false)
+	if err != nil {
+		return false, err
+	}
+	if user != nil {
+		payment.UserID = user.ID
+	}
+
+	// Payments in another currency than their invoice are stored converted,
+	// so an update is compared with what was charged.
+	amount, currency := payment.Amount, payment.Currency
+	if payment.ChargedCurrency != "" {
+		amount, currency = payment.ChargedAmount, payment.ChargedCurrency
+	}
+	newAmount, newCurrency, status := amount, currency, payment.ProviderStatus
+	r.float(&newAmount, "amount", true)
+	r.code(&newCurrency, "currency", false)
+	r.oneOf(&status, "status", payments.StatusSucceeded, payments.StatusPending, payments.StatusDeclined, payments.StatusFailed)
+	unchanged(r, "amount", newAmount, amount)
+	unchanged(r, "currency", newCurrency, currency)
+	unchanged(r, "status", status, payment.ProviderStatus)
+	if r.creating && newAmount <= 0 && r.text("amount") != "" {
+		r.fail("amount", "must be more than zero")
+	}
+	payment.Amount, payment.Currency, payment.ProviderStatus = newAmount, newCurrency, status
+
+	r.date(&payment.PaymentDate, "payment_date", true)
+	if r.has("transaction_id") {
+		r.set(&payment.TransactionID, "transaction_id", true)
+	}
+	r.set(&payment.PaymentMethod, "payment_method", false)
+	r.set(&payment.Provider, "provider", false)
+	if err := unique(tx, r, &models.Payment{}, "transaction_id", "transaction_id", payment.TransactionID, payment.ID); err != nil {
+		return false, err
+	}
+	if r.creating && invoice != nil && payment.Currency != "" && !payment.PaymentDate.IsZero() {
+		if _, err := billing.ExchangeRateOn(tx, payment.Currency, invoice.Currency, payment.PaymentDate); err != nil {
+			r.fail("currency", "%v", err)
+		}
+	}
+
+	if len(r.errors) > 0 || !write {
+		return r.creating, nil
+	}
+	if !r.creating {
+		return false, save(tx, r, payment)
+	}
+	if payment.ProviderStatus == payments.StatusSucceeded {
+		return true, billing.ImportPayment(tx, invoice, payment)
+	}
+	if payment.Currency == "" {
+		payment.Currency = invoice.Currency
+	}
+	return true, save(tx, r, payment)
+}
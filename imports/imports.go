@@ -0,0 +1,321 @@
+// Package imports creates and updates organizations, users, plans,
+// subscriptions, invoices and payments in bulk from CSV or JSON files, as when
+// migrating from another billing system. Records are matched by the ID they
+// had there, their external ID, so importing a file again updates them
+// instead of creating duplicates.
+package imports
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownEntity = errors.New("unknown import entity")
+	ErrUnknownFormat = errors.New("unknown import format")
+	ErrCompleted     = errors.New("import already completed")
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// Statuses of an import run.
+const (
+	StatusRunning     = "running"
+	StatusInterrupted = "interrupted" // stopped before the end, e.g., when the request was cancelled
+	StatusFailed      = "failed"
+	StatusCompleted   = "completed"
+)
+
+// ChunkSize is how many rows are applied in one transaction. A run that stops
+// resumes after the last chunk it committed.
+var ChunkSize = 100
+
+// Row is the fields of a record in a file, by name.
+type Row map[string]string
+
+type RowError struct {
+	Row     int    `json:"row"` // 1-based, not counting a CSV header
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is what a dry run found a file would do.
+type Report struct {
+	Entity string     `json:"entity"`
+	Rows   int        `json:"rows"`
+	Create int        `json:"create"`
+	Update int        `json:"update"`
+	Failed int        `json:"failed"` // rows that would be skipped for errors
+	Errors []RowError `json:"errors"`
+}
+
+// Parse reads the rows of a file. CSV files start with a header naming the
+// fields. JSON files hold an array of objects, or one object per line as
+// written by NDJSON exports.
+func Parse(format string, data []byte) ([]Row, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(data)
+	case FormatJSON:
+		return parseJSON(data)
+	default:
+		return nil, fmt.Errorf("%w %q; expected csv or json", ErrUnknownFormat, format)
+	}
+}
+
+func parseCSV(data []byte) ([]Row, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	header, err := reader.Read()
+	if err == io.EOF {
+		return []Row{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	rows := []Row{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		row := Row{}
+		for i, value := range record {
+			row[header[i]] = value
+		}
+		rows = append(rows, row)
+	}
+}
+
+func parseJSON(data []byte) ([]Row, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var objects []map[string]any
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := decoder.Decode(&objects); err != nil {
+			return nil, fmt.Errorf("failed to read JSON: %w", err)
+		}
+	} else {
+		for decoder.More() {
+			var object map[string]any
+			if err := decoder.Decode(&object); err != nil {
+				return nil, fmt.Errorf("failed to read JSON on object %d: %w", len(objects)+1, err)
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	rows := make([]Row, len(objects))
+	for i, object := range objects {
+		rows[i] = Row{}
+		for field, value := range object {
+			switch v := value.(type) {
+			case nil:
+				rows[i][field] = ""
+			case string:
+				rows[i][field] = v
+			case json.Number, bool:
+				rows[i][field] = fmt.Sprint(v)
+			default:
+				return nil, fmt.Errorf("field %q of object %d must be a string, number or boolean", field, i+1)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// load parses a file of records of an entity, checking that it has no fields
+// the entity does not know.
+func load(entityName, format string, data []byte) (*entity, []Row, error) {
+	e, ok := entities[entityName]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q; expected one of %v", ErrUnknownEntity, entityName, Entities())
+	}
+	rows, err := Parse(format, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, row := range rows {
+		for field := range row {
+			if field != "external_id" && !slices.Contains(e.fields, field) {
+				return nil, nil, fmt.Errorf("unknown field %q for %s; expected external_id or one of %v", field, entityName, e.fields)
+			}
+		}
+	}
+	return e, rows, nil
+}
+
+// DryRun validates a file without changing anything, reporting which rows
+// would create or update records and the errors of those that would fail.
+func DryRun(db *gorm.DB, entityName, format string, data []byte) (*Report, error) {
+	e, rows, err := load(entityName, format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Entity: entityName, Rows: len(rows), Errors: []RowError{}}
+	seen := map[string]bool{}
+	for i, values := range rows {
+		r := &row{number: i + 1, values: values}
+		created, err := e.apply(db, r, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to validate row %d: %w", r.number, err)
+		}
+		if len(r.errors) > 0 {
+			report.Failed++
+			report.Errors = append(report.Errors, r.errors...)
+			continue
+		}
+		// A record created by an earlier row is updated by a later one.
+		if externalID := r.text("external_id"); created && !seen[externalID] {
+			report.Create++
+			seen[externalID] = true
+		} else {
+			report.Update++
+		}
+	}
+	return report, nil
+}
+
+// Start records an import run for a file and applies it. Rows with errors
+// are skipped and reported on the run; the others are applied in order.
+// Files that cannot be read are rejected before a run is created.
+func Start(ctx context.Context, db *gorm.DB, entityName, format string, data []byte) (*models.ImportRun, error) {
+	e, rows, err := load(entityName, format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &models.ImportRun{
+		Entity:    entityName,
+		Format:    format,
+		Data:      data,
+		Status:    StatusRunning,
+		TotalRows: len(rows),
+	}
+	if err := db.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to create import run: %w", err)
+	}
+	return run, process(ctx, db, run, e, rows)
+}
+
+// Resume continues an import run that stopped before the end, from the
+// first row it had not applied.
+func Resume(ctx context.Context, db *gorm.DB, runID uint) (*models.ImportRun, error) {
+	var run models.ImportRun
+	if err := db.First(&run, runID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load import run %d: %w", runID, err)
+	}
+	if run.Status == StatusCompleted {
+		return &run, ErrCompleted
+	}
+	e, rows, err := load(run.Entity, run.Format, run.Data)
+	if err != nil {
+		return &run, err
+	}
+
+	if err := db.Model(&run).Updates(map[string]any{"status": StatusRunning, "error": ""}).Error; err != nil {
+		return &run, fmt.Errorf("failed to resume import run %d: %w", run.ID, err)
+	}
+	return &run, process(ctx, db, &run, e, rows)
+}
+
+// process applies the rows of a run a chunk at a time. Each chunk commits
+// with the run's progress, so the run always knows which rows were applied.
+func process(ctx context.Context, db *gorm.DB, run *models.ImportRun, e *entity, rows []Row) error {
+	for run.ProcessedRows < len(rows) {
+		if err := ctx.Err(); err != nil {
+			return stop(db, run, StatusInterrupted, err)
+		}
+
+		progress := *run
+		progress.Errors = nil
+		end := min(run.ProcessedRows+ChunkSize, len(rows))
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for i := run.ProcessedRows; i < end; i++ {
+				r := &row{number: i + 1, values: rows[i]}
+				created, err := applyRow(tx, e, r)
+				if err != nil {
+					return err
+				}
+				switch {
+				case len(r.errors) > 0:
+					progress.Failed++
+					for _, rowErr := range r.errors {
+						progress.Errors = append(progress.Errors, models.ImportError{ImportRunID: run.ID, Row: rowErr.Row, Field: rowErr.Field, Message: rowErr.Message})
+					}
+				case created:
+					progress.Created++
+				default:
+					progress.Updated++
+				}
+			}
+			if len(progress.Errors) > 0 {
+				if err := tx.Create(&progress.Errors).Error; err != nil {
+					return err
+				}
+			}
+			progress.ProcessedRows = end
+			return tx.Model(run).Updates(map[string]any{
+				"processed_rows": progress.ProcessedRows,
+				"created":        progress.Created,
+				"updated":        progress.Updated,
+				"failed":         progress.Failed,
+			}).Error
+		})
+		if err != nil {
+			return stop(db, run, StatusFailed, err)
+		}
+		run.ProcessedRows, run.Created, run.Updated, run.Failed = progress.ProcessedRows, progress.Created, progress.Updated, progress.Failed
+	}
+
+	run.Status = StatusCompleted
+	if err := db.Model(run).Update("status", StatusCompleted).Error; err != nil {
+		return fmt.Errorf("failed to complete import run %d: %w", run.ID, err)
+	}
+	return nil
+}
+
+// applyRow applies a row in a savepoint, so that a row the database rejects
+// is reported without undoing the rest of its chunk.
+func applyRow(tx *gorm.DB, e *entity, r *row) (bool, error) {
+	if err := tx.SavePoint("import_row").Error; err != nil {
+		return false, err
+	}
+	created, err := e.apply(tx, r, true)
+	if err != nil {
+		if err := tx.RollbackTo("import_row").Error; err != nil {
+			return false, err
+		}
+		r.fail("", "%v", err)
+	}
+	return created, nil
+}
+
+func stop(db *gorm.DB, run *models.ImportRun, status string, cause error) error {
+	run.Status, run.Error = status, cause.Error()
+	if err := db.Model(run).Updates(map[string]any{"status": status, "error": run.Error}).Error; err != nil {
+		return fmt.Errorf("failed to stop import run %d: %w", run.ID, err)
+	}
+	return fmt.Errorf("import run %d %s at row %d: %w", run.ID, status, run.ProcessedRows+1, cause)
+}
@@ -0,0 +1,212 @@
+package imports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupImportDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(database.Models...))
+	return db
+}
+
+func importFile(t *testing.T, db *gorm.DB, entity, format, data string) *models.ImportRun {
+	run, err := Start(context.Background(), db, entity, format, []byte(data))
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompleted, run.Status)
+	return run
+}
+
+// importCustomer imports an organization with a user and a monthly plan.
+func importCustomer(t *testing.T, db *gorm.DB) {
+	importFile(t, db, "organizations", FormatCSV, "external_id,name,billing_email,country\norg_1,Acme,billing@acme.test,us\n")
+	importFile(t, db, "users", FormatCSV, "external_id,organization_external_id,username,email,password\nusr_1,org_1,jane,jane@acme.test,secret1\n")
+	importFile(t, db, "plans", FormatJSON, `[{"external_id": "plan_1", "organization_external_id": "org_1", "name": "Pro", "price": 30, "currency": "usd"}]`)
+}
+
+func TestParse(t *testing.T) {
+	rows, err := Parse(FormatCSV, []byte("\ufeffExternal_ID, Name\n1,\"Acme, Inc.\"\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Row{{"external_id": "1", "name": "Acme, Inc."}}, rows)
+
+	rows, err = Parse(FormatJSON, []byte("{\"external_id\": 1, \"tax_inclusive\": true, \"tax_id\": null}\n{\"external_id\": \"2\"}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Row{{"external_id": "1", "tax_inclusive": "true", "tax_id": ""}, {"external_id": "2"}}, rows)
+
+	_, err = Parse(FormatJSON, []byte(`[{"external_id": {"id": 1}}]`))
+	assert.Error(t, err)
+	_, err = Parse("xlsx", nil)
+	assert.True(t, errors.Is(err, ErrUnknownFormat))
+}
+
+func TestDryRunReportsRowErrors(t *testing.T) {
+	db := setupImportDB(t)
+	importCustomer(t, db)
+
+	report, err := DryRun(db, "subscriptions", FormatCSV, []byte("external_id,organization_external_id,plan_external_id,user_external_id,status,start_date\n"+
+		"sub_1,org_1,plan_1,usr_1,active,2026-01-15\n"+
+		"sub_2,org_1,plan_9,,paused,15/01/2026\n"+
+		"sub_1,org_1,plan_1,usr_1,cancelled,2026-01-15\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Rows)
+	assert.Equal(t, 1, report.Create)
+	assert.Equal(t, 1, report.Update) // the third row updates what the first creates
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, []RowError{
+		{Row: 2, Field: "plan_external_id", Message: `no plan was imported with external ID "plan_9"`},
+		{Row: 2, Field: "start_date", Message: "must be a date formatted as YYYY-MM-DD"},
+	}, report.Errors)
+
+	var count int64
+	db.Model(&models.Subscription{}).Count(&count)
+	assert.Zero(t, count)
+
+	_, err = DryRun(db, "subscriptions", FormatCSV, []byte("external_id,plan\nsub_1,plan_1\n"))
+	assert.ErrorContains(t, err, `unknown field "plan"`)
+	_, err = DryRun(db, "customers", FormatCSV, nil)
+	assert.True(t, errors.Is(err, ErrUnknownEntity))
+}
+
+func TestImportUpsertsByExternalID(t *testing.T) {
+	db := setupImportDB(t)
+	importCustomer(t, db)
+
+	var user models.User
+	db.Where("external_id = ?", "usr_1").First(&user)
+	assert.NotEmpty(t, user.PasswordHash)
+	var plan models.SubscriptionPlan
+	db.Where("external_id = ?", "plan_1").First(&plan)
+	assert.Equal(t, "USD", plan.Currency)
+	assert.Equal(t, "monthly", plan.Interval)
+
+	// Fields a file leaves out are kept.
+	run := importFile(t, db, "plans", FormatCSV, "external_id,price\nplan_1,35\n")
+	assert.Equal(t, 1, run.Updated)
+	db.First(&plan, plan.ID)
+	assert.Equal(t, 35.0, plan.Price)
+	assert.Equal(t, "Pro", plan.Name)
+
+	importFile(t, db, "subscriptions", FormatCSV, "external_id,organization_external_id,plan_external_id,user_external_id,status,start_date\nsub_1,org_1,plan_1,usr_1,cancelled,2026-01-15\n")
+	var subscription models.Subscription
+	db.Where("external_id = ?", "sub_1").First(&subscription)
+	assert.False(t, subscription.IsActive)
+	assert.Equal(t, "2026-02-15", subscription.CurrentPeriodEnd.Format("2006-01-02"))
+
+	var count int64
+	db.Model(&models.SubscriptionPlan{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestImportInvoicesAndPayments(t *testing.T) {
+	db := setupImportDB(t)
+	importCustomer(t, db)
+
+	importFile(t, db, "invoices", FormatCSV, "external_id,organization_external_id,user_external_id,number,amount,tax_amount,currency,issue_date,due_date\n"+
+		"in_1,org_1,usr_1,OLD-1,120,20,USD,2026-02-01,2026-02-15\n"+
+		"in_2,org_1,usr_1,OLD-2,60,0,USD,2026-03-01,2026-03-15\n")
+	run := importFile(t, db, "payments", FormatCSV, "external_id,invoice_external_id,amount,payment_date\n"+
+		"pay_1,in_1,120,2026-02-10\n"+
+		"pay_2,in_2,70,2026-03-10\n"+
+		"pay_3,in_2,abc,2026-03-10\n")
+	assert.Equal(t, 2, run.Created)
+	assert.Equal(t, 1, run.Failed)
+
+	var invoices []models.Invoice
+	db.Order("id").Find(&invoices)
+	assert.True(t, invoices[0].Paid)
+	assert.Equal(t, 100.0, invoices[0].Subtotal)
+	assert.True(t, invoices[1].Paid)
+
+	var credit models.CreditEntry
+	assert.NoError(t, db.First(&credit).Error)
+	assert.Equal(t, 10.0, credit.Amount) // overpaid on the second invoice
+
+	var entries int64
+	db.Model(&models.JournalEntry{}).Count(&entries)
+	assert.Equal(t, int64(4), entries)
+
+	var payment models.Payment
+	db.Where("external_id = ?", "pay_1").First(&payment)
+	assert.Equal(t, "import_pay_1", payment.TransactionID)
+
+	// Invoices imported without a currency are in USD.
+	run = importFile(t, db, "invoices", FormatCSV, "external_id,organization_external_id,amount,issue_date\nin_3,org_1,40,2026-04-01\n")
+	assert.Equal(t, 1, run.Created)
+	var invoice models.Invoice
+	db.Where("external_id = ?", "in_3").First(&invoice)
+	assert.Equal(t, "USD", invoice.Currency)
+
+	// Booked amounts cannot be changed by importing a file again.
+	run = importFile(t, db, "invoices", FormatCSV, "external_id,amount\nin_1,150\n")
+	assert.Equal(t, 1, run.Failed)
+	var runErrors []models.ImportError
+	db.Where("import_run_id = ?", run.ID).Find(&runErrors)
+	assert.Equal(t, "amount", runErrors[0].Field)
+	assert.Equal(t, "cannot change once imported", runErrors[0].Message)
+}
+
+func TestResumeInterruptedImport(t *testing.T) {
+	db := setupImportDB(t)
+	defer func(size int) { ChunkSize = size }(ChunkSize)
+	ChunkSize = 2
+
+	data := "external_id,name,billing_email\n" +
+		"org_1,One,one@example.test\n" +
+		"org_2,Two,two@example.test\n" +
+		"org_3,Three,\n" +
+		"org_4,Four,four@example.test\n" +
+		"org_5,Five,five@example.test\n"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	run, err := Start(ctx, db, "organizations", FormatCSV, []byte(data))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, StatusInterrupted, run.Status)
+	assert.Equal(t, 0, run.ProcessedRows)
+
+	// Stop after the first chunk by applying it alone.
+	assert.NoError(t, process(context.Background(), db, run, entities["organizations"], mustParse(t, data)[:2]))
+	db.Model(run).Update("status", StatusInterrupted)
+
+	run, err = Resume(context.Background(), db, run.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompleted, run.Status)
+	assert.Equal(t, 5, run.ProcessedRows)
+	assert.Equal(t, 4, run.Created)
+	assert.Equal(t, 1, run.Failed)
+
+	var count int64
+	db.Model(&models.Organization{}).Count(&count)
+	assert.Equal(t, int64(4), count)
+	var rowErrors []models.ImportError
+	db.Where("import_run_id = ?", run.ID).Find(&rowErrors)
+	assert.Equal(t, []string{"3 billing_email is required"}, errorStrings(rowErrors))
+
+	_, err = Resume(context.Background(), db, run.ID)
+	assert.ErrorIs(t, err, ErrCompleted)
+}
+
+func mustParse(t *testing.T, data string) []Row {
+	rows, err := Parse(FormatCSV, []byte(data))
+	assert.NoError(t, err)
+	return rows
+}
+
+func errorStrings(rowErrors []models.ImportError) []string {
+	s := make([]string, len(rowErrors))
+	for i, e := range rowErrors {
+		s[i] = fmt.Sprintf("%d %s %s", e.Row, e.Field, e.Message)
+	}
+	return s
+}
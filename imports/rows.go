@@ -0,0 +1,175 @@
+package imports
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// row reads the fields of a row, collecting what is wrong with them.
+type row struct {
+	number   int
+	values   Row
+	creating bool // the row creates a record rather than updating one
+	errors   []RowError
+}
+
+func (r *row) fail(field, format string, args ...any) {
+	r.errors = append(r.errors, RowError{Row: r.number, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *row) has(field string) bool {
+	_, ok := r.values[field]
+	return ok
+}
+
+func (r *row) text(field string) string {
+	return strings.TrimSpace(r.values[field])
+}
+
+// required returns a field that must not be empty.
+func (r *row) required(field string) string {
+	value := r.text(field)
+	if value == "" {
+		r.fail(field, "is required")
+	}
+	return value
+}
+
+// needs reports whether a field must be given a value: required fields of
+// new records, and required fields an update sets.
+func (r *row) needs(field string, required bool) bool {
+	return required && (r.creating || r.has(field))
+}
+
+// set copies a field into dest if the row has it, so that updates leave
+// fields a file does not mention alone.
+func (r *row) set(dest *string, field string, required bool) {
+	switch {
+	case r.needs(field, required):
+		*dest = r.required(field)
+	case r.has(field):
+		*dest = r.text(field)
+	}
+}
+
+// code is like set for upper case codes such as currencies and countries.
+func (r *row) code(dest *string, field string, required bool) {
+	r.set(dest, field, required)
+	*dest = strings.ToUpper(*dest)
+}
+
+func (r *row) float(dest *float64, field string, required bool) {
+	value := r.text(field)
+	if value == "" {
+		if r.needs(field, required) {
+			r.fail(field, "is required")
+		}
+		return
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.fail(field, "must be a number")
+		return
+	}
+	if parsed < 0 {
+		r.fail(field, "must not be negative")
+		return
+	}
+	*dest = parsed
+}
+
+func (r *row) boolean(dest *bool, field string) {
+	value := r.text(field)
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		r.fail(field, "must be true or false")
+		return
+	}
+	*dest = parsed
+}
+
+// date reads a day (YYYY-MM-DD) or a time (RFC 3339), as exports write them.
+func (r *row) date(dest *time.Time, field string, required bool) {
+	value := r.text(field)
+	if value == "" {
+		if r.needs(field, required) {
+			r.fail(field, "is required")
+		}
+		return
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			*dest = parsed.UTC()
+			return
+		}
+	}
+	r.fail(field, "must be a date formatted as YYYY-MM-DD")
+}
+
+// oneOf checks that a field, if given, has one of a set of values.
+func (r *row) oneOf(dest *string, field string, values ...string) {
+	value := strings.ToLower(r.text(field))
+	if value == "" {
+		return
+	}
+	for _, allowed := range values {
+		if value == allowed {
+			*dest = value
+			return
+		}
+	}
+	r.fail(field, "must be one of %s", strings.Join(values, ", "))
+}
+
+// imported returns the record imported with an external ID, or nil if there
+// is none.
+func imported[T any](tx *gorm.DB, externalID string) (*T, error) {
+	var records []T
+	if err := tx.Where("external_id = ?", externalID).Limit(1).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+// reference returns the imported record whose external ID a field holds. It
+// is nil if the field is empty or, failing the row, if no such record was
+// imported.
+func reference[T any](tx *gorm.DB, r *row, field, kind string, required bool) (*T, error) {
+	externalID := r.text(field)
+	if externalID == "" {
+		if r.needs(field, required) {
+			r.fail(field, "is required")
+		}
+		return nil, nil
+	}
+	record, err := imported[T](tx, externalID)
+	if err == nil && record == nil {
+		r.fail(field, "no %s was imported with external ID %q", kind, externalID)
+	}
+	return record, err
+}
+
+// unique fails the row if another record than id has a value in a column.
+func unique(tx *gorm.DB, r *row, model any, field, column, value string, id uint) error {
+	if value == "" {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(model).Where(column+" = ? AND id <> ?", value, id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		r.fail(field, "%q is already taken", value)
+	}
+	return nil
+}
@@ -1,16 +1,123 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"time"
 
+	"invoxa/billing"
 	"invoxa/database"
+	"invoxa/events"
 	"invoxa/handlers"
+	"invoxa/notifications"
+	"invoxa/search"
+	"invoxa/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
 
+// runBillingJobs periodically renews subscriptions whose billing period has
+// ended, retries collection of past due invoices and reminds organizations of
+// upcoming renewals.
+func runBillingJobs(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx := context.Background()
+
+		renewals, err := billing.RenewSubscriptions(ctx, database.DB, handlers.PaymentProvider, time.Now())
+		if err != nil {
+			log.Printf("Subscription renewal failed: %v", err)
+		} else if renewals.Renewed > 0 || renewals.Failed > 0 {
+			log.Printf("Renewed %d subscriptions (%d charged, %d awaiting payment, %d failed)", renewals.Renewed, renewals.Charged, renewals.AwaitingPayment, renewals.Failed)
+		}
+
+		dunning, err := billing.RunDunning(ctx, database.DB, handlers.PaymentProvider, time.Now())
+		if err != nil {
+			log.Printf("Dunning failed: %v", err)
+		} else if dunning.Attempted > 0 {
+			log.Printf("Retried %d past due invoices (%d collected, %d uncollectible)", dunning.Attempted, dunning.Collected, dunning.Uncollectible)
+		}
+
+		expired, err := billing.ExpireAllCredits(database.DB, time.Now())
+		if err != nil {
+			log.Printf("Credit expiry failed: %v", err)
+		} else if expired > 0 {
+			log.Printf("Expired %d credit grants", expired)
+		}
+
+		reminded, err := notifications.SendRenewalReminders(ctx, database.DB, time.Now())
+		if err != nil {
+			log.Printf("Renewal reminders failed: %v", err)
+		} else if reminded > 0 {
+			log.Printf("Sent %d renewal reminders", reminded)
+		}
+	}
+}
+
+// runWebhookDeliveries sends queued webhook events and retries failed ones.
+func runWebhookDeliveries(interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := webhooks.DeliverDue(context.Background(), database.DB, time.Now())
+		if err != nil {
+			log.Printf("Webhook delivery failed: %v", err)
+		}
+		if report != nil && report.Attempted > 0 {
+			log.Printf("Sent %d webhook deliveries (%d succeeded, %d gave up)", report.Attempted, report.Succeeded, report.Failed)
+		}
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+
 	database.ConnectDatabase()
+	if err := search.CreateIndexes(database.DB); err != nil {
+		log.Fatalf("Failed to create search indexes: %v", err)
+	}
+
+	handlers.PaymentWebhookSecret = os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	handlers.AdminToken = os.Getenv("ADMIN_API_TOKEN")
+	billing.SellerCountry = os.Getenv("SELLER_COUNTRY")
+	if path := os.Getenv("TAX_RULES_FILE"); path != "" {
+		rules, err := billing.LoadTaxRules(path)
+		if err != nil {
+			log.Fatalf("Failed to load tax rules: %v", err)
+		}
+		billing.Calculator = rules
+	}
+
+	if path := os.Getenv("EXCHANGE_RATES_FILE"); path != "" {
+		loaded, err := billing.LoadExchangeRates(database.DB, path)
+		if err != nil {
+			log.Fatalf("Failed to load exchange rates: %v", err)
+		}
+		log.Printf("Loaded %d exchange rates from %s", loaded, path)
+	}
+
+	from := os.Getenv("MAIL_FROM")
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		notifications.Sender = &notifications.SMTPNotifier{
+			Addr:     addr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	} else if dir := os.Getenv("MAIL_OUTBOX_DIR"); dir != "" {
+		notifications.Sender = &notifications.FileNotifier{Dir: dir, From: from}
+	}
+
+	go runBillingJobs(time.Hour)
+	go runWebhookDeliveries(10 * time.Second)
+
+	dispatcher := events.NewDispatcher()
+	dispatcher.Subscribe("webhooks", webhooks.HandleEvent)
+	dispatcher.Subscribe("notifications", notifications.HandleEvent, notifications.EventTypes...)
+	go dispatcher.Run(context.Background(), database.DB, 2*time.Second)
 
 	r := gin.Default()
 
@@ -23,16 +130,88 @@ func main() {
 		authRequired.POST("/pay_invoice", handlers.PayInvoice)
 		authRequired.POST("/upgrade_plan", handlers.UpgradePlan)
 		authRequired.GET("/invoice/:id", handlers.GetInvoice)
+		authRequired.GET("/invoice/:id/pdf", handlers.GetInvoicePDF)
+		authRequired.GET("/invoice/:id/html", handlers.GetInvoicePage)
+		authRequired.GET("/invoice/:id/collection_attempts", handlers.GetCollectionAttempts)
+		authRequired.GET("/invoice/:id/revenue_schedule", handlers.GetRevenueSchedule)
+		authRequired.GET("/payment/:id", handlers.GetPayment)
+		authRequired.GET("/payment/:id/receipt", handlers.GetReceipt)
+		authRequired.GET("/payment/:id/receipt/pdf", handlers.GetReceiptPDF)
+		authRequired.GET("/payment/:id/receipt/html", handlers.GetReceiptPage)
 		authRequired.POST("/refund", handlers.Refund)
 		authRequired.GET("/user/:id/subscriptions", handlers.GetUserSubscriptions)
 		authRequired.POST("/subscription_plans", handlers.CreateSubscriptionPlan)
+		authRequired.GET("/exchange_rates", handlers.GetExchangeRate)
 
 		authRequired.GET("org/:id/summary", handlers.GetOrgSummary)
+		authRequired.GET("org/:id/credits", handlers.GetCreditBalance)
+		authRequired.GET("org/:id/dunning_policy", handlers.GetDunningPolicy)
+		authRequired.PUT("org/:id/dunning_policy", handlers.UpdateDunningPolicy)
+		authRequired.GET("org/:id/trial_balance", handlers.GetTrialBalance)
+		authRequired.GET("org/:id/ledger/check", handlers.CheckLedger)
+		authRequired.GET("org/:id/chart_of_accounts", handlers.GetChartOfAccounts)
+		authRequired.PUT("org/:id/chart_of_accounts", handlers.UpdateChartOfAccounts)
+		authRequired.PUT("org/:id/billing_details", handlers.UpdateBillingDetails)
+		authRequired.PUT("org/:id/invoice_numbering", handlers.UpdateInvoiceNumbering)
+		authRequired.GET("org/:id/branding", handlers.GetBranding)
+		authRequired.PUT("org/:id/branding", handlers.UpdateBranding)
+		authRequired.GET("org/:id/notifications", handlers.GetNotificationSettings)
+		authRequired.PUT("org/:id/notifications", handlers.UpdateNotificationSettings)
+		authRequired.GET("org/:id/sent_messages", handlers.GetSentMessages)
+		authRequired.GET("org/:id/templates", handlers.ListDocumentTemplates)
+		authRequired.PUT("org/:id/templates/:kind/:format", handlers.UpdateDocumentTemplate)
+		authRequired.DELETE("org/:id/templates/:kind/:format", handlers.DeleteDocumentTemplate)
+		authRequired.POST("org/:id/templates/:kind/:format/preview", handlers.PreviewDocumentTemplate)
+		authRequired.GET("org/:id/invoices", handlers.ListInvoices)
+		authRequired.GET("org/:id/invoices/by_number/:number", handlers.GetInvoiceByNumber)
+		authRequired.GET("org/:id/payments", handlers.ListPayments)
+		authRequired.GET("org/:id/refunds", handlers.ListRefunds)
+		authRequired.GET("org/:id/subscriptions", handlers.ListSubscriptions)
+		authRequired.GET("org/:id/ar_aging", handlers.GetOrgARAging)
+		authRequired.GET("org/:id/revenue_recognition", handlers.GetOrgRevenueRecognition)
+		authRequired.GET("org/:id/export/:dataset", handlers.ExportData)
+		authRequired.GET("org/:id/tax_rates", handlers.ListTaxRates)
+		authRequired.POST("org/:id/tax_rates", handlers.CreateTaxRate)
+		authRequired.DELETE("org/:id/tax_rates/:rate_id", handlers.DeleteTaxRate)
+		authRequired.GET("org/:id/webhook_endpoints", handlers.ListWebhookEndpoints)
+		authRequired.POST("org/:id/webhook_endpoints", handlers.CreateWebhookEndpoint)
+		authRequired.DELETE("org/:id/webhook_endpoints/:endpoint_id", handlers.DeleteWebhookEndpoint)
+		authRequired.GET("org/:id/webhook_endpoints/:endpoint_id/deliveries", handlers.GetWebhookDeliveries)
+		authRequired.POST("org/:id/webhook_deliveries/:delivery_id/resend", handlers.ResendWebhookDelivery)
+		authRequired.GET("org/:id/payment_methods", handlers.ListPaymentMethods)
+		authRequired.POST("org/:id/payment_methods", handlers.AddPaymentMethod)
+		authRequired.DELETE("org/:id/payment_methods/:method_id", handlers.RemovePaymentMethod)
+		authRequired.POST("org/:id/payment_methods/:method_id/default", handlers.SetDefaultPaymentMethod)
 	}
-	
+
 	r.POST("/users", handlers.CreateUser)
 	r.POST("/organizations", handlers.CreateOrganization)
-	r.POST("/admin/clear_db", handlers.ClearDatabase)
+
+	admin := r.Group("/admin")
+	admin.Use(handlers.AdminMiddleware())
+	{
+		admin.POST("/clear_db", handlers.ClearDatabase)
+		admin.POST("/run_renewals", handlers.RunRenewals)
+		admin.POST("/run_dunning", handlers.RunDunning)
+		admin.POST("/run_credit_expiry", handlers.RunCreditExpiry)
+		admin.POST("/exchange_rates", handlers.ImportExchangeRates)
+		admin.POST("/org/:id/credits", handlers.GrantCredit)
+		admin.GET("/org/:id/disputes", handlers.ListDisputes)
+		admin.GET("/org/:id/disputes/:dispute_id", handlers.GetDispute)
+		admin.PATCH("/org/:id/disputes/:dispute_id", handlers.UpdateDispute)
+		admin.GET("/search", handlers.Search)
+		admin.GET("/reports/mrr", handlers.GetMRRReport)
+		admin.GET("/reports/mrr_movements", handlers.GetMRRMovements)
+		admin.GET("/reports/cohorts", handlers.GetCohortReport)
+		admin.GET("/reports/ar_aging", handlers.GetARAging)
+		admin.GET("/reports/revenue_recognition", handlers.GetRevenueRecognition)
+		admin.GET("/export/:dataset", handlers.ExportAllData)
+		admin.POST("/imports/:entity", handlers.ImportData)
+		admin.GET("/import_runs/:id", handlers.GetImportRun)
+		admin.POST("/import_runs/:id/resume", handlers.ResumeImportRun)
+	}
+
+	r.POST("/webhooks/payments", handlers.ReceivePaymentWebhook)
 
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -41,4 +220,4 @@ func main() {
 	})
 
 	log.Fatal(r.Run(":8080"))
-}
\ No newline at end of file
+}
@@ -16,28 +16,45 @@ type User struct {
 	Invoices       []Invoice
 	Payments       []Payment
 	Refunds        []Refund
+	ExternalID     *string `gorm:"uniqueIndex"` // ID in the system the record was imported from
 }
 
 type Organization struct {
 	gorm.Model
-	Name              string `gorm:"unique;not null"`
-	BillingEmail      string `gorm:"not null"`
-	Users             []User
-	Subscriptions     []Subscription     
-	SubscriptionPlans []SubscriptionPlan 
-	Invoices          []Invoice
+	Name                string `gorm:"unique;not null"`
+	BillingEmail        string `gorm:"not null"`
+	ProviderCustomerID  string // customer reference at the payment provider
+	TaxID               string // e.g., an EU VAT number; business customers in other EU countries are reverse charged
+	AddressLine1        string
+	AddressLine2        string
+	City                string
+	Region              string // state, province or county
+	PostalCode          string
+	Country             string // ISO 3166-1 alpha-2 code
+	ReportingCurrency   string `gorm:"not null;default:'USD'"` // currency summaries are converted to
+	InvoicePrefix       string // replaces {prefix} in invoice numbers; defaults to 'INV'
+	InvoiceNumberFormat string // e.g., '{prefix}-{year}-{seq:6}', the default
+	NotificationOptOuts string // comma-separated notification types not emailed to BillingEmail
+	Users               []User
+	Subscriptions       []Subscription
+	SubscriptionPlans   []SubscriptionPlan
+	Invoices            []Invoice
+	ExternalID          *string `gorm:"uniqueIndex"`
 }
 
 type SubscriptionPlan struct {
 	gorm.Model
-	Name           string `gorm:"not null;uniqueIndex:idx_org_plan_name"` 
+	Name           string `gorm:"not null;uniqueIndex:idx_org_plan_name"`
 	Description    string
 	Price          float64 `gorm:"not null"`
 	Currency       string  `gorm:"not null;default:'USD'"`
-	Interval       string  `gorm:"not null;default:'monthly'"`             // e.g., 'monthly', 'yearly'
-	OrganizationID uint    `gorm:"not null;uniqueIndex:idx_org_plan_name"` 
+	Interval       string  `gorm:"not null;default:'monthly'"` // e.g., 'monthly', 'yearly'
+	TaxInclusive   bool    // Price already includes tax
+	TaxCode        string  // product tax code used to look up tax rules, e.g., 'saas'
+	OrganizationID uint    `gorm:"not null;uniqueIndex:idx_org_plan_name"`
 	Organization   Organization
 	Subscriptions  []Subscription
+	ExternalID     *string `gorm:"uniqueIndex"`
 }
 
 type Subscription struct {
@@ -46,50 +63,412 @@ type Subscription struct {
 	Organization       Organization
 	SubscriptionPlanID uint `gorm:"not null"`
 	SubscriptionPlan   SubscriptionPlan
+	UserID             uint      // user who started the subscription; renewal invoices are raised in their name
 	StartDate          time.Time `gorm:"not null"`
 	EndDate            time.Time
-	IsActive           bool `gorm:"default:true"`
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time  `gorm:"index"` // renewal is due once this passes
+	IsActive           bool       `gorm:"default:true"`
+	PausedAt           *time.Time // paused subscriptions stay active but do not renew
+	ExternalID         *string    `gorm:"uniqueIndex"`
 }
 
 type Invoice struct {
 	gorm.Model
-	OrganizationID uint `gorm:"not null"`
-	Organization   Organization
-	UserID         uint // user who triggered the invoice 
-	User           User
-	Amount         float64   `gorm:"not null"`
-	Currency       string    `gorm:"not null;default:'USD'"`
-	IssueDate      time.Time `gorm:"not null"`
-	DueDate        time.Time `gorm:"not null"`
-	Paid           bool      `gorm:"default:false"`
-	Payments       []Payment
-	Refunds        []Refund
+	OrganizationID   uint    `gorm:"not null;uniqueIndex:idx_invoice_number"`
+	Number           *string `gorm:"uniqueIndex:idx_invoice_number"` // sequential per organization, assigned at finalization
+	Organization     Organization
+	UserID           uint // user who triggered the invoice
+	User             User
+	SubscriptionID   *uint   // subscription the invoice bills for, if any
+	Amount           float64 `gorm:"not null"` // total including tax
+	Subtotal         float64 // amount before tax
+	TaxAmount        float64
+	TaxInclusive     bool       // the price the invoice was raised for included tax
+	TaxCode          string     // product tax code of what the invoice bills for
+	ReverseCharge    bool       // no tax charged; the customer accounts for VAT themselves
+	Currency         string     `gorm:"not null;default:'USD'"`
+	IssueDate        time.Time  `gorm:"not null"`
+	DueDate          time.Time  `gorm:"not null"`
+	PeriodStart      time.Time  // service period the invoice bills for; zero for one-off charges
+	PeriodEnd        time.Time  // exclusive
+	CreditApplied    float64    `gorm:"default:0"` // portion of Amount settled from the organization's credit balance
+	Paid             bool       `gorm:"default:false"`
+	Uncollectible    bool       `gorm:"default:false"` // written off after collection failed
+	NextCollectionAt *time.Time // when the next automatic collection attempt is scheduled
+	TaxLines         []InvoiceTaxLine
+	TaxCalculation   *TaxCalculation
+	Payments         []Payment
+	Refunds          []Refund
+	ExternalID       *string `gorm:"uniqueIndex"`
 }
 
 type Payment struct {
 	gorm.Model
-	InvoiceID     uint `gorm:"not null"`
-	Invoice       Invoice
-	UserID        uint // user who made the payment
-	User          User
-	Amount        float64   `gorm:"not null"`
-	Currency      string    `gorm:"not null;default:'USD'"`
-	PaymentDate   time.Time `gorm:"not null"`
-	TransactionID string    `gorm:"unique;not null"`
-	PaymentMethod string
+	InvoiceID        uint `gorm:"not null"`
+	Invoice          Invoice
+	UserID           uint // user who made the payment
+	User             User
+	Amount           float64   `gorm:"not null"`
+	Currency         string    `gorm:"not null;default:'USD'"`
+	PaymentDate      time.Time `gorm:"not null"`
+	TransactionID    string    `gorm:"unique;not null"`
+	PaymentMethod    string
+	PaymentMethodID  *uint  // stored payment method that was charged, if any
+	Provider         string // payment provider that processed the charge
+	ProviderChargeID string `gorm:"index"`
+	ProviderStatus   string
+	ChargedAmount    float64 // amount taken in ChargedCurrency when the payment was made in another currency than the invoice
+	ChargedCurrency  string
+	ExchangeRate     float64 // units of the invoice currency per unit of ChargedCurrency
+	ExternalID       *string `gorm:"uniqueIndex"`
 }
 
 type Refund struct {
 	gorm.Model
-	InvoiceID     uint `gorm:"not null"`
-	Invoice       Invoice
-	PaymentID     uint 
-	Payment       Payment
-	UserID        uint 
-	User          User
-	Amount        float64   `gorm:"not null"`
-	Currency      string    `gorm:"not null;default:'USD'"`
-	RefundDate    time.Time `gorm:"not null"`
-	TransactionID string    `gorm:"unique;not null"`
-	Reason        string
+	InvoiceID        uint `gorm:"not null"`
+	Invoice          Invoice
+	PaymentID        uint
+	Payment          Payment
+	UserID           uint
+	User             User
+	Amount           float64   `gorm:"not null"`
+	Currency         string    `gorm:"not null;default:'USD'"`
+	RefundDate       time.Time `gorm:"not null"`
+	TransactionID    string    `gorm:"unique;not null"`
+	Reason           string
+	Provider         string // payment provider that processed the refund
+	ProviderRefundID string `gorm:"index"`
+	ProviderStatus   string
+}
+
+type CreditEntry struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;index"`
+	Organization   Organization
+	Type           string  `gorm:"not null"` // 'grant', 'application', 'expiration' or 'reversal'
+	Amount         float64 `gorm:"not null"` // positive for grants, negative otherwise
+	Currency       string  `gorm:"not null;default:'USD'"`
+	Remaining      float64 // unconsumed portion of a grant
+	ExpiresAt      *time.Time
+	GrantID        *uint // grant drawn down by an application or expiration
+	InvoiceID      *uint // invoice that produced or consumed the credit
+	Description    string
+}
+
+type JournalEntry struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;index"`
+	Organization   Organization
+	SourceType     string `gorm:"not null;index:idx_journal_source"` // 'invoice', 'payment', 'refund' or 'credit'
+	SourceID       uint   `gorm:"not null;index:idx_journal_source"`
+	Description    string
+	PostedAt       time.Time `gorm:"not null"`
+	Lines          []JournalLine
+}
+
+type JournalLine struct {
+	gorm.Model
+	JournalEntryID uint    `gorm:"not null;index"`
+	OrganizationID uint    `gorm:"not null;index"`
+	Account        string  `gorm:"not null"` // e.g., 'accounts_receivable', 'revenue', 'cash'
+	Currency       string  `gorm:"not null;default:'USD'"`
+	Debit          float64 `gorm:"not null;default:0"`
+	Credit         float64 `gorm:"not null;default:0"`
+}
+
+type PaymentMethod struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;index"`
+	Organization   Organization
+	Type           string `gorm:"not null"` // e.g., 'card', 'bank_account'
+	Brand          string
+	Last4          string
+	ExpMonth       int
+	ExpYear        int
+	ProviderToken  string `gorm:"not null"`
+	IsDefault      bool   `gorm:"default:false"`
+}
+
+type DunningPolicy struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;uniqueIndex"`
+	Organization   Organization
+	RetryDays      string `gorm:"not null;default:'1,3,7'"`  // days after the due date to retry collection, e.g., '1,3,7'
+	FinalAction    string `gorm:"not null;default:'cancel'"` // 'cancel' or 'pause' the subscription once retries are exhausted
+	SendReminders  bool   `gorm:"default:true"`
+}
+
+type CollectionAttempt struct {
+	gorm.Model
+	InvoiceID      uint `gorm:"not null;index"`
+	Invoice        Invoice
+	OrganizationID uint      `gorm:"not null;index"`
+	AttemptNumber  int       `gorm:"not null"`
+	AttemptedAt    time.Time `gorm:"not null"`
+	Succeeded      bool
+	PaymentID      *uint
+	FailureCode    string // e.g., 'card_declined', 'timeout', 'no_payment_method'
+	FailureMessage string
+	NextAttemptAt  *time.Time
+	ReminderSent   bool
+	Final          bool // no further attempts follow
+}
+
+type ProviderEvent struct {
+	gorm.Model
+	Provider   string    `gorm:"not null"`
+	EventID    string    `gorm:"not null;uniqueIndex"` // provider's event ID, used to ignore redeliveries
+	Type       string    `gorm:"not null"`             // e.g., 'payment.succeeded', 'payment.refunded'
+	Outcome    string    `gorm:"not null"`             // 'processed' or 'ignored'
+	Detail     string    // what the event changed, or why it was ignored
+	ReceivedAt time.Time `gorm:"not null"`
+}
+
+type WebhookEndpoint struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;index"`
+	Organization   Organization
+	URL            string `gorm:"not null"`
+	Secret         string `gorm:"not null" json:"-"` // signing secret, only shown when the endpoint is created
+	Events         string // comma-separated event types to deliver; empty means all
+	Active         bool   `gorm:"default:true"`
+}
+
+type WebhookDelivery struct {
+	gorm.Model
+	EndpointID     uint   `gorm:"not null;index"`
+	OrganizationID uint   `gorm:"not null;index"`
+	EventID        string `gorm:"not null;index"`
+	EventType      string `gorm:"not null"`
+	Payload        string `gorm:"type:text;not null"`
+	Status         string `gorm:"not null;default:'pending'"` // 'pending', 'succeeded' or 'failed'
+	Attempts       int
+	NextAttemptAt  *time.Time `gorm:"index"` // nil once the delivery succeeded or gave up
+	DeliveredAt    *time.Time
+	LastStatusCode int
+	LastError      string
+	Log            []WebhookAttempt `gorm:"foreignKey:DeliveryID"`
+}
+
+type WebhookAttempt struct {
+	gorm.Model
+	DeliveryID   uint      `gorm:"not null;index"`
+	AttemptedAt  time.Time `gorm:"not null"`
+	StatusCode   int       // 0 when no response was received
+	Error        string
+	DurationMs   int64
+	ResponseBody string // truncated
+}
+
+type OutboxEvent struct {
+	gorm.Model
+	EventID        string    `gorm:"not null;uniqueIndex"`
+	OrganizationID uint      `gorm:"not null;index"`
+	Type           string    `gorm:"not null"` // e.g., 'invoice.created', 'subscription.renewed'
+	Payload        string    `gorm:"type:text;not null"`
+	OccurredAt     time.Time `gorm:"not null"`
+	Status         string    `gorm:"not null;default:'pending';index"` // 'pending', 'dispatched' or 'failed'
+	Attempts       int
+	NextAttemptAt  *time.Time
+	LastError      string
+	DispatchedAt   *time.Time
+}
+
+type EventHandling struct {
+	gorm.Model
+	OutboxEventID uint      `gorm:"not null;uniqueIndex:idx_event_subscriber"`
+	Subscriber    string    `gorm:"not null;uniqueIndex:idx_event_subscriber"`
+	HandledAt     time.Time `gorm:"not null"`
+}
+
+type Dispute struct {
+	gorm.Model
+	OrganizationID                uint `gorm:"not null;index"`
+	PaymentID                     uint `gorm:"not null;index"`
+	Payment                       Payment
+	InvoiceID                     uint    `gorm:"not null"`
+	ProviderDisputeID             string  `gorm:"index"`
+	Amount                        float64 `gorm:"not null"`
+	Currency                      string  `gorm:"not null;default:'USD'"`
+	Fee                           float64 // charged by the provider, not returned if the dispute is won
+	Reason                        string  // e.g., 'fraudulent', 'product_not_received'
+	Status                        string  `gorm:"not null;default:'needs_response';index"` // 'needs_response', 'under_review', 'won' or 'lost'
+	EvidenceDueBy                 *time.Time
+	EvidenceExplanation           string `gorm:"type:text"`
+	EvidenceCustomerCommunication string `gorm:"type:text"`
+	EvidenceServiceDocumentation  string `gorm:"type:text"`
+	EvidenceSubmittedAt           *time.Time
+	ResolvedAt                    *time.Time
+}
+
+type TaxRate struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;index"`
+	Organization   Organization
+	Name           string  `gorm:"not null"` // e.g., 'VAT', 'GST', 'CA state sales tax'
+	Country        string  `gorm:"not null"` // ISO 3166-1 alpha-2 code
+	Region         string  // empty for rates that apply to the whole country
+	Rate           float64 `gorm:"not null"` // percentage, e.g., 19 for 19%
+}
+
+type InvoiceTaxLine struct {
+	gorm.Model
+	InvoiceID     uint `gorm:"not null;index"`
+	TaxRateID     *uint
+	Name          string `gorm:"not null"`
+	Country       string
+	Region        string
+	Rate          float64 // percentage
+	TaxableAmount float64
+	Amount        float64
+}
+
+// TaxCalculation records what a tax calculator was asked and what it answered
+// when an invoice was finalized.
+type TaxCalculation struct {
+	gorm.Model
+	InvoiceID  uint   `gorm:"not null;uniqueIndex"`
+	Calculator string `gorm:"not null"`
+	Request    string `gorm:"type:text"` // JSON
+	Result     string `gorm:"type:text"` // JSON
+}
+
+// ExchangeRate is the price of one unit of BaseCurrency in QuoteCurrency from
+// EffectiveDate until a later rate for the pair takes over.
+type ExchangeRate struct {
+	gorm.Model
+	BaseCurrency  string    `gorm:"not null;uniqueIndex:idx_exchange_rate"`
+	QuoteCurrency string    `gorm:"not null;uniqueIndex:idx_exchange_rate"`
+	EffectiveDate time.Time `gorm:"not null;uniqueIndex:idx_exchange_rate"`
+	Rate          float64   `gorm:"not null"`
+	Source        string    // e.g., 'api', 'file'
+}
+
+// InvoiceSequence is the last invoice number issued by an organization in a
+// numbering period (a year or month when the number format includes one).
+// Receipts are counted in their own periods, prefixed with 'receipt:'.
+type InvoiceSequence struct {
+	gorm.Model
+	OrganizationID uint   `gorm:"not null;uniqueIndex:idx_invoice_sequence"`
+	Period         string `gorm:"not null;uniqueIndex:idx_invoice_sequence"`
+	LastNumber     int64  `gorm:"not null"`
+}
+
+// OrganizationBranding customizes the invoice documents of an organization.
+type OrganizationBranding struct {
+	gorm.Model
+	OrganizationID uint   `gorm:"not null;uniqueIndex"`
+	Logo           []byte `json:"-"`
+	LogoType       string // 'png' or 'jpg'
+	PrimaryColor   string // hex, e.g., '#1f2937'
+	AccentColor    string // hex
+	FooterText     string
+}
+
+// DocumentTemplate replaces one of the default document templates for an
+// organization.
+type DocumentTemplate struct {
+	gorm.Model
+	OrganizationID uint   `gorm:"not null;uniqueIndex:idx_document_template"`
+	Kind           string `gorm:"not null;uniqueIndex:idx_document_template"` // 'invoice', 'receipt' or 'credit_note'
+	Format         string `gorm:"not null;uniqueIndex:idx_document_template"` // 'html' or 'text'
+	Body           string `gorm:"type:text;not null"`
+}
+
+// SentMessage logs an email sent to an organization's billing contact.
+type SentMessage struct {
+	gorm.Model
+	OrganizationID uint      `gorm:"not null;index"`
+	Type           string    `gorm:"not null"`             // notification type, e.g., 'invoice_created'
+	DedupKey       string    `gorm:"not null;uniqueIndex"` // identifies what the message is about, so it is sent once
+	Recipient      string    `gorm:"not null"`
+	Subject        string    `gorm:"not null"`
+	Notifier       string    // e.g., 'smtp' or 'file'
+	SentAt         time.Time `gorm:"not null"`
+}
+
+// Receipt confirms a payment. It keeps the payment as it stood when it was
+// received, including the balance then left on the invoice.
+type Receipt struct {
+	gorm.Model
+	OrganizationID   uint   `gorm:"not null;uniqueIndex:idx_receipt_number"`
+	Number           string `gorm:"not null;uniqueIndex:idx_receipt_number"`
+	PaymentID        uint   `gorm:"not null;uniqueIndex"`
+	Payment          Payment
+	InvoiceID        uint `gorm:"not null;index"`
+	InvoiceNumber    string
+	Payer            string  // user who paid, or the organization for automatic collection
+	Amount           float64 `gorm:"not null"` // in the invoice currency
+	Currency         string  `gorm:"not null"`
+	ChargedAmount    float64 // amount taken in ChargedCurrency when the payment was made in another currency
+	ChargedCurrency  string
+	PaymentMethod    string    // e.g., 'Visa ending in 4242'
+	RemainingBalance float64   // still due on the invoice after this payment
+	IssuedAt         time.Time `gorm:"not null"`
+}
+
+// RevenueSchedule spreads the revenue of an invoice, its amount before tax,
+// over the service period it bills for.
+type RevenueSchedule struct {
+	gorm.Model
+	InvoiceID      uint `gorm:"not null;uniqueIndex"`
+	Invoice        Invoice
+	OrganizationID uint    `gorm:"not null;index"`
+	SubscriptionID *uint   `gorm:"index"`
+	Currency       string  `gorm:"not null"`
+	Amount         float64 `gorm:"not null"`
+	PeriodStart    time.Time
+	PeriodEnd      time.Time
+	EndedAt        *time.Time // the service stopped early, e.g., on a plan change
+	Lines          []RevenueScheduleLine
+}
+
+// RevenueScheduleLine is revenue recognized in a month. Lines are never
+// changed; refunds and plan changes add lines moving what is left of the
+// schedule.
+type RevenueScheduleLine struct {
+	gorm.Model
+	RevenueScheduleID uint      `gorm:"not null;index"`
+	Month             time.Time `gorm:"not null;index"` // first day of the month
+	Amount            float64   `gorm:"not null"`
+	Reason            string    // 'invoice', 'refund' or 'plan_change'
+	SourceID          uint      // refund or subscription behind an adjustment
+	BookedAt          time.Time `gorm:"not null;index"`
+}
+
+// AccountMapping gives a journal account the code and name it has in an
+// organization's accounting system, for accounting exports.
+type AccountMapping struct {
+	gorm.Model
+	OrganizationID uint   `gorm:"not null;uniqueIndex:idx_account_mapping"`
+	Account        string `gorm:"not null;uniqueIndex:idx_account_mapping"` // e.g., 'revenue'
+	Code           string `gorm:"not null"`
+	Name           string `gorm:"not null"`
+}
+
+// ImportRun is a bulk import of one file of records. Rows are applied in
+// order, and the run records how many have been handled so that an
+// interrupted run resumes where it stopped.
+type ImportRun struct {
+	gorm.Model
+	Entity        string `gorm:"not null"` // 'organizations', 'users', 'plans', 'subscriptions', 'invoices' or 'payments'
+	Format        string `gorm:"not null"` // 'csv' or 'json'
+	Data          []byte `gorm:"not null"`
+	Status        string `gorm:"not null;index"` // 'running', 'interrupted', 'failed' or 'completed'
+	Error         string // why the run stopped, if it failed
+	TotalRows     int
+	ProcessedRows int
+	Created       int
+	Updated       int
+	Failed        int // rows skipped for errors
+	Errors        []ImportError
+}
+
+type ImportError struct {
+	gorm.Model
+	ImportRunID uint   `gorm:"not null;index"`
+	Row         int    `gorm:"not null"` // 1-based, not counting a CSV header
+	Field       string // empty for errors about the whole row
+	Message     string `gorm:"not null"`
 }
@@ -0,0 +1,320 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/documents"
+	"invoxa/events"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// Notification types. Organizations can opt out of each one.
+const (
+	InvoiceCreated   = "invoice_created"
+	PaymentSucceeded = "payment_succeeded"
+	PaymentFailed    = "payment_failed" // collection attempts, including dunning reminders, and reversed payments
+	RefundIssued     = "refund_issued"
+	RenewalUpcoming  = "renewal_upcoming"
+)
+
+var Types = []string{InvoiceCreated, PaymentSucceeded, PaymentFailed, RefundIssued, RenewalUpcoming}
+
+// EventTypes lists the events HandleEvent sends emails for.
+var EventTypes = []string{
+	events.InvoiceCreated,
+	events.PaymentSucceeded,
+	events.CollectionFailed,
+	events.PaymentFailed,
+	events.PaymentRefunded,
+}
+
+// RenewalNotice is how long before a subscription renews its organization is
+// reminded.
+var RenewalNotice = 7 * 24 * time.Hour
+
+// Sender delivers every notification.
+var Sender Notifier = LogNotifier{}
+
+// ValidTypes checks a list of notification types.
+func ValidTypes(types []string) error {
+	for _, notificationType := range types {
+		if !knownType(notificationType) {
+			return fmt.Errorf("unknown notification type %q", notificationType)
+		}
+	}
+	return nil
+}
+
+func knownType(notificationType string) bool {
+	for _, known := range Types {
+		if notificationType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// OptOuts lists the notification types an organization does not receive.
+func OptOuts(org *models.Organization) []string {
+	optOuts := []string{}
+	for _, notificationType := range strings.Split(org.NotificationOptOuts, ",") {
+		if notificationType = strings.TrimSpace(notificationType); notificationType != "" {
+			optOuts = append(optOuts, notificationType)
+		}
+	}
+	return optOuts
+}
+
+func wants(org *models.Organization, notificationType string) bool {
+	if org.BillingEmail == "" {
+		return false
+	}
+	for _, optOut := range OptOuts(org) {
+		if optOut == notificationType {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver sends a message to the organization's billing contact and logs it
+// under key. A message already logged under the same key is not sent again,
+// and false is returned.
+func deliver(ctx context.Context, db *gorm.DB, org *models.Organization, notificationType, key string, msg *Message) (bool, error) {
+	var sent int64
+	if err := db.Model(&models.SentMessage{}).Where("dedup_key = ?", key).Count(&sent).Error; err != nil {
+		return false, fmt.Errorf("failed to check sent messages: %w", err)
+	}
+	if sent > 0 {
+		return false, nil
+	}
+
+	msg.To = org.BillingEmail
+	if err := Sender.Send(ctx, msg); err != nil {
+		return false, fmt.Errorf("failed to send %s email to organization %d: %w", notificationType, org.ID, err)
+	}
+	err := db.Create(&models.SentMessage{
+		OrganizationID: org.ID,
+		Type:           notificationType,
+		DedupKey:       key,
+		Recipient:      msg.To,
+		Subject:        msg.Subject,
+		Notifier:       Sender.Name(),
+		SentAt:         time.Now(),
+	}).Error
+	return err == nil, err
+}
+
+// documentMessage renders a billing document with the organization's text and
+// HTML templates.
+func documentMessage(db *gorm.DB, organizationID uint, kind string, doc any, subject string) (*Message, error) {
+	text, err := documents.Render(db, organizationID, kind, documents.FormatText, doc)
+	if err != nil {
+		return nil, err
+	}
+	html, err := documents.Render(db, organizationID, kind, documents.FormatHTML, doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Subject: subject, Text: text, HTML: html}, nil
+}
+
+func formatAmount(currency string, amount float64) string {
+	return fmt.Sprintf("%s %.2f", currency, amount)
+}
+
+// HandleEvent is the event bus subscriber that emails billing contacts about
+// new invoices, payments, failed collections and refunds.
+func HandleEvent(ctx context.Context, tx *gorm.DB, event *events.Event) error {
+	var org models.Organization
+	if err := tx.First(&org, event.OrganizationID).Error; err != nil {
+		return fmt.Errorf("failed to load organization %d: %w", event.OrganizationID, err)
+	}
+
+	switch event.Type {
+	case events.InvoiceCreated:
+		return notifyInvoiceCreated(ctx, tx, &org, event)
+	case events.PaymentSucceeded:
+		return notifyPaymentSucceeded(ctx, tx, &org, event)
+	case events.CollectionFailed:
+		return notifyCollectionFailed(ctx, tx, &org, event)
+	case events.PaymentFailed:
+		return notifyPaymentReversed(ctx, tx, &org, event)
+	case events.PaymentRefunded:
+		return notifyRefund(ctx, tx, &org, event)
+	}
+	return nil
+}
+
+func notifyInvoiceCreated(ctx context.Context, tx *gorm.DB, org *models.Organization, event *events.Event) error {
+	if !wants(org, InvoiceCreated) {
+		return nil
+	}
+	var invoice models.Invoice
+	if err := event.Decode(&invoice); err != nil {
+		return err
+	}
+	doc, err := documents.LoadInvoice(tx, invoice.ID, event.OccurredAt)
+	if err != nil {
+		return err
+	}
+	msg, err := documentMessage(tx, org.ID, documents.KindInvoice, doc, "Invoice "+doc.Number)
+	if err != nil {
+		return err
+	}
+	_, err = deliver(ctx, tx, org, InvoiceCreated, event.ID, msg)
+	return err
+}
+
+func notifyPaymentSucceeded(ctx context.Context, tx *gorm.DB, org *models.Organization, event *events.Event) error {
+	if !wants(org, PaymentSucceeded) {
+		return nil
+	}
+	var payment models.Payment
+	if err := event.Decode(&payment); err != nil {
+		return err
+	}
+	doc, err := documents.LoadReceipt(tx, payment.ID)
+	if err != nil {
+		return err
+	}
+	msg, err := documentMessage(tx, org.ID, documents.KindReceipt, doc, fmt.Sprintf("Receipt %s for invoice %s", doc.Number, doc.InvoiceNumber))
+	if err != nil {
+		return err
+	}
+	_, err = deliver(ctx, tx, org, PaymentSucceeded, event.ID, msg)
+	return err
+}
+
+// notifyCollectionFailed sends the dunning reminder for a failed collection
+// attempt, if the organization's dunning policy asks for reminders.
+func notifyCollectionFailed(ctx context.Context, tx *gorm.DB, org *models.Organization, event *events.Event) error {
+	if !wants(org, PaymentFailed) {
+		return nil
+	}
+	var attempt models.CollectionAttempt
+	if err := event.Decode(&attempt); err != nil {
+		return err
+	}
+	policy, err := billing.DunningPolicyFor(tx, org.ID)
+	if err != nil {
+		return err
+	}
+	if !policy.SendReminders {
+		return nil
+	}
+	var invoice models.Invoice
+	if err := tx.First(&invoice, attempt.InvoiceID).Error; err != nil {
+		return fmt.Errorf("failed to load invoice %d: %w", attempt.InvoiceID, err)
+	}
+
+	number := billing.InvoiceNumber(&invoice)
+	due := formatAmount(invoice.Currency, billing.AmountDue(&invoice))
+	reason := attempt.FailureMessage
+	if reason == "" {
+		reason = attempt.FailureCode
+	}
+	msg := &Message{Subject: "Payment failed for invoice " + number}
+	if attempt.Final {
+		msg.Subject = fmt.Sprintf("Invoice %s is uncollectible", number)
+		msg.Text = fmt.Sprintf("We could not collect payment of %s for invoice %s after %d attempts, so the invoice has been marked uncollectible.\n\nPlease contact us to settle the balance.\n",
+			due, number, attempt.AttemptNumber)
+	} else {
+		msg.Text = fmt.Sprintf("We could not collect payment of %s for invoice %s: %s.\n\nWe will try again on %s. Please check that your payment method is up to date.\n",
+			due, number, reason, attempt.NextAttemptAt.Format("2006-01-02"))
+	}
+	if _, err := deliver(ctx, tx, org, PaymentFailed, event.ID, msg); err != nil {
+		return err
+	}
+	return tx.Model(&models.CollectionAttempt{}).Where("id = ?", attempt.ID).Update("reminder_sent", true).Error
+}
+
+// notifyPaymentReversed tells the organization that a payment failed after
+// it had been accepted, which reopened its invoice.
+func notifyPaymentReversed(ctx context.Context, tx *gorm.DB, org *models.Organization, event *events.Event) error {
+	if !wants(org, PaymentFailed) {
+		return nil
+	}
+	var payment models.Payment
+	if err := event.Decode(&payment); err != nil {
+		return err
+	}
+	var invoice models.Invoice
+	if err := tx.First(&invoice, payment.InvoiceID).Error; err != nil {
+		return fmt.Errorf("failed to load invoice %d: %w", payment.InvoiceID, err)
+	}
+
+	number := billing.InvoiceNumber(&invoice)
+	msg := &Message{
+		Subject: "Payment failed for invoice " + number,
+		Text: fmt.Sprintf("Your payment of %s for invoice %s has failed, and the invoice is open again with %s due.\n",
+			formatAmount(payment.Currency, payment.Amount), number, formatAmount(invoice.Currency, billing.AmountDue(&invoice))),
+	}
+	_, err := deliver(ctx, tx, org, PaymentFailed, event.ID, msg)
+	return err
+}
+
+func notifyRefund(ctx context.Context, tx *gorm.DB, org *models.Organization, event *events.Event) error {
+	if !wants(org, RefundIssued) {
+		return nil
+	}
+	var refund models.Refund
+	if err := event.Decode(&refund); err != nil {
+		return err
+	}
+	doc, err := documents.LoadCreditNote(tx, refund.ID)
+	if err != nil {
+		return err
+	}
+	msg, err := documentMessage(tx, org.ID, documents.KindCreditNote, doc, "Refund for invoice "+doc.InvoiceNumber)
+	if err != nil {
+		return err
+	}
+	_, err = deliver(ctx, tx, org, RefundIssued, event.ID, msg)
+	return err
+}
+
+// SendRenewalReminders reminds organizations of subscriptions that renew
+// within RenewalNotice. Each renewal is announced once; reminders that fail
+// to send are tried again on the next run.
+func SendRenewalReminders(ctx context.Context, db *gorm.DB, now time.Time) (int, error) {
+	var subscriptions []models.Subscription
+	err := db.Preload("SubscriptionPlan").Preload("Organization").
+		Where("is_active = ? AND paused_at IS NULL AND current_period_end > ? AND current_period_end <= ?", true, now, now.Add(RenewalNotice)).
+		Find(&subscriptions).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load upcoming renewals: %w", err)
+	}
+
+	sent := 0
+	for _, subscription := range subscriptions {
+		org := subscription.Organization
+		if !wants(&org, RenewalUpcoming) {
+			continue
+		}
+		plan := subscription.SubscriptionPlan
+		renewsOn := subscription.CurrentPeriodEnd.Format("2006-01-02")
+		msg := &Message{
+			Subject: fmt.Sprintf("Your %s subscription renews on %s", plan.Name, renewsOn),
+			Text: fmt.Sprintf("Your %s subscription renews on %s for %s (%s).\n\nThe renewal invoice will be charged to your default payment method.\n",
+				plan.Name, renewsOn, formatAmount(plan.Currency, plan.Price), plan.Interval),
+		}
+		key := fmt.Sprintf("renewal:%d:%s", subscription.ID, renewsOn)
+		delivered, err := deliver(ctx, db, &org, RenewalUpcoming, key, msg)
+		if err != nil {
+			log.Printf("Failed to send renewal reminder for subscription %d: %v", subscription.ID, err)
+			continue
+		}
+		if delivered {
+			sent++
+		}
+	}
+	return sent, nil
+}
@@ -0,0 +1,238 @@
+package notifications
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupNotificationsDB(t *testing.T) (*gorm.DB, *models.Organization, *Outbox) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(database.Models...))
+
+	org := models.Organization{Name: "Test Org", BillingEmail: "billing@test.org"}
+	assert.NoError(t, db.Create(&org).Error)
+
+	outbox := &Outbox{}
+	original := Sender
+	Sender = outbox
+	t.Cleanup(func() { Sender = original })
+	return db, &org, outbox
+}
+
+func dispatch(t *testing.T, db *gorm.DB) {
+	dispatcher := events.NewDispatcher()
+	dispatcher.Subscribe("notifications", HandleEvent, EventTypes...)
+	report, err := dispatcher.DispatchPending(context.Background(), db, time.Now())
+	assert.NoError(t, err)
+	assert.Zero(t, report.Retrying)
+}
+
+func TestInvoiceAndReceiptEmails(t *testing.T) {
+	db, org, outbox := setupNotificationsDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorSucceed)
+	ctx := context.Background()
+
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 25, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now().AddDate(0, 0, 30)}
+	assert.NoError(t, billing.FinalizeInvoice(db, &invoice))
+	_, err := billing.CollectInvoice(ctx, db, provider, &invoice, billing.CollectParams{Token: "pm_card_visa"})
+	assert.NoError(t, err)
+	dispatch(t, db)
+
+	messages := outbox.Messages()
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "billing@test.org", messages[0].To)
+	assert.Equal(t, "Invoice "+*invoice.Number, messages[0].Subject)
+	assert.Contains(t, messages[0].Text, "Total: USD 25.00")
+	assert.Contains(t, messages[0].HTML, "<!DOCTYPE html>")
+	assert.Equal(t, "Receipt RCPT-"+time.Now().Format("2006")+"-000001 for invoice "+*invoice.Number, messages[1].Subject)
+	assert.Contains(t, messages[1].Text, "We received your payment of USD 25.00")
+
+	var sent []models.SentMessage
+	db.Where("organization_id = ?", org.ID).Order("id").Find(&sent)
+	assert.Len(t, sent, 2)
+	assert.Equal(t, InvoiceCreated, sent[0].Type)
+	assert.Equal(t, "outbox", sent[0].Notifier)
+
+	// Opted-out notifications are not sent.
+	db.Model(org).Update("notification_opt_outs", PaymentSucceeded)
+	second := models.Invoice{OrganizationID: org.ID, Amount: 10, Currency: "USD", IssueDate: time.Now(), DueDate: time.Now()}
+	assert.NoError(t, billing.FinalizeInvoice(db, &second))
+	_, err = billing.CollectInvoice(ctx, db, provider, &second, billing.CollectParams{Token: "pm_card_visa"})
+	assert.NoError(t, err)
+	dispatch(t, db)
+	messages = outbox.Messages()
+	assert.Len(t, messages, 3)
+	assert.Equal(t, "Invoice "+*second.Number, messages[2].Subject)
+}
+
+func TestDunningReminderEmails(t *testing.T) {
+	db, org, outbox := setupNotificationsDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorDecline)
+	db.Create(&models.PaymentMethod{OrganizationID: org.ID, Type: "card", ProviderToken: "tok_visa", IsDefault: true})
+	db.Create(&models.DunningPolicy{OrganizationID: org.ID, RetryDays: "1,3", FinalAction: billing.DunningActionCancel, SendReminders: true})
+	db.Model(org).Update("notification_opt_outs", InvoiceCreated)
+
+	dueDate := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 40, Currency: "USD", IssueDate: dueDate, DueDate: dueDate}
+	assert.NoError(t, billing.FinalizeInvoice(db, &invoice))
+
+	_, err := billing.RunDunning(context.Background(), db, provider, dueDate.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	_, err = billing.RunDunning(context.Background(), db, provider, dueDate.AddDate(0, 0, 3))
+	assert.NoError(t, err)
+	dispatch(t, db)
+
+	messages := outbox.Messages()
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "Payment failed for invoice "+*invoice.Number, messages[0].Subject)
+	assert.Contains(t, messages[0].Text, "We will try again on 2026-03-04")
+	assert.Empty(t, messages[0].HTML)
+	assert.Equal(t, "Invoice "+*invoice.Number+" is uncollectible", messages[1].Subject)
+
+	var attempts []models.CollectionAttempt
+	db.Where("invoice_id = ?", invoice.ID).Order("attempt_number").Find(&attempts)
+	assert.Len(t, attempts, 2)
+	assert.True(t, attempts[0].ReminderSent)
+	assert.True(t, attempts[1].ReminderSent)
+}
+
+func TestDunningRemindersFollowPolicy(t *testing.T) {
+	db, org, outbox := setupNotificationsDB(t)
+	provider := payments.NewFakeProvider(payments.BehaviorDecline)
+	db.Create(&models.PaymentMethod{OrganizationID: org.ID, Type: "card", ProviderToken: "tok_visa", IsDefault: true})
+	db.Create(&models.DunningPolicy{OrganizationID: org.ID, RetryDays: "1,3", FinalAction: billing.DunningActionCancel})
+	db.Model(&models.DunningPolicy{}).Where("organization_id = ?", org.ID).Update("send_reminders", false)
+	db.Model(org).Update("notification_opt_outs", InvoiceCreated)
+
+	dueDate := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := models.Invoice{OrganizationID: org.ID, Amount: 40, Currency: "USD", IssueDate: dueDate, DueDate: dueDate}
+	assert.NoError(t, billing.FinalizeInvoice(db, &invoice))
+	_, err := billing.RunDunning(context.Background(), db, provider, dueDate.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	dispatch(t, db)
+
+	assert.Empty(t, outbox.Messages())
+}
+
+func TestSendRenewalReminders(t *testing.T) {
+	db, org, outbox := setupNotificationsDB(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	plan := models.SubscriptionPlan{Name: "Pro", Price: 49, Currency: "EUR", Interval: "monthly", OrganizationID: org.ID}
+	db.Create(&plan)
+	soon := models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, StartDate: now.AddDate(0, -1, 3), IsActive: true,
+		CurrentPeriodStart: now.AddDate(0, -1, 3), CurrentPeriodEnd: now.AddDate(0, 0, 3)}
+	later := models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, StartDate: now, IsActive: true,
+		CurrentPeriodStart: now, CurrentPeriodEnd: now.AddDate(0, 1, 0)}
+	db.Create(&soon)
+	db.Create(&later)
+
+	sent, err := SendRenewalReminders(context.Background(), db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	messages := outbox.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "Your Pro subscription renews on 2026-03-04", messages[0].Subject)
+	assert.Contains(t, messages[0].Text, "EUR 49.00 (monthly)")
+
+	// Each renewal is announced once.
+	sent, err = SendRenewalReminders(context.Background(), db, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Zero(t, sent)
+
+	db.Model(org).Update("notification_opt_outs", RenewalUpcoming)
+	db.Model(&later).Update("current_period_end", now.AddDate(0, 0, 5))
+	sent, err = SendRenewalReminders(context.Background(), db, now)
+	assert.NoError(t, err)
+	assert.Zero(t, sent)
+}
+
+func TestFileNotifierWritesMIMEMessages(t *testing.T) {
+	dir := t.TempDir()
+	notifier := &FileNotifier{Dir: dir, From: "billing@invoxa.example"}
+	msg := &Message{To: "billing@test.org", Subject: "Rechnung für März", Text: "Total: EUR 10.00", HTML: "<p>Total: EUR 10.00</p>"}
+	assert.NoError(t, notifier.Send(context.Background(), msg))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	f, err := os.Open(files[0])
+	assert.NoError(t, err)
+	defer f.Close()
+
+	parsed, err := mail.ReadMessage(f)
+	assert.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Rechnung für März", subject)
+	assert.Equal(t, "billing@invoxa.example", parsed.Header.Get("From"))
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(part)
+		bodies = append(bodies, part.Header.Get("Content-Type")+": "+strings.TrimSpace(string(body)))
+	}
+	assert.Equal(t, []string{
+		"text/plain; charset=utf-8: Total: EUR 10.00",
+		"text/html; charset=utf-8: <p>Total: EUR 10.00</p>",
+	}, bodies)
+}
+
+func TestSMTPNotifierGivesUpOnUnresponsiveServer(t *testing.T) {
+	// The server accepts connections but never sends its greeting.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	msg := &Message{To: "billing@test.org", Subject: "Invoice", Text: "Total: USD 10.00"}
+
+	notifier := &SMTPNotifier{Addr: listener.Addr().String(), From: "billing@invoxa.example", Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err = notifier.Send(context.Background(), msg)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	notifier.Timeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.Error(t, notifier.Send(ctx, msg))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,246 @@
+// Package notifications emails billing contacts about their invoices,
+// payments and subscriptions.
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Message is an email with a plain-text body and an optional HTML
+// alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Bytes encodes the message as a MIME email from the given address.
+func (m *Message) Bytes(from string, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	body := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+body.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// Notifier sends emails. Send should return an error only when the message
+// was not accepted, since failed notifications are retried.
+type Notifier interface {
+	Name() string
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SMTPNotifier sends messages through an SMTP server, authenticating with
+// PLAIN auth when a username is set. Notifications are sent while the event
+// that triggered them is being handled, so a server that stops responding
+// fails the message after Timeout instead of holding up the dispatcher.
+type SMTPNotifier struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration // for the whole conversation; DefaultSMTPTimeout if zero
+}
+
+const DefaultSMTPTimeout = 30 * time.Second
+
+func (n *SMTPNotifier) Name() string { return "smtp" }
+
+func (n *SMTPNotifier) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	body, err := msg.Bytes(n.From, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if err := n.send(ctx, msg.To, body); err != nil {
+		return fmt.Errorf("failed to send message to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// send does what smtp.SendMail does, but within a deadline and for no
+// longer than ctx lasts.
+func (n *SMTPNotifier) send(ctx context.Context, to string, body []byte) error {
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = DefaultSMTPTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	host, _, _ := net.SplitHostPort(n.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.Username != "" {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, host)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileNotifier writes each message to a .eml file in a directory, for
+// development without a mail server.
+type FileNotifier struct {
+	Dir  string
+	From string
+}
+
+func (n *FileNotifier) Name() string { return "file" }
+
+func (n *FileNotifier) Send(ctx context.Context, msg *Message) error {
+	body, err := msg.Bytes(n.From, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if err := os.MkdirAll(n.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(n.Dir, time.Now().UTC().Format("20060102T150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(body); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(f.Name()), err)
+	}
+	return f.Close()
+}
+
+// LogNotifier only logs the messages it is given. It is the default until a
+// mail server or outbox directory is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Name() string { return "log" }
+
+func (LogNotifier) Send(ctx context.Context, msg *Message) error {
+	log.Printf("Email to %s: %s", msg.To, msg.Subject)
+	return nil
+}
+
+// Outbox keeps sent messages in memory, for tests.
+type Outbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (o *Outbox) Name() string { return "outbox" }
+
+func (o *Outbox) Send(ctx context.Context, msg *Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, *msg)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (o *Outbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
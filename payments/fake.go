@@ -0,0 +1,241 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Behavior controls how the fake provider answers charges.
+type Behavior string
+
+const (
+	BehaviorSucceed Behavior = "succeed"
+	BehaviorDecline Behavior = "decline"
+	BehaviorTimeout Behavior = "timeout"
+)
+
+// Tokens with these prefixes trigger the matching behavior regardless of the
+// provider default, so tests can mix outcomes against one fake.
+const (
+	TokenDeclinePrefix = "tok_decline"
+	TokenTimeoutPrefix = "tok_timeout"
+)
+
+// FakeProvider is an in-process Provider for development and tests. It keeps
+// all state in memory and never contacts a real gateway.
+type FakeProvider struct {
+	// Behavior is the default outcome of a charge.
+	Behavior Behavior
+	// Delay is waited before every charge, honoring context cancellation.
+	Delay time.Duration
+
+	mu        sync.Mutex
+	seq       int
+	customers map[string]Customer
+	methods   map[string]*PaymentMethod // keyed by token
+	owners    map[string]string         // payment method token to customer ID
+	charges   map[string]*Charge
+	refunds   map[string]*Refund
+	keys      map[string]string // idempotency key to charge or refund ID
+}
+
+func NewFakeProvider(behavior Behavior) *FakeProvider {
+	return &FakeProvider{
+		Behavior:  behavior,
+		customers: map[string]Customer{},
+		methods:   map[string]*PaymentMethod{},
+		owners:    map[string]string{},
+		charges:   map[string]*Charge{},
+		refunds:   map[string]*Refund{},
+		keys:      map[string]string{},
+	}
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *FakeProvider) nextID(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_fake_%06d", prefix, f.seq)
+}
+
+func (f *FakeProvider) CreateCustomer(ctx context.Context, customer Customer) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := f.nextID("cus")
+	f.customers[id] = customer
+	return id, nil
+}
+
+// AttachPaymentMethod accepts any token. Tokens containing a card brand
+// ('visa', 'mastercard', 'amex') report that brand; the last four digits are
+// derived from the token so that different tokens are distinguishable.
+func (f *FakeProvider) AttachPaymentMethod(ctx context.Context, customerID, token string) (*PaymentMethod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, ErrNotFound
+	}
+	if owner, ok := f.owners[token]; ok && owner != customerID {
+		return nil, fmt.Errorf("payment method %s is attached to another customer", token)
+	}
+
+	brand := "visa"
+	for _, candidate := range []string{"mastercard", "amex", "visa"} {
+		if strings.Contains(token, candidate) {
+			brand = candidate
+			break
+		}
+	}
+	last4 := "4242"
+	if digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, token); len(digits) >= 4 {
+		last4 = digits[len(digits)-4:]
+	}
+
+	method := &PaymentMethod{
+		Token:    token,
+		Type:     "card",
+		Brand:    brand,
+		Last4:    last4,
+		ExpMonth: 12,
+		ExpYear:  time.Now().Year() + 3,
+	}
+	f.methods[token] = method
+	f.owners[token] = customerID
+	copied := *method
+	return &copied, nil
+}
+
+func (f *FakeProvider) DetachPaymentMethod(ctx context.Context, customerID, token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if owner, ok := f.owners[token]; !ok || owner != customerID {
+		return ErrNotFound
+	}
+	delete(f.methods, token)
+	delete(f.owners, token)
+	return nil
+}
+
+func (f *FakeProvider) behaviorFor(token string) Behavior {
+	switch {
+	case strings.HasPrefix(token, TokenDeclinePrefix):
+		return BehaviorDecline
+	case strings.HasPrefix(token, TokenTimeoutPrefix):
+		return BehaviorTimeout
+	case f.Behavior == "":
+		return BehaviorSucceed
+	}
+	return f.Behavior
+}
+
+func (f *FakeProvider) Charge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-ctx.Done():
+			return nil, ErrTimeout
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("charge amount must be positive")
+	}
+	if _, ok := f.customers[req.CustomerID]; !ok {
+		return nil, ErrNotFound
+	}
+	if id, ok := f.keys[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		if charge, ok := f.charges[id]; ok {
+			copied := *charge
+			return &copied, nil
+		}
+	}
+
+	switch f.behaviorFor(req.PaymentMethod) {
+	case BehaviorDecline:
+		return nil, &DeclineError{Code: "card_declined", Message: "The card was declined"}
+	case BehaviorTimeout:
+		return nil, ErrTimeout
+	}
+
+	charge := &Charge{
+		ID:       f.nextID("ch"),
+		Status:   StatusSucceeded,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+	}
+	f.charges[charge.ID] = charge
+	if req.IdempotencyKey != "" {
+		f.keys[req.IdempotencyKey] = charge.ID
+	}
+	copied := *charge
+	return &copied, nil
+}
+
+func (f *FakeProvider) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[req.ChargeID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if id, ok := f.keys[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		if refund, ok := f.refunds[id]; ok {
+			copied := *refund
+			return &copied, nil
+		}
+	}
+
+	refunded := 0.0
+	for _, refund := range f.refunds {
+		if refund.ChargeID == charge.ID {
+			refunded += refund.Amount
+		}
+	}
+	if req.Amount <= 0 || refunded+req.Amount > charge.Amount+0.005 {
+		return nil, fmt.Errorf("refund amount exceeds the refundable balance of charge %s", charge.ID)
+	}
+
+	refund := &Refund{
+		ID:       f.nextID("re"),
+		ChargeID: charge.ID,
+		Status:   StatusSucceeded,
+		Amount:   req.Amount,
+	}
+	f.refunds[refund.ID] = refund
+	if req.IdempotencyKey != "" {
+		f.keys[req.IdempotencyKey] = refund.ID
+	}
+	if refunded+req.Amount >= charge.Amount-0.005 {
+		charge.Status = StatusRefunded
+	}
+	copied := *refund
+	return &copied, nil
+}
+
+func (f *FakeProvider) ChargeStatus(ctx context.Context, chargeID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[chargeID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return charge.Status, nil
+}
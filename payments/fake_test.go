@@ -0,0 +1,70 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeProviderChargeAndRefund(t *testing.T) {
+	ctx := context.Background()
+	provider := NewFakeProvider(BehaviorSucceed)
+
+	customerID, err := provider.CreateCustomer(ctx, Customer{Name: "Acme", Email: "billing@acme.test"})
+	assert.NoError(t, err)
+
+	method, err := provider.AttachPaymentMethod(ctx, customerID, "tok_mastercard_5555444433331111")
+	assert.NoError(t, err)
+	assert.Equal(t, "mastercard", method.Brand)
+	assert.Equal(t, "1111", method.Last4)
+
+	req := ChargeRequest{CustomerID: customerID, PaymentMethod: method.Token, Amount: 25, Currency: "USD", IdempotencyKey: "invoice-1"}
+	charge, err := provider.Charge(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusSucceeded, charge.Status)
+
+	// Retrying with the same idempotency key does not charge twice.
+	again, err := provider.Charge(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, charge.ID, again.ID)
+
+	_, err = provider.Refund(ctx, RefundRequest{ChargeID: charge.ID, Amount: 30})
+	assert.Error(t, err)
+
+	refund, err := provider.Refund(ctx, RefundRequest{ChargeID: charge.ID, Amount: 25})
+	assert.NoError(t, err)
+	assert.Equal(t, charge.ID, refund.ChargeID)
+
+	status, err := provider.ChargeStatus(ctx, charge.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusRefunded, status)
+}
+
+func TestFakeProviderFailures(t *testing.T) {
+	ctx := context.Background()
+	provider := NewFakeProvider(BehaviorSucceed)
+	customerID, _ := provider.CreateCustomer(ctx, Customer{Name: "Acme"})
+
+	_, err := provider.Charge(ctx, ChargeRequest{CustomerID: customerID, PaymentMethod: TokenDeclinePrefix + "_insufficient_funds", Amount: 10, Currency: "USD"})
+	assert.True(t, errors.Is(err, ErrDeclined))
+	var decline *DeclineError
+	assert.True(t, errors.As(err, &decline))
+	assert.Equal(t, "card_declined", decline.Code)
+
+	_, err = provider.Charge(ctx, ChargeRequest{CustomerID: customerID, PaymentMethod: TokenTimeoutPrefix, Amount: 10, Currency: "USD"})
+	assert.True(t, errors.Is(err, ErrTimeout))
+
+	provider.Behavior = BehaviorDecline
+	_, err = provider.Charge(ctx, ChargeRequest{CustomerID: customerID, PaymentMethod: "tok_visa", Amount: 10, Currency: "USD"})
+	assert.True(t, errors.Is(err, ErrDeclined))
+
+	provider.Behavior = BehaviorSucceed
+	provider.Delay = time.Second
+	deadline, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = provider.Charge(deadline, ChargeRequest{CustomerID: customerID, PaymentMethod: "tok_visa", Amount: 10, Currency: "USD"})
+	assert.True(t, errors.Is(err, ErrTimeout))
+}
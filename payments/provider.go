@@ -0,0 +1,101 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	StatusSucceeded   = "succeeded"
+	StatusPending     = "pending"
+	StatusDeclined    = "declined"
+	StatusRefunded    = "refunded"
+	StatusFailed      = "failed"       // reported failed after initially succeeding, e.g., a returned bank debit
+	StatusDisputed    = "disputed"     // the cardholder has disputed the charge
+	StatusChargedBack = "charged_back" // the dispute was lost and the funds returned to the cardholder
+)
+
+var (
+	// ErrDeclined is returned when the provider refuses a charge.
+	ErrDeclined = errors.New("payment declined")
+	// ErrTimeout is returned when the provider does not answer in time. The
+	// outcome of the operation is unknown and should be checked with ChargeStatus.
+	ErrTimeout = errors.New("payment provider timed out")
+	// ErrNotFound is returned for unknown customers, charges or payment methods.
+	ErrNotFound = errors.New("not found at payment provider")
+)
+
+// DeclineError carries the provider's reason for a declined charge.
+type DeclineError struct {
+	Code    string
+	Message string
+}
+
+func (e *DeclineError) Error() string {
+	return fmt.Sprintf("payment declined: %s (%s)", e.Message, e.Code)
+}
+
+func (e *DeclineError) Unwrap() error {
+	return ErrDeclined
+}
+
+type Customer struct {
+	Name  string
+	Email string
+}
+
+type PaymentMethod struct {
+	Token    string // provider reference for the stored method
+	Type     string // e.g., 'card', 'bank_account'
+	Brand    string
+	Last4    string
+	ExpMonth int
+	ExpYear  int
+}
+
+type ChargeRequest struct {
+	CustomerID     string
+	PaymentMethod  string // token of an attached payment method
+	Amount         float64
+	Currency       string
+	Description    string
+	IdempotencyKey string // repeated requests with the same key return the original charge
+}
+
+type Charge struct {
+	ID       string
+	Status   string
+	Amount   float64
+	Currency string
+}
+
+type RefundRequest struct {
+	ChargeID       string
+	Amount         float64
+	Reason         string
+	IdempotencyKey string
+}
+
+type Refund struct {
+	ID       string
+	ChargeID string
+	Status   string
+	Amount   float64
+}
+
+// Provider is a payment gateway that can hold customers and their payment
+// methods and move money on their behalf.
+type Provider interface {
+	// Name identifies the provider in stored references.
+	Name() string
+	CreateCustomer(ctx context.Context, customer Customer) (string, error)
+	// AttachPaymentMethod stores a tokenized payment method on the customer
+	// and returns its details.
+	AttachPaymentMethod(ctx context.Context, customerID, token string) (*PaymentMethod, error)
+	DetachPaymentMethod(ctx context.Context, customerID, token string) error
+	Charge(ctx context.Context, req ChargeRequest) (*Charge, error)
+	Refund(ctx context.Context, req RefundRequest) (*Refund, error)
+	// ChargeStatus fetches the current status of a charge.
+	ChargeStatus(ctx context.Context, chargeID string) (string, error)
+}
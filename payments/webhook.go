@@ -0,0 +1,131 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentFailed    = "payment.failed"
+	EventPaymentRefunded  = "payment.refunded"
+	EventPaymentDisputed  = "payment.disputed"
+	EventDisputeClosed    = "dispute.closed"
+)
+
+// SignatureHeader carries the webhook signature in the form
+// "t=<unix timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<payload>">".
+const SignatureHeader = "Invoxa-Signature"
+
+// DefaultTolerance is how far a signature timestamp may drift from the
+// receiver's clock before the request is rejected as a possible replay.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrStaleTimestamp   = errors.New("webhook timestamp outside tolerance")
+)
+
+// Event is a notification posted by the payment processor.
+type Event struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Created int64     `json:"created"`
+	Data    EventData `json:"data"`
+}
+
+type EventData struct {
+	ChargeID       string  `json:"charge_id"`
+	RefundID       string  `json:"refund_id,omitempty"`
+	DisputeID      string  `json:"dispute_id,omitempty"`
+	InvoiceID      uint    `json:"invoice_id,omitempty"` // set by the processor from charge metadata
+	Amount         float64 `json:"amount"`
+	Currency       string  `json:"currency"`
+	FailureCode    string  `json:"failure_code,omitempty"`
+	FailureMessage string  `json:"failure_message,omitempty"`
+	Reason         string  `json:"reason,omitempty"`
+	Fee            float64 `json:"fee,omitempty"`             // dispute fee withheld by the provider
+	EvidenceDueBy  int64   `json:"evidence_due_by,omitempty"` // unix time
+	Status         string  `json:"status,omitempty"`          // outcome of a closed dispute: 'won' or 'lost'
+}
+
+func computeSignature(payload []byte, secret string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPayload returns the signature header value for a payload.
+func SignPayload(payload []byte, secret string, timestamp time.Time) string {
+	ts := timestamp.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", ts, computeSignature(payload, secret, ts))
+}
+
+// VerifySignature checks a signature header against the payload and rejects
+// timestamps further than tolerance from now. Several v1 signatures may be
+// present while a secret is being rotated; any match is accepted.
+func VerifySignature(payload []byte, header, secret string, tolerance time.Duration, now time.Time) error {
+	if secret == "" {
+		return fmt.Errorf("%w: no secret configured", ErrInvalidSignature)
+	}
+
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+			}
+			timestamp = ts
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("%w: missing timestamp or signature", ErrInvalidSignature)
+	}
+
+	drift := now.Sub(time.Unix(timestamp, 0))
+	if drift > tolerance || drift < -tolerance {
+		return ErrStaleTimestamp
+	}
+
+	expected := []byte(computeSignature(payload, secret, timestamp))
+	for _, signature := range signatures {
+		if hmac.Equal(expected, []byte(signature)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// ParseEvent verifies a webhook request and decodes its event.
+func ParseEvent(payload []byte, header, secret string, now time.Time) (*Event, error) {
+	if err := VerifySignature(payload, header, secret, DefaultTolerance, now); err != nil {
+		return nil, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("malformed webhook payload: %w", err)
+	}
+	if event.ID == "" || event.Type == "" {
+		return nil, fmt.Errorf("webhook event is missing an id or type")
+	}
+	return &event, nil
+}
@@ -0,0 +1,49 @@
+package payments
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"payment.succeeded"}`)
+	now := time.Unix(1700000000, 0)
+	header := SignPayload(payload, "whsec_test", now)
+
+	assert.NoError(t, VerifySignature(payload, header, "whsec_test", DefaultTolerance, now.Add(time.Minute)))
+
+	// A rotated secret is accepted alongside the old one.
+	rotated := header + ",v1=" + computeSignature(payload, "whsec_new", now.Unix())
+	assert.NoError(t, VerifySignature(payload, rotated, "whsec_new", DefaultTolerance, now))
+
+	err := VerifySignature([]byte(`{"id":"evt_1","type":"payment.failed"}`), header, "whsec_test", DefaultTolerance, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	err = VerifySignature(payload, header, "wrong", DefaultTolerance, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	err = VerifySignature(payload, header, "whsec_test", DefaultTolerance, now.Add(10*time.Minute))
+	assert.True(t, errors.Is(err, ErrStaleTimestamp))
+
+	err = VerifySignature(payload, "v1=abc", "whsec_test", DefaultTolerance, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	err = VerifySignature(payload, header, "", DefaultTolerance, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+}
+
+func TestParseEventRequiresIDAndType(t *testing.T) {
+	now := time.Now()
+	payload := []byte(`{"type":"payment.succeeded"}`)
+	_, err := ParseEvent(payload, SignPayload(payload, "whsec_test", now), "whsec_test", now)
+	assert.Error(t, err)
+
+	payload = []byte(`{"id":"evt_2","type":"payment.refunded","data":{"charge_id":"ch_1","refund_id":"re_1","amount":5}}`)
+	event, err := ParseEvent(payload, SignPayload(payload, "whsec_test", now), "whsec_test", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "re_1", event.Data.RefundID)
+	assert.Equal(t, 5.0, event.Data.Amount)
+}
@@ -0,0 +1,183 @@
+package reports
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/export"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+)
+
+// AgingBuckets holds open invoice balances by how many days they are past
+// their due date.
+type AgingBuckets struct {
+	Current    float64 `json:"current"` // not yet due
+	Days1To30  float64 `json:"days_1_30"`
+	Days31To60 float64 `json:"days_31_60"`
+	Days61To90 float64 `json:"days_61_90"`
+	Over90     float64 `json:"over_90"`
+	Total      float64 `json:"total"`
+}
+
+func (b *AgingBuckets) add(balance float64, daysPastDue int) {
+	switch {
+	case daysPastDue <= 0:
+		b.Current = roundCents(b.Current + balance)
+	case daysPastDue <= 30:
+		b.Days1To30 = roundCents(b.Days1To30 + balance)
+	case daysPastDue <= 60:
+		b.Days31To60 = roundCents(b.Days31To60 + balance)
+	case daysPastDue <= 90:
+		b.Days61To90 = roundCents(b.Days61To90 + balance)
+	default:
+		b.Over90 = roundCents(b.Over90 + balance)
+	}
+	b.Total = roundCents(b.Total + balance)
+}
+
+// AgingCustomer is what one customer of an organization owes in a currency.
+// The customer is the user invoices were raised for; invoices raised for no
+// user are owed by the organization itself.
+type AgingCustomer struct {
+	UserID   uint   `json:"user_id,omitempty"`
+	Customer string `json:"customer"`
+	Invoices int    `json:"invoices"`
+	AgingBuckets
+}
+
+// AgingOrganization is what an organization owes in a currency.
+type AgingOrganization struct {
+	OrganizationID uint            `json:"organization_id"`
+	Organization   string          `json:"organization"`
+	Currency       string          `json:"currency"`
+	Invoices       int             `json:"invoices"`
+	Customers      []AgingCustomer `json:"customers"`
+	AgingBuckets
+}
+
+type AgingReport struct {
+	AsOf          time.Time               `json:"as_of"`
+	Organizations []AgingOrganization     `json:"organizations"`
+	Totals        map[string]AgingBuckets `json:"totals"` // by currency
+}
+
+// DaysPastDue counts the whole days from an invoice's due date to a time.
+func DaysPastDue(invoice *models.Invoice, at time.Time) int {
+	due := time.Date(invoice.DueDate.Year(), invoice.DueDate.Month(), invoice.DueDate.Day(), 0, 0, 0, 0, time.UTC)
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	return int(day.Sub(due).Hours() / 24)
+}
+
+// Aging buckets the open balances of unpaid invoices issued by a time, of
+// one organization or of all of them if organizationID is 0. The open
+// balance is what is due after credits less payments still pending with the
+// provider. A payment that went through without covering the invoice was
+// kept as credit, so it is already in the credit applied or not at all.
+// Invoices written off as uncollectible are left out.
+func Aging(db *gorm.DB, organizationID uint, at time.Time) (*AgingReport, error) {
+	query := db.Preload("Organization").Preload("User").Preload("Payments").
+		Where("paid = ? AND uncollectible = ? AND issue_date <= ?", false, false, at)
+	if organizationID != 0 {
+		query = query.Where("organization_id = ?", organizationID)
+	}
+	var invoices []models.Invoice
+	if err := query.Order("organization_id, currency, user_id, id").Find(&invoices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load unpaid invoices: %w", err)
+	}
+
+	report := &AgingReport{AsOf: at, Organizations: []AgingOrganization{}, Totals: map[string]AgingBuckets{}}
+	for i := range invoices {
+		invoice := &invoices[i]
+		balance := billing.AmountDue(invoice)
+		for _, payment := range invoice.Payments {
+			if payment.ProviderStatus == payments.StatusPending {
+				balance -= payment.Amount
+			}
+		}
+		balance = roundCents(balance)
+		if balance <= 0 {
+			continue
+		}
+		days := DaysPastDue(invoice, at)
+
+		n := len(report.Organizations)
+		if n == 0 || report.Organizations[n-1].OrganizationID != invoice.OrganizationID || report.Organizations[n-1].Currency != invoice.Currency {
+			report.Organizations = append(report.Organizations, AgingOrganization{
+				OrganizationID: invoice.OrganizationID,
+				Organization:   invoice.Organization.Name,
+				Currency:       invoice.Currency,
+			})
+			n++
+		}
+		org := &report.Organizations[n-1]
+		org.Invoices++
+		org.add(balance, days)
+
+		m := len(org.Customers)
+		if m == 0 || org.Customers[m-1].UserID != invoice.UserID {
+			customer := AgingCustomer{UserID: invoice.UserID, Customer: invoice.User.Username}
+			if invoice.UserID == 0 {
+				customer.Customer = invoice.Organization.Name
+			}
+			org.Customers = append(org.Customers, customer)
+			m++
+		}
+		org.Customers[m-1].Invoices++
+		org.Customers[m-1].add(balance, days)
+
+		totals := report.Totals[invoice.Currency]
+		totals.add(balance, days)
+		report.Totals[invoice.Currency] = totals
+	}
+	return report, nil
+}
+
+var agingHeader = []string{"organization_id", "organization", "user_id", "customer", "currency", "invoices",
+	"current", "days_1_30", "days_31_60", "days_61_90", "over_90", "total"}
+
+func agingRecord(organizationID, organization, userID, customer, currency, invoices string, b AgingBuckets) []string {
+	record := []string{organizationID, export.EscapeFormula(organization), userID, export.EscapeFormula(customer), currency, invoices}
+	for _, amount := range []float64{b.Current, b.Days1To30, b.Days31To60, b.Days61To90, b.Over90, b.Total} {
+		record = append(record, strconv.FormatFloat(amount, 'f', 2, 64))
+	}
+	return record
+}
+
+// WriteAgingCSV writes a report with a row for each customer, followed by a
+// total row per currency.
+func WriteAgingCSV(w io.Writer, report *AgingReport) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(agingHeader); err != nil {
+		return err
+	}
+	for _, org := range report.Organizations {
+		for _, customer := range org.Customers {
+			record := agingRecord(strconv.FormatUint(uint64(org.OrganizationID), 10), org.Organization,
+				strconv.FormatUint(uint64(customer.UserID), 10), customer.Customer, org.Currency, strconv.Itoa(customer.Invoices), customer.AgingBuckets)
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	currencies := make([]string, 0, len(report.Totals))
+	for currency := range report.Totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		if err := out.Write(agingRecord("", "Total", "", "", currency, "", report.Totals[currency])); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
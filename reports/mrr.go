@@ -0,0 +1,395 @@
+// Package reports computes revenue analytics. Subscription metrics treat
+// each organization as one customer: its monthly recurring revenue (MRR) is
+// the sum of its active subscriptions, normalized to a month by plan
+// interval and converted to a reporting currency.
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// MonthlyAmount normalizes a plan's price to one month, e.g., a tenth of a
+// 120.00 yearly plan is 10.00 a month.
+func MonthlyAmount(plan *models.SubscriptionPlan) float64 {
+	switch plan.Interval {
+	case "weekly":
+		return plan.Price * 52 / 12
+	case "quarterly":
+		return plan.Price / 3
+	case "yearly", "annual", "annually":
+		return plan.Price / 12
+	default:
+		return plan.Price
+	}
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// ratio returns part/whole rounded to four decimals, or 0 without a whole.
+func ratio(part, whole float64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(part/whole*10000) / 10000
+}
+
+// subscriptionRevenue is the MRR a subscription brings in while it runs.
+type subscriptionRevenue struct {
+	customer uint
+	currency string
+	monthly  float64
+	start    time.Time
+	end      time.Time // zero while the subscription runs
+}
+
+// activeBefore reports whether the subscription was running just before t.
+func (s *subscriptionRevenue) activeBefore(t time.Time) bool {
+	return s.start.Before(t) && (s.end.IsZero() || !s.end.Before(t))
+}
+
+// book holds every subscription and converts their MRR to one currency.
+type book struct {
+	db            *gorm.DB
+	currency      string
+	subscriptions []subscriptionRevenue
+	rates         map[string]float64 // by currency and day; 0 when missing
+	missing       []string
+}
+
+func loadBook(db *gorm.DB, currency string) (*book, error) {
+	var subscriptions []models.Subscription
+	if err := db.Preload("SubscriptionPlan").Find(&subscriptions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load subscriptions: %w", err)
+	}
+
+	b := &book{db: db, currency: currency, rates: map[string]float64{}}
+	for _, subscription := range subscriptions {
+		revenue := subscriptionRevenue{
+			customer: subscription.OrganizationID,
+			currency: subscription.SubscriptionPlan.Currency,
+			monthly:  MonthlyAmount(&subscription.SubscriptionPlan),
+			start:    subscription.StartDate,
+			end:      subscription.EndDate,
+		}
+		// Paused subscriptions bring in nothing from the day they were
+		// paused, and cancelled ones from when they were last changed if no
+		// end date was recorded.
+		if subscription.PausedAt != nil && (revenue.end.IsZero() || subscription.PausedAt.Before(revenue.end)) {
+			revenue.end = *subscription.PausedAt
+		}
+		if !subscription.IsActive && revenue.end.IsZero() {
+			revenue.end = subscription.UpdatedAt
+		}
+		b.subscriptions = append(b.subscriptions, revenue)
+	}
+	return b, nil
+}
+
+// rate returns the rate from a currency to the reporting currency on a day,
+// or 0 if there is none.
+func (b *book) rate(currency string, on time.Time) (float64, error) {
+	key := currency + on.Format("2006-01-02")
+	if rate, ok := b.rates[key]; ok {
+		return rate, nil
+	}
+	rate, err := billing.ExchangeRateOn(b.db, currency, b.currency, on)
+	if errors.Is(err, billing.ErrNoExchangeRate) {
+		if !slices.Contains(b.missing, currency) {
+			b.missing = append(b.missing, currency)
+		}
+		rate, err = 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	b.rates[key] = rate
+	return rate, nil
+}
+
+// customerMRR returns the MRR of each paying customer just before t,
+// converted at the rates of a day. Subscriptions in currencies without a rate
+// are left out.
+func (b *book) customerMRR(t, ratesOn time.Time) (map[uint]float64, error) {
+	mrr := map[uint]float64{}
+	for i := range b.subscriptions {
+		s := &b.subscriptions[i]
+		if !s.activeBefore(t) || s.monthly == 0 {
+			continue
+		}
+		rate, err := b.rate(s.currency, ratesOn)
+		if err != nil {
+			return nil, err
+		}
+		if rate != 0 {
+			mrr[s.customer] += s.monthly * rate
+		}
+	}
+	return mrr, nil
+}
+
+func total(mrr map[uint]float64) float64 {
+	var sum float64
+	for _, amount := range mrr {
+		sum += amount
+	}
+	return roundCents(sum)
+}
+
+// MRRReport is the recurring revenue at a point in time.
+type MRRReport struct {
+	Date                 time.Time `json:"date"`
+	Currency             string    `json:"currency"`
+	MRR                  float64   `json:"mrr"`
+	ARR                  float64   `json:"arr"`
+	Customers            int       `json:"customers"`
+	AveragePerCustomer   float64   `json:"average_per_customer"`
+	MissingExchangeRates []string  `json:"missing_exchange_rates,omitempty"`
+}
+
+// MRR computes the MRR and ARR (12 times MRR) of all customers at a time.
+func MRR(db *gorm.DB, currency string, at time.Time) (*MRRReport, error) {
+	b, err := loadBook(db, currency)
+	if err != nil {
+		return nil, err
+	}
+	mrr, err := b.customerMRR(at, at)
+	if err != nil {
+		return nil, err
+	}
+	report := &MRRReport{
+		Date:                 at,
+		Currency:             currency,
+		MRR:                  total(mrr),
+		ARR:                  roundCents(total(mrr) * 12),
+		Customers:            len(mrr),
+		MissingExchangeRates: b.missing,
+	}
+	if report.Customers > 0 {
+		report.AveragePerCustomer = roundCents(report.MRR / float64(report.Customers))
+	}
+	return report, nil
+}
+
+// MonthlyMovements breaks down how MRR changed over a month, comparing each
+// customer's MRR at the start of the month with its MRR at the end.
+type MonthlyMovements struct {
+	Month             string  `json:"month"` // YYYY-MM
+	StartingMRR       float64 `json:"starting_mrr"`
+	NewMRR            float64 `json:"new_mrr"`          // from customers who never paid before
+	ReactivationMRR   float64 `json:"reactivation_mrr"` // from customers who had churned
+	ExpansionMRR      float64 `json:"expansion_mrr"`
+	ContractionMRR    float64 `json:"contraction_mrr"`
+	ChurnedMRR        float64 `json:"churned_mrr"`
+	NetNewMRR         float64 `json:"net_new_mrr"`
+	EndingMRR         float64 `json:"ending_mrr"`
+	StartingCustomers int     `json:"starting_customers"`
+	NewCustomers      int     `json:"new_customers"`
+	ChurnedCustomers  int     `json:"churned_customers"`
+	EndingCustomers   int     `json:"ending_customers"`
+	// LogoChurnRate is the share of starting customers lost in the month.
+	LogoChurnRate float64 `json:"logo_churn_rate"`
+	// GrossRevenueChurnRate is churned and contraction MRR as a share of
+	// starting MRR; NetRevenueChurnRate also subtracts expansion MRR, so it
+	// is negative when existing customers grew.
+	GrossRevenueChurnRate float64 `json:"gross_revenue_churn_rate"`
+	NetRevenueChurnRate   float64 `json:"net_revenue_churn_rate"`
+}
+
+type MovementsReport struct {
+	Currency             string             `json:"currency"`
+	Months               []MonthlyMovements `json:"months"`
+	MissingExchangeRates []string           `json:"missing_exchange_rates,omitempty"`
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// monthEnd is the end of a month, or now for the current month.
+func monthEnd(month, now time.Time) time.Time {
+	end := month.AddDate(0, 1, 0)
+	if now.Before(end) {
+		return now
+	}
+	return end
+}
+
+// months lists the first days of the months from from to to, stopping at
+// the current month.
+func months(from, to, now time.Time) []time.Time {
+	var list []time.Time
+	for month := monthStart(from); !month.After(monthStart(to)) && month.Before(now); month = month.AddDate(0, 1, 0) {
+		list = append(list, month)
+	}
+	return list
+}
+
+// Movements computes the MRR movements of each month from from to to. Both
+// snapshots of a month are converted at the rates of its last day, so
+// currency swings do not show up as expansion or contraction.
+func Movements(db *gorm.DB, currency string, from, to, now time.Time) (*MovementsReport, error) {
+	b, err := loadBook(db, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// Customers who paid at some point before each month are reactivated
+	// rather than new when they start paying again.
+	firstPaid := map[uint]time.Time{}
+	for _, s := range b.subscriptions {
+		if first, ok := firstPaid[s.customer]; s.monthly > 0 && (!ok || s.start.Before(first)) {
+			firstPaid[s.customer] = s.start
+		}
+	}
+
+	report := &MovementsReport{Currency: currency, Months: []MonthlyMovements{}}
+	for _, month := range months(from, to, now) {
+		end := monthEnd(month, now)
+		before, err := b.customerMRR(month, end)
+		if err != nil {
+			return nil, err
+		}
+		after, err := b.customerMRR(end, end)
+		if err != nil {
+			return nil, err
+		}
+
+		m := MonthlyMovements{
+			Month:             month.Format("2006-01"),
+			StartingMRR:       total(before),
+			EndingMRR:         total(after),
+			StartingCustomers: len(before),
+			EndingCustomers:   len(after),
+		}
+		for customer, amount := range after {
+			previous, ok := before[customer]
+			switch {
+			case !ok && firstPaid[customer].Before(month):
+				m.ReactivationMRR += amount
+				m.NewCustomers++
+			case !ok:
+				m.NewMRR += amount
+				m.NewCustomers++
+			case amount > previous:
+				m.ExpansionMRR += amount - previous
+			case amount < previous:
+				m.ContractionMRR += previous - amount
+			}
+		}
+		for customer, amount := range before {
+			if _, ok := after[customer]; !ok {
+				m.ChurnedMRR += amount
+				m.ChurnedCustomers++
+			}
+		}
+
+		m.NewMRR = roundCents(m.NewMRR)
+		m.ReactivationMRR = roundCents(m.ReactivationMRR)
+		m.ExpansionMRR = roundCents(m.ExpansionMRR)
+		m.ContractionMRR = roundCents(m.ContractionMRR)
+		m.ChurnedMRR = roundCents(m.ChurnedMRR)
+		m.NetNewMRR = roundCents(m.NewMRR + m.ReactivationMRR + m.ExpansionMRR - m.ContractionMRR - m.ChurnedMRR)
+		m.LogoChurnRate = ratio(float64(m.ChurnedCustomers), float64(m.StartingCustomers))
+		m.GrossRevenueChurnRate = ratio(m.ChurnedMRR+m.ContractionMRR, m.StartingMRR)
+		m.NetRevenueChurnRate = ratio(m.ChurnedMRR+m.ContractionMRR-m.ExpansionMRR, m.StartingMRR)
+		report.Months = append(report.Months, m)
+	}
+	report.MissingExchangeRates = b.missing
+	return report, nil
+}
+
+// Cohort follows the customers who started paying in the same month.
+// Element i of each slice is the state at the end of the i-th month after the
+// cohort's first, up to the current month.
+type Cohort struct {
+	Month             string    `json:"month"` // YYYY-MM
+	Customers         int       `json:"customers"`
+	Retained          []int     `json:"retained"`           // customers still paying
+	CustomerRetention []float64 `json:"customer_retention"` // retained as a share of Customers
+	MRR               []float64 `json:"mrr"`
+	// RevenueRetention is MRR as a share of the cohort's MRR at the end of
+	// its first month, so expansion can take it above 1.
+	RevenueRetention []float64 `json:"revenue_retention"`
+}
+
+type CohortReport struct {
+	Currency             string   `json:"currency"`
+	Cohorts              []Cohort `json:"cohorts"`
+	MissingExchangeRates []string `json:"missing_exchange_rates,omitempty"`
+}
+
+// Cohorts builds retention tables for the customers whose first subscription
+// started in the months from from to to. MRR is converted at the rates of the
+// end of each month.
+func Cohorts(db *gorm.DB, currency string, from, to, now time.Time) (*CohortReport, error) {
+	b, err := loadBook(db, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	joined := map[uint]time.Time{}
+	for _, s := range b.subscriptions {
+		if first, ok := joined[s.customer]; !ok || s.start.Before(first) {
+			joined[s.customer] = s.start
+		}
+	}
+	members := map[time.Time][]uint{}
+	for customer, start := range joined {
+		month := monthStart(start)
+		members[month] = append(members[month], customer)
+	}
+
+	report := &CohortReport{Currency: currency, Cohorts: []Cohort{}}
+	snapshots := map[time.Time]map[uint]float64{}
+	for _, month := range months(from, to, now) {
+		customers := members[month]
+		sort.Slice(customers, func(i, j int) bool { return customers[i] < customers[j] })
+		cohort := Cohort{Month: month.Format("2006-01"), Customers: len(customers)}
+		if len(customers) == 0 {
+			report.Cohorts = append(report.Cohorts, cohort)
+			continue
+		}
+
+		var firstMRR float64
+		for offset := month; offset.Before(now); offset = offset.AddDate(0, 1, 0) {
+			end := monthEnd(offset, now)
+			mrr, ok := snapshots[end]
+			if !ok {
+				if mrr, err = b.customerMRR(end, end); err != nil {
+					return nil, err
+				}
+				snapshots[end] = mrr
+			}
+
+			retained, revenue := 0, 0.0
+			for _, customer := range customers {
+				if amount, ok := mrr[customer]; ok {
+					retained++
+					revenue += amount
+				}
+			}
+			if offset.Equal(month) {
+				firstMRR = revenue
+			}
+			cohort.Retained = append(cohort.Retained, retained)
+			cohort.CustomerRetention = append(cohort.CustomerRetention, ratio(float64(retained), float64(len(customers))))
+			cohort.MRR = append(cohort.MRR, roundCents(revenue))
+			cohort.RevenueRetention = append(cohort.RevenueRetention, ratio(revenue, firstMRR))
+		}
+		report.Cohorts = append(report.Cohorts, cohort)
+	}
+	report.MissingExchangeRates = b.missing
+	return report, nil
+}
@@ -0,0 +1,96 @@
+package reports
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+// RecognitionMonth is the revenue recognized and deferred in one currency
+// in a month. DeferredEnd is DeferredStart plus Billed and Adjustments less
+// Recognized.
+type RecognitionMonth struct {
+	Month         string  `json:"month"` // YYYY-MM
+	Currency      string  `json:"currency"`
+	DeferredStart float64 `json:"deferred_start"`
+	Billed        float64 `json:"billed"`      // invoiced before tax
+	Adjustments   float64 `json:"adjustments"` // refunds and plan changes, usually negative
+	Recognized    float64 `json:"recognized"`
+	DeferredEnd   float64 `json:"deferred_end"`
+}
+
+type RecognitionReport struct {
+	Months []RecognitionMonth `json:"months"`
+}
+
+// Recognition reports the recognized and deferred revenue of each month
+// from from to to, of one organization or of all of them if organizationID
+// is 0. Months after now show what is scheduled to be recognized.
+func Recognition(db *gorm.DB, organizationID uint, from, to time.Time) (*RecognitionReport, error) {
+	query := db.Model(&models.RevenueScheduleLine{}).
+		Select("revenue_schedule_lines.month, revenue_schedule_lines.amount, revenue_schedule_lines.reason, revenue_schedule_lines.booked_at, revenue_schedules.currency").
+		Joins("JOIN revenue_schedules ON revenue_schedules.id = revenue_schedule_lines.revenue_schedule_id AND revenue_schedules.deleted_at IS NULL")
+	if organizationID != 0 {
+		query = query.Where("revenue_schedules.organization_id = ?", organizationID)
+	}
+	var lines []struct {
+		Month    time.Time
+		Amount   float64
+		Reason   string
+		BookedAt time.Time
+		Currency string
+	}
+	if err := query.Scan(&lines).Error; err != nil {
+		return nil, fmt.Errorf("failed to load revenue schedules: %w", err)
+	}
+
+	report := &RecognitionReport{Months: []RecognitionMonth{}}
+	for month := monthStart(from); !month.After(monthStart(to)); month = month.AddDate(0, 1, 0) {
+		byCurrency := map[string]*RecognitionMonth{}
+		for _, line := range lines {
+			m := byCurrency[line.Currency]
+			if m == nil {
+				m = &RecognitionMonth{Month: month.Format("2006-01"), Currency: line.Currency}
+				byCurrency[line.Currency] = m
+			}
+			recognizedIn, bookedIn := billing.MonthOf(line.Month), billing.MonthOf(line.BookedAt)
+			if bookedIn.Before(month) && !recognizedIn.Before(month) {
+				m.DeferredStart += line.Amount
+			}
+			if bookedIn.Equal(month) && line.Reason == billing.RecognitionInvoice {
+				m.Billed += line.Amount
+			} else if bookedIn.Equal(month) {
+				m.Adjustments += line.Amount
+			}
+			if recognizedIn.Equal(month) {
+				m.Recognized += line.Amount
+			}
+			if !bookedIn.After(month) && recognizedIn.After(month) {
+				m.DeferredEnd += line.Amount
+			}
+		}
+
+		currencies := make([]string, 0, len(byCurrency))
+		for currency := range byCurrency {
+			currencies = append(currencies, currency)
+		}
+		sort.Strings(currencies)
+		for _, currency := range currencies {
+			m := byCurrency[currency]
+			m.DeferredStart = roundCents(m.DeferredStart)
+			m.Billed = roundCents(m.Billed)
+			m.Adjustments = roundCents(m.Adjustments)
+			m.Recognized = roundCents(m.Recognized)
+			m.DeferredEnd = roundCents(m.DeferredEnd)
+			if m.DeferredStart != 0 || m.Billed != 0 || m.Adjustments != 0 || m.Recognized != 0 || m.DeferredEnd != 0 {
+				report.Months = append(report.Months, *m)
+			}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,276 @@
+package reports
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupReportsDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(database.Models...))
+	return db
+}
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2026, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestMonthlyAmount(t *testing.T) {
+	assert.Equal(t, 10.0, MonthlyAmount(&models.SubscriptionPlan{Price: 120, Interval: "yearly"}))
+	assert.Equal(t, 30.0, MonthlyAmount(&models.SubscriptionPlan{Price: 90, Interval: "quarterly"}))
+	assert.Equal(t, 52.0, MonthlyAmount(&models.SubscriptionPlan{Price: 12, Interval: "weekly"}))
+	assert.Equal(t, 25.0, MonthlyAmount(&models.SubscriptionPlan{Price: 25, Interval: "monthly"}))
+}
+
+func TestSubscriptionMetrics(t *testing.T) {
+	db := setupReportsDB(t)
+	now := day(time.April, 15)
+
+	vendor := models.Organization{Name: "Vendor"}
+	db.Create(&vendor)
+	plan := func(name string, price float64, currency, interval string) models.SubscriptionPlan {
+		plan := models.SubscriptionPlan{Name: name, Price: price, Currency: currency, Interval: interval, OrganizationID: vendor.ID}
+		db.Create(&plan)
+		return plan
+	}
+	basic := plan("Basic", 100, "USD", "monthly")
+	pro := plan("Pro", 150, "USD", "monthly")
+	annual := plan("Annual", 1200, "USD", "yearly")
+	euro := plan("Euro", 50, "EUR", "monthly")
+	quarterly := plan("Quarterly", 90, "USD", "quarterly")
+	starter := plan("Starter", 40, "USD", "monthly")
+	db.Create(&models.ExchangeRate{BaseCurrency: "EUR", QuoteCurrency: "USD", EffectiveDate: day(time.January, 1), Rate: 1.2})
+
+	customer := func(name string) models.Organization {
+		org := models.Organization{Name: name}
+		db.Create(&org)
+		return org
+	}
+	subscribe := func(org models.Organization, plan models.SubscriptionPlan, start, end time.Time) models.Subscription {
+		subscription := models.Subscription{OrganizationID: org.ID, SubscriptionPlanID: plan.ID, StartDate: start, EndDate: end, IsActive: true}
+		db.Create(&subscription)
+		if !end.IsZero() {
+			db.Model(&subscription).Update("is_active", false)
+		}
+		return subscription
+	}
+
+	// Acme upgrades in March; Globex cancels its annual plan in February and
+	// comes back on a cheaper plan in April; Initech pays in euros; Hooli
+	// pauses in March.
+	acme, globex, initech, hooli := customer("Acme"), customer("Globex"), customer("Initech"), customer("Hooli")
+	subscribe(acme, basic, day(time.January, 10), day(time.March, 5))
+	subscribe(acme, pro, day(time.March, 5), time.Time{})
+	subscribe(globex, annual, day(time.January, 20), day(time.February, 15))
+	subscribe(globex, starter, day(time.April, 2), time.Time{})
+	subscribe(initech, euro, day(time.February, 3), time.Time{})
+	paused := subscribe(hooli, quarterly, day(time.February, 10), time.Time{})
+	pausedAt := day(time.March, 20)
+	db.Model(&paused).Update("paused_at", &pausedAt)
+
+	mrr, err := MRR(db, "USD", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 250.0, mrr.MRR)
+	assert.Equal(t, 3000.0, mrr.ARR)
+	assert.Equal(t, 3, mrr.Customers)
+	assert.Empty(t, mrr.MissingExchangeRates)
+
+	mrr, err = MRR(db, "USD", day(time.March, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, 190.0, mrr.MRR)
+
+	movements, err := Movements(db, "USD", day(time.January, 1), day(time.December, 1), now)
+	assert.NoError(t, err)
+	assert.Len(t, movements.Months, 4)
+	jan, feb, mar, apr := movements.Months[0], movements.Months[1], movements.Months[2], movements.Months[3]
+
+	assert.Equal(t, "2026-01", jan.Month)
+	assert.Equal(t, 200.0, jan.NewMRR)
+	assert.Equal(t, 2, jan.NewCustomers)
+	assert.Equal(t, 0.0, jan.LogoChurnRate)
+
+	assert.Equal(t, 200.0, feb.StartingMRR)
+	assert.Equal(t, 90.0, feb.NewMRR)
+	assert.Equal(t, 100.0, feb.ChurnedMRR)
+	assert.Equal(t, -10.0, feb.NetNewMRR)
+	assert.Equal(t, 190.0, feb.EndingMRR)
+	assert.Equal(t, 0.5, feb.LogoChurnRate)
+	assert.Equal(t, 0.5, feb.GrossRevenueChurnRate)
+
+	assert.Equal(t, 50.0, mar.ExpansionMRR)
+	assert.Equal(t, 30.0, mar.ChurnedMRR)
+	assert.Equal(t, 210.0, mar.EndingMRR)
+	assert.Equal(t, 0.3333, mar.LogoChurnRate)
+	assert.Equal(t, 0.1579, mar.GrossRevenueChurnRate)
+	assert.Equal(t, -0.1053, mar.NetRevenueChurnRate)
+
+	// The current month runs up to now, and Globex counts as reactivated.
+	assert.Equal(t, "2026-04", apr.Month)
+	assert.Equal(t, 0.0, apr.NewMRR)
+	assert.Equal(t, 40.0, apr.ReactivationMRR)
+	assert.Equal(t, 250.0, apr.EndingMRR)
+
+	cohorts, err := Cohorts(db, "USD", day(time.January, 1), day(time.April, 1), now)
+	assert.NoError(t, err)
+	assert.Len(t, cohorts.Cohorts, 4)
+	assert.Equal(t, Cohort{
+		Month:             "2026-01",
+		Customers:         2,
+		Retained:          []int{2, 1, 1, 2},
+		CustomerRetention: []float64{1, 0.5, 0.5, 1},
+		MRR:               []float64{200, 100, 150, 190},
+		RevenueRetention:  []float64{1, 0.5, 0.75, 0.95},
+	}, cohorts.Cohorts[0])
+	assert.Equal(t, []int{2, 1, 1}, cohorts.Cohorts[1].Retained)
+	assert.Equal(t, []float64{1, 0.6667, 0.6667}, cohorts.Cohorts[1].RevenueRetention)
+	assert.Equal(t, 0, cohorts.Cohorts[2].Customers)
+
+	// Subscriptions in currencies without a rate are left out and reported.
+	yen := plan("Yen", 5000, "JPY", "monthly")
+	subscribe(customer("Umbrella"), yen, day(time.April, 1), time.Time{})
+	mrr, err = MRR(db, "USD", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 250.0, mrr.MRR)
+	assert.Equal(t, []string{"JPY"}, mrr.MissingExchangeRates)
+}
+
+func TestOrganizationRevenue(t *testing.T) {
+	db := setupReportsDB(t)
+	org := models.Organization{Name: "Acme"}
+	db.Create(&org)
+	issued := day(time.March, 1)
+
+	taxed := models.Invoice{OrganizationID: org.ID, Amount: 120, Subtotal: 100, TaxAmount: 20, Currency: "USD", IssueDate: issued, DueDate: issued, Paid: true}
+	db.Create(&taxed)
+	db.Create(&models.Refund{InvoiceID: taxed.ID, Amount: 60, Currency: "USD", RefundDate: issued, TransactionID: "re_1", ProviderStatus: "succeeded"})
+	db.Create(&models.Refund{InvoiceID: taxed.ID, Amount: 60, Currency: "USD", RefundDate: issued, TransactionID: "re_2", ProviderStatus: "failed"})
+	db.Create(&models.Invoice{OrganizationID: org.ID, Amount: 80, Currency: "USD", IssueDate: issued, DueDate: issued, Paid: true})
+	db.Create(&models.Invoice{OrganizationID: org.ID, Amount: 500, Currency: "USD", IssueDate: issued, DueDate: issued})
+	db.Create(&models.Invoice{OrganizationID: org.ID, Amount: 40, Currency: "EUR", IssueDate: issued, DueDate: issued, Paid: true})
+	db.Create(&models.ExchangeRate{BaseCurrency: "EUR", QuoteCurrency: "USD", EffectiveDate: issued, Rate: 1.25})
+
+	revenue, err := OrganizationRevenue(db, org.ID, "USD")
+	assert.NoError(t, err)
+	// Half of the taxed invoice was refunded, so half its subtotal counts.
+	assert.Equal(t, 180.0, revenue.Total)
+	assert.Equal(t, map[string]float64{"USD": 130, "EUR": 40}, revenue.ByCurrency)
+	assert.Empty(t, revenue.MissingExchangeRates)
+
+	revenue, err = OrganizationRevenue(db, org.ID, "GBP")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, revenue.Total)
+	assert.ElementsMatch(t, []string{"USD", "EUR"}, revenue.MissingExchangeRates)
+}
+
+func TestAging(t *testing.T) {
+	db := setupReportsDB(t)
+	at := day(time.June, 30)
+
+	acme := models.Organization{Name: "Acme"}
+	globex := models.Organization{Name: "Globex"}
+	db.Create(&acme)
+	db.Create(&globex)
+	jane := models.User{Username: "jane", Email: "jane@acme.example", PasswordHash: "x", OrganizationID: acme.ID}
+	db.Create(&jane)
+
+	invoice := func(org models.Organization, userID uint, amount float64, currency string, due time.Time) models.Invoice {
+		invoice := models.Invoice{OrganizationID: org.ID, UserID: userID, Amount: amount, Currency: currency, IssueDate: due.AddDate(0, 0, -30), DueDate: due}
+		db.Create(&invoice)
+		return invoice
+	}
+	invoice(acme, jane.ID, 100, "USD", day(time.July, 10))     // current
+	invoice(acme, jane.ID, 50, "USD", day(time.June, 30))      // due today, still current
+	invoice(acme, jane.ID, 30, "USD", day(time.June, 29))      // 1 day
+	invoice(acme, jane.ID, 20, "USD", day(time.May, 1))        // 60 days
+	invoice(acme, 0, 40, "USD", day(time.April, 1))            // 90 days
+	invoice(acme, 0, 10, "USD", day(time.March, 1))            // 121 days
+	invoice(globex, 0, 70, "EUR", day(time.June, 1))           // 29 days
+	partly := invoice(globex, 0, 80, "EUR", day(time.May, 31)) // 30 days
+	db.Model(&partly).Update("credit_applied", 20)
+	db.Create(&models.Payment{InvoiceID: partly.ID, Amount: 25, Currency: "EUR", PaymentDate: at, TransactionID: "ch_1", ProviderStatus: "succeeded"})
+	db.Create(&models.Payment{InvoiceID: partly.ID, Amount: 35, Currency: "EUR", PaymentDate: at, TransactionID: "ch_2", ProviderStatus: "declined"})
+	db.Create(&models.Payment{InvoiceID: partly.ID, Amount: 15, Currency: "EUR", PaymentDate: at, TransactionID: "ch_3", ProviderStatus: "pending"})
+
+	paid := invoice(acme, jane.ID, 500, "USD", day(time.May, 1))
+	db.Model(&paid).Update("paid", true)
+	writtenOff := invoice(acme, jane.ID, 500, "USD", day(time.May, 1))
+	db.Model(&writtenOff).Update("uncollectible", true)
+	invoice(acme, jane.ID, 500, "USD", day(time.August, 30)) // issued after the report date
+
+	report, err := Aging(db, 0, at)
+	assert.NoError(t, err)
+	assert.Len(t, report.Organizations, 2)
+
+	usd := report.Organizations[0]
+	assert.Equal(t, "Acme", usd.Organization)
+	assert.Equal(t, 6, usd.Invoices)
+	assert.Equal(t, AgingBuckets{Current: 150, Days1To30: 30, Days31To60: 20, Days61To90: 40, Over90: 10, Total: 250}, usd.AgingBuckets)
+	assert.Equal(t, []AgingCustomer{
+		{Customer: "Acme", Invoices: 2, AgingBuckets: AgingBuckets{Days61To90: 40, Over90: 10, Total: 50}},
+		{UserID: jane.ID, Customer: "jane", Invoices: 4, AgingBuckets: AgingBuckets{Current: 150, Days1To30: 30, Days31To60: 20, Total: 200}},
+	}, usd.Customers)
+
+	// Credits and pending payments reduce the balance. A short payment that
+	// went through was kept as credit, so it does not.
+	eur := report.Organizations[1]
+	assert.Equal(t, "EUR", eur.Currency)
+	assert.Equal(t, AgingBuckets{Days1To30: 115, Total: 115}, eur.AgingBuckets)
+	assert.Equal(t, map[string]AgingBuckets{"USD": usd.AgingBuckets, "EUR": eur.AgingBuckets}, report.Totals)
+
+	db.Model(&globex).Update("name", "+Globex")
+	report, err = Aging(db, globex.ID, at)
+	assert.NoError(t, err)
+	assert.Len(t, report.Organizations, 1)
+	assert.NotContains(t, report.Totals, "USD")
+
+	var csv strings.Builder
+	assert.NoError(t, WriteAgingCSV(&csv, report))
+	assert.Equal(t, fmt.Sprintf(`organization_id,organization,user_id,customer,currency,invoices,current,days_1_30,days_31_60,days_61_90,over_90,total
+%d,'+Globex,0,'+Globex,EUR,2,0.00,115.00,0.00,0.00,0.00,115.00
+,Total,,,EUR,,0.00,115.00,0.00,0.00,0.00,115.00
+`, globex.ID), csv.String())
+}
+
+func TestRecognition(t *testing.T) {
+	db := setupReportsDB(t)
+	org := models.Organization{Name: "Acme"}
+	other := models.Organization{Name: "Globex"}
+	db.Create(&org)
+	db.Create(&other)
+
+	finalize := func(org models.Organization, amount float64, currency string, issued, start, end time.Time) models.Invoice {
+		invoice := models.Invoice{OrganizationID: org.ID, Amount: amount, Currency: currency, IssueDate: issued, DueDate: issued, PeriodStart: start, PeriodEnd: end}
+		assert.NoError(t, billing.FinalizeInvoice(db, &invoice))
+		return invoice
+	}
+	yearly := finalize(org, 1200, "USD", day(time.January, 1), day(time.January, 1), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	finalize(org, 50, "EUR", day(time.February, 10), time.Time{}, time.Time{})
+	finalize(other, 999, "USD", day(time.January, 1), day(time.January, 1), day(time.February, 1))
+	refund := models.Refund{InvoiceID: yearly.ID, Amount: 120, Currency: "USD", RefundDate: day(time.March, 1), TransactionID: "re_1"}
+	db.Create(&refund)
+	assert.NoError(t, billing.RecognizeRefund(db, &refund))
+
+	report, err := Recognition(db, org.ID, day(time.January, 1), day(time.March, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, []RecognitionMonth{
+		{Month: "2026-01", Currency: "USD", Billed: 1200, Recognized: 101.92, DeferredEnd: 1098.08},
+		{Month: "2026-02", Currency: "EUR", Billed: 50, Recognized: 50},
+		{Month: "2026-02", Currency: "USD", DeferredStart: 1098.08, Recognized: 92.05, DeferredEnd: 1006.03},
+		{Month: "2026-03", Currency: "USD", DeferredStart: 1006.03, Adjustments: -120, Recognized: 89.76, DeferredEnd: 796.27},
+	}, report.Months)
+
+	report, err = Recognition(db, 0, day(time.January, 1), day(time.January, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, []RecognitionMonth{{Month: "2026-01", Currency: "USD", Billed: 2199, Recognized: 1100.92, DeferredEnd: 1098.08}}, report.Months)
+}
@@ -0,0 +1,70 @@
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"invoxa/billing"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+)
+
+// Revenue is what an organization has earned from paid invoices.
+type Revenue struct {
+	Total      float64            // in the reporting currency
+	ByCurrency map[string]float64 // in each invoice currency
+	// MissingExchangeRates lists currencies whose invoices could not be
+	// converted and are left out of Total.
+	MissingExchangeRates []string
+}
+
+// InvoiceRevenue returns the revenue an invoice brought in: nothing until it
+// is paid, then its amount before tax less the untaxed share of refunds.
+func InvoiceRevenue(invoice *models.Invoice) float64 {
+	if !invoice.Paid || invoice.Amount == 0 {
+		return 0
+	}
+	net := invoice.Amount
+	if invoice.Subtotal != 0 || invoice.TaxAmount != 0 {
+		net = invoice.Subtotal
+	}
+	var refunded float64
+	for _, refund := range invoice.Refunds {
+		if refund.ProviderStatus != payments.StatusFailed {
+			refunded += refund.Amount
+		}
+	}
+	return roundCents(max(net-refunded*net/invoice.Amount, 0))
+}
+
+// OrganizationRevenue sums the revenue of an organization's invoices,
+// converted to currency at the rate on each invoice's issue date.
+func OrganizationRevenue(db *gorm.DB, organizationID uint, currency string) (*Revenue, error) {
+	var invoices []models.Invoice
+	if err := db.Preload("Refunds").Where("organization_id = ? AND paid = ?", organizationID, true).Find(&invoices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load invoices: %w", err)
+	}
+
+	revenue := &Revenue{ByCurrency: map[string]float64{}}
+	for i := range invoices {
+		invoice := &invoices[i]
+		amount := InvoiceRevenue(invoice)
+		revenue.ByCurrency[invoice.Currency] = roundCents(revenue.ByCurrency[invoice.Currency] + amount)
+		converted, _, err := billing.Convert(db, amount, invoice.Currency, currency, invoice.IssueDate)
+		if errors.Is(err, billing.ErrNoExchangeRate) {
+			if !slices.Contains(revenue.MissingExchangeRates, invoice.Currency) {
+				revenue.MissingExchangeRates = append(revenue.MissingExchangeRates, invoice.Currency)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		revenue.Total += converted
+	}
+	revenue.Total = roundCents(revenue.Total)
+	return revenue, nil
+}
@@ -0,0 +1,254 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidQuery is returned for queries that cannot be parsed or use
+// unknown fields or malformed values.
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// Node is a parsed search query.
+type Node interface{ node() }
+
+// And matches records that match all of its nodes. Terms next to each other
+// are joined with And.
+type And []Node
+
+// Or matches records that match any of its nodes.
+type Or []Node
+
+// Not matches records that do not match its node.
+type Not struct{ Node Node }
+
+// Text is a free-text term or quoted phrase.
+type Text string
+
+// Field compares a field of a record with a value, e.g., 'amount:>=100' or
+// 'date:2026-03-01..2026-03-31'.
+type Field struct {
+	Name  string
+	Op    string // '=', '>', '>=', '<', '<=' or '..'
+	Value string
+	To    string // upper bound of a '..' range; either bound may be empty
+}
+
+func (And) node()   {}
+func (Or) node()    {}
+func (Not) node()   {}
+func (Text) node()  {}
+func (Field) node() {}
+
+type token struct {
+	text   string
+	quoted bool // a quoted phrase, never an operator or field
+	paren  rune
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidQuery, fmt.Sprintf(format, args...))
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, token{paren: r})
+			i++
+		default:
+			var b strings.Builder
+			quoted := r == '"'
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				if runes[i] != '"' {
+					b.WriteRune(runes[i])
+					i++
+					continue
+				}
+				// A quoted part runs to the closing quote, spaces included.
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end == len(runes) {
+					return nil, invalid("unterminated quote")
+				}
+				b.WriteString(string(runes[i+1 : end]))
+				i = end + 1
+			}
+			tokens = append(tokens, token{text: b.String(), quoted: quoted})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos == len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t != nil && !t.quoted && t.paren == 0 && t.text == word {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// Parse parses a search query. Terms are separated by spaces and all have to
+// match; OR between terms matches either of them. AND, NOT (or a leading
+// '-') and parentheses work as usual. A term is a word, a "quoted phrase" or
+// field:value, where the value can be compared with >, >=, < or <=, or be a
+// range such as 10..20.
+func Parse(input string) (Node, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, invalid("empty query")
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, invalid("unexpected %q", string(t.paren)+t.text)
+	}
+	return node, nil
+}
+
+func (p *parser) or() (Node, error) {
+	var nodes Or
+	for {
+		node, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if !p.keyword("OR") {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) and() (Node, error) {
+	var nodes And
+	for {
+		node, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if p.keyword("AND") {
+			continue
+		}
+		if t := p.peek(); t == nil || t.paren == ')' || (!t.quoted && t.text == "OR") {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) unary() (Node, error) {
+	if p.keyword("NOT") {
+		node, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{node}, nil
+	}
+
+	t := p.peek()
+	if t == nil {
+		return nil, invalid("unexpected end of query")
+	}
+	p.pos++
+	switch {
+	case t.paren == '(':
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.peek(); t == nil || t.paren != ')' {
+			return nil, invalid("missing )")
+		}
+		p.pos++
+		return node, nil
+	case t.paren == ')':
+		return nil, invalid("unexpected )")
+	case t.quoted:
+		return Text(t.text), nil
+	case t.text == "AND" || t.text == "OR":
+		return nil, invalid("unexpected %s", t.text)
+	case len(t.text) > 1 && t.text[0] == '-':
+		node, err := term(t.text[1:])
+		if err != nil {
+			return nil, err
+		}
+		return Not{node}, nil
+	}
+	return term(t.text)
+}
+
+func term(text string) (Node, error) {
+	name, value, ok := strings.Cut(text, ":")
+	if !ok || !isFieldName(name) {
+		return Text(text), nil
+	}
+
+	field := Field{Name: strings.ToLower(name), Op: "="}
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(value, op) {
+			field.Op, value = op, value[len(op):]
+			break
+		}
+	}
+	if from, to, ok := strings.Cut(value, ".."); ok && field.Op == "=" {
+		if from == "" && to == "" {
+			return nil, invalid("empty range for %s", field.Name)
+		}
+		field.Op, field.Value, field.To = "..", from, to
+		return field, nil
+	}
+	if value == "" {
+		return nil, invalid("missing value for %s", field.Name)
+	}
+	field.Value = value
+	return field, nil
+}
+
+func isFieldName(s string) bool {
+	if s == "" || !unicode.IsLetter([]rune(s)[0]) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
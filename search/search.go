@@ -0,0 +1,641 @@
+// Package search finds invoices, payments, refunds and users across all
+// organizations with a small query language (see Parse). On Postgres, free
+// text is matched with full-text search; other databases, such as the sqlite
+// used in tests, fall back to case-insensitive substring matching.
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"invoxa/billing"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+)
+
+// Hit is a record that matched a search.
+type Hit struct {
+	Type           string    `json:"type"` // 'invoice', 'payment', 'refund' or 'user'
+	ID             uint      `json:"id"`
+	OrganizationID uint      `json:"organization_id"`
+	Organization   string    `json:"organization"`
+	Summary        string    `json:"summary"`
+	Amount         float64   `json:"amount,omitempty"`
+	Currency       string    `json:"currency,omitempty"`
+	Status         string    `json:"status,omitempty"`
+	Date           time.Time `json:"date"`
+}
+
+// textColumns are the columns matched by free text in each table.
+var textColumns = map[string][]string{
+	"invoices":        {"number", "currency"},
+	"payments":        {"transaction_id", "provider_charge_id", "payment_method", "currency"},
+	"refunds":         {"transaction_id", "provider_refund_id", "reason", "currency"},
+	"users":           {"username", "email"},
+	"organizations":   {"name", "billing_email"},
+	"payment_methods": {"type", "brand", "last4"},
+}
+
+func textExpression(table string) string {
+	parts := make([]string, len(textColumns[table]))
+	for i, column := range textColumns[table] {
+		parts[i] = fmt.Sprintf("COALESCE(%s, '')", column)
+	}
+	return strings.Join(parts, " || ' ' || ")
+}
+
+// CreateIndexes adds the full-text indexes used by searches on Postgres. It
+// does nothing on other databases.
+func CreateIndexes(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for table := range textColumns {
+		sql := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_search ON %s USING GIN (to_tsvector('simple', %s))", table, table, textExpression(table))
+		if err := db.Exec(sql).Error; err != nil {
+			return fmt.Errorf("failed to create search index on %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// condition is a SQL boolean expression with its arguments.
+type condition struct {
+	sql  string
+	args []any
+}
+
+var (
+	always = condition{sql: "1 = 1"}
+	never  = condition{sql: "1 = 0"}
+)
+
+func join(op string, conditions []condition) condition {
+	var parts []string
+	var args []any
+	for _, c := range conditions {
+		parts = append(parts, "("+c.sql+")")
+		args = append(args, c.args...)
+	}
+	return condition{sql: strings.Join(parts, " "+op+" "), args: args}
+}
+
+func anyOf(conditions ...condition) condition { return join("OR", conditions) }
+
+// in matches rows whose column is among the IDs of a table's rows matching c.
+func in(column, table string, c condition) condition {
+	return condition{
+		sql:  fmt.Sprintf("%s IN (SELECT id FROM %s WHERE deleted_at IS NULL AND (%s))", column, table, c.sql),
+		args: c.args,
+	}
+}
+
+func likePattern(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(value))
+	return "%" + value + "%"
+}
+
+// contains matches a column containing value, ignoring case.
+func contains(column, value string) condition {
+	return condition{sql: fmt.Sprintf(`LOWER(%s) LIKE ? ESCAPE '\'`, column), args: []any{likePattern(value)}}
+}
+
+// compiler turns queries into conditions on one table.
+type compiler struct {
+	postgres bool
+	now      time.Time
+}
+
+// text matches free text against the text columns of a table.
+func (c *compiler) text(table, value string) condition {
+	if c.postgres {
+		return condition{sql: fmt.Sprintf("to_tsvector('simple', %s) @@ plainto_tsquery('simple', ?)", textExpression(table)), args: []any{value}}
+	}
+	return contains(textExpression(table), value)
+}
+
+// fieldFunc compiles a field for a kind of record.
+type fieldFunc func(c *compiler, f Field) (condition, error)
+
+// kind is a type of record that can be searched.
+type kind struct {
+	name string
+	date string // column hits are ordered by
+	// text matches free text against the record and its related records.
+	text   func(c *compiler, value string) condition
+	fields map[string]fieldFunc
+	load   func(db *gorm.DB, now time.Time, limit int) ([]Hit, error)
+}
+
+func (c *compiler) compile(k *kind, node Node) (condition, error) {
+	switch n := node.(type) {
+	case And, Or:
+		nodes, op := []Node(nil), ""
+		if and, ok := n.(And); ok {
+			nodes, op = and, "AND"
+		} else {
+			nodes, op = n.(Or), "OR"
+		}
+		conditions := make([]condition, len(nodes))
+		for i, child := range nodes {
+			var err error
+			if conditions[i], err = c.compile(k, child); err != nil {
+				return condition{}, err
+			}
+		}
+		return join(op, conditions), nil
+	case Not:
+		inner, err := c.compile(k, n.Node)
+		if err != nil {
+			return condition{}, err
+		}
+		return condition{sql: "NOT (" + inner.sql + ")", args: inner.args}, nil
+	case Text:
+		return k.text(c, string(n)), nil
+	case Field:
+		if n.Name == "type" {
+			if n.Op != "=" {
+				return condition{}, invalid("type only takes a value")
+			}
+			if strings.EqualFold(n.Value, k.name) {
+				return always, nil
+			}
+			return never, nil
+		}
+		field, ok := k.fields[n.Name]
+		if !ok {
+			return never, nil
+		}
+		return field(c, n)
+	}
+	return condition{}, invalid("unsupported query")
+}
+
+// fieldNames lists the fields some kind of record knows.
+func fieldNames() map[string]bool {
+	names := map[string]bool{"type": true}
+	for _, k := range kinds {
+		for name := range k.fields {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func checkFields(node Node, names map[string]bool) error {
+	switch n := node.(type) {
+	case And:
+		for _, child := range n {
+			if err := checkFields(child, names); err != nil {
+				return err
+			}
+		}
+	case Or:
+		for _, child := range n {
+			if err := checkFields(child, names); err != nil {
+				return err
+			}
+		}
+	case Not:
+		return checkFields(n.Node, names)
+	case Field:
+		if !names[n.Name] {
+			return invalid("unknown field %s", n.Name)
+		}
+	}
+	return nil
+}
+
+// Run searches all organizations' records and returns up to limit hits,
+// newest first.
+func Run(db *gorm.DB, query string, now time.Time, limit int) ([]Hit, error) {
+	node, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFields(node, fieldNames()); err != nil {
+		return nil, err
+	}
+
+	c := &compiler{postgres: db.Dialector.Name() == "postgres", now: now}
+	hits := []Hit{}
+	for _, k := range kinds {
+		where, err := c.compile(k, node)
+		if err != nil {
+			return nil, err
+		}
+		found, err := k.load(db.Where(where.sql, where.args...).Order(k.date+" DESC").Order("id DESC"), now, limit)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, found...)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Date.After(hits[j].Date) })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// ownOrganization scopes tables with an organization_id.
+func ownOrganization(c condition) condition {
+	return in("organization_id", "organizations", c)
+}
+
+// invoiceOrganization scopes tables with an invoice_id.
+func invoiceOrganization(c condition) condition {
+	return in("invoice_id", "invoices", ownOrganization(c))
+}
+
+// settled excludes payments that failed or were declined.
+var settled = condition{sql: "provider_status NOT IN (?, ?)", args: []any{payments.StatusFailed, payments.StatusDeclined}}
+
+func organizationField(k func(condition) condition) fieldFunc {
+	return func(c *compiler, f Field) (condition, error) {
+		if f.Op != "=" {
+			return condition{}, invalid("%s only takes a value", f.Name)
+		}
+		return k(contains("name", f.Value)), nil
+	}
+}
+
+func containsField(column string) fieldFunc {
+	return func(c *compiler, f Field) (condition, error) {
+		if f.Op != "=" {
+			return condition{}, invalid("%s only takes a value", f.Name)
+		}
+		return contains(column, f.Value), nil
+	}
+}
+
+func equalField(column string, normalize func(string) string) fieldFunc {
+	return func(c *compiler, f Field) (condition, error) {
+		if f.Op != "=" {
+			return condition{}, invalid("%s only takes a value", f.Name)
+		}
+		return condition{sql: column + " = ?", args: []any{normalize(f.Value)}}, nil
+	}
+}
+
+// relatedField applies a field to related records, e.g., the payment methods
+// of payments.
+func relatedField(column, table string, field fieldFunc) fieldFunc {
+	return func(c *compiler, f Field) (condition, error) {
+		inner, err := field(c, f)
+		if err != nil {
+			return condition{}, err
+		}
+		return in(column, table, inner), nil
+	}
+}
+
+// paymentsOf matches invoices with a settled payment matching the field.
+func paymentsOf(field fieldFunc) fieldFunc {
+	return func(c *compiler, f Field) (condition, error) {
+		inner, err := field(c, f)
+		if err != nil {
+			return condition{}, err
+		}
+		return condition{
+			sql:  fmt.Sprintf("id IN (SELECT invoice_id FROM payments WHERE deleted_at IS NULL AND %s AND (%s))", settled.sql, inner.sql),
+			args: append(append([]any{}, settled.args...), inner.args...),
+		}, nil
+	}
+}
+
+var (
+	userField   = relatedField("user_id", "users", containsField("username"))
+	last4Field  = relatedField("payment_method_id", "payment_methods", equalField("last4", strings.TrimSpace))
+	methodField = func(c *compiler, f Field) (condition, error) {
+		if f.Op != "=" {
+			return condition{}, invalid("%s only takes a value", f.Name)
+		}
+		methods := anyOf(contains("type", f.Value), contains("brand", f.Value))
+		return anyOf(contains("payment_method", f.Value), in("payment_method_id", "payment_methods", methods)), nil
+	}
+	invoiceNumberField = relatedField("invoice_id", "invoices", containsField("number"))
+)
+
+func transactionField(providerColumn string) fieldFunc {
+	return func(c *compiler, f Field) (condition, error) {
+		if f.Op != "=" {
+			return condition{}, invalid("%s only takes a value", f.Name)
+		}
+		return condition{sql: "transaction_id = ? OR " + providerColumn + " = ?", args: []any{f.Value, f.Value}}, nil
+	}
+}
+
+var (
+	lower = strings.ToLower
+	upper = strings.ToUpper
+)
+
+var kinds = []*kind{
+	{
+		name: "invoice",
+		date: "issue_date",
+		text: func(c *compiler, value string) condition {
+			methods := in("payment_method_id", "payment_methods", c.text("payment_methods", value))
+			return anyOf(
+				c.text("invoices", value),
+				ownOrganization(c.text("organizations", value)),
+				condition{sql: "id IN (SELECT invoice_id FROM payments WHERE deleted_at IS NULL AND (" + methods.sql + "))", args: methods.args},
+			)
+		},
+		fields: map[string]fieldFunc{
+			"id":       numberField("id"),
+			"org":      organizationField(ownOrganization),
+			"number":   containsField("number"),
+			"status":   invoiceStatusField,
+			"amount":   numberField("amount"),
+			"currency": equalField("currency", upper),
+			"date":     dateField("issue_date"),
+			"due":      dateField("due_date"),
+			"user":     userField,
+			"last4":    paymentsOf(last4Field),
+			"method":   paymentsOf(methodField),
+			"paid":     paymentsOf(dateField("payment_date")),
+		},
+		load: loadInvoices,
+	},
+	{
+		name: "payment",
+		date: "payment_date",
+		text: func(c *compiler, value string) condition {
+			return anyOf(
+				c.text("payments", value),
+				invoiceOrganization(c.text("organizations", value)),
+				in("payment_method_id", "payment_methods", c.text("payment_methods", value)),
+			)
+		},
+		fields: map[string]fieldFunc{
+			"id":          numberField("id"),
+			"org":         organizationField(invoiceOrganization),
+			"invoice":     invoiceNumberField,
+			"status":      equalField("provider_status", lower),
+			"amount":      numberField("amount"),
+			"currency":    equalField("currency", upper),
+			"date":        dateField("payment_date"),
+			"user":        userField,
+			"last4":       last4Field,
+			"method":      methodField,
+			"transaction": transactionField("provider_charge_id"),
+		},
+		load: loadPayments,
+	},
+	{
+		name: "refund",
+		date: "refund_date",
+		text: func(c *compiler, value string) condition {
+			return anyOf(c.text("refunds", value), invoiceOrganization(c.text("organizations", value)))
+		},
+		fields: map[string]fieldFunc{
+			"id":          numberField("id"),
+			"org":         organizationField(invoiceOrganization),
+			"invoice":     invoiceNumberField,
+			"payment":     numberField("payment_id"),
+			"status":      equalField("provider_status", lower),
+			"amount":      numberField("amount"),
+			"currency":    equalField("currency", upper),
+			"date":        dateField("refund_date"),
+			"user":        userField,
+			"reason":      containsField("reason"),
+			"transaction": transactionField("provider_refund_id"),
+		},
+		load: loadRefunds,
+	},
+	{
+		name: "user",
+		date: "created_at",
+		text: func(c *compiler, value string) condition {
+			return anyOf(c.text("users", value), ownOrganization(c.text("organizations", value)))
+		},
+		fields: map[string]fieldFunc{
+			"id":    numberField("id"),
+			"org":   organizationField(ownOrganization),
+			"user":  containsField("username"),
+			"email": containsField("email"),
+			"date":  dateField("created_at"),
+		},
+		load: loadUsers,
+	},
+}
+
+func invoiceStatusField(c *compiler, f Field) (condition, error) {
+	if f.Op != "=" {
+		return condition{}, invalid("status only takes a value")
+	}
+	switch lower(f.Value) {
+	case "paid":
+		return condition{sql: "paid = ?", args: []any{true}}, nil
+	case "uncollectible":
+		return condition{sql: "uncollectible = ?", args: []any{true}}, nil
+	case "open":
+		return condition{sql: "paid = ? AND uncollectible = ? AND due_date >= ?", args: []any{false, false, c.now}}, nil
+	case "past_due":
+		return condition{sql: "paid = ? AND uncollectible = ? AND due_date < ?", args: []any{false, false, c.now}}, nil
+	}
+	// Other statuses are those of payments and refunds.
+	return never, nil
+}
+
+func invoiceStatus(invoice *models.Invoice, now time.Time) string {
+	switch {
+	case invoice.Paid:
+		return "paid"
+	case invoice.Uncollectible:
+		return "uncollectible"
+	case now.After(invoice.DueDate):
+		return "past_due"
+	}
+	return "open"
+}
+
+func loadInvoices(db *gorm.DB, now time.Time, limit int) ([]Hit, error) {
+	var invoices []models.Invoice
+	if err := db.Preload("Organization").Limit(limit).Find(&invoices).Error; err != nil {
+		return nil, fmt.Errorf("failed to search invoices: %w", err)
+	}
+	hits := make([]Hit, len(invoices))
+	for i, invoice := range invoices {
+		hits[i] = Hit{
+			Type:           "invoice",
+			ID:             invoice.ID,
+			OrganizationID: invoice.OrganizationID,
+			Organization:   invoice.Organization.Name,
+			Summary:        "Invoice " + billing.InvoiceNumber(&invoice),
+			Amount:         invoice.Amount,
+			Currency:       invoice.Currency,
+			Status:         invoiceStatus(&invoice, now),
+			Date:           invoice.IssueDate,
+		}
+	}
+	return hits, nil
+}
+
+func loadPayments(db *gorm.DB, now time.Time, limit int) ([]Hit, error) {
+	var found []models.Payment
+	if err := db.Preload("Invoice.Organization").Limit(limit).Find(&found).Error; err != nil {
+		return nil, fmt.Errorf("failed to search payments: %w", err)
+	}
+	hits := make([]Hit, len(found))
+	for i, payment := range found {
+		hits[i] = Hit{
+			Type:           "payment",
+			ID:             payment.ID,
+			OrganizationID: payment.Invoice.OrganizationID,
+			Organization:   payment.Invoice.Organization.Name,
+			Summary:        fmt.Sprintf("Payment for invoice %s", billing.InvoiceNumber(&payment.Invoice)),
+			Amount:         payment.Amount,
+			Currency:       payment.Currency,
+			Status:         payment.ProviderStatus,
+			Date:           payment.PaymentDate,
+		}
+	}
+	return hits, nil
+}
+
+func loadRefunds(db *gorm.DB, now time.Time, limit int) ([]Hit, error) {
+	var refunds []models.Refund
+	if err := db.Preload("Invoice.Organization").Limit(limit).Find(&refunds).Error; err != nil {
+		return nil, fmt.Errorf("failed to search refunds: %w", err)
+	}
+	hits := make([]Hit, len(refunds))
+	for i, refund := range refunds {
+		hits[i] = Hit{
+			Type:           "refund",
+			ID:             refund.ID,
+			OrganizationID: refund.Invoice.OrganizationID,
+			Organization:   refund.Invoice.Organization.Name,
+			Summary:        fmt.Sprintf("Refund for invoice %s", billing.InvoiceNumber(&refund.Invoice)),
+			Amount:         refund.Amount,
+			Currency:       refund.Currency,
+			Status:         refund.ProviderStatus,
+			Date:           refund.RefundDate,
+		}
+	}
+	return hits, nil
+}
+
+func loadUsers(db *gorm.DB, now time.Time, limit int) ([]Hit, error) {
+	var users []models.User
+	if err := db.Preload("Organization").Limit(limit).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	hits := make([]Hit, len(users))
+	for i, user := range users {
+		hits[i] = Hit{
+			Type:           "user",
+			ID:             user.ID,
+			OrganizationID: user.OrganizationID,
+			Organization:   user.Organization.Name,
+			Summary:        fmt.Sprintf("%s <%s>", user.Username, user.Email),
+			Date:           user.CreatedAt,
+		}
+	}
+	return hits, nil
+}
+
+func numberField(column string) fieldFunc {
+	return func(c *compiler, f Field) (condition, error) {
+		parse := func(s string) (float64, error) {
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return 0, invalid("%s must be a number", f.Name)
+			}
+			return n, nil
+		}
+		if f.Op != ".." {
+			n, err := parse(f.Value)
+			if err != nil {
+				return condition{}, err
+			}
+			return condition{sql: column + " " + f.Op + " ?", args: []any{n}}, nil
+		}
+
+		var bounds []condition
+		for _, bound := range []struct{ value, op string }{{f.Value, ">="}, {f.To, "<="}} {
+			if bound.value == "" {
+				continue
+			}
+			n, err := parse(bound.value)
+			if err != nil {
+				return condition{}, err
+			}
+			bounds = append(bounds, condition{sql: column + " " + bound.op + " ?", args: []any{n}})
+		}
+		return join("AND", bounds), nil
+	}
+}
+
+// dateSpan parses a day (2026-03-01), month (2026-03) or year (2026) into
+// the time it covers.
+func dateSpan(s string) (time.Time, time.Time, error) {
+	spans := []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	}
+	for _, span := range spans {
+		if start, err := time.Parse(span.layout, s); err == nil {
+			return start, start.AddDate(span.years, span.months, span.days), nil
+		}
+	}
+	return time.Time{}, time.Time{}, invalid("%q is not a date; expected YYYY-MM-DD, YYYY-MM or YYYY", s)
+}
+
+// dateField compares a date column with a day, month or year. A month or
+// year matches any time within it, so 'date:2026-03' finds all of March and
+// 'date:>2026-03' starts in April.
+func dateField(column string) fieldFunc {
+	return func(c *compiler, f Field) (condition, error) {
+		after := func(t time.Time) condition { return condition{sql: column + " >= ?", args: []any{t}} }
+		before := func(t time.Time) condition { return condition{sql: column + " < ?", args: []any{t}} }
+
+		if f.Op == ".." {
+			var bounds []condition
+			if f.Value != "" {
+				start, _, err := dateSpan(f.Value)
+				if err != nil {
+					return condition{}, err
+				}
+				bounds = append(bounds, after(start))
+			}
+			if f.To != "" {
+				_, end, err := dateSpan(f.To)
+				if err != nil {
+					return condition{}, err
+				}
+				bounds = append(bounds, before(end))
+			}
+			return join("AND", bounds), nil
+		}
+
+		start, end, err := dateSpan(f.Value)
+		if err != nil {
+			return condition{}, err
+		}
+		switch f.Op {
+		case ">":
+			return after(end), nil
+		case ">=":
+			return after(start), nil
+		case "<":
+			return before(start), nil
+		case "<=":
+			return before(end), nil
+		}
+		return join("AND", []condition{after(start), before(end)}), nil
+	}
+}
@@ -0,0 +1,133 @@
+package search
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestParse(t *testing.T) {
+	node, err := Parse(`org:acme last4:4242 (status:paid OR amount:>=100) -reason:"customer request" "late fee"`)
+	assert.NoError(t, err)
+	assert.Equal(t, And{
+		Field{Name: "org", Op: "=", Value: "acme"},
+		Field{Name: "last4", Op: "=", Value: "4242"},
+		Or{
+			Field{Name: "status", Op: "=", Value: "paid"},
+			Field{Name: "amount", Op: ">=", Value: "100"},
+		},
+		Not{Field{Name: "reason", Op: "=", Value: "customer request"}},
+		Text("late fee"),
+	}, node)
+
+	node, err = Parse("date:2026-03..2026-04 AND NOT refund")
+	assert.NoError(t, err)
+	assert.Equal(t, And{Field{Name: "date", Op: "..", Value: "2026-03", To: "2026-04"}, Not{Text("refund")}}, node)
+
+	node, err = Parse("amount:..50 OR 12:30")
+	assert.NoError(t, err)
+	assert.Equal(t, Or{Field{Name: "amount", Op: "..", To: "50"}, Text("12:30")}, node)
+
+	for _, query := range []string{"", "   ", "(acme", "acme)", "acme OR", "AND acme", "acme AND", `"acme`, "amount:", "amount:.."} {
+		_, err := Parse(query)
+		assert.True(t, errors.Is(err, ErrInvalidQuery), "query %q: %v", query, err)
+	}
+}
+
+func setupSearchDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(database.Models...))
+	assert.NoError(t, CreateIndexes(db))
+	return db
+}
+
+func TestRunFindsRecordsAcrossOrganizations(t *testing.T) {
+	db := setupSearchDB(t)
+	now := time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC)
+
+	acme := models.Organization{Name: "Acme Corp", BillingEmail: "billing@acme.example"}
+	globex := models.Organization{Name: "Globex", BillingEmail: "ap@globex.example"}
+	db.Create(&acme)
+	db.Create(&globex)
+	jane := models.User{Username: "jane.doe", Email: "jane@acme.example", PasswordHash: "x", OrganizationID: acme.ID}
+	db.Create(&jane)
+	visa := models.PaymentMethod{OrganizationID: acme.ID, Type: "card", Brand: "Visa", Last4: "4242", ProviderToken: "tok_visa"}
+	amex := models.PaymentMethod{OrganizationID: acme.ID, Type: "card", Brand: "Amex", Last4: "0005", ProviderToken: "tok_amex"}
+	db.Create(&visa)
+	db.Create(&amex)
+
+	invoice := func(org *models.Organization, number string, amount float64, issued time.Time, paid bool) models.Invoice {
+		invoice := models.Invoice{OrganizationID: org.ID, Number: &number, Amount: amount, Currency: "USD", IssueDate: issued, DueDate: issued.AddDate(0, 0, 30), Paid: paid}
+		db.Create(&invoice)
+		return invoice
+	}
+	march := invoice(&acme, "INV-2026-000003", 120, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), true)
+	february := invoice(&acme, "INV-2026-000002", 80, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), true)
+	invoice(&acme, "INV-2026-000004", 40, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), false)
+	invoice(&globex, "GLX-2026-000001", 120, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), true)
+
+	paidByVisa := models.Payment{InvoiceID: march.ID, UserID: jane.ID, Amount: 120, Currency: "USD", PaymentDate: time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC),
+		TransactionID: "ch_1", PaymentMethodID: &visa.ID, ProviderStatus: "succeeded"}
+	db.Create(&paidByVisa)
+	db.Create(&models.Payment{InvoiceID: february.ID, Amount: 80, Currency: "USD", PaymentDate: time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+		TransactionID: "ch_2", PaymentMethodID: &amex.ID, ProviderStatus: "succeeded"})
+	db.Create(&models.Refund{InvoiceID: march.ID, PaymentID: paidByVisa.ID, Amount: 20, Currency: "USD", RefundDate: time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC),
+		TransactionID: "re_1", Reason: "Customer request"})
+
+	search := func(query string) []string {
+		hits, err := Run(db, query, now, 50)
+		assert.NoError(t, err, query)
+		summaries := []string{}
+		for _, hit := range hits {
+			summaries = append(summaries, hit.Type+" "+hit.Summary)
+		}
+		return summaries
+	}
+
+	// "The invoice for acme that was paid by card ending 4242 last March."
+	assert.Equal(t, []string{"invoice Invoice INV-2026-000003"}, search("type:invoice org:acme last4:4242 paid:2026-03"))
+
+	assert.Equal(t, []string{
+		"refund Refund for invoice INV-2026-000003",
+		"payment Payment for invoice INV-2026-000003",
+		"payment Payment for invoice INV-2026-000002",
+	}, search("acme (type:payment OR type:refund)"))
+	assert.Equal(t, []string{
+		"payment Payment for invoice INV-2026-000003",
+		"invoice Invoice INV-2026-000003",
+	}, search("visa"))
+	assert.Equal(t, []string{"refund Refund for invoice INV-2026-000003"}, search(`reason:"customer request"`))
+	assert.Equal(t, []string{"user jane.doe <jane@acme.example>"}, search("email:jane@acme"))
+	assert.Equal(t, []string{
+		"invoice Invoice GLX-2026-000001",
+		"invoice Invoice INV-2026-000003",
+	}, search("type:invoice amount:100..200"))
+	assert.Equal(t, []string{"invoice Invoice INV-2026-000004"}, search("type:invoice status:open"))
+	assert.Equal(t, []string{"invoice Invoice INV-2026-000002"}, search("type:invoice org:acme date:<2026-03"))
+	assert.Equal(t, []string{
+		"invoice Invoice INV-2026-000004",
+		"invoice Invoice INV-2026-000002",
+	}, search("type:invoice acme -number:000003"))
+	assert.Equal(t, []string{
+		"payment Payment for invoice INV-2026-000003",
+		"payment Payment for invoice INV-2026-000002",
+	}, search("status:succeeded"))
+	assert.Empty(t, search("initech"))
+
+	for _, query := range []string{"colour:blue", "amount:lots", "date:March", "type:>invoice"} {
+		_, err := Run(db, query, now, 50)
+		assert.True(t, errors.Is(err, ErrInvalidQuery), "query %q: %v", query, err)
+	}
+
+	hits, err := Run(db, "acme", now, 2)
+	assert.NoError(t, err)
+	assert.Len(t, hits, 2)
+}
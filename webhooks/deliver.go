@@ -0,0 +1,173 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"invoxa/models"
+	"invoxa/payments"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxAttempts is the number of deliveries tried before giving up on an event.
+	MaxAttempts = 8
+	// InitialBackoff is the wait after the first failed attempt; it doubles
+	// with every further failure up to MaxBackoff.
+	InitialBackoff = 30 * time.Second
+	MaxBackoff     = 6 * time.Hour
+
+	maxLoggedBody = 1024
+)
+
+// Client sends webhook requests. Endpoints that take longer than the timeout
+// count as failed and are retried. It connects directly, never through a
+// proxy, and refuses internal addresses.
+var Client = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: checkDialAddress}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// Backoff returns how long to wait before retrying after the given number
+// of failed attempts.
+func Backoff(attempts int) time.Duration {
+	wait := InitialBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= MaxBackoff {
+			return MaxBackoff
+		}
+	}
+	return wait
+}
+
+type DeliveryReport struct {
+	Attempted int `json:"attempted"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"` // gave up after MaxAttempts
+}
+
+// DeliverDue attempts every pending delivery whose next attempt is due.
+func DeliverDue(ctx context.Context, db *gorm.DB, now time.Time) (*DeliveryReport, error) {
+	var deliveries []models.WebhookDelivery
+	err := db.Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+		Order("next_attempt_at, id").
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load due webhook deliveries: %w", err)
+	}
+
+	report := &DeliveryReport{}
+	for i := range deliveries {
+		if err := Deliver(ctx, db, &deliveries[i], now); err != nil {
+			return report, err
+		}
+		report.Attempted++
+		switch deliveries[i].Status {
+		case DeliverySucceeded:
+			report.Succeeded++
+		case DeliveryFailed:
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// Deliver posts the event to its endpoint once, logs the attempt, and either
+// marks the delivery succeeded or schedules the next retry. Only database
+// errors are returned; a failed request is recorded on the delivery.
+func Deliver(ctx context.Context, db *gorm.DB, delivery *models.WebhookDelivery, now time.Time) error {
+	var endpoint models.WebhookEndpoint
+	err := db.Unscoped().First(&endpoint, delivery.EndpointID).Error
+	if err != nil {
+		return fmt.Errorf("failed to load webhook endpoint %d: %w", delivery.EndpointID, err)
+	}
+
+	attempt := models.WebhookAttempt{DeliveryID: delivery.ID, AttemptedAt: now}
+	if endpoint.DeletedAt.Valid || !endpoint.Active {
+		attempt.Error = "endpoint is disabled"
+	} else {
+		post(ctx, &endpoint, delivery, &attempt)
+	}
+
+	delivery.Attempts++
+	delivery.LastStatusCode = attempt.StatusCode
+	delivery.LastError = attempt.Error
+	switch {
+	case attempt.Error == "":
+		delivery.Status = DeliverySucceeded
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= MaxAttempts || endpoint.DeletedAt.Valid:
+		delivery.Status = DeliveryFailed
+		delivery.NextAttemptAt = nil
+	default:
+		next := now.Add(Backoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return fmt.Errorf("failed to log webhook attempt: %w", err)
+		}
+		err := tx.Model(delivery).Updates(map[string]interface{}{
+			"status":           delivery.Status,
+			"attempts":         delivery.Attempts,
+			"next_attempt_at":  delivery.NextAttemptAt,
+			"delivered_at":     delivery.DeliveredAt,
+			"last_status_code": delivery.LastStatusCode,
+			"last_error":       delivery.LastError,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update webhook delivery %d: %w", delivery.ID, err)
+		}
+		return nil
+	})
+}
+
+// post sends a signed delivery and records the outcome on the attempt. Any
+// 2xx response counts as success.
+func post(ctx context.Context, endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery, attempt *models.WebhookAttempt) {
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Invoxa-Webhooks/1.0")
+	req.Header.Set(payments.SignatureHeader, payments.SignPayload(payload, endpoint.Secret, time.Now()))
+
+	start := time.Now()
+	resp, err := Client.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxLoggedBody))
+	attempt.StatusCode = resp.StatusCode
+	attempt.ResponseBody = string(body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("endpoint responded with %s", resp.Status)
+	}
+}
+
+// Resend queues a delivery for another attempt, including ones that already
+// succeeded or gave up, and attempts it immediately.
+func Resend(ctx context.Context, db *gorm.DB, delivery *models.WebhookDelivery, now time.Time) error {
+	delivery.Status = DeliveryPending
+	delivery.Attempts = 0
+	return Deliver(ctx, db, delivery, now)
+}
@@ -0,0 +1,150 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/database"
+	"invoxa/events"
+	"invoxa/models"
+	"invoxa/payments"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupWebhookDB(t *testing.T) *gorm.DB {
+	// Test endpoints listen on loopback.
+	AllowInternalAddresses = true
+	t.Cleanup(func() { AllowInternalAddresses = false })
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(database.Models...))
+	return db
+}
+
+func testEvent(organizationID uint, eventType, data string) *events.Event {
+	return &events.Event{ID: events.NewID(), Type: eventType, OrganizationID: organizationID, OccurredAt: time.Now(), Data: json.RawMessage(data)}
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1))
+	assert.Equal(t, time.Minute, Backoff(2))
+	assert.Equal(t, 4*time.Minute, Backoff(4))
+	assert.Equal(t, MaxBackoff, Backoff(20))
+}
+
+func TestEnqueueRespectsSubscriptions(t *testing.T) {
+	db := setupWebhookDB(t)
+
+	db.Create(&models.WebhookEndpoint{OrganizationID: 1, URL: "http://a.test", Secret: "s1", Active: true})
+	db.Create(&models.WebhookEndpoint{OrganizationID: 1, URL: "http://b.test", Secret: "s2", Events: "invoice.paid", Active: true})
+	db.Create(&models.WebhookEndpoint{OrganizationID: 2, URL: "http://c.test", Secret: "s3", Active: true})
+
+	assert.NoError(t, HandleEvent(context.Background(), db, testEvent(1, events.InvoiceCreated, `{"id":7}`)))
+	assert.NoError(t, HandleEvent(context.Background(), db, testEvent(1, events.InvoicePaid, `{"id":7}`)))
+
+	var deliveries []models.WebhookDelivery
+	db.Order("id").Find(&deliveries)
+	assert.Len(t, deliveries, 3)
+	assert.Equal(t, events.InvoiceCreated, deliveries[0].EventType)
+	// Both endpoints receive the same event ID for invoice.paid.
+	assert.Equal(t, deliveries[1].EventID, deliveries[2].EventID)
+
+	var event Event
+	assert.NoError(t, json.Unmarshal([]byte(deliveries[0].Payload), &event))
+	assert.Equal(t, uint(1), event.OrganizationID)
+	assert.JSONEq(t, `{"id":7}`, string(event.Data))
+
+	assert.Error(t, ValidEventTypes("invoice.paid,invoice.deleted"))
+	assert.NoError(t, ValidEventTypes("invoice.paid, payment.refunded"))
+}
+
+func TestDeliverRetriesWithBackoffAndSigns(t *testing.T) {
+	db := setupWebhookDB(t)
+
+	failing := true
+	var received []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(payments.SignatureHeader)
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("boom"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	endpoint := models.WebhookEndpoint{OrganizationID: 1, URL: server.URL, Secret: "whsec_test", Active: true}
+	db.Create(&endpoint)
+	assert.NoError(t, HandleEvent(context.Background(), db, testEvent(1, events.PaymentRefunded, `{"amount":5}`)))
+
+	now := time.Now()
+	report, err := DeliverDue(context.Background(), db, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Attempted)
+	assert.Equal(t, 0, report.Succeeded)
+
+	var delivery models.WebhookDelivery
+	db.First(&delivery)
+	assert.Equal(t, DeliveryPending, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, delivery.LastStatusCode)
+	assert.WithinDuration(t, now.Add(InitialBackoff), *delivery.NextAttemptAt, time.Second)
+	assert.NoError(t, payments.VerifySignature(received, signature, "whsec_test", payments.DefaultTolerance, time.Now()))
+
+	// Nothing is due until the backoff has passed.
+	report, _ = DeliverDue(context.Background(), db, now.Add(time.Second))
+	assert.Equal(t, 0, report.Attempted)
+
+	failing = false
+	report, _ = DeliverDue(context.Background(), db, now.Add(InitialBackoff))
+	assert.Equal(t, 1, report.Succeeded)
+
+	var delivered models.WebhookDelivery
+	db.Preload("Log").First(&delivered, delivery.ID)
+	assert.Equal(t, DeliverySucceeded, delivered.Status)
+	assert.Nil(t, delivered.NextAttemptAt)
+	assert.Len(t, delivered.Log, 2)
+	assert.Equal(t, "boom", delivered.Log[0].ResponseBody)
+}
+
+func TestDeliverGivesUpAfterMaxAttempts(t *testing.T) {
+	db := setupWebhookDB(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	db.Create(&models.WebhookEndpoint{OrganizationID: 1, URL: server.URL, Secret: "s", Active: true})
+	assert.NoError(t, HandleEvent(context.Background(), db, testEvent(1, events.InvoiceCreated, `{}`)))
+
+	now := time.Now()
+	for i := 0; i < MaxAttempts; i++ {
+		_, err := DeliverDue(context.Background(), db, now)
+		assert.NoError(t, err)
+		now = now.Add(MaxBackoff)
+	}
+
+	var delivery models.WebhookDelivery
+	db.First(&delivery)
+	assert.Equal(t, DeliveryFailed, delivery.Status)
+	assert.Equal(t, MaxAttempts, delivery.Attempts)
+
+	// A manual resend tries again even after giving up.
+	assert.NoError(t, Resend(context.Background(), db, &delivery, now))
+	db.First(&delivery, delivery.ID)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, DeliveryPending, delivery.Status)
+}
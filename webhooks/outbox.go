@@ -0,0 +1,119 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Event is the body posted to webhook endpoints.
+type Event struct {
+	ID             string          `json:"id"`
+	Type           string          `json:"type"`
+	Created        int64           `json:"created"`
+	OrganizationID uint            `json:"organization_id"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// NewSecret returns a random signing secret for a new endpoint.
+func NewSecret() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// ValidEventTypes checks a comma-separated list of event types.
+func ValidEventTypes(eventTypes string) error {
+	if eventTypes == "" {
+		return nil
+	}
+	for _, eventType := range strings.Split(eventTypes, ",") {
+		if !knownEventType(strings.TrimSpace(eventType)) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
+
+func knownEventType(eventType string) bool {
+	for _, known := range events.Types {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+func subscribed(endpoint *models.WebhookEndpoint, eventType string) bool {
+	if endpoint.Events == "" {
+		return true
+	}
+	for _, event := range strings.Split(endpoint.Events, ",") {
+		if strings.TrimSpace(event) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleEvent is the event bus subscriber that queues a delivery of the
+// event to every active endpoint of the organization that subscribes to it.
+// The delivery worker sends them afterwards.
+func HandleEvent(ctx context.Context, tx *gorm.DB, event *events.Event) error {
+	var endpoints []models.WebhookEndpoint
+	if err := tx.Where("organization_id = ? AND active = ?", event.OrganizationID, true).Find(&endpoints).Error; err != nil {
+		return fmt.Errorf("failed to load webhook endpoints: %w", err)
+	}
+
+	var payload []byte
+	now := time.Now()
+	for i := range endpoints {
+		if !subscribed(&endpoints[i], event.Type) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(Event{
+				ID:             event.ID,
+				Type:           event.Type,
+				Created:        event.OccurredAt.Unix(),
+				OrganizationID: event.OrganizationID,
+				Data:           event.Data,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+			}
+		}
+
+		delivery := models.WebhookDelivery{
+			EndpointID:     endpoints[i].ID,
+			OrganizationID: event.OrganizationID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        string(payload),
+			Status:         DeliveryPending,
+			NextAttemptAt:  &now,
+		}
+		if err := tx.Create(&delivery).Error; err != nil {
+			return fmt.Errorf("failed to queue %s event: %w", event.Type, err)
+		}
+	}
+	return nil
+}
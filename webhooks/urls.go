@@ -0,0 +1,76 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"syscall"
+)
+
+// ErrInternalAddress is returned for webhook URLs that resolve to loopback,
+// private, link-local or otherwise non-public addresses. Webhooks are sent
+// from inside our network, so such endpoints could reach internal services.
+var ErrInternalAddress = errors.New("webhook URL resolves to an internal address")
+
+// AllowInternalAddresses lets webhooks be sent over plain HTTP to internal
+// addresses, for tests and local development only.
+var AllowInternalAddresses = false
+
+// sharedAddressSpace is the carrier-grade NAT range, which net.IP does not
+// count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func internal(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || sharedAddressSpace.Contains(addr.Unmap())
+}
+
+// ValidateURL checks that a webhook URL uses https and that its host
+// resolves only to public addresses. The host is checked again when each
+// delivery connects, as what it resolves to can change.
+func ValidateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return errors.New("invalid webhook URL")
+	}
+	if AllowInternalAddresses && (u.Scheme == "http" || u.Scheme == "https") {
+		return nil
+	}
+	if u.Scheme != "https" {
+		return errors.New("webhook URL must use https")
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("cannot resolve webhook host %q", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if internal(addr.IP) {
+			return fmt.Errorf("%w: %s", ErrInternalAddress, u.Hostname())
+		}
+	}
+	return nil
+}
+
+// checkDialAddress refuses connections to internal addresses once the host
+// of a delivery has been resolved.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if AllowInternalAddresses {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internal(ip) {
+		return fmt.Errorf("%w: %s", ErrInternalAddress, host)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoxa/events"
+	"invoxa/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateURL(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, ValidateURL(ctx, "https://203.0.113.10/hooks"))
+
+	for _, raw := range []string{
+		"https://127.0.0.1/hooks",
+		"https://localhost:8443/hooks",
+		"https://10.1.2.3/hooks",
+		"https://192.168.0.10/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://100.64.0.1/hooks",
+		"https://[::1]/hooks",
+		"https://[fd00::1]/hooks",
+		"https://[::ffff:10.0.0.1]/hooks",
+	} {
+		assert.True(t, errors.Is(ValidateURL(ctx, raw), ErrInternalAddress), raw)
+	}
+	assert.ErrorContains(t, ValidateURL(ctx, "http://203.0.113.10/hooks"), "https")
+	assert.Error(t, ValidateURL(ctx, "https:///hooks"))
+}
+
+func TestDeliverRefusesInternalAddresses(t *testing.T) {
+	db := setupWebhookDB(t)
+	AllowInternalAddresses = false
+
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	// An endpoint whose host came to resolve to an internal address.
+	db.Create(&models.WebhookEndpoint{OrganizationID: 1, URL: server.URL, Secret: "s", Active: true})
+	assert.NoError(t, HandleEvent(context.Background(), db, testEvent(1, events.InvoiceCreated, `{}`)))
+	_, err := DeliverDue(context.Background(), db, time.Now())
+	assert.NoError(t, err)
+
+	var delivery models.WebhookDelivery
+	db.First(&delivery)
+	assert.False(t, called)
+	assert.Equal(t, DeliveryPending, delivery.Status)
+	assert.Contains(t, delivery.LastError, ErrInternalAddress.Error())
+}